@@ -1,9 +1,14 @@
 package aws
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/fatih/color"
@@ -17,6 +22,10 @@ type Credentials struct {
 	SecretAccessKey string
 	SessionToken    string
 	Expiration      time.Time
+	// Region is the AWS region the credentials are meant to be used in, if any.
+	Region string
+	// RoleArn is the ARN of the IAM role the credentials belong to, if known.
+	RoleArn string
 }
 
 // Profile represents an AWS profile
@@ -28,74 +37,241 @@ type Profile struct {
 
 const expireKey = "aws_expiration"
 
+// credentialKeys are the keys WriteToFile writes credentials to.
+var credentialKeys = []string{"aws_access_key_id", "aws_secret_access_key", "aws_session_token", expireKey}
+
+// expirationKeyPattern matches valid names of additional expiration keys.
+var expirationKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// ValidateExpirationKey returns an error if key can't be used as an additional key for the
+// expiration in WriteToFile.
+func ValidateExpirationKey(key string) error {
+	if !expirationKeyPattern.MatchString(key) {
+		return fmt.Errorf("invalid expiration key %q: only letters, digits, '_', '.' and '-' are allowed", key)
+	}
+	for _, k := range credentialKeys {
+		if k != expireKey && strings.EqualFold(key, k) {
+			return fmt.Errorf("invalid expiration key %q: the key is used for credentials", key)
+		}
+	}
+
+	return nil
+}
+
+// LegacySessionTokenVar is the environment variable used for the session token by tools which
+// predate AWS_SESSION_TOKEN.
+const LegacySessionTokenVar = "AWS_SECURITY_TOKEN"
+
 // WriteToFile writes credentials to an AWS CLI credentials file
-// (https://docs.aws.amazon.com/cli/latest/userguide/cli-config-files.html). In addition, this
-// function removes expired temporary credentials from the credentials file.
-func WriteToFile(c *Credentials, filename string, section string) error {
-	cfg, err := ini.LooseLoad(filename)
-	if err != nil {
-		return err
+// (https://docs.aws.amazon.com/cli/latest/userguide/cli-config-files.html). The expiration is
+// written in RFC 3339 format to aws_expiration and, if expirationKey is set, also to expirationKey
+// for tools which expect another key, e.g. x_security_token_expires. In addition, this function
+// removes expired temporary credentials from the credentials file.
+func WriteToFile(c *Credentials, filename, section, expirationKey string) error {
+	return updateINI(filename, credentialsProfile(c, section, expirationKey))
+}
+
+// DiffToFile returns the changes WriteToFile would make to the credentials file filename, without
+// writing it. Secret values are redacted.
+func DiffToFile(c *Credentials, filename, section, expirationKey string) (string, error) {
+	return diffINI(filename, credentialsProfile(c, section, expirationKey))
+}
+
+func credentialsProfile(c *Credentials, section, expirationKey string) func(*ini.File) error {
+	return func(cfg *ini.File) error {
+		cfg.DeleteSection(section)
+		_, err := cfg.Section(section).NewKey("aws_access_key_id", c.AccessKeyID)
+		if err != nil {
+			return err
+		}
+		_, err = cfg.Section(section).NewKey("aws_secret_access_key", c.SecretAccessKey)
+		if err != nil {
+			return err
+		}
+		_, err = cfg.Section(section).NewKey("aws_session_token", c.SessionToken)
+		if err != nil {
+			return err
+		}
+		_, err = cfg.Section(section).NewKey(expireKey, c.Expiration.UTC().Format(time.RFC3339))
+		if err != nil {
+			return err
+		}
+		if expirationKey != "" && expirationKey != expireKey {
+			_, err = cfg.Section(section).NewKey(expirationKey, c.Expiration.UTC().Format(time.RFC3339))
+			if err != nil {
+				return err
+			}
+		}
+
+		// Remove expired credentials.
+		for _, s := range cfg.Sections() {
+			if !s.HasKey(expireKey) {
+				continue
+			}
+			v, err := s.Key(expireKey).TimeFormat(time.RFC3339)
+			if err != nil {
+				log.Printf(color.YellowString("Cannot parse date (%v) in section %s: %s"),
+					s.Key(expireKey), s.Name(), err)
+				continue
+			}
+			if time.Now().UTC().Unix() > v.Unix() {
+				cfg.DeleteSection(s.Name())
+			}
+		}
+
+		return nil
 	}
-	cfg.DeleteSection(section)
-	_, err = cfg.Section(section).NewKey("aws_access_key_id", c.AccessKeyID)
+}
+
+// ReadFromFile reads the credentials of the given section of an AWS CLI credentials file written by
+// WriteToFile. An error is returned if the section doesn't contain temporary credentials.
+func ReadFromFile(filename string, section string) (*Credentials, error) {
+	cfg, err := ini.LooseLoad(filename)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("%s contains errors: %w", filename, err)
 	}
-	_, err = cfg.Section(section).NewKey("aws_secret_access_key", c.SecretAccessKey)
+
+	s, err := cfg.GetSection(section)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("no credentials for profile %s in %s", section, filename)
 	}
-	_, err = cfg.Section(section).NewKey("aws_session_token", c.SessionToken)
-	if err != nil {
-		return err
+	for _, k := range credentialKeys {
+		if !s.HasKey(k) {
+			return nil, fmt.Errorf("profile %s in %s has no %s", section, filename, k)
+		}
 	}
-	_, err = cfg.Section(section).NewKey(expireKey, c.Expiration.UTC().Format(time.RFC3339))
+
+	exp, err := s.Key(expireKey).TimeFormat(time.RFC3339)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("parsing expiration of profile %s: %v", section, err)
 	}
 
-	// Remove expired credentials.
-	for _, s := range cfg.Sections() {
-		if !s.HasKey(expireKey) {
-			continue
-		}
-		v, err := s.Key(expireKey).TimeFormat(time.RFC3339)
-		if err != nil {
-			log.Printf(color.YellowString("Cannot parse date (%v) in section %s: %s"),
-				s.Key(expireKey), s.Name(), err)
-			continue
-		}
-		if time.Now().UTC().Unix() > v.Unix() {
-			cfg.DeleteSection(s.Name())
-		}
+	return &Credentials{
+		AccessKeyID:     s.Key("aws_access_key_id").String(),
+		SecretAccessKey: s.Key("aws_secret_access_key").String(),
+		SessionToken:    s.Key("aws_session_token").String(),
+		Expiration:      exp,
+	}, nil
+}
+
+// envPrefixRegexp matches strings which may be prepended to an environment variable name.
+var envPrefixRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ValidateEnvPrefix returns an error if prefix can't be prepended to the names of the environment
+// variables printed by WriteToShell or written by WriteToDotenv.
+func ValidateEnvPrefix(prefix string) error {
+	if prefix != "" && !envPrefixRegexp.MatchString(prefix) {
+		return fmt.Errorf("invalid environment variable prefix '%s': only letters, digits and "+
+			"underscores are allowed and the prefix must not start with a digit", prefix)
 	}
 
-	return cfg.SaveTo(filename)
+	return nil
 }
 
 // WriteToShell writes (prints) credentials to stdout. If windows is true, Windows syntax will be
-// used.
-func WriteToShell(c *Credentials, windows bool, w io.Writer) {
+// used. The given prefix is prepended to the names of the environment variables. If legacy is
+// true, the session token is also written as AWS_SECURITY_TOKEN, which is read by some older tools
+// instead of AWS_SESSION_TOKEN.
+func WriteToShell(c *Credentials, windows bool, prefix string, legacy bool, w io.Writer) {
 	log.Println(color.GreenString("Please paste the following in your shell:"))
+
+	vars := [][2]string{
+		{"AWS_ACCESS_KEY_ID", c.AccessKeyID},
+		{"AWS_SECRET_ACCESS_KEY", c.SecretAccessKey},
+		{"AWS_SESSION_TOKEN", c.SessionToken},
+	}
+	if legacy {
+		vars = append(vars, [2]string{LegacySessionTokenVar, c.SessionToken})
+	}
+	if c.Region != "" {
+		vars = append(vars, [2]string{"AWS_REGION", c.Region}, [2]string{"AWS_DEFAULT_REGION", c.Region})
+	}
+
+	command := "export"
 	if windows {
-		fmt.Fprintf(
-			w,
-			"set AWS_ACCESS_KEY_ID=%v\nset AWS_SECRET_ACCESS_KEY=%v\nset AWS_SESSION_TOKEN=%v\n",
-			c.AccessKeyID,
-			c.SecretAccessKey,
-			c.SessionToken,
-		)
-	} else {
-		fmt.Fprintf(
-			w,
-			"export AWS_ACCESS_KEY_ID=%v\nexport AWS_SECRET_ACCESS_KEY=%v\nexport AWS_SESSION_TOKEN=%v\n",
-			c.AccessKeyID,
-			c.SecretAccessKey,
-			c.SessionToken,
-		)
+		command = "set"
+	}
+	for _, v := range vars {
+		fmt.Fprintf(w, "%s %s%s=%v\n", command, prefix, v[0], v[1])
 	}
 }
 
+// credentialProcessOutput is the format in which the AWS CLI and SDKs expect credentials from a
+// credential_process
+// (https://docs.aws.amazon.com/cli/latest/userguide/cli-configure-sourcing-external.html).
+type credentialProcessOutput struct {
+	Version         int    `json:"Version"`
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	SessionToken    string `json:"SessionToken"`
+	Expiration      string `json:"Expiration"`
+}
+
+// WriteCredentialProcess writes credentials in the JSON format expected from a credential_process.
+func WriteCredentialProcess(c *Credentials, w io.Writer) error {
+	return json.NewEncoder(w).Encode(credentialProcessOutput{
+		Version:         1,
+		AccessKeyID:     c.AccessKeyID,
+		SecretAccessKey: c.SecretAccessKey,
+		SessionToken:    c.SessionToken,
+		Expiration:      c.Expiration.UTC().Format(time.RFC3339),
+	})
+}
+
+// WriteBase64JSON writes credentials as a single line containing the base64 encoding of the JSON
+// written by WriteCredentialProcess, e.g. for storing them in a single secret variable of a CI
+// system which doesn't preserve whitespace.
+func WriteBase64JSON(c *Credentials, w io.Writer) error {
+	var b bytes.Buffer
+	if err := WriteCredentialProcess(c, &b); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintln(w, base64.StdEncoding.EncodeToString(bytes.TrimSpace(b.Bytes())))
+	return err
+}
+
+// PowerShellVariable is the variable the credentials are assigned to by WritePowerShellObject.
+const PowerShellVariable = "ClissoCredentials"
+
+// powerShellQuotes are the characters PowerShell treats as single quotes, including typographic
+// ones, each of which has to be doubled inside a single-quoted string.
+var powerShellQuotes = strings.NewReplacer(
+	"'", "''", "\u2018", "\u2018\u2018", "\u2019", "\u2019\u2019", "\u201a", "\u201a\u201a", "\u201b", "\u201b\u201b",
+)
+
+// powerShellString returns s as a single-quoted PowerShell string, in which no characters other
+// than quotes are special.
+func powerShellString(s string) string {
+	return "'" + powerShellQuotes.Replace(s) + "'"
+}
+
+// WritePowerShellObject writes PowerShell code which assigns the credentials to
+// $ClissoCredentials as an object with the same fields as the output of WriteCredentialProcess,
+// and the region if known. The expiration is a UTC [datetime].
+func WritePowerShellObject(c *Credentials, w io.Writer) error {
+	fields := [][2]string{
+		{"AccessKeyId", powerShellString(c.AccessKeyID)},
+		{"SecretAccessKey", powerShellString(c.SecretAccessKey)},
+		{"SessionToken", powerShellString(c.SessionToken)},
+		{"Expiration", fmt.Sprintf("[datetime]::Parse(%s).ToUniversalTime()",
+			powerShellString(c.Expiration.UTC().Format(time.RFC3339)))},
+	}
+	if c.Region != "" {
+		fields = append(fields, [2]string{"Region", powerShellString(c.Region)})
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "$%s = [pscustomobject]@{\n", PowerShellVariable)
+	for _, f := range fields {
+		fmt.Fprintf(&b, "    %s = %s\n", f[0], f[1])
+	}
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
 // GetValidCredentials returns profiles which have a aws_expiration key but are not yet expired.
 func GetValidCredentials(filename string) ([]Profile, error) {
 	var profiles []Profile
@@ -2,8 +2,13 @@ package aws
 
 import (
 	"bytes"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -27,7 +32,7 @@ func TestWriteToFile(t *testing.T) {
 	p := "expiredprofile"
 
 	// Write credentials
-	err := WriteToFile(&c, fn, p)
+	err := WriteToFile(&c, fn, p, "")
 	if err != nil {
 		t.Fatal("Could not write credentials to file: ", err)
 	}
@@ -50,7 +55,7 @@ func TestWriteToFile(t *testing.T) {
 	p = "testprofile"
 
 	// Write credentials
-	err = WriteToFile(&c, fn, p)
+	err = WriteToFile(&c, fn, p, "")
 	if err != nil {
 		t.Fatal("Could not write credentials to file: ", err)
 	}
@@ -115,6 +120,61 @@ func TestWriteToFile(t *testing.T) {
 	}
 }
 
+func TestWriteToFileExpirationKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clisso-expiration-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fn := filepath.Join(dir, "credentials")
+
+	exp := time.Date(2030, 3, 4, 13, 30, 0, 0, time.FixedZone("CET", 3600))
+	c := Credentials{AccessKeyID: "testkey", SecretAccessKey: "testsecret", SessionToken: "testtoken", Expiration: exp}
+	if err = WriteToFile(&c, fn, "testprofile", "x_security_token_expires"); err != nil {
+		t.Fatal("Could not write credentials to file: ", err)
+	}
+
+	cfg, err := ini.Load(fn)
+	if err != nil {
+		t.Fatal("Could not load INI file: ", err)
+	}
+	s := cfg.Section("testprofile")
+
+	// The expiration is written to both keys in RFC 3339 format, in UTC.
+	for _, key := range []string{"aws_expiration", "x_security_token_expires"} {
+		if v := s.Key(key).String(); v != "2030-03-04T12:30:00Z" {
+			t.Errorf("Wrong %s: got %q, want %q", key, v, "2030-03-04T12:30:00Z")
+		}
+		if _, err := time.Parse(time.RFC3339, s.Key(key).String()); err != nil {
+			t.Errorf("%s isn't in RFC 3339 format: %v", key, err)
+		}
+	}
+}
+
+func TestValidateExpirationKey(t *testing.T) {
+	for _, test := range []struct {
+		key         string
+		expectError bool
+	}{
+		{"x_security_token_expires", false},
+		{"aws_expiration", false},
+		{"aws_session_token", true},
+		{"AWS_ACCESS_KEY_ID", true},
+		{"expires at", true},
+		{"", true},
+	} {
+		t.Run(test.key, func(t *testing.T) {
+			err := ValidateExpirationKey(test.key)
+			if test.expectError && err == nil {
+				t.Errorf("expected error")
+			}
+			if !test.expectError && err != nil {
+				t.Errorf("unexpected error %+v", err)
+			}
+		})
+	}
+}
+
 func TestGetValidCredentials(t *testing.T) {
 	fn := "test_creds.txt"
 
@@ -134,7 +194,7 @@ func TestGetValidCredentials(t *testing.T) {
 	p := "expired"
 
 	// Write credentials
-	err := WriteToFile(&c, fn, p)
+	err := WriteToFile(&c, fn, p, "")
 	if err != nil {
 		t.Fatal("Could not write credentials to file: ", err)
 	}
@@ -144,7 +204,7 @@ func TestGetValidCredentials(t *testing.T) {
 	p = "valid"
 
 	// Write credentials
-	err = WriteToFile(&c, fn, p)
+	err = WriteToFile(&c, fn, p, "")
 	if err != nil {
 		t.Fatal("Could not write credentials to file: ", err)
 	}
@@ -217,7 +277,7 @@ func TestWriteToShellUnix(t *testing.T) {
 	}
 	var b bytes.Buffer
 
-	WriteToShell(&c, false, &b)
+	WriteToShell(&c, false, "", false, &b)
 
 	got := b.String()
 	want := fmt.Sprintf(
@@ -246,7 +306,7 @@ func TestWriteToShellWindows(t *testing.T) {
 	}
 	var b bytes.Buffer
 
-	WriteToShell(&c, true, &b)
+	WriteToShell(&c, true, "", false, &b)
 
 	got := b.String()
 	want := fmt.Sprintf(
@@ -260,3 +320,221 @@ func TestWriteToShellWindows(t *testing.T) {
 		t.Fatalf("Wrong info written to shell: got %v want %v", got, want)
 	}
 }
+
+func TestWriteToShellPrefix(t *testing.T) {
+	c := Credentials{
+		AccessKeyID:     "testkey",
+		SecretAccessKey: "testsecret",
+		SessionToken:    "testtoken",
+		Expiration:      time.Now(),
+	}
+	var b bytes.Buffer
+
+	WriteToShell(&c, false, "MYAPP_", false, &b)
+
+	got := b.String()
+	want := "export MYAPP_AWS_ACCESS_KEY_ID=testkey\nexport MYAPP_AWS_SECRET_ACCESS_KEY=testsecret\n" +
+		"export MYAPP_AWS_SESSION_TOKEN=testtoken\n"
+
+	if got != want {
+		t.Fatalf("Wrong info written to shell: got %v want %v", got, want)
+	}
+}
+
+func TestWriteToShellLegacy(t *testing.T) {
+	c := Credentials{
+		AccessKeyID:     "testkey",
+		SecretAccessKey: "testsecret",
+		SessionToken:    "testtoken",
+		Expiration:      time.Now(),
+	}
+	var b bytes.Buffer
+
+	WriteToShell(&c, false, "", true, &b)
+
+	got := b.String()
+	want := "export AWS_ACCESS_KEY_ID=testkey\nexport AWS_SECRET_ACCESS_KEY=testsecret\n" +
+		"export AWS_SESSION_TOKEN=testtoken\nexport AWS_SECURITY_TOKEN=testtoken\n"
+
+	if got != want {
+		t.Fatalf("Wrong info written to shell: got %v want %v", got, want)
+	}
+}
+
+func TestWriteToShellRegion(t *testing.T) {
+	c := Credentials{
+		AccessKeyID:     "testkey",
+		SecretAccessKey: "testsecret",
+		SessionToken:    "testtoken",
+		Expiration:      time.Now(),
+		Region:          "eu-west-1",
+	}
+	var b bytes.Buffer
+
+	WriteToShell(&c, false, "", false, &b)
+
+	got := b.String()
+	want := "export AWS_ACCESS_KEY_ID=testkey\nexport AWS_SECRET_ACCESS_KEY=testsecret\n" +
+		"export AWS_SESSION_TOKEN=testtoken\nexport AWS_REGION=eu-west-1\nexport AWS_DEFAULT_REGION=eu-west-1\n"
+
+	if got != want {
+		t.Fatalf("Wrong info written to shell: got %v want %v", got, want)
+	}
+}
+
+func TestValidateEnvPrefix(t *testing.T) {
+	for _, test := range []struct {
+		prefix      string
+		expectError bool
+	}{
+		{"", false},
+		{"MYAPP_", false},
+		{"_my_app2_", false},
+		{"2MYAPP_", true},
+		{"MY-APP_", true},
+		{"MY APP_", true},
+		{"MYAPP=", true},
+	} {
+		t.Run(test.prefix, func(t *testing.T) {
+			err := ValidateEnvPrefix(test.prefix)
+			if test.expectError && err == nil {
+				t.Errorf("expected error")
+			}
+			if !test.expectError && err != nil {
+				t.Errorf("unexpected error %+v", err)
+			}
+		})
+	}
+}
+
+func TestWriteCredentialProcess(t *testing.T) {
+	c := Credentials{
+		AccessKeyID:     "testkey",
+		SecretAccessKey: "testsecret",
+		SessionToken:    "testtoken",
+		Expiration:      time.Date(2021, 3, 4, 12, 30, 0, 0, time.FixedZone("CET", 3600)),
+	}
+	var b bytes.Buffer
+
+	if err := WriteCredentialProcess(&c, &b); err != nil {
+		t.Fatal("Could not write credentials: ", err)
+	}
+
+	got := b.String()
+	want := `{"Version":1,"AccessKeyId":"testkey","SecretAccessKey":"testsecret",` +
+		`"SessionToken":"testtoken","Expiration":"2021-03-04T11:30:00Z"}` + "\n"
+
+	if got != want {
+		t.Fatalf("Wrong credential_process output: got %v want %v", got, want)
+	}
+}
+
+func TestWriteBase64JSON(t *testing.T) {
+	c := Credentials{
+		AccessKeyID:     "testkey",
+		SecretAccessKey: "testsecret",
+		SessionToken:    "testtoken",
+		Expiration:      time.Date(2021, 3, 4, 12, 30, 0, 0, time.FixedZone("CET", 3600)),
+	}
+	var b bytes.Buffer
+
+	if err := WriteBase64JSON(&c, &b); err != nil {
+		t.Fatal("Could not write credentials: ", err)
+	}
+
+	line := b.String()
+	if strings.Count(line, "\n") != 1 || !strings.HasSuffix(line, "\n") {
+		t.Fatalf("Output isn't a single line: %q", line)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(line))
+	if err != nil {
+		t.Fatal("Could not decode output: ", err)
+	}
+	var got credentialProcessOutput
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal("Could not parse decoded output: ", err)
+	}
+
+	want := credentialProcessOutput{1, "testkey", "testsecret", "testtoken", "2021-03-04T11:30:00Z"}
+	if got != want {
+		t.Fatalf("Wrong credentials after decoding: got %+v want %+v", got, want)
+	}
+}
+
+func TestWritePowerShellObject(t *testing.T) {
+	c := Credentials{
+		AccessKeyID:     "testkey",
+		SecretAccessKey: "test'secret’$(rm)`n",
+		SessionToken:    "testtoken",
+		Expiration:      time.Date(2021, 3, 4, 12, 30, 0, 0, time.FixedZone("CET", 3600)),
+		Region:          "eu-west-1",
+	}
+	var b bytes.Buffer
+
+	if err := WritePowerShellObject(&c, &b); err != nil {
+		t.Fatal("Could not write credentials: ", err)
+	}
+
+	got := b.String()
+	want := "$ClissoCredentials = [pscustomobject]@{\n" +
+		"    AccessKeyId = 'testkey'\n" +
+		"    SecretAccessKey = 'test''secret’’$(rm)`n'\n" +
+		"    SessionToken = 'testtoken'\n" +
+		"    Expiration = [datetime]::Parse('2021-03-04T11:30:00Z').ToUniversalTime()\n" +
+		"    Region = 'eu-west-1'\n" +
+		"}\n"
+
+	if got != want {
+		t.Fatalf("Wrong PowerShell object written: got %v want %v", got, want)
+	}
+}
+
+func TestPowerShellString(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		s      string
+		expect string
+	}{
+		{"Plain", "testkey", "'testkey'"},
+		{"Empty", "", "''"},
+		{"Single quote", "it's", "'it''s'"},
+		{"Typographic quotes", "‘a’‚b‛", "'‘‘a’’‚‚b‛‛'"},
+		// Double quotes, variables, subexpressions and escapes aren't expanded in single quotes.
+		{"Not expanded", "\"$env:PATH $(rm) `n\"", "'\"$env:PATH $(rm) `n\"'"},
+		{"Base64", "FwoGZXIvYXdzEBYaDA+/=", "'FwoGZXIvYXdzEBYaDA+/='"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if s := powerShellString(test.s); s != test.expect {
+				t.Errorf("expected %s, received %s", test.expect, s)
+			}
+		})
+	}
+}
+
+func TestReadFromFile(t *testing.T) {
+	fn := "test_read_creds.txt"
+	defer os.Remove(fn)
+
+	c := Credentials{
+		AccessKeyID:     "testkey",
+		SecretAccessKey: "testsecret",
+		SessionToken:    "testtoken",
+		Expiration:      time.Now().Add(time.Hour).UTC().Truncate(time.Second),
+	}
+	if err := WriteToFile(&c, fn, "valid", ""); err != nil {
+		t.Fatal("Could not write credentials to file: ", err)
+	}
+
+	res, err := ReadFromFile(fn, "valid")
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	if *res != c {
+		t.Errorf("expected %+v, received %+v", c, *res)
+	}
+
+	if _, err := ReadFromFile(fn, "not-there"); err == nil {
+		t.Errorf("expected error for missing profile")
+	}
+}
@@ -0,0 +1,70 @@
+package aws
+
+import (
+	"sort"
+
+	"github.com/go-ini/ini"
+)
+
+// WriteCredentialProcessProfiles writes profiles which source their credentials from an external
+// process to an AWS CLI config file
+// (https://docs.aws.amazon.com/cli/latest/userguide/cli-configure-sourcing-external.html).
+// profiles maps profile names to credential_process commands. Other profiles in the file, as well
+// as other keys in the written profiles, are preserved.
+func WriteCredentialProcessProfiles(filename string, profiles map[string]string) error {
+	return updateINI(filename, credentialProcessProfiles(profiles))
+}
+
+// DiffCredentialProcessProfiles returns the changes WriteCredentialProcessProfiles would make to
+// filename as a diff of the affected profiles, without writing the file. An empty string is
+// returned if the file wouldn't change.
+func DiffCredentialProcessProfiles(filename string, profiles map[string]string) (string, error) {
+	return diffINI(filename, credentialProcessProfiles(profiles))
+}
+
+func credentialProcessProfiles(profiles map[string]string) func(*ini.File) error {
+	return func(cfg *ini.File) error {
+		// Sort profiles so that new profiles are always appended in the same order.
+		names := make([]string, 0, len(profiles))
+		for name := range profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			cfg.Section(configSection(name)).Key("credential_process").SetValue(profiles[name])
+		}
+
+		return nil
+	}
+}
+
+// WriteRegion sets the region of profile in an AWS CLI config file, preserving all other settings.
+func WriteRegion(filename, profile, region string) error {
+	return updateINI(filename, profileRegion(profile, region))
+}
+
+// DiffRegion returns the changes WriteRegion would make to the AWS CLI config file filename, without
+// writing it.
+func DiffRegion(filename, profile, region string) (string, error) {
+	return diffINI(filename, profileRegion(profile, region))
+}
+
+func profileRegion(profile, region string) func(*ini.File) error {
+	return func(cfg *ini.File) error {
+		cfg.Section(configSection(profile)).Key("region").SetValue(region)
+
+		return nil
+	}
+}
+
+// configSection returns the name of the section of the given profile in an AWS CLI config file.
+// Unlike in the credentials file, all profiles other than the default one are prefixed with
+// "profile".
+func configSection(profile string) string {
+	if profile == "default" {
+		return profile
+	}
+
+	return "profile " + profile
+}
@@ -0,0 +1,162 @@
+package aws
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/go-ini/ini"
+)
+
+func TestWriteCredentialProcessProfiles(t *testing.T) {
+	fn := "test_config.txt"
+	defer os.Remove(fn)
+
+	existing := `[default]
+region = us-east-1
+
+[profile unmanaged]
+region = eu-central-1
+
+[profile app-1]
+region = eu-west-1
+credential_process = old command
+`
+	if err := ioutil.WriteFile(fn, []byte(existing), 0600); err != nil {
+		t.Fatal("Could not write config file: ", err)
+	}
+
+	profiles := map[string]string{
+		"app-1": "/usr/local/bin/clisso get app-1 --credential-process",
+		"app-2": "/usr/local/bin/clisso get app-2 --credential-process",
+	}
+	if err := WriteCredentialProcessProfiles(fn, profiles); err != nil {
+		t.Fatal("Could not write profiles: ", err)
+	}
+
+	cfg, err := ini.Load(fn)
+	if err != nil {
+		t.Fatal("Could not load INI file: ", err)
+	}
+
+	for _, test := range []struct {
+		section string
+		key     string
+		expect  string
+	}{
+		{"default", "region", "us-east-1"},
+		{"profile unmanaged", "region", "eu-central-1"},
+		{"profile app-1", "region", "eu-west-1"},
+		{"profile app-1", "credential_process", profiles["app-1"]},
+		{"profile app-2", "credential_process", profiles["app-2"]},
+	} {
+		s, err := cfg.GetSection(test.section)
+		if err != nil {
+			t.Errorf("Section %q is missing", test.section)
+			continue
+		}
+		if v := s.Key(test.key).String(); v != test.expect {
+			t.Errorf("[%s] %s: got %q, want %q", test.section, test.key, v, test.expect)
+		}
+	}
+
+	if cfg.Section("profile unmanaged").HasKey("credential_process") {
+		t.Errorf("Unmanaged profile was modified")
+	}
+}
+
+func TestDiffCredentialProcessProfiles(t *testing.T) {
+	fn := "test_config_diff.txt"
+	defer os.Remove(fn)
+
+	existing := `[default]
+region = us-east-1
+
+[profile app-1]
+region = eu-west-1
+credential_process = old command
+
+[profile app-3]
+credential_process = /usr/local/bin/clisso get app-3 --credential-process
+`
+	if err := ioutil.WriteFile(fn, []byte(existing), 0600); err != nil {
+		t.Fatal("Could not write config file: ", err)
+	}
+
+	profiles := map[string]string{
+		"app-1": "/usr/local/bin/clisso get app-1 --credential-process",
+		"app-2": "/usr/local/bin/clisso get app-2 --credential-process",
+		"app-3": "/usr/local/bin/clisso get app-3 --credential-process",
+	}
+	diff, err := DiffCredentialProcessProfiles(fn, profiles)
+	if err != nil {
+		t.Fatal("Could not compare profiles: ", err)
+	}
+
+	// Unchanged profiles are omitted.
+	want := `--- test_config_diff.txt
++++ test_config_diff.txt
+ [profile app-1]
+ region = eu-west-1
+-credential_process = old command
++credential_process = /usr/local/bin/clisso get app-1 --credential-process
++[profile app-2]
++credential_process = /usr/local/bin/clisso get app-2 --credential-process
+`
+	if diff != want {
+		t.Errorf("Wrong diff: got\n%s\nwant\n%s", diff, want)
+	}
+
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		t.Fatal("Could not read config file: ", err)
+	}
+	if string(b) != existing {
+		t.Errorf("Config file was modified:\n%s", b)
+	}
+
+	// Nothing changes once the profiles are written.
+	if err = WriteCredentialProcessProfiles(fn, profiles); err != nil {
+		t.Fatal("Could not write profiles: ", err)
+	}
+	if diff, err = DiffCredentialProcessProfiles(fn, profiles); err != nil || diff != "" {
+		t.Errorf("Expected no changes, got %q (error %v)", diff, err)
+	}
+}
+
+func TestWriteRegion(t *testing.T) {
+	fn := "test_region_config.txt"
+	defer os.Remove(fn)
+
+	existing := `[profile app-1]
+credential_process = some command
+`
+	if err := ioutil.WriteFile(fn, []byte(existing), 0600); err != nil {
+		t.Fatal("Could not write config file: ", err)
+	}
+
+	for _, test := range []struct {
+		profile string
+		section string
+	}{
+		{"app-1", "profile app-1"},
+		{"default", "default"},
+	} {
+		if err := WriteRegion(fn, test.profile, "eu-west-1"); err != nil {
+			t.Fatal("Could not write region: ", err)
+		}
+
+		cfg, err := ini.Load(fn)
+		if err != nil {
+			t.Fatal("Could not load INI file: ", err)
+		}
+		if v := cfg.Section(test.section).Key("region").String(); v != "eu-west-1" {
+			t.Errorf("[%s] region: got %q, want %q", test.section, v, "eu-west-1")
+		}
+	}
+
+	cfg, _ := ini.Load(fn)
+	if v := cfg.Section("profile app-1").Key("credential_process").String(); v != "some command" {
+		t.Errorf("credential_process was modified: %q", v)
+	}
+}
@@ -0,0 +1,78 @@
+package aws
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// federationEndpoint is the endpoint which exchanges temporary credentials for a console sign-in
+// token (https://docs.aws.amazon.com/IAM/latest/UserGuide/id_roles_providers_enable-console-custom-url.html).
+var federationEndpoint = "https://signin.aws.amazon.com/federation"
+
+// consoleIssuer is the issuer shown by the console for sessions started using ConsoleURL.
+const consoleIssuer = "clisso"
+
+// federationTimeout limits the time for getting a sign-in token.
+const federationTimeout = 10 * time.Second
+
+// federationSession is the session parameter of a getSigninToken request.
+type federationSession struct {
+	SessionID    string `json:"sessionId"`
+	SessionKey   string `json:"sessionKey"`
+	SessionToken string `json:"sessionToken"`
+}
+
+// ConsoleURL exchanges the given credentials for a sign-in token at the AWS federation endpoint and
+// returns a URL which signs in to the AWS Management Console as the role the credentials belong
+// to. The URL has to be used within 15 minutes. If the credentials have a region, the console is
+// opened in that region.
+func ConsoleURL(c *Credentials) (string, error) {
+	session, err := json.Marshal(federationSession{
+		SessionID:    c.AccessKeyID,
+		SessionKey:   c.SecretAccessKey,
+		SessionToken: c.SessionToken,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	q := url.Values{}
+	q.Set("Action", "getSigninToken")
+	q.Set("Session", string(session))
+	client := http.Client{Timeout: federationTimeout}
+	resp, err := client.Get(federationEndpoint + "?" + q.Encode())
+	if err != nil {
+		return "", fmt.Errorf("getting sign-in token: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("getting sign-in token: %s", resp.Status)
+	}
+
+	var token struct {
+		SigninToken string
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("parsing sign-in token: %v", err)
+	}
+	if token.SigninToken == "" {
+		return "", errors.New("no sign-in token received")
+	}
+
+	destination := "https://console.aws.amazon.com/"
+	if c.Region != "" {
+		destination = "https://console.aws.amazon.com/console/home?region=" + url.QueryEscape(c.Region)
+	}
+
+	q = url.Values{}
+	q.Set("Action", "login")
+	q.Set("Issuer", consoleIssuer)
+	q.Set("Destination", destination)
+	q.Set("SigninToken", token.SigninToken)
+
+	return federationEndpoint + "?" + q.Encode(), nil
+}
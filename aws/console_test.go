@@ -0,0 +1,87 @@
+package aws
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestConsoleURL(t *testing.T) {
+	var session federationSession
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a := r.URL.Query().Get("Action"); a != "getSigninToken" {
+			t.Errorf("expected action getSigninToken, received %q", a)
+		}
+		if err := json.Unmarshal([]byte(r.URL.Query().Get("Session")), &session); err != nil {
+			t.Errorf("unexpected error %+v", err)
+		}
+		fmt.Fprint(w, `{"SigninToken":"signin-token"}`)
+	}))
+	defer srv.Close()
+	defer func(e string) { federationEndpoint = e }(federationEndpoint)
+	federationEndpoint = srv.URL + "/federation"
+
+	c := Credentials{
+		AccessKeyID:     "expectedkey",
+		SecretAccessKey: "expectedsecret",
+		SessionToken:    "expectedtoken",
+		Region:          "eu-west-1",
+	}
+	u, err := ConsoleURL(&c)
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+
+	// The sign-in token is obtained using the given credentials.
+	if session.SessionID != c.AccessKeyID || session.SessionKey != c.SecretAccessKey || session.SessionToken != c.SessionToken {
+		t.Errorf("unexpected session %+v", session)
+	}
+
+	if !strings.HasPrefix(u, federationEndpoint+"?") {
+		t.Fatalf("expected a URL of the federation endpoint, received %s", u)
+	}
+	parsed, err := url.Parse(u)
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	for k, v := range map[string]string{
+		"Action":      "login",
+		"Issuer":      "clisso",
+		"Destination": "https://console.aws.amazon.com/console/home?region=eu-west-1",
+		"SigninToken": "signin-token",
+	} {
+		if received := parsed.Query().Get(k); received != v {
+			t.Errorf("%s: expected %q, received %q", k, v, received)
+		}
+	}
+}
+
+func TestConsoleURLErrors(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		status int
+		body   string
+	}{
+		{"Error status", http.StatusBadRequest, "Bad Request"},
+		{"Invalid response", http.StatusOK, "<html>"},
+		{"No token", http.StatusOK, "{}"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(test.status)
+				fmt.Fprint(w, test.body)
+			}))
+			defer srv.Close()
+			defer func(e string) { federationEndpoint = e }(federationEndpoint)
+			federationEndpoint = srv.URL
+
+			if _, err := ConsoleURL(&Credentials{}); err == nil {
+				t.Errorf("expected error")
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package aws
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// WriteToDotenv writes credentials to a dotenv file as used by e.g. docker-compose. Variables
+// which are already present in the file are updated in place, all other lines are preserved. The
+// given prefix is prepended to the names of the variables. If legacy is true, the session token is
+// also written as AWS_SECURITY_TOKEN.
+func WriteToDotenv(c *Credentials, filename, prefix string, legacy bool) error {
+	if err := ValidateEnvPrefix(prefix); err != nil {
+		return err
+	}
+
+	vars := [][2]string{
+		{"AWS_ACCESS_KEY_ID", c.AccessKeyID},
+		{"AWS_SECRET_ACCESS_KEY", c.SecretAccessKey},
+		{"AWS_SESSION_TOKEN", c.SessionToken},
+	}
+	if legacy {
+		vars = append(vars, [2]string{LegacySessionTokenVar, c.SessionToken})
+	}
+	vars = append(vars, [2]string{"AWS_SESSION_EXPIRATION", c.Expiration.UTC().Format(time.RFC3339)})
+	if c.Region != "" {
+		vars = append(vars, [2]string{"AWS_REGION", c.Region}, [2]string{"AWS_DEFAULT_REGION", c.Region})
+	}
+
+	return updateFile(filename, func(b []byte) ([]byte, error) {
+		var lines []string
+		if len(b) > 0 {
+			lines = strings.Split(strings.TrimRight(string(b), "\n"), "\n")
+		}
+
+		for _, v := range vars {
+			key := prefix + v[0]
+			line := fmt.Sprintf("%s=%s", key, v[1])
+			replaced := false
+			for i, l := range lines {
+				if dotenvKey(l) == key {
+					lines[i] = line
+					replaced = true
+				}
+			}
+			if !replaced {
+				lines = append(lines, line)
+			}
+		}
+
+		return []byte(strings.Join(lines, "\n") + "\n"), nil
+	})
+}
+
+// dotenvKey returns the name of the variable set in a line of a dotenv file, or an empty string
+// for comments and other lines which don't set a variable.
+func dotenvKey(line string) string {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "#") {
+		return ""
+	}
+	line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+
+	i := strings.Index(line, "=")
+	if i < 0 {
+		return ""
+	}
+
+	return strings.TrimSpace(line[:i])
+}
@@ -0,0 +1,129 @@
+package aws
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestWriteToDotenv(t *testing.T) {
+	fn := "test_dotenv.txt"
+	defer os.Remove(fn)
+
+	existing := `# Service settings
+SERVICE_URL=https://example.com
+export AWS_ACCESS_KEY_ID=oldkey
+AWS_SESSION_TOKEN = oldtoken
+`
+	if err := ioutil.WriteFile(fn, []byte(existing), 0600); err != nil {
+		t.Fatal("Could not write dotenv file: ", err)
+	}
+
+	c := Credentials{
+		AccessKeyID:     "testkey",
+		SecretAccessKey: "testsecret",
+		SessionToken:    "testtoken",
+		Expiration:      time.Date(2021, 3, 4, 12, 30, 0, 0, time.UTC),
+		Region:          "eu-west-1",
+	}
+	if err := WriteToDotenv(&c, fn, "", false); err != nil {
+		t.Fatal("Could not write credentials: ", err)
+	}
+
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		t.Fatal("Could not read dotenv file: ", err)
+	}
+	want := `# Service settings
+SERVICE_URL=https://example.com
+AWS_ACCESS_KEY_ID=testkey
+AWS_SESSION_TOKEN=testtoken
+AWS_SECRET_ACCESS_KEY=testsecret
+AWS_SESSION_EXPIRATION=2021-03-04T12:30:00Z
+AWS_REGION=eu-west-1
+AWS_DEFAULT_REGION=eu-west-1
+`
+	if got := string(b); got != want {
+		t.Errorf("Wrong dotenv file: got\n%v\nwant\n%v", got, want)
+	}
+
+	// A new file is created.
+	os.Remove(fn)
+	if err := WriteToDotenv(&c, fn, "", false); err != nil {
+		t.Fatal("Could not write credentials: ", err)
+	}
+	fi, err := os.Stat(fn)
+	if err != nil {
+		t.Fatal("Dotenv file wasn't created: ", err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("Wrong file mode %v", fi.Mode().Perm())
+	}
+}
+
+func TestWriteToDotenvLegacy(t *testing.T) {
+	fn := "test_dotenv_legacy.txt"
+	defer os.Remove(fn)
+
+	c := Credentials{
+		AccessKeyID:     "testkey",
+		SecretAccessKey: "testsecret",
+		SessionToken:    "testtoken",
+		Expiration:      time.Date(2021, 3, 4, 12, 30, 0, 0, time.UTC),
+	}
+	if err := WriteToDotenv(&c, fn, "", true); err != nil {
+		t.Fatal("Could not write credentials: ", err)
+	}
+
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		t.Fatal("Could not read dotenv file: ", err)
+	}
+	want := `AWS_ACCESS_KEY_ID=testkey
+AWS_SECRET_ACCESS_KEY=testsecret
+AWS_SESSION_TOKEN=testtoken
+AWS_SECURITY_TOKEN=testtoken
+AWS_SESSION_EXPIRATION=2021-03-04T12:30:00Z
+`
+	if got := string(b); got != want {
+		t.Errorf("Wrong dotenv file: got\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestWriteToDotenvPrefix(t *testing.T) {
+	fn := "test_dotenv_prefix.txt"
+	defer os.Remove(fn)
+
+	if err := ioutil.WriteFile(fn, []byte("AWS_ACCESS_KEY_ID=otherkey\nMYAPP_AWS_ACCESS_KEY_ID=oldkey\n"), 0600); err != nil {
+		t.Fatal("Could not write dotenv file: ", err)
+	}
+
+	c := Credentials{
+		AccessKeyID:     "testkey",
+		SecretAccessKey: "testsecret",
+		SessionToken:    "testtoken",
+		Expiration:      time.Date(2021, 3, 4, 12, 30, 0, 0, time.UTC),
+	}
+	if err := WriteToDotenv(&c, fn, "MYAPP_", false); err != nil {
+		t.Fatal("Could not write credentials: ", err)
+	}
+
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		t.Fatal("Could not read dotenv file: ", err)
+	}
+	want := `AWS_ACCESS_KEY_ID=otherkey
+MYAPP_AWS_ACCESS_KEY_ID=testkey
+MYAPP_AWS_SECRET_ACCESS_KEY=testsecret
+MYAPP_AWS_SESSION_TOKEN=testtoken
+MYAPP_AWS_SESSION_EXPIRATION=2021-03-04T12:30:00Z
+`
+	if got := string(b); got != want {
+		t.Errorf("Wrong dotenv file: got\n%v\nwant\n%v", got, want)
+	}
+
+	if err := WriteToDotenv(&c, fn, "1APP_", false); err == nil {
+		t.Error("expected error")
+	}
+}
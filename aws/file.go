@@ -0,0 +1,231 @@
+package aws
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-ini/ini"
+
+	"github.com/allcloud-io/clisso/lock"
+)
+
+// fileLockWait is the time to wait for another clisso run to finish updating a file.
+const fileLockWait = 10 * time.Second
+
+// updateFile replaces the contents of filename with the result of applying update to them. The
+// contents are nil if the file doesn't exist. A lock is held during the read-modify-write cycle,
+// so that concurrent clisso runs don't lose each other's changes, and the file is replaced
+// atomically, so that other tools (e.g. the AWS CLI) never read a partially written file.
+func updateFile(filename string, update func([]byte) ([]byte, error)) error {
+	path, err := fileLockPath(filename)
+	if err != nil {
+		return err
+	}
+	l, err := lock.Acquire(path, fileLockWait)
+	if err != nil {
+		return fmt.Errorf("locking %s: %v", filename, err)
+	}
+	defer l.Release()
+
+	b, err := ioutil.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	b, err = update(b)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filename, b)
+}
+
+// fileLockPath returns the path of the lock file for filename. Lock files are kept in the cache
+// directory instead of next to the file, so that they don't clutter e.g. ~/.aws.
+func fileLockPath(filename string) (string, error) {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return "", err
+	}
+
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("getting cache directory: %v", err)
+	}
+	dir = filepath.Join(dir, "clisso", "files")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("creating lock directory: %v", err)
+	}
+
+	return filepath.Join(dir, fmt.Sprintf("%x.lock", sha256.Sum256([]byte(abs)))), nil
+}
+
+// updateINI applies update to the ini file filename using updateFile.
+func updateINI(filename string, update func(*ini.File) error) error {
+	return updateFile(filename, func(b []byte) ([]byte, error) {
+		cfg, err := ini.Load(b)
+		if err != nil {
+			return nil, err
+		}
+		if err = update(cfg); err != nil {
+			return nil, err
+		}
+
+		var buf bytes.Buffer
+		if _, err = cfg.WriteTo(&buf); err != nil {
+			return nil, err
+		}
+
+		return buf.Bytes(), nil
+	})
+}
+
+// diffINI returns the changes applying update to the INI file filename would make, without writing
+// the file. Only changed sections are included, in a format similar to a unified diff: each line
+// of a section is prefixed with "-" if it's removed, "+" if it's added and " " if it's unchanged. An
+// empty string is returned if nothing would change.
+func diffINI(filename string, update func(*ini.File) error) (string, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	before, err := ini.Load(b)
+	if err != nil {
+		return "", err
+	}
+	after, err := ini.Load(b)
+	if err != nil {
+		return "", err
+	}
+	if err = update(after); err != nil {
+		return "", err
+	}
+
+	// Sections are listed in the order of the updated file, followed by removed sections.
+	names := after.SectionStrings()
+	for _, name := range before.SectionStrings() {
+		if _, err := after.GetSection(name); err != nil {
+			names = append(names, name)
+		}
+	}
+
+	var diff bytes.Buffer
+	for _, name := range names {
+		diff.WriteString(diffSection(name, before, after))
+	}
+	if diff.Len() == 0 {
+		return "", nil
+	}
+
+	return fmt.Sprintf("--- %s\n+++ %s\n%s", filename, filename, diff.String()), nil
+}
+
+// secretKeys are the keys of INI files whose values are redacted in diffs.
+var secretKeys = map[string]bool{
+	"aws_secret_access_key": true,
+	"aws_session_token":     true,
+	"aws_security_token":    true,
+}
+
+// diffSection returns the diff of the section name between before and after, or an empty string
+// if the section is the same in both.
+func diffSection(name string, before, after *ini.File) string {
+	orig, _ := before.GetSection(name)
+	updated, _ := after.GetSection(name)
+
+	var lines []string
+	changed := false
+	line := func(prefix string, k *ini.Key) {
+		v := k.Value()
+		if secretKeys[k.Name()] {
+			v = "REDACTED"
+		}
+		lines = append(lines, fmt.Sprintf("%s%s = %s", prefix, k.Name(), v))
+		if prefix != " " {
+			changed = true
+		}
+	}
+
+	if updated != nil {
+		for _, k := range updated.Keys() {
+			switch {
+			case orig == nil || !orig.HasKey(k.Name()):
+				line("+", k)
+			case orig.Key(k.Name()).Value() != k.Value():
+				line("-", orig.Key(k.Name()))
+				line("+", k)
+			default:
+				line(" ", k)
+			}
+		}
+	}
+	if orig != nil {
+		for _, k := range orig.Keys() {
+			if updated == nil || !updated.HasKey(k.Name()) {
+				line("-", k)
+			}
+		}
+	}
+	if !changed {
+		return ""
+	}
+
+	header := " "
+	switch {
+	case orig == nil:
+		header = "+"
+	case updated == nil:
+		header = "-"
+	}
+	lines = append([]string{fmt.Sprintf("%s[%s]", header, name)}, lines...)
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// writeFileAtomic writes data to a temporary file in the directory of filename and renames it to
+// filename. The permissions of an existing file are kept, new files are only accessible by the
+// current user since they hold credentials. If filename is a symlink, e.g. to a file managed with
+// other dotfiles, its target is replaced instead of the link.
+func writeFileAtomic(filename string, data []byte) error {
+	if resolved, err := filepath.EvalSymlinks(filename); err == nil {
+		filename = resolved
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	mode := os.FileMode(0600)
+	if fi, err := os.Stat(filename); err == nil {
+		mode = fi.Mode().Perm()
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	// Removing the temporary file fails harmlessly once it has been renamed.
+	defer os.Remove(f.Name())
+
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(f.Name(), mode); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), filename)
+}
@@ -0,0 +1,117 @@
+package aws
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-ini/ini"
+)
+
+func TestWriteToFileConcurrentReads(t *testing.T) {
+	fn := "test_atomic_creds.txt"
+	defer os.Remove(fn)
+
+	// A large file makes partial writes likely to be observed.
+	c := Credentials{
+		AccessKeyID:     "testkey",
+		SecretAccessKey: "testsecret",
+		SessionToken:    "testtoken",
+		Expiration:      time.Now().Add(time.Hour),
+	}
+	const profiles = 2000
+	cfg := ini.Empty()
+	for i := 0; i < profiles; i++ {
+		s := cfg.Section(fmt.Sprintf("profile-%d", i))
+		s.Key("aws_access_key_id").SetValue(c.AccessKeyID)
+		s.Key(expireKey).SetValue(c.Expiration.UTC().Format(time.RFC3339))
+	}
+	if err := cfg.SaveTo(fn); err != nil {
+		t.Fatal("Could not write credentials file: ", err)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			if err := WriteToFile(&c, fn, fmt.Sprintf("profile-%d", i), ""); err != nil {
+				t.Error("Could not write credentials: ", err)
+				return
+			}
+		}
+	}()
+
+	reads := 0
+	for {
+		select {
+		case <-done:
+			wg.Wait()
+			if reads == 0 {
+				t.Error("File wasn't read while being written")
+			}
+			return
+		default:
+		}
+
+		b, err := ioutil.ReadFile(fn)
+		if err != nil {
+			t.Fatal("Could not read credentials file: ", err)
+		}
+		// Every profile, including the one being rewritten, has an expiration.
+		if n := bytes.Count(b, []byte(expireKey)); n != profiles {
+			t.Fatalf("Read a partial file with %d of %d profiles", n, profiles)
+		}
+		reads++
+	}
+}
+
+func TestWriteToFileSymlink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clisso-symlink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	target := filepath.Join(dir, "dotfiles-credentials")
+	if err = ioutil.WriteFile(target, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "credentials")
+	if err = os.Symlink(target, link); err != nil {
+		t.Skip("Symlinks aren't supported: ", err)
+	}
+
+	c := Credentials{
+		AccessKeyID:     "testkey",
+		SecretAccessKey: "testsecret",
+		SessionToken:    "testtoken",
+		Expiration:      time.Now().Add(time.Hour),
+	}
+	if err = WriteToFile(&c, link, "test", ""); err != nil {
+		t.Fatal("Could not write credentials: ", err)
+	}
+
+	// The link is kept and the credentials are written to its target.
+	fi, err := os.Lstat(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("expected %s to stay a symlink", link)
+	}
+	b, err := ioutil.ReadFile(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(b, []byte("testkey")) {
+		t.Errorf("expected credentials in %s, received %q", target, b)
+	}
+}
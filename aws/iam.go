@@ -0,0 +1,159 @@
+package aws
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+)
+
+// GetMaxSessionDuration returns the maximum session duration, in seconds, allowed by the IAM role
+// with the given ARN. The role is looked up using the given credentials, which requires the
+// iam:GetRole permission.
+func GetMaxSessionDuration(c *Credentials, roleArn string) (int64, error) {
+	sess, err := newSession(c)
+	if err != nil {
+		return 0, err
+	}
+
+	return getMaxSessionDuration(iam.New(sess), roleArn)
+}
+
+func getMaxSessionDuration(svc iamiface.IAMAPI, roleArn string) (int64, error) {
+	resp, err := svc.GetRole(&iam.GetRoleInput{RoleName: aws.String(RoleName(roleArn))})
+	if err != nil {
+		return 0, fmt.Errorf("getting role: %v", err)
+	}
+
+	if resp.Role == nil || resp.Role.MaxSessionDuration == nil {
+		return 0, fmt.Errorf("no maximum session duration returned for role %s", roleArn)
+	}
+
+	return *resp.Role.MaxSessionDuration, nil
+}
+
+// GetAccountAlias returns the alias of the AWS account the given credentials belong to, which
+// requires the iam:ListAccountAliases permission. An error is returned if the account has no alias.
+func GetAccountAlias(c *Credentials) (string, error) {
+	sess, err := newSession(c)
+	if err != nil {
+		return "", err
+	}
+
+	return getAccountAlias(iam.New(sess))
+}
+
+func getAccountAlias(svc iamiface.IAMAPI) (string, error) {
+	resp, err := svc.ListAccountAliases(&iam.ListAccountAliasesInput{})
+	if err != nil {
+		return "", fmt.Errorf("listing account aliases: %v", err)
+	}
+
+	// An account has at most one alias.
+	if len(resp.AccountAliases) == 0 || resp.AccountAliases[0] == nil {
+		return "", errors.New("account has no alias")
+	}
+
+	return *resp.AccountAliases[0], nil
+}
+
+// ErrPoliciesDenied is returned by GetRolePolicies when the credentials aren't allowed to list the
+// policies of the role.
+var ErrPoliciesDenied = errors.New("not allowed to list role policies")
+
+// RolePolicies holds the names of the managed policies attached to an IAM role and of the role's
+// inline policies.
+type RolePolicies struct {
+	Attached []string
+	Inline   []string
+}
+
+// GetRolePolicies returns the policies of the IAM role with the given ARN, which requires the
+// iam:ListAttachedRolePolicies and iam:ListRolePolicies permissions. ErrPoliciesDenied is returned
+// if either permission is missing.
+func GetRolePolicies(c *Credentials, roleArn string) (*RolePolicies, error) {
+	sess, err := newSession(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return getRolePolicies(iam.New(sess), roleArn)
+}
+
+func getRolePolicies(svc iamiface.IAMAPI, roleArn string) (*RolePolicies, error) {
+	name := aws.String(RoleName(roleArn))
+	var p RolePolicies
+
+	err := svc.ListAttachedRolePoliciesPages(
+		&iam.ListAttachedRolePoliciesInput{RoleName: name},
+		func(out *iam.ListAttachedRolePoliciesOutput, last bool) bool {
+			for _, ap := range out.AttachedPolicies {
+				p.Attached = append(p.Attached, aws.StringValue(ap.PolicyName))
+			}
+			return true
+		},
+	)
+	if err != nil {
+		return nil, policiesError("listing attached role policies", err)
+	}
+
+	err = svc.ListRolePoliciesPages(
+		&iam.ListRolePoliciesInput{RoleName: name},
+		func(out *iam.ListRolePoliciesOutput, last bool) bool {
+			p.Inline = append(p.Inline, aws.StringValueSlice(out.PolicyNames)...)
+			return true
+		},
+	)
+	if err != nil {
+		return nil, policiesError("listing inline role policies", err)
+	}
+
+	return &p, nil
+}
+
+func policiesError(action string, err error) error {
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "AccessDenied" {
+		return ErrPoliciesDenied
+	}
+
+	return fmt.Errorf("%s: %v", action, err)
+}
+
+// AccountID returns the ID of the AWS account of the IAM role with the given ARN, e.g.
+// 123456789012 for arn:aws:iam::123456789012:role/MyRole.
+func AccountID(roleArn string) string {
+	parts := strings.Split(roleArn, ":")
+	if len(parts) < 5 {
+		return ""
+	}
+
+	return parts[4]
+}
+
+// newSession returns an AWS session which uses the given temporary credentials. The region of the
+// credentials is used if they have one, which also selects the partition and the regional STS
+// endpoint.
+func newSession(c *Credentials) (*session.Session, error) {
+	cfg := aws.NewConfig().
+		WithCredentials(credentials.NewStaticCredentials(c.AccessKeyID, c.SecretAccessKey, c.SessionToken)).
+		// IAM is a global service, however the SDK requires a region to be set.
+		WithRegion("us-east-1")
+	if c.Region != "" {
+		cfg = cfg.WithRegion(c.Region).WithSTSRegionalEndpoint(endpoints.RegionalSTSEndpoint)
+	}
+
+	return session.NewSession(cfg)
+}
+
+// RoleName returns the name of the IAM role with the given ARN, e.g. MyRole for
+// arn:aws:iam::123456789012:role/path/MyRole.
+func RoleName(roleArn string) string {
+	return roleArn[strings.LastIndex(roleArn, "/")+1:]
+}
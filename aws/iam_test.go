@@ -0,0 +1,205 @@
+package aws
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+)
+
+type mockIAM struct {
+	iamiface.IAMAPI
+
+	roles   map[string]int64
+	aliases []string
+	denied  bool
+
+	// attached and inline map role names to pages of policy names.
+	attached map[string][][]string
+	inline   map[string][][]string
+}
+
+func (m *mockIAM) ListAccountAliases(*iam.ListAccountAliasesInput) (*iam.ListAccountAliasesOutput, error) {
+	if m.denied {
+		return nil, errors.New("AccessDenied")
+	}
+
+	return &iam.ListAccountAliasesOutput{AccountAliases: aws.StringSlice(m.aliases)}, nil
+}
+
+func (m *mockIAM) GetRole(in *iam.GetRoleInput) (*iam.GetRoleOutput, error) {
+	d, ok := m.roles[*in.RoleName]
+	if !ok {
+		return nil, errors.New("AccessDenied")
+	}
+
+	return &iam.GetRoleOutput{Role: &iam.Role{RoleName: in.RoleName, MaxSessionDuration: aws.Int64(d)}}, nil
+}
+
+func (m *mockIAM) ListAttachedRolePoliciesPages(in *iam.ListAttachedRolePoliciesInput, fn func(*iam.ListAttachedRolePoliciesOutput, bool) bool) error {
+	pages, ok := m.attached[*in.RoleName]
+	if !ok {
+		return awserr.New("AccessDenied", "not authorized to perform iam:ListAttachedRolePolicies", nil)
+	}
+
+	for i, page := range pages {
+		out := &iam.ListAttachedRolePoliciesOutput{}
+		for _, name := range page {
+			out.AttachedPolicies = append(out.AttachedPolicies, &iam.AttachedPolicy{PolicyName: aws.String(name)})
+		}
+		if !fn(out, i == len(pages)-1) {
+			break
+		}
+	}
+
+	return nil
+}
+
+func (m *mockIAM) ListRolePoliciesPages(in *iam.ListRolePoliciesInput, fn func(*iam.ListRolePoliciesOutput, bool) bool) error {
+	pages, ok := m.inline[*in.RoleName]
+	if !ok {
+		return awserr.New("AccessDenied", "not authorized to perform iam:ListRolePolicies", nil)
+	}
+
+	for i, page := range pages {
+		if !fn(&iam.ListRolePoliciesOutput{PolicyNames: aws.StringSlice(page)}, i == len(pages)-1) {
+			break
+		}
+	}
+
+	return nil
+}
+
+func TestGetMaxSessionDuration(t *testing.T) {
+	svc := &mockIAM{roles: map[string]int64{"MyRole": 14400}}
+
+	for _, test := range []struct {
+		name        string
+		arn         string
+		expect      int64
+		expectError bool
+	}{
+		{"Role", "arn:aws:iam::123456789012:role/MyRole", 14400, false},
+		{"Role with path", "arn:aws:iam::123456789012:role/some/path/MyRole", 14400, false},
+		{"Access denied", "arn:aws:iam::123456789012:role/OtherRole", 0, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			d, err := getMaxSessionDuration(svc, test.arn)
+			if test.expectError && err == nil {
+				t.Errorf("expected error")
+			}
+			if !test.expectError && err != nil {
+				t.Errorf("unexpected error %+v", err)
+			}
+			if d != test.expect {
+				t.Errorf("expected %d, received %d", test.expect, d)
+			}
+		})
+	}
+}
+
+func TestGetAccountAlias(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		svc         *mockIAM
+		expect      string
+		expectError bool
+	}{
+		{"Alias", &mockIAM{aliases: []string{"my-company-prod"}}, "my-company-prod", false},
+		{"No alias", &mockIAM{}, "", true},
+		{"Access denied", &mockIAM{denied: true}, "", true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := getAccountAlias(test.svc)
+			if test.expectError && err == nil {
+				t.Errorf("expected error")
+			}
+			if !test.expectError && err != nil {
+				t.Errorf("unexpected error %+v", err)
+			}
+			if res != test.expect {
+				t.Errorf("expected %q, received %q", test.expect, res)
+			}
+		})
+	}
+}
+
+func TestAccountID(t *testing.T) {
+	for arn, expect := range map[string]string{
+		"arn:aws:iam::123456789012:role/MyRole":      "123456789012",
+		"arn:aws:iam::210987654321:role/path/MyRole": "210987654321",
+		"invalid": "",
+	} {
+		if res := AccountID(arn); res != expect {
+			t.Errorf("%s: expected %q, received %q", arn, expect, res)
+		}
+	}
+}
+
+func TestGetRolePolicies(t *testing.T) {
+	svc := &mockIAM{
+		attached: map[string][][]string{
+			"MyRole":     {{"ReadOnlyAccess", "AmazonS3FullAccess"}, {"Billing"}},
+			"InlineOnly": {},
+			"NoInline":   {{"ReadOnlyAccess"}},
+		},
+		inline: map[string][][]string{
+			"MyRole":     {{"deny-iam"}},
+			"InlineOnly": {{"custom-a"}, {"custom-b"}},
+		},
+	}
+
+	for _, test := range []struct {
+		name        string
+		arn         string
+		expect      *RolePolicies
+		expectError error
+	}{
+		{
+			"Attached and inline policies",
+			"arn:aws:iam::123456789012:role/MyRole",
+			&RolePolicies{Attached: []string{"ReadOnlyAccess", "AmazonS3FullAccess", "Billing"}, Inline: []string{"deny-iam"}},
+			nil,
+		},
+		{
+			"Inline policies only",
+			"arn:aws:iam::123456789012:role/path/InlineOnly",
+			&RolePolicies{Inline: []string{"custom-a", "custom-b"}},
+			nil,
+		},
+		{"Inline policies denied", "arn:aws:iam::123456789012:role/NoInline", nil, ErrPoliciesDenied},
+		{"Access denied", "arn:aws:iam::123456789012:role/OtherRole", nil, ErrPoliciesDenied},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := getRolePolicies(svc, test.arn)
+			if err != test.expectError {
+				t.Fatalf("expected error %v, received %v", test.expectError, err)
+			}
+			if !reflect.DeepEqual(p, test.expect) {
+				t.Errorf("expected %+v, received %+v", test.expect, p)
+			}
+		})
+	}
+}
+
+func TestNewSession(t *testing.T) {
+	for _, test := range []struct {
+		region string
+		expect string
+	}{
+		{"", "us-east-1"},
+		{"cn-north-1", "cn-north-1"},
+	} {
+		sess, err := newSession(&Credentials{Region: test.region})
+		if err != nil {
+			t.Fatalf("unexpected error %+v", err)
+		}
+		if received := *sess.Config.Region; received != test.expect {
+			t.Errorf("expected %q, received %q", test.expect, received)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package aws
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// The environment variables which point the AWS CLI and SDKs to a container credentials endpoint,
+// such as the one served by NewCredentialsHandler.
+const (
+	ContainerCredentialsURIVar     = "AWS_CONTAINER_CREDENTIALS_FULL_URI"
+	ContainerAuthorizationTokenVar = "AWS_CONTAINER_AUTHORIZATION_TOKEN"
+)
+
+// containerCredentialsOutput is the format in which the AWS CLI and SDKs expect credentials from a
+// container credentials endpoint
+// (https://docs.aws.amazon.com/AmazonECS/latest/developerguide/task-iam-roles.html), which is also
+// served by aws-vault's exec server.
+type containerCredentialsOutput struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	Token           string `json:"Token"`
+	Expiration      string `json:"Expiration"`
+}
+
+// NewCredentialsHandler returns an http.Handler which serves the credentials returned by get in
+// the format of a container credentials endpoint. Requests are only served if their Authorization
+// header is token, which the clients read from AWS_CONTAINER_AUTHORIZATION_TOKEN.
+func NewCredentialsHandler(token string, get func() (*Credentials, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(token)) != 1 {
+			http.Error(w, "invalid authorization token", http.StatusForbidden)
+			return
+		}
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		c, err := get()
+		if err != nil {
+			log.Printf(color.RedString("Error getting credentials for %s: %v"), r.RemoteAddr, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(containerCredentialsOutput{
+			AccessKeyID:     c.AccessKeyID,
+			SecretAccessKey: c.SecretAccessKey,
+			Token:           c.SessionToken,
+			Expiration:      c.Expiration.UTC().Format(time.RFC3339),
+		})
+		if err != nil {
+			log.Printf(color.YellowString("Error writing credentials to %s: %v"), r.RemoteAddr, err)
+		}
+	})
+}
@@ -0,0 +1,91 @@
+package aws
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewCredentialsHandler(t *testing.T) {
+	exp := time.Date(2021, 2, 10, 10, 0, 0, 0, time.UTC)
+	calls := 0
+	var getErr error
+	srv := httptest.NewServer(NewCredentialsHandler("secret-token", func() (*Credentials, error) {
+		calls++
+		if getErr != nil {
+			return nil, getErr
+		}
+		return &Credentials{
+			AccessKeyID:     "expectedkey",
+			SecretAccessKey: "expectedsecret",
+			SessionToken:    "expectedtoken",
+			Expiration:      exp,
+		}, nil
+	}))
+	defer srv.Close()
+
+	request := func(method, token string) *http.Response {
+		req, err := http.NewRequest(method, srv.URL+"/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error %+v", err)
+		}
+		return resp
+	}
+
+	for _, tc := range []struct {
+		name   string
+		method string
+		token  string
+		expect int
+	}{
+		{name: "no token", method: http.MethodGet, expect: http.StatusForbidden},
+		{name: "wrong token", method: http.MethodGet, token: "other-token", expect: http.StatusForbidden},
+		{name: "wrong method", method: http.MethodPost, token: "secret-token", expect: http.StatusMethodNotAllowed},
+	} {
+		resp := request(tc.method, tc.token)
+		resp.Body.Close()
+		if resp.StatusCode != tc.expect {
+			t.Errorf("%s: expected status %d, received %d", tc.name, tc.expect, resp.StatusCode)
+		}
+	}
+	if calls != 0 {
+		t.Errorf("expected no credentials to be obtained for rejected requests, received %d calls", calls)
+	}
+
+	resp := request(http.MethodGet, "secret-token")
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d, received %d", http.StatusOK, resp.StatusCode)
+	}
+	var out map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	for k, v := range map[string]string{
+		"AccessKeyId":     "expectedkey",
+		"SecretAccessKey": "expectedsecret",
+		"Token":           "expectedtoken",
+		"Expiration":      "2021-02-10T10:00:00Z",
+	} {
+		if out[k] != v {
+			t.Errorf("%s: expected %q, received %q", k, v, out[k])
+		}
+	}
+
+	getErr = errors.New("authentication failed")
+	resp = request(http.MethodGet, "secret-token")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected status %d, received %d", http.StatusInternalServerError, resp.StatusCode)
+	}
+}
@@ -0,0 +1,50 @@
+package aws
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// socketTimeout limits the time for connecting to and writing to a socket.
+const socketTimeout = 5 * time.Second
+
+// WriteToSocket writes credentials as JSON, in the format used for credential_process (see
+// WriteCredentialProcess), to the Unix domain socket at path. The socket has to belong to the
+// current user and must not be writable by anyone else. Connecting is tried up to attempts times,
+// waiting backoff between attempts, so that a local agent which is starting up can be reached.
+func WriteToSocket(c *Credentials, path string, attempts int, backoff time.Duration) error {
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	var conn net.Conn
+	var err error
+	for i := 1; i <= attempts; i++ {
+		if i > 1 {
+			time.Sleep(backoff)
+		}
+
+		if err = checkSocket(path); err != nil {
+			continue
+		}
+
+		conn, err = net.DialTimeout("unix", path, socketTimeout)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("connecting to socket %s: %v", path, err)
+	}
+	defer conn.Close()
+
+	if err = conn.SetWriteDeadline(time.Now().Add(socketTimeout)); err != nil {
+		return err
+	}
+	if err = WriteCredentialProcess(c, conn); err != nil {
+		return fmt.Errorf("writing to socket %s: %v", path, err)
+	}
+
+	return nil
+}
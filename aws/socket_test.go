@@ -0,0 +1,98 @@
+// +build !windows
+
+package aws
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func listen(t *testing.T, path string, mode os.FileMode) net.Listener {
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal("Could not listen on socket: ", err)
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		t.Fatal("Could not change socket mode: ", err)
+	}
+
+	return l
+}
+
+func TestWriteToSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clisso")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "agent.sock")
+
+	l := listen(t, path, 0700)
+	defer l.Close()
+
+	received := make(chan credentialProcessOutput)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			close(received)
+			return
+		}
+		defer conn.Close()
+
+		var out credentialProcessOutput
+		if err := json.NewDecoder(conn).Decode(&out); err != nil {
+			t.Errorf("Could not decode credentials: %v", err)
+		}
+		received <- out
+	}()
+
+	c := Credentials{
+		AccessKeyID:     "testkey",
+		SecretAccessKey: "testsecret",
+		SessionToken:    "testtoken",
+		Expiration:      time.Date(2021, 3, 4, 12, 30, 0, 0, time.UTC),
+	}
+	if err := WriteToSocket(&c, path, 1, 0); err != nil {
+		t.Fatal("Could not write to socket: ", err)
+	}
+
+	out := <-received
+	if out.AccessKeyID != "testkey" || out.SecretAccessKey != "testsecret" ||
+		out.SessionToken != "testtoken" || out.Expiration != "2021-03-04T12:30:00Z" {
+		t.Errorf("Wrong credentials received: %+v", out)
+	}
+}
+
+func TestWriteToSocketErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clisso")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := Credentials{AccessKeyID: "testkey"}
+
+	if err := WriteToSocket(&c, filepath.Join(dir, "missing.sock"), 2, time.Millisecond); err == nil {
+		t.Errorf("expected error for missing socket")
+	}
+
+	path := filepath.Join(dir, "open.sock")
+	l := listen(t, path, 0766)
+	defer l.Close()
+	if err := WriteToSocket(&c, path, 1, 0); err == nil {
+		t.Errorf("expected error for socket writable by other users")
+	}
+
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteToSocket(&c, file, 1, 0); err == nil {
+		t.Errorf("expected error for regular file")
+	}
+}
@@ -0,0 +1,32 @@
+// +build !windows
+
+package aws
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// checkSocket verifies that path is a socket owned by the current user which nobody else can
+// connect to. Connecting to a Unix domain socket requires write permission on it.
+func checkSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s is not a socket", path)
+	}
+
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok && int(st.Uid) != os.Getuid() {
+		return fmt.Errorf("socket %s is owned by another user", path)
+	}
+
+	if fi.Mode().Perm()&0022 != 0 {
+		return fmt.Errorf("socket %s is writable by other users (mode %v)", path, fi.Mode().Perm())
+	}
+
+	return nil
+}
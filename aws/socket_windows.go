@@ -0,0 +1,12 @@
+// +build windows
+
+package aws
+
+import "os"
+
+// checkSocket verifies that path exists. Unix permission bits aren't meaningful on Windows, where
+// access to sockets is controlled by ACLs.
+func checkSocket(path string) error {
+	_, err := os.Lstat(path)
+	return err
+}
@@ -2,18 +2,24 @@ package aws
 
 import (
 	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
 )
 
 const (
 	// A friendly message to show to the user when a requested duration exceeds the configured
-	// maximum.
+	// maximum. The fallback duration in seconds is passed as the format argument.
 	DurationExceededMessage = "The requested duration exceeded the allowed maximum. Falling " +
-		"back to 1 hour.\nTo update the maximum session duration you can use the following " +
+		"back to %d seconds.\nTo update the maximum session duration you can use the following " +
 		"command:\n\naws iam update-role --role-name <role_name> --max-session-duration " +
 		"<duration>\n\nFor more information please refer to the AWS documentation:\n" +
 		"https://docs.aws.amazon.com/IAM/latest/UserGuide/id_roles_manage_modify.html"
@@ -22,25 +28,57 @@ const (
 	ErrInvalidSessionDuration = "The requested DurationSeconds exceeds the MaxSessionDuration " +
 		"set for this role."
 	// A custom error which indicates that the requested duration exceeded the configured maximum.
-	// TODO Replace this with a custom error type.
 	ErrDurationExceeded = "DurationExceeded"
 )
 
+// maxDurationPattern matches the maximum session duration in STS errors which include it, such as
+// the validation error returned for durations above the global limit of 12 hours.
+var maxDurationPattern = regexp.MustCompile(`(?i)(?:less than or equal to|MaxSessionDuration(?: of| is|:|=)?)\s*(\d+)`)
+
+// DurationExceededError is returned by AssumeSAMLRole when the requested duration exceeds the
+// maximum allowed by the role. Its message is ErrDurationExceeded.
+type DurationExceededError struct {
+	// Max is the maximum session duration in seconds if STS included it in the error, zero
+	// otherwise.
+	Max int64
+}
+
+func (e *DurationExceededError) Error() string {
+	return ErrDurationExceeded
+}
+
+// parseDurationExceeded returns a DurationExceededError if the given STS error message indicates
+// that the requested duration exceeded the maximum, nil otherwise.
+func parseDurationExceeded(msg string) *DurationExceededError {
+	isExceeded := strings.Contains(msg, ErrInvalidSessionDuration) ||
+		(strings.Contains(msg, "durationSeconds") && strings.Contains(msg, "less than or equal to"))
+	if !isExceeded {
+		return nil
+	}
+
+	e := &DurationExceededError{}
+	if m := maxDurationPattern.FindStringSubmatch(msg); m != nil {
+		e.Max, _ = strconv.ParseInt(m[1], 10, 64)
+	}
+
+	return e
+}
+
 // AssumeSAMLRole assumes an AWS IAM role using a SAML assertion.
 // In cases where the requested session duration is higher than the maximum allowed on AWS, STS
 // returns a specific error message to indicate that. In this case we return a custom error to the
 // caller to allow special handling such as retrying with a lower duration.
-func AssumeSAMLRole(PrincipalArn, RoleArn, SAMLAssertion string, duration int64) (*Credentials, error) {
-	creds, err := assumeSAMLRole(PrincipalArn, RoleArn, SAMLAssertion, duration)
+// If region is set, the regional STS endpoint of that region is used and the returned credentials
+// are marked as belonging to the region.
+func AssumeSAMLRole(PrincipalArn, RoleArn, SAMLAssertion string, duration int64, region string) (*Credentials, error) {
+	creds, err := assumeSAMLRole(PrincipalArn, RoleArn, SAMLAssertion, duration, region)
 	if err != nil {
 		// Verify error is an AWS error.
 		if awsErr, ok := err.(awserr.Error); ok {
 			// Check if error indicates exceeded duration.
-			if awsErr.Message() == ErrInvalidSessionDuration {
+			if e := parseDurationExceeded(awsErr.Message()); e != nil {
 				// Return a custom error to allow the caller to retry etc.
-				// TODO Return a custom error type instead of a special value:
-				// https://dave.cheney.net/2014/12/24/inspecting-errors
-				return nil, errors.New(ErrDurationExceeded)
+				return nil, e
 			}
 		}
 		return nil, err
@@ -49,7 +87,7 @@ func AssumeSAMLRole(PrincipalArn, RoleArn, SAMLAssertion string, duration int64)
 	return creds, nil
 }
 
-func assumeSAMLRole(PrincipalArn, RoleArn, SAMLAssertion string, duration int64) (*Credentials, error) {
+func assumeSAMLRole(PrincipalArn, RoleArn, SAMLAssertion string, duration int64, region string) (*Credentials, error) {
 	input := sts.AssumeRoleWithSAMLInput{
 		PrincipalArn:    aws.String(PrincipalArn),
 		RoleArn:         aws.String(RoleArn),
@@ -57,7 +95,12 @@ func assumeSAMLRole(PrincipalArn, RoleArn, SAMLAssertion string, duration int64)
 		DurationSeconds: aws.Int64(duration),
 	}
 
-	sess := session.Must(session.NewSession())
+	cfg := aws.NewConfig()
+	if region != "" {
+		cfg = cfg.WithRegion(region).WithSTSRegionalEndpoint(endpoints.RegionalSTSEndpoint)
+	}
+
+	sess := session.Must(session.NewSession(cfg))
 	svc := sts.New(sess)
 
 	aResp, err := svc.AssumeRoleWithSAML(&input)
@@ -75,7 +118,50 @@ func assumeSAMLRole(PrincipalArn, RoleArn, SAMLAssertion string, duration int64)
 		SecretAccessKey: secretKey,
 		SessionToken:    sessionToken,
 		Expiration:      expiration,
+		Region:          region,
+		RoleArn:         RoleArn,
 	}
 
 	return &creds, nil
 }
+
+// ErrCredentialsRejected is returned by GetCallerIdentity when AWS rejects the credentials, e.g.
+// because the session was revoked.
+var ErrCredentialsRejected = errors.New("credentials rejected by AWS")
+
+// rejectedCodes are the error codes STS returns for credentials which are invalid or no longer
+// valid.
+var rejectedCodes = []string{
+	"AccessDenied",
+	"ExpiredToken",
+	"InvalidClientTokenId",
+	"SignatureDoesNotMatch",
+}
+
+// GetCallerIdentity returns the ARN of the identity the given credentials belong to, e.g. the ARN
+// of the assumed role session. No permissions are required. ErrCredentialsRejected is returned if
+// AWS doesn't accept the credentials.
+func GetCallerIdentity(c *Credentials) (string, error) {
+	sess, err := newSession(c)
+	if err != nil {
+		return "", err
+	}
+
+	return getCallerIdentity(sts.New(sess))
+}
+
+func getCallerIdentity(svc stsiface.STSAPI) (string, error) {
+	resp, err := svc.GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok {
+			for _, code := range rejectedCodes {
+				if awsErr.Code() == code {
+					return "", ErrCredentialsRejected
+				}
+			}
+		}
+		return "", fmt.Errorf("getting caller identity: %v", err)
+	}
+
+	return aws.StringValue(resp.Arn), nil
+}
@@ -0,0 +1,110 @@
+package aws
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+)
+
+func TestParseDurationExceeded(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		msg         string
+		expectError bool
+		expectMax   int64
+	}{
+		{"Role maximum", ErrInvalidSessionDuration, true, 0},
+		{
+			"Global maximum",
+			"1 validation error detected: Value '50000' at 'durationSeconds' failed to satisfy " +
+				"constraint: Member must have value less than or equal to 43200",
+			true, 43200,
+		},
+		{"Role maximum included", ErrInvalidSessionDuration + " MaxSessionDuration: 14400", true, 14400},
+		{"Other error", "Not authorized to perform sts:AssumeRoleWithSAML", false, 0},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			e := parseDurationExceeded(test.msg)
+			if !test.expectError {
+				if e != nil {
+					t.Errorf("unexpected error %+v", e)
+				}
+				return
+			}
+			if e == nil {
+				t.Fatalf("expected error")
+			}
+			if e.Error() != ErrDurationExceeded {
+				t.Errorf("expected %q, received %q", ErrDurationExceeded, e.Error())
+			}
+			if e.Max != test.expectMax {
+				t.Errorf("expected maximum %d, received %d", test.expectMax, e.Max)
+			}
+		})
+	}
+}
+
+type mockSTS struct {
+	stsiface.STSAPI
+
+	arn string
+	err error
+}
+
+func (m *mockSTS) GetCallerIdentity(*sts.GetCallerIdentityInput) (*sts.GetCallerIdentityOutput, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	return &sts.GetCallerIdentityOutput{Arn: aws.String(m.arn)}, nil
+}
+
+func TestGetCallerIdentity(t *testing.T) {
+	arn := "arn:aws:sts::123456789012:assumed-role/Admin/user@example.com"
+
+	for _, test := range []struct {
+		name           string
+		svc            *mockSTS
+		expectArn      string
+		expectRejected bool
+		expectError    bool
+	}{
+		{name: "Valid", svc: &mockSTS{arn: arn}, expectArn: arn},
+		{
+			name:           "Revoked",
+			svc:            &mockSTS{err: awserr.New("InvalidClientTokenId", "The security token included in the request is invalid.", nil)},
+			expectRejected: true,
+			expectError:    true,
+		},
+		{
+			name:           "Expired",
+			svc:            &mockSTS{err: awserr.New("ExpiredToken", "The security token included in the request is expired", nil)},
+			expectRejected: true,
+			expectError:    true,
+		},
+		{name: "Network error", svc: &mockSTS{err: errors.New("connection refused")}, expectError: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			a, err := getCallerIdentity(test.svc)
+			if !test.expectError {
+				if err != nil {
+					t.Fatalf("unexpected error %+v", err)
+				}
+				if a != test.expectArn {
+					t.Errorf("expected %s, received %s", test.expectArn, a)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected error")
+			}
+			if (err == ErrCredentialsRejected) != test.expectRejected {
+				t.Errorf("unexpected error %+v", err)
+			}
+		})
+	}
+}
@@ -3,9 +3,9 @@ package cmd
 import (
 	"fmt"
 	"log"
-	"sort"
 	"strconv"
 
+	"github.com/allcloud-io/clisso/config"
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -15,6 +15,7 @@ import (
 var provider string
 var arn string
 var duration int
+var expectedIssuer string
 
 // OneLogin
 var appID string
@@ -28,6 +29,8 @@ func init() {
 	cmdAppsCreateOneLogin.Flags().StringVar(&provider, "provider", "", "Name of the Clisso provider")
 	cmdAppsCreateOneLogin.Flags().IntVar(&duration, "duration", 0, "(Optional) Session duration in seconds")
 	cmdAppsCreateOneLogin.Flags().StringVar(&arn, "arn", "", "(Optional) preferred arn for app")
+	cmdAppsCreateOneLogin.Flags().StringVar(&expectedIssuer, "expected-issuer", "",
+		"(Optional) Reject SAML assertions not issued by this issuer")
 	mandatoryFlag(cmdAppsCreateOneLogin, "app-id")
 	mandatoryFlag(cmdAppsCreateOneLogin, "provider")
 
@@ -35,6 +38,8 @@ func init() {
 	cmdAppsCreateOkta.Flags().StringVar(&provider, "provider", "", "Name of the Clisso provider")
 	cmdAppsCreateOkta.Flags().StringVar(&URL, "url", "", "Okta app URL")
 	cmdAppsCreateOkta.Flags().IntVar(&duration, "duration", 0, "(Optional) Session duration in seconds")
+	cmdAppsCreateOkta.Flags().StringVar(&expectedIssuer, "expected-issuer", "",
+		"(Optional) Reject SAML assertions not issued by this issuer")
 	mandatoryFlag(cmdAppsCreateOkta, "provider")
 	mandatoryFlag(cmdAppsCreateOkta, "url")
 
@@ -58,23 +63,16 @@ var cmdAppsList = &cobra.Command{
 	Short: "List apps",
 	Long:  "List all configured apps.",
 	Run: func(cmd *cobra.Command, args []string) {
-		apps := viper.GetStringMap("apps")
+		apps := config.Apps()
 
 		if len(apps) == 0 {
 			fmt.Println("No apps configured")
 			return
 		}
 
-		// Sort apps alphabetically
-		keys := make([]string, 0, len(apps))
-		for k := range apps {
-			keys = append(keys, k)
-		}
-		sort.Strings(keys)
-
 		selected := viper.GetString("global.selected-app")
 
-		for _, k := range keys {
+		for _, k := range apps {
 			if k == selected {
 				log.Printf(color.GreenString("* %s"), k)
 			} else {
@@ -134,10 +132,12 @@ var cmdAppsCreateOneLogin = &cobra.Command{
 			conf["duration"] = strconv.Itoa(duration)
 		}
 
-		viper.Set(fmt.Sprintf("apps.%s", name), conf)
+		if expectedIssuer != "" {
+			conf["expected-issuer"] = expectedIssuer
+		}
 
 		// Write config to file
-		err := viper.WriteConfig()
+		err := config.Update(map[string]interface{}{fmt.Sprintf("apps.%s", name): conf})
 		if err != nil {
 			log.Fatalf(color.RedString("Error writing config: %v"), err)
 		}
@@ -185,10 +185,12 @@ var cmdAppsCreateOkta = &cobra.Command{
 			conf["duration"] = strconv.Itoa(duration)
 		}
 
-		viper.Set(fmt.Sprintf("apps.%s", name), conf)
+		if expectedIssuer != "" {
+			conf["expected-issuer"] = expectedIssuer
+		}
 
 		// Write config to file
-		err := viper.WriteConfig()
+		err := config.Update(map[string]interface{}{fmt.Sprintf("apps.%s", name): conf})
 		if err != nil {
 			log.Fatalf(color.RedString("Error writing config: %v"), err)
 		}
@@ -205,18 +207,16 @@ var cmdAppsSelect = &cobra.Command{
 		app := args[0]
 
 		if app == "" {
-			viper.Set("global.selected-app", "")
 			log.Println(color.GreenString("Unsetting selected app"))
 		} else {
 			if exists := viper.Get("apps." + app); exists == nil {
 				log.Fatalf(color.RedString("App '%s' doesn't exist"), app)
 			}
 			log.Printf(color.GreenString("Setting selected app to '%s'"), app)
-			viper.Set("global.selected-app", app)
 		}
 
 		// Write config to file
-		err := viper.WriteConfig()
+		err := config.Update(map[string]interface{}{"global.selected-app": app})
 		if err != nil {
 			log.Fatalf(color.RedString("Error writing config: %v"), err)
 		}
@@ -0,0 +1,399 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/allcloud-io/clisso/aws"
+	"github.com/allcloud-io/clisso/config"
+	"github.com/allcloud-io/clisso/saml"
+	"github.com/allcloud-io/clisso/spinner"
+	"github.com/fatih/color"
+	"github.com/spf13/viper"
+)
+
+// defaultFallbackDuration is the session duration used when the requested duration exceeds the
+// maximum allowed by the role, unless configured otherwise.
+const defaultFallbackDuration = 3600
+
+// defaultClockSkew is the difference in seconds between the local clock and that of the identity
+// provider which is tolerated when checking the validity period of assertions, unless configured
+// otherwise using global.clock-skew.
+const defaultClockSkew = 60
+
+// checkAssertion optionally verifies the assertion was issued by the expected identity provider
+// and for AWS before presenting it to STS.
+func checkAssertion(app, assertion string) error {
+	if issuer := viper.GetString(fmt.Sprintf("apps.%s.expected-issuer", app)); issuer != "" {
+		if err := saml.CheckIssuer(assertion, issuer); err != nil {
+			return err
+		}
+	}
+
+	if checkAudience(app) {
+		if err := saml.CheckAudience(assertion, saml.AWSAudience); err != nil {
+			return err
+		}
+	}
+
+	if checkValidity(app) {
+		skew := defaultClockSkew
+		if viper.IsSet("global.clock-skew") {
+			skew = viper.GetInt("global.clock-skew")
+		}
+		if skew < 0 {
+			return fmt.Errorf("invalid clock skew %d: must not be negative", skew)
+		}
+		if err := saml.CheckValidity(assertion, time.Now(), time.Duration(skew)*time.Second); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkAudience returns whether the audience of the assertions of app should be verified using
+// the following order of preference: app.check-audience -> global.check-audience
+func checkAudience(app string) bool {
+	key := fmt.Sprintf("apps.%s.check-audience", app)
+	if viper.IsSet(key) {
+		return viper.GetBool(key)
+	}
+
+	return viper.GetBool("global.check-audience")
+}
+
+// checkValidity returns whether the validity period of the assertions of app should be verified
+// using the following order of preference: app.check-validity -> global.check-validity
+func checkValidity(app string) bool {
+	key := fmt.Sprintf("apps.%s.check-validity", app)
+	if viper.IsSet(key) {
+		return viper.GetBool(key)
+	}
+
+	return viper.GetBool("global.check-validity")
+}
+
+// preferredRole returns the ARN of the role to assume for app. If alias is set, it is resolved using
+// the roles map of the app or, if the alias isn't defined there, of the global section. Otherwise
+// the app's arn is returned, which may be empty.
+func preferredRole(app, alias string) (string, error) {
+	if alias == "" {
+		return viper.GetString(fmt.Sprintf("apps.%s.arn", app)), nil
+	}
+
+	// Viper lowercases the keys of maps.
+	key := strings.ToLower(alias)
+	for _, section := range []string{fmt.Sprintf("apps.%s", app), "global"} {
+		if arn := viper.GetStringMapString(section + ".roles")[key]; arn != "" {
+			return arn, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown role alias '%s'. Define it in the roles setting of the app or of global", alias)
+}
+
+// checkRoleAlias returns an error if the role arn, which alias refers to, isn't contained in the
+// SAML assertion.
+func checkRoleAlias(assertion, alias, arn string) error {
+	roles, err := saml.Roles(assertion)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range roles {
+		if r.Role == arn {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("role alias '%s' refers to %s, which isn't contained in the SAML assertion", alias, arn)
+}
+
+// allowedRoles returns the ARNs of the roles app may assume, as listed in its allowed-roles. Any
+// role may be assumed if the list is empty.
+func allowedRoles(app string) []string {
+	return viper.GetStringSlice(fmt.Sprintf("apps.%s.allowed-roles", app))
+}
+
+// checkAllowedRole returns an error if arn is set but isn't one of the allowed roles of app.
+func checkAllowedRole(app, arn string) error {
+	allowed := allowedRoles(app)
+	if arn == "" || len(allowed) == 0 {
+		return nil
+	}
+
+	for _, r := range allowed {
+		if r == arn {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("role %s isn't in the allowed-roles of app %s", arn, app)
+}
+
+// appRoles returns the roles of the assertion which app may assume, i.e. those of its saml-provider
+// (if set) which are in its allowed-roles (if set). An error is returned if no role remains.
+func appRoles(app, assertion string) ([]saml.ARN, error) {
+	arns, err := saml.Roles(assertion)
+	if err != nil {
+		return nil, err
+	}
+	if provider := viper.GetString(fmt.Sprintf("apps.%s.saml-provider", app)); provider != "" {
+		if arns = saml.FilterProvider(arns, provider); len(arns) == 0 {
+			return nil, fmt.Errorf("no AWS role can be assumed using the SAML provider %s", provider)
+		}
+	}
+	if allowed := allowedRoles(app); len(allowed) > 0 {
+		if arns = saml.FilterRoles(arns, allowed); len(arns) == 0 {
+			return nil, fmt.Errorf("%v (allowed-roles of app %s: %s)", saml.ErrNoAllowedRoles, app, strings.Join(allowed, ", "))
+		}
+	}
+
+	return arns, nil
+}
+
+// checkAccount compares the account of the role roleArn with the expected-account of app, if set.
+// A mismatch is an error if expectedAccountStrict is true. Otherwise a warning is printed, unless in
+// quiet mode.
+func checkAccount(app, roleArn string) error {
+	expected := viper.GetString(fmt.Sprintf("apps.%s.expected-account", app))
+	if expected == "" {
+		return nil
+	}
+
+	account := aws.AccountID(roleArn)
+	if account == expected {
+		return nil
+	}
+
+	err := fmt.Errorf("role %s belongs to account %s, but app %s expects account %s", roleArn, account, app, expected)
+	if expectedAccountStrict(app) {
+		return err
+	}
+	if !viper.GetBool("global.quiet") {
+		log.Printf(color.YellowString("Warning: %v"), err)
+	}
+
+	return nil
+}
+
+// expectedAccountStrict returns whether a role outside the expected-account of app must not be
+// assumed using the following order of preference: app.expected-account-strict ->
+// global.expected-account-strict
+func expectedAccountStrict(app string) bool {
+	key := fmt.Sprintf("apps.%s.expected-account-strict", app)
+	if viper.IsSet(key) {
+		return viper.GetBool(key)
+	}
+
+	return viper.GetBool("global.expected-account-strict")
+}
+
+// globalSTSWarning ensures the warning about the global STS endpoint is printed at most once per
+// run, even when assuming several roles.
+var globalSTSWarning sync.Once
+
+// warnGlobalSTS prints a warning suggesting to configure a region if roles are assumed using the
+// global STS endpoint, i.e. no region is given. The warning isn't printed in quiet mode or if
+// global.sts-global-endpoint is set to opt into the global endpoint deliberately.
+func warnGlobalSTS(region string) {
+	if region != "" || viper.GetBool("global.quiet") || viper.GetBool("global.sts-global-endpoint") {
+		return
+	}
+
+	globalSTSWarning.Do(func() {
+		log.Println(color.YellowString("Using the global STS endpoint, which AWS recommends against. " +
+			"Configure regions for the app or use --region to use a regional STS endpoint instead, " +
+			"or set global.sts-global-endpoint to true to hide this warning."))
+	})
+}
+
+// assumeRole selects an IAM role from the given SAML assertion and assumes it using the assertion.
+// pArn is the preferred role ARN for the app, if any. If the requested duration exceeds the
+// maximum allowed by the role, the role is assumed again using a fallback duration and the
+// maximum is recorded in the app's config for future runs. If region is set, the role is assumed
+// using the regional STS endpoint of that region. Only the allowed roles of the app are considered,
+// and only for the app's saml-provider if it's set.
+// The ARN of the assumed role is returned along with the credentials.
+func assumeRole(app, assertion, pArn string, duration int64, region string) (*aws.Credentials, string, error) {
+	if err := checkAssertion(app, assertion); err != nil {
+		return nil, "", err
+	}
+	if err := checkAllowedRole(app, pArn); err != nil {
+		return nil, "", err
+	}
+
+	arn, err := saml.Select(assertion, saml.Preferences{
+		Role:     pArn,
+		Provider: viper.GetString(fmt.Sprintf("apps.%s.saml-provider", app)),
+		Allowed:  allowedRoles(app),
+	})
+	if err == saml.ErrNoAllowedRoles {
+		return nil, "", fmt.Errorf("%v (allowed-roles of app %s: %s)", err, app, strings.Join(allowedRoles(app), ", "))
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	duration = checkDuration(app, arn.Role, duration)
+
+	creds, fallback, err := assumeWithFallback(app, arn, assertion, duration, region, "")
+	if err != nil {
+		return nil, "", err
+	}
+	if fallback != 0 {
+		recordMaxDuration(app, arn.Role, creds, fallback)
+	}
+
+	return creds, arn.Role, nil
+}
+
+// assumeWithFallback assumes the role of arn using the given assertion. If the requested duration
+// exceeds the maximum allowed by the role, the role is assumed again using the durations returned
+// by fallbackDurations. The duration which succeeded after falling back is returned, or zero if no
+// fallback was needed. The account of the role is verified using checkAccount before assuming it.
+// message is shown next to the spinner while assuming the role.
+func assumeWithFallback(app string, arn saml.ARN, assertion string, duration int64, region, message string) (*aws.Credentials, int64, error) {
+	if err := checkAccount(app, arn.Role); err != nil {
+		return nil, 0, err
+	}
+	warnGlobalSTS(region)
+
+	max := assertionMaxDuration(assertion)
+	if d := clampDuration(duration, max); d != duration {
+		if d < duration && !viper.GetBool("global.quiet") {
+			log.Printf(color.YellowString("The session duration of %d seconds exceeds the maximum of %d "+
+				"seconds allowed by the SAML assertion. Using %d seconds instead."), duration, max, d)
+		}
+		duration = d
+	}
+
+	s := spinner.New()
+	s.SetMessage(message)
+	s.Start()
+	creds, err := aws.AssumeSAMLRole(arn.Provider, arn.Role, assertion, duration, region)
+	s.Stop()
+	exceeded, ok := err.(*aws.DurationExceededError)
+	if !ok {
+		return creds, 0, err
+	}
+
+	for _, d := range fallbackDurations(app, exceeded.Max, duration) {
+		log.Printf(color.YellowString(aws.DurationExceededMessage), d)
+		s.Start()
+		creds, err = aws.AssumeSAMLRole(arn.Provider, arn.Role, assertion, d, region)
+		s.Stop()
+		if err == nil {
+			return creds, d, nil
+		}
+		if _, ok := err.(*aws.DurationExceededError); !ok {
+			return nil, 0, err
+		}
+	}
+
+	return nil, 0, err
+}
+
+// assertionMaxDuration returns the SessionDuration of the assertion, or zero if it has none or it
+// can't be read. AWS enforces SessionDuration only for console sessions, but it's applied to all
+// sessions: it's the longest session the identity provider grants the user, so clisso doesn't
+// hand out credentials which outlive it.
+func assertionMaxDuration(assertion string) int64 {
+	max, err := saml.SessionDuration(assertion)
+	if err != nil {
+		log.Printf(color.YellowString("Ignoring the session duration of the SAML assertion: %v"), err)
+		return 0
+	}
+
+	return max
+}
+
+// clampDuration returns the session duration to request from STS for the requested duration. It is
+// limited to the range allowed by STS and, if assertionMax (the SessionDuration of the assertion)
+// isn't zero, to at most assertionMax. Shorter durations are requested as is, regardless of the
+// SessionDuration of the assertion.
+func clampDuration(requested, assertionMax int64) int64 {
+	if assertionMax > 0 && requested > assertionMax {
+		requested = assertionMax
+	}
+	if requested < minDuration {
+		requested = minDuration
+	}
+
+	return requested
+}
+
+// fallbackDurations returns the session durations to try, in order, after the requested duration
+// exceeded the maximum allowed by a role: the maximum returned by STS (if known), followed by the
+// fallback duration configured for app. Durations which aren't shorter than the requested one are
+// omitted.
+func fallbackDurations(app string, max, requested int64) []int64 {
+	var durations []int64
+	if max > 0 && max < requested {
+		durations = append(durations, max)
+	}
+
+	if d := fallbackDuration(app); d < requested && d != max {
+		durations = append(durations, d)
+	}
+
+	return durations
+}
+
+// fallbackDuration returns the fallback session duration for app using the following order of
+// preference: app.fallback-duration -> global.fallback-duration -> hardcoded default of 3600
+func fallbackDuration(app string) int64 {
+	if d := viper.GetInt64(fmt.Sprintf("apps.%s.fallback-duration", app)); d > 0 {
+		return d
+	}
+
+	if d := viper.GetInt64("global.fallback-duration"); d > 0 {
+		return d
+	}
+
+	return defaultFallbackDuration
+}
+
+// checkDuration returns the session duration to request for role when using app. If a maximum
+// session duration has previously been recorded for the role and the requested duration exceeds
+// it, a warning is printed and the recorded maximum is returned instead, saving a round-trip to
+// STS which is known to fail.
+func checkDuration(app, role string, duration int64) int64 {
+	max := viper.GetInt64(fmt.Sprintf("apps.%s.max-duration", app))
+	if max == 0 || duration <= max || viper.GetString(fmt.Sprintf("apps.%s.max-duration-role", app)) != role {
+		return duration
+	}
+
+	log.Printf(
+		color.YellowString("The session duration of %d seconds configured for app '%s' exceeds the "+
+			"maximum of %d seconds allowed by role '%s'. Using %d seconds instead.\nPlease update "+
+			"the duration in the config file. If the maximum was raised on the role, remove the "+
+			"app's 'max-duration' config value."),
+		duration, app, max, role, max,
+	)
+
+	return max
+}
+
+// recordMaxDuration saves the maximum session duration of role in the config of app. The maximum
+// is read from the role using the given credentials if they allow it. Otherwise the fallback
+// duration, which is known to work, is recorded.
+func recordMaxDuration(app, role string, creds *aws.Credentials, fallback int64) {
+	max, err := aws.GetMaxSessionDuration(creds, role)
+	if err != nil {
+		max = fallback
+	} else {
+		log.Printf(color.YellowString("The maximum session duration allowed by role '%s' is %d seconds"), role, max)
+	}
+
+	if err := config.Update(map[string]interface{}{
+		fmt.Sprintf("apps.%s.max-duration", app):      max,
+		fmt.Sprintf("apps.%s.max-duration-role", app): role,
+	}); err != nil {
+		log.Printf(color.YellowString("Could not record the maximum session duration: %v"), err)
+	}
+}
@@ -0,0 +1,356 @@
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/allcloud-io/clisso/saml"
+)
+
+func TestCheckDuration(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	role := "arn:aws:iam::123456789012:role/MyRole"
+
+	for _, test := range []struct {
+		name       string
+		max        int64
+		maxRole    string
+		duration   int64
+		expect     int64
+		expectWarn bool
+	}{
+		{"Nothing recorded", 0, "", 14400, 14400, false},
+		{"Within recorded maximum", 7200, role, 3600, 3600, false},
+		{"Equal to recorded maximum", 7200, role, 7200, 7200, false},
+		{"Exceeds recorded maximum", 7200, role, 14400, 7200, true},
+		{"Maximum recorded for another role", 7200, "arn:aws:iam::123456789012:role/Other", 14400, 14400, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			buf.Reset()
+			viper.Set("apps.test.max-duration", test.max)
+			viper.Set("apps.test.max-duration-role", test.maxRole)
+
+			res := checkDuration("test", role, test.duration)
+			if res != test.expect {
+				t.Errorf("expected duration %d, received %d", test.expect, res)
+			}
+
+			warned := buf.Len() > 0
+			if warned != test.expectWarn {
+				t.Errorf("expected warning: %v, received: %q", test.expectWarn, buf.String())
+			}
+		})
+	}
+}
+
+func TestCheckAudience(t *testing.T) {
+	defer viper.Set("global.check-audience", nil)
+	defer viper.Set("apps.audience-app.check-audience", nil)
+
+	for _, test := range []struct {
+		name   string
+		global interface{}
+		app    interface{}
+		expect bool
+	}{
+		{"Default", nil, nil, false},
+		{"Global", true, nil, true},
+		{"App", nil, true, true},
+		{"App overrides global", true, false, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			viper.Set("global.check-audience", test.global)
+			viper.Set("apps.audience-app.check-audience", test.app)
+
+			if res := checkAudience("audience-app"); res != test.expect {
+				t.Errorf("expected %v, received %v", test.expect, res)
+			}
+		})
+	}
+}
+
+func TestCheckAssertionValidity(t *testing.T) {
+	b, err := ioutil.ReadFile("../saml/testdata/validity-response")
+	if err != nil {
+		t.Fatalf("could not read test data: %v", err)
+	}
+	defer viper.Set("global.check-validity", nil)
+	defer viper.Set("apps.validity-app.check-validity", nil)
+
+	// The assertion expired long ago, which is only detected if the check is enabled.
+	if err = checkAssertion("validity-app", string(b)); err != nil {
+		t.Errorf("unexpected error %+v", err)
+	}
+
+	viper.Set("global.check-validity", true)
+	if err = checkAssertion("validity-app", string(b)); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("expected error for expired assertion, received %v", err)
+	}
+
+	viper.Set("apps.validity-app.check-validity", false)
+	if err = checkAssertion("validity-app", string(b)); err != nil {
+		t.Errorf("unexpected error %+v", err)
+	}
+}
+
+func TestFallbackDurations(t *testing.T) {
+	defer viper.Set("global.fallback-duration", nil)
+	defer viper.Set("apps.fallback-app.fallback-duration", nil)
+
+	for _, test := range []struct {
+		name      string
+		global    int64
+		app       int64
+		max       int64
+		requested int64
+		expect    []int64
+	}{
+		{"Default fallback", 0, 0, 0, 43200, []int64{3600}},
+		{"Maximum from error", 0, 0, 14400, 43200, []int64{14400, 3600}},
+		{"Maximum equals fallback", 0, 0, 3600, 43200, []int64{3600}},
+		{"Global fallback", 7200, 0, 0, 43200, []int64{7200}},
+		{"App fallback overrides global", 7200, 10800, 0, 43200, []int64{10800}},
+		{"Fallback not shorter than requested", 0, 0, 0, 3600, nil},
+		{"Maximum not shorter than requested", 0, 0, 43200, 14400, []int64{3600}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			viper.Set("global.fallback-duration", test.global)
+			viper.Set("apps.fallback-app.fallback-duration", test.app)
+
+			res := fallbackDurations("fallback-app", test.max, test.requested)
+			if !reflect.DeepEqual(res, test.expect) {
+				t.Errorf("expected %v, received %v", test.expect, res)
+			}
+		})
+	}
+}
+
+func TestClampDuration(t *testing.T) {
+	for _, test := range []struct {
+		name         string
+		requested    int64
+		assertionMax int64
+		expect       int64
+	}{
+		{"No SessionDuration", 43200, 0, 43200},
+		{"Shorter than SessionDuration", 1800, 43200, 1800},
+		{"Much shorter than SessionDuration", 900, 43200, 900},
+		{"Equal to SessionDuration", 7200, 7200, 7200},
+		{"Longer than SessionDuration", 43200, 7200, 7200},
+		{"Below STS minimum", 600, 43200, 900},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			res := clampDuration(test.requested, test.assertionMax)
+			if res != test.expect {
+				t.Errorf("expected %d, received %d", test.expect, res)
+			}
+		})
+	}
+}
+
+func TestAssertionMaxDuration(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		path   string
+		expect int64
+	}{
+		{"SessionDuration", "../saml/testdata/session-duration-response", 7200},
+		{"Invalid SessionDuration", "../saml/testdata/invalid-session-duration-response", 0},
+		{"No SessionDuration", "../saml/testdata/valid-response", 0},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			b, err := ioutil.ReadFile(test.path)
+			if err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+
+			if res := assertionMaxDuration(string(b)); res != test.expect {
+				t.Errorf("expected %d, received %d", test.expect, res)
+			}
+		})
+	}
+}
+
+func TestWarnGlobalSTS(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	defer viper.Set("global.quiet", false)
+	defer viper.Set("global.sts-global-endpoint", false)
+
+	for _, test := range []struct {
+		name       string
+		region     string
+		quiet      bool
+		optIn      bool
+		expectWarn bool
+	}{
+		{"Global endpoint", "", false, false, true},
+		{"Regional endpoint", "eu-west-1", false, false, false},
+		{"Quiet mode", "", true, false, false},
+		{"Global endpoint opted into", "", false, true, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			buf.Reset()
+			globalSTSWarning = sync.Once{}
+			viper.Set("global.quiet", test.quiet)
+			viper.Set("global.sts-global-endpoint", test.optIn)
+
+			warnGlobalSTS(test.region)
+			// The warning is printed only once per run.
+			warnGlobalSTS(test.region)
+
+			warnings := strings.Count(buf.String(), "global STS endpoint")
+			if test.expectWarn && warnings != 1 {
+				t.Errorf("expected a single warning, received %q", buf.String())
+			}
+			if !test.expectWarn && warnings != 0 {
+				t.Errorf("expected no warning, received %q", buf.String())
+			}
+		})
+	}
+}
+
+func TestCheckAccount(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	viper.Set("apps.account-app.expected-account", "123456789012")
+	defer viper.Set("apps.account-app.expected-account", "")
+	defer viper.Set("apps.account-app.expected-account-strict", nil)
+	defer viper.Set("global.expected-account-strict", false)
+
+	for _, test := range []struct {
+		name        string
+		role        string
+		appStrict   interface{}
+		strict      bool
+		expectWarn  bool
+		expectError bool
+	}{
+		{"Matching", "arn:aws:iam::123456789012:role/Admin", nil, true, false, false},
+		{"Mismatching", "arn:aws:iam::210987654321:role/Admin", nil, false, true, false},
+		{"Mismatching, strict", "arn:aws:iam::210987654321:role/Admin", nil, true, false, true},
+		{"Mismatching, strict for the app", "arn:aws:iam::210987654321:role/Admin", true, false, false, true},
+		{"Mismatching, not strict for the app", "arn:aws:iam::210987654321:role/Admin", false, true, true, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			buf.Reset()
+			viper.Set("apps.account-app.expected-account-strict", test.appStrict)
+			viper.Set("global.expected-account-strict", test.strict)
+
+			err := checkAccount("account-app", test.role)
+			if test.expectError && err == nil {
+				t.Errorf("expected error")
+			}
+			if !test.expectError && err != nil {
+				t.Errorf("unexpected error %+v", err)
+			}
+			if warned := strings.Contains(buf.String(), "expects account 123456789012"); warned != test.expectWarn {
+				t.Errorf("expected warning %t, received %q", test.expectWarn, buf.String())
+			}
+		})
+	}
+
+	if err := checkAccount("other-app", "arn:aws:iam::210987654321:role/Admin"); err != nil {
+		t.Errorf("unexpected error for app without expected-account %+v", err)
+	}
+}
+
+func TestPreferredRole(t *testing.T) {
+	viper.Set("apps.alias-app.arn", "arn:aws:iam::123456789012:role/Default")
+	viper.Set("apps.alias-app.roles", map[string]interface{}{"prod-admin": "arn:aws:iam::123456789012:role/AppAdmin"})
+	viper.Set("global.roles", map[string]interface{}{
+		"prod-admin": "arn:aws:iam::123456789012:role/GlobalAdmin",
+		"Read-Only":  "arn:aws:iam::210987654321:role/ReadOnly",
+	})
+	defer viper.Set("apps.alias-app.roles", nil)
+	defer viper.Set("global.roles", nil)
+
+	for _, test := range []struct {
+		name        string
+		alias       string
+		expect      string
+		expectError bool
+	}{
+		{"No alias", "", "arn:aws:iam::123456789012:role/Default", false},
+		{"App alias overrides global alias", "prod-admin", "arn:aws:iam::123456789012:role/AppAdmin", false},
+		{"Global alias", "read-only", "arn:aws:iam::210987654321:role/ReadOnly", false},
+		{"Alias is case-insensitive", "READ-ONLY", "arn:aws:iam::210987654321:role/ReadOnly", false},
+		{"Unknown alias", "dev-admin", "", true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			arn, err := preferredRole("alias-app", test.alias)
+			if test.expectError && err == nil {
+				t.Errorf("expected error")
+			}
+			if !test.expectError && err != nil {
+				t.Errorf("unexpected error %+v", err)
+			}
+			if arn != test.expect {
+				t.Errorf("expected %q, received %q", test.expect, arn)
+			}
+		})
+	}
+}
+
+func TestCheckRoleAlias(t *testing.T) {
+	b, err := ioutil.ReadFile("../saml/testdata/valid-response")
+	if err != nil {
+		t.Fatalf("could not read test data: %v", err)
+	}
+	assertion := string(b)
+
+	if err = checkRoleAlias(assertion, "my-role", "arn:aws:iam::123456789012:role/OneLogin-MyRole1"); err != nil {
+		t.Errorf("unexpected error %+v", err)
+	}
+
+	err = checkRoleAlias(assertion, "other", "arn:aws:iam::123456789012:role/Other")
+	if err == nil || !strings.Contains(err.Error(), "isn't contained in the SAML assertion") {
+		t.Errorf("expected error for role missing from the assertion, received %v", err)
+	}
+}
+
+func TestAllowedRoles(t *testing.T) {
+	b, err := ioutil.ReadFile("../saml/testdata/valid-response")
+	if err != nil {
+		t.Fatalf("could not read test data: %v", err)
+	}
+	assertion := string(b)
+
+	viper.Set("apps.allowed-app.allowed-roles", []string{"arn:aws:iam::123456789012:role/OneLogin-MyRole1"})
+	defer viper.Set("apps.allowed-app.allowed-roles", nil)
+
+	if err = checkAllowedRole("allowed-app", "arn:aws:iam::123456789012:role/OneLogin-MyRole1"); err != nil {
+		t.Errorf("unexpected error %+v", err)
+	}
+	if err = checkAllowedRole("allowed-app", ""); err != nil {
+		t.Errorf("unexpected error %+v", err)
+	}
+	if err = checkAllowedRole("other-app", "arn:aws:iam::123456789012:role/OneLogin-MyRole0"); err != nil {
+		t.Errorf("unexpected error for app without allowed-roles %+v", err)
+	}
+
+	// The roles are checked before assuming any of them.
+	_, _, err = assumeRole("allowed-app", assertion, "arn:aws:iam::123456789012:role/OneLogin-MyRole0", 3600, "")
+	if err == nil || !strings.Contains(err.Error(), "isn't in the allowed-roles of app allowed-app") {
+		t.Errorf("expected error for disallowed role, received %v", err)
+	}
+
+	viper.Set("apps.allowed-app.allowed-roles", []string{"arn:aws:iam::123456789012:role/Other"})
+	_, _, err = assumeRole("allowed-app", assertion, "", 3600, "")
+	if err == nil || !strings.Contains(err.Error(), saml.ErrNoAllowedRoles.Error()) {
+		t.Errorf("expected error for assertion without allowed roles, received %v", err)
+	}
+}
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/fatih/color"
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/spf13/viper"
+
+	"github.com/allcloud-io/clisso/aws"
+)
+
+// auditEntry is a line of the audit log. It describes credentials obtained by clisso without any
+// of their secrets: only the fields below are written, never the keys or the session token.
+type auditEntry struct {
+	Time       string `json:"time"`
+	App        string `json:"app"`
+	Provider   string `json:"provider"`
+	Role       string `json:"role"`
+	Account    string `json:"account"`
+	Expiration string `json:"expiration"`
+	Output     string `json:"output"`
+}
+
+func newAuditEntry(app, provider, role, mode string, creds *aws.Credentials, now time.Time) auditEntry {
+	return auditEntry{
+		Time:       now.UTC().Format(time.RFC3339),
+		App:        app,
+		Provider:   provider,
+		Role:       role,
+		Account:    aws.AccountID(role),
+		Expiration: creds.Expiration.UTC().Format(time.RFC3339),
+		Output:     mode,
+	}
+}
+
+// appendAuditLog appends e as a line of JSON to the audit log at path, creating the file if it
+// doesn't exist.
+func appendAuditLog(path string, e auditEntry) error {
+	path, err := homedir.Expand(path)
+	if err != nil {
+		return fmt.Errorf("expanding audit log path: %v", err)
+	}
+	if err = ensureParentDir(path, "Audit log"); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("encoding audit log entry: %v", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("opening audit log: %v", err)
+	}
+	if _, err = f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("writing audit log: %v", err)
+	}
+
+	return f.Close()
+}
+
+// auditCredentials records the credentials obtained for the role of app in the audit log, if
+// global.audit-log is set. mode is where the credentials were written to. Since the credentials
+// have already been handed out, a failure to record them only results in a warning.
+func auditCredentials(app, provider, role, mode string, creds *aws.Credentials) {
+	path := viper.GetString("global.audit-log")
+	if path == "" {
+		return
+	}
+
+	if err := appendAuditLog(path, newAuditEntry(app, provider, role, mode, creds, time.Now())); err != nil {
+		log.Printf(color.YellowString("Warning: %v"), err)
+	}
+}
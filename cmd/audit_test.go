@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/allcloud-io/clisso/aws"
+)
+
+func TestAuditLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clisso-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "logs", "audit.log")
+	viper.Set("global.audit-log", path)
+	defer viper.Set("global.audit-log", "")
+
+	expiration := time.Date(2021, 3, 4, 12, 30, 0, 0, time.UTC)
+	creds := aws.Credentials{
+		AccessKeyID:     "AKIAEXAMPLEKEY",
+		SecretAccessKey: "secret-access-key-value",
+		SessionToken:    "session-token-value",
+		Expiration:      expiration,
+	}
+	role := "arn:aws:iam::123456789012:role/Admin"
+	auditCredentials("audit-app", "audit-provider", role, outputShell, &creds)
+	auditCredentials("audit-app", "audit-provider", role, outputCredentialProcess, &creds)
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{creds.AccessKeyID, creds.SecretAccessKey, creds.SessionToken} {
+		if strings.Contains(string(b), secret) {
+			t.Errorf("audit log contains secret %q", secret)
+		}
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, received %d: %q", len(lines), b)
+	}
+	var e auditEntry
+	if err = json.Unmarshal([]byte(lines[1]), &e); err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	if _, err = time.Parse(time.RFC3339, e.Time); err != nil {
+		t.Errorf("invalid time %q: %v", e.Time, err)
+	}
+	e.Time = ""
+	expect := auditEntry{
+		App:        "audit-app",
+		Provider:   "audit-provider",
+		Role:       role,
+		Account:    "123456789012",
+		Expiration: "2021-03-04T12:30:00Z",
+		Output:     outputCredentialProcess,
+	}
+	if e != expect {
+		t.Errorf("expected %+v, received %+v", expect, e)
+	}
+}
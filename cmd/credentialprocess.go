@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/allcloud-io/clisso/aws"
+)
+
+// credentialProcessRefreshWindow is how long before their expiration the AWS CLI and SDKs refresh
+// the credentials of a credential_process, at most. Cached credentials are only reused if they stay
+// valid for longer, since the caller would otherwise run clisso again right away.
+const credentialProcessRefreshWindow = 15 * time.Minute
+
+// credentialProcessCachePath returns the path of the file credentials obtained in output mode
+// credential-process are cached in.
+var credentialProcessCachePath = func() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("getting cache directory: %v", err)
+	}
+
+	return filepath.Join(dir, "clisso", "credential-process"), nil
+}
+
+func init() {
+	// The command's flags are shared with get and added once they are defined, in get's init.
+	RootCmd.AddCommand(cmdCredentialProcess)
+}
+
+// credentialProcessContext adjusts the settings when credentials are obtained for the
+// credential_process of an AWS profile, i.e. in output mode credential-process. The spinner and
+// warnings are disabled since the output is read by the AWS CLI or SDK rather than by the user. If
+// stderr, where prompts are written, isn't a terminal (the AWS CLI captures it), prompting is
+// disabled as well so that a missing password fails immediately rather than waiting for input the
+// user can't be asked for. Credentials then have to be obtained without input, e.g. using the
+// password stored in the keychain.
+func credentialProcessContext(mode string, stderrTerminal bool) {
+	if mode != outputCredentialProcess {
+		return
+	}
+
+	viper.Set("global.quiet", true)
+	if !stderrTerminal {
+		viper.Set("global.non-interactive", true)
+	}
+}
+
+// credentialProcessCacheKey returns the profile of the cache file holding the credentials of app for
+// the role roleArn, which is empty if the app's role isn't configured, in region, which is empty
+// if no region is selected. Like the profile the AWS CLI runs clisso for, it identifies a single
+// app and role, and the region is part of the credentials.
+func credentialProcessCacheKey(app, roleArn, region string) string {
+	key := app
+	if roleArn != "" {
+		key += " " + roleArn
+	}
+	if region != "" {
+		key += " " + region
+	}
+
+	return key
+}
+
+// cachedProcessCredentials returns the credentials of app for the role roleArn in region cached by
+// cacheProcessCredentials, if they are valid for longer than credentialProcessRefreshWindow after
+// now, or the app's minimum validity if it's longer (see minValidity). The AWS CLI (unlike the
+// SDKs) doesn't keep the credentials of a credential_process between its runs, so reusing them
+// avoids authenticating for every command. Since the credentials are returned with their original
+// expiration, the caller refreshes them at the same time as clisso.
+func cachedProcessCredentials(app, roleArn, region string, now time.Time) (*aws.Credentials, error) {
+	path, err := credentialProcessCachePath()
+	if err != nil {
+		return nil, err
+	}
+
+	creds, err := aws.ReadFromFile(path, credentialProcessCacheKey(app, roleArn, region))
+	if err != nil {
+		return nil, err
+	}
+	window := credentialProcessRefreshWindow
+	min, err := minValidity(app)
+	if err != nil {
+		return nil, err
+	}
+	if min > window {
+		window = min
+	}
+	if creds.Expiration.Sub(now) <= window {
+		return nil, fmt.Errorf("cached credentials of app %s expire within %v", app, window)
+	}
+
+	return creds, nil
+}
+
+// cacheProcessCredentials caches credentials obtained for app in output mode credential-process.
+func cacheProcessCredentials(app, roleArn, region string, creds *aws.Credentials) error {
+	path, err := credentialProcessCachePath()
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("creating cache directory: %v", err)
+	}
+
+	return aws.WriteToFile(creds, path, credentialProcessCacheKey(app, roleArn, region), "")
+}
+
+var cmdCredentialProcess = &cobra.Command{
+	Use:   "credential-process [app name]",
+	Short: "Get temporary credentials for an app as an AWS credential_process",
+	Long: `Obtain temporary credentials for the specified app and print them as JSON for
+use in the credential_process setting of an AWS CLI profile. This is the same
+as 'clisso get --credential-process'.
+
+The spinner and warnings are disabled. If stderr isn't a terminal, as when run
+by the AWS CLI, prompting is disabled too, so the password must be stored in the
+keychain and an MFA device must be selectable without input (see mfa-device).
+
+The credentials are cached and reused by later runs while they are valid for
+more than 15 minutes, i.e. until the AWS CLI would refresh them, or for longer
+than --min-validity (or the app's min-validity) if that's longer.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		credentialProcess = true
+		cmdGet.Run(cmd, args)
+	},
+}
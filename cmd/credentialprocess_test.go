@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/allcloud-io/clisso/aws"
+)
+
+func TestCredentialProcessContext(t *testing.T) {
+	for _, test := range []struct {
+		name                 string
+		credentialProcess    bool
+		stderrTerminal       bool
+		expectQuiet          bool
+		expectNonInteractive bool
+	}{
+		{"Interactive", false, true, false, false},
+		{"Interactive with captured stderr", false, false, false, false},
+		{"Credential process in a terminal", true, true, true, false},
+		{"Credential process run by the AWS CLI", true, false, true, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			defer func(b bool) { credentialProcess = b }(credentialProcess)
+			defer viper.Set("global.quiet", false)
+			defer viper.Set("global.non-interactive", false)
+			viper.Set("apps.test-app.output", "")
+			credentialProcess = test.credentialProcess
+
+			mode, err := outputMode("test-app")
+			if err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+			credentialProcessContext(mode, test.stderrTerminal)
+
+			if q := viper.GetBool("global.quiet"); q != test.expectQuiet {
+				t.Errorf("expected quiet %t, received %t", test.expectQuiet, q)
+			}
+			if n := viper.GetBool("global.non-interactive"); n != test.expectNonInteractive {
+				t.Errorf("expected non-interactive %t, received %t", test.expectNonInteractive, n)
+			}
+		})
+	}
+}
+
+func TestCachedProcessCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clisso-credential-process")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(f func() (string, error)) { credentialProcessCachePath = f }(credentialProcessCachePath)
+	credentialProcessCachePath = func() (string, error) { return filepath.Join(dir, "cache", "credential-process"), nil }
+
+	role := "arn:aws:iam::123456789012:role/Admin"
+	issued := time.Now().Truncate(time.Second)
+	creds := aws.Credentials{
+		AccessKeyID:     "key",
+		SecretAccessKey: "secret",
+		SessionToken:    "token",
+		Expiration:      issued.Add(time.Hour),
+	}
+	if err = cacheProcessCredentials("cache-app", role, "eu-west-1", &creds); err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+
+	for _, test := range []struct {
+		name        string
+		app         string
+		role        string
+		region      string
+		now         time.Time
+		expectReuse bool
+	}{
+		// The AWS CLI runs the credential_process again for its next command.
+		{"Next command", "cache-app", role, "eu-west-1", issued.Add(time.Minute), true},
+		// The SDK refreshes the credentials shortly before they expire, so they must not be
+		// returned again.
+		{"Refresh near expiry", "cache-app", role, "eu-west-1", issued.Add(50 * time.Minute), false},
+		{"Expired", "cache-app", role, "eu-west-1", issued.Add(2 * time.Hour), false},
+		{"Other role", "cache-app", "arn:aws:iam::123456789012:role/ReadOnly", "eu-west-1", issued, false},
+		{"Other app", "other-app", role, "eu-west-1", issued, false},
+		{"Other region", "cache-app", role, "us-east-1", issued, false},
+		{"No region", "cache-app", role, "", issued, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cached, err := cachedProcessCredentials(test.app, test.role, test.region, test.now)
+			if !test.expectReuse {
+				if err == nil {
+					t.Errorf("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+
+			// The output announces the expiration of the cached credentials, so that the SDK
+			// refreshes them when clisso would obtain new ones.
+			var out bytes.Buffer
+			if err = aws.WriteCredentialProcess(cached, &out); err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+			var output struct {
+				AccessKeyID string `json:"AccessKeyId"`
+				Expiration  string
+			}
+			if err = json.Unmarshal(out.Bytes(), &output); err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+			if output.AccessKeyID != creds.AccessKeyID {
+				t.Errorf("expected access key %q, received %q", creds.AccessKeyID, output.AccessKeyID)
+			}
+			if expect := creds.Expiration.UTC().Format(time.RFC3339); output.Expiration != expect {
+				t.Errorf("expected expiration %s, received %s", expect, output.Expiration)
+			}
+		})
+	}
+}
+
+func TestCachedProcessCredentialsMinValidity(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clisso-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(f func() (string, error)) { credentialProcessCachePath = f }(credentialProcessCachePath)
+	credentialProcessCachePath = func() (string, error) { return filepath.Join(dir, "cache", "credential-process"), nil }
+	defer viper.Set("apps.cache-app.min-validity", nil)
+	defer func(v string) { minValidityFlag = v }(minValidityFlag)
+
+	issued := time.Now().Truncate(time.Second)
+	creds := aws.Credentials{AccessKeyID: "key", Expiration: issued.Add(time.Hour)}
+	if err = cacheProcessCredentials("cache-app", "", "", &creds); err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+
+	for _, test := range []struct {
+		name        string
+		flag        string
+		app         string
+		now         time.Time
+		expectReuse bool
+	}{
+		// A shorter minimum validity doesn't stop the SDK from refreshing the credentials.
+		{"Shorter than refresh window", "", "5m", issued.Add(50 * time.Minute), false},
+		{"Long enough", "", "30m", issued.Add(20 * time.Minute), true},
+		{"Too short", "", "30m", issued.Add(40 * time.Minute), false},
+		{"Flag overrides app", "600", "30m", issued.Add(40 * time.Minute), true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			minValidityFlag = test.flag
+			viper.Set("apps.cache-app.min-validity", test.app)
+
+			_, err := cachedProcessCredentials("cache-app", "", "", test.now)
+			if test.expectReuse && err != nil {
+				t.Errorf("unexpected error %+v", err)
+			}
+			if !test.expectReuse && err == nil {
+				t.Errorf("expected error")
+			}
+		})
+	}
+}
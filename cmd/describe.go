@@ -0,0 +1,210 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/allcloud-io/clisso/config"
+	"github.com/fatih/color"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// redacted replaces secret values in the output of the describe command.
+const redacted = "<redacted>"
+
+func init() {
+	RootCmd.AddCommand(cmdDescribe)
+}
+
+// setting is a single config value in the output of the describe command.
+type setting struct {
+	Name  string
+	Value string
+}
+
+// describeApp returns the effective configuration used when getting credentials for app, after
+// applying provider-level values, environment variables and defaults. Secrets are redacted.
+func describeApp(app string) ([]setting, error) {
+	provider, pType, err := appProvider(app)
+	if err != nil {
+		return nil, err
+	}
+
+	settings := []setting{
+		{"App", app},
+		{"Provider", provider},
+		{"Provider type", pType},
+	}
+
+	switch pType {
+	case "onelogin":
+		p, err := config.GetOneLoginProvider(provider)
+		if err != nil {
+			return nil, fmt.Errorf("reading provider config: %v", err)
+		}
+		a, err := config.GetOneLoginApp(app)
+		if err != nil {
+			return nil, fmt.Errorf("reading config for app %s: %v", app, err)
+		}
+
+		ipVersion := p.IPVersion
+		if ipVersion == "" {
+			ipVersion = "dual-stack"
+		}
+		mfaPush := p.MFAPush
+		if a.MFAPush != "" {
+			mfaPush = a.MFAPush
+		}
+		mfaDevice := p.MFADevice
+		if a.MFADevice != "" {
+			mfaDevice = a.MFADevice
+		}
+
+		settings = append(settings,
+			setting{"App ID", a.ID},
+			setting{"Region", p.Region},
+			setting{"Subdomain", p.Subdomain},
+			setting{"Client ID", p.ClientID},
+			setting{"Client secret", redact(p.ClientSecret)},
+			setting{"Username", valueOrDefault(valueOrDefault(a.Username, p.Username), "<prompt>")},
+			setting{"IP version", ipVersion},
+			setting{"Extra HTTP headers", headerNames(p.Headers)},
+			setting{"MFA push mode", mfaPush},
+			setting{"MFA device", valueOrDefault(mfaDevice, "<prompt>")},
+		)
+	case "okta":
+		p, err := config.GetOktaProvider(provider)
+		if err != nil {
+			return nil, fmt.Errorf("reading provider config: %v", err)
+		}
+		a, err := config.GetOktaApp(app)
+		if err != nil {
+			return nil, fmt.Errorf("reading config for app %s: %v", app, err)
+		}
+
+		settings = append(settings,
+			setting{"URL", a.URL},
+			setting{"Base URL", p.BaseURL},
+			setting{"Username", valueOrDefault(valueOrDefault(a.Username, p.Username), "<prompt>")},
+		)
+	default:
+		return nil, fmt.Errorf("Unsupported identity provider type '%s' for app '%s'", pType, app)
+	}
+
+	path, format, err := credentialsFile(app)
+	if err != nil {
+		return nil, err
+	}
+
+	mode, err := outputMode(app)
+	if err != nil {
+		return nil, err
+	}
+
+	settings = append(settings,
+		setting{"Output", mode},
+		setting{"Preferred role ARN", valueOrDefault(viper.GetString(fmt.Sprintf("apps.%s.arn", app)), "<prompt>")},
+		setting{"SAML provider", valueOrDefault(viper.GetString(fmt.Sprintf("apps.%s.saml-provider", app)), "<prompt>")},
+		setting{"Allowed roles", valueOrDefault(strings.Join(allowedRoles(app), ", "), "<any>")},
+		setting{"Regions", valueOrDefault(strings.Join(viper.GetStringSlice(fmt.Sprintf("apps.%s.regions", app)), ", "), "<any>")},
+		setting{"Expected account", describeExpectedAccount(app)},
+		setting{"Expected issuer", valueOrDefault(viper.GetString(fmt.Sprintf("apps.%s.expected-issuer", app)), "<any>")},
+		setting{"Session duration", fmt.Sprintf("%d", sessionDuration(app, provider))},
+		setting{"Credentials file", path},
+		setting{"Credentials file format", format},
+		setting{"Profile", describeProfile(app)},
+		setting{"Tags", valueOrDefault(strings.Join(config.AppTags(app), ", "), "<none>")},
+	)
+
+	return settings, nil
+}
+
+// describeProfile returns the profile the credentials of app are written to. If the profile is
+// rendered from a template and the app's role isn't configured, the template is shown instead.
+func describeProfile(app string) string {
+	p, err := profileName(app, viper.GetString(fmt.Sprintf("apps.%s.arn", app)))
+	if err != nil {
+		return fmt.Sprintf("<template %s>", profileTemplate(app))
+	}
+
+	return p
+}
+
+// describeExpectedAccount returns the expected-account of app and whether a mismatch is an error.
+func describeExpectedAccount(app string) string {
+	account := viper.GetString(fmt.Sprintf("apps.%s.expected-account", app))
+	if account == "" {
+		return "<any>"
+	}
+
+	if expectedAccountStrict(app) {
+		return account + " (strict)"
+	}
+
+	return account
+}
+
+// headerNames returns a sorted, comma-separated list of the names of the given HTTP headers. The
+// values are omitted since they may contain secrets.
+func headerNames(headers map[string]string) string {
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, http.CanonicalHeaderKey(k))
+	}
+	sort.Strings(names)
+
+	return strings.Join(names, ", ")
+}
+
+// redact hides a secret value while still showing whether it is set.
+func redact(v string) string {
+	if v == "" {
+		return ""
+	}
+
+	return redacted
+}
+
+// valueOrDefault returns v or, if v is empty, def.
+func valueOrDefault(v, def string) string {
+	if v == "" {
+		return def
+	}
+
+	return v
+}
+
+var cmdDescribe = &cobra.Command{
+	Use:   "describe [app name]",
+	Short: "Show the effective configuration of an app",
+	Long: `Show the configuration which is used when getting credentials for the specified app,
+after applying provider-level values, environment variables and defaults. Secrets
+are redacted.
+
+If no app is specified, the selected app (if configured) will be described.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		app, err := appFromArgs(args)
+		if err != nil {
+			log.Fatal(color.RedString(err.Error()))
+		}
+
+		settings, err := describeApp(app)
+		if err != nil {
+			log.Fatalf(color.RedString("Could not describe app '%s': %v"), app, err)
+		}
+
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Setting", "Value"})
+		for _, s := range settings {
+			table.Append([]string{s.Name, s.Value})
+		}
+		table.Render()
+	},
+}
@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestDescribeApp(t *testing.T) {
+	os.Unsetenv("AWS_PROFILE")
+	viper.Set("global.credentials-path", "/tmp/credentials")
+	viper.Set("providers.describe-provider", map[string]interface{}{
+		"type":          "onelogin",
+		"client-id":     "my-client-id",
+		"client-secret": "my-client-secret",
+		"subdomain":     "mycompany",
+		"duration":      7200,
+		"headers":       map[string]interface{}{"x-api-key": "my-api-key"},
+	})
+	viper.Set("apps.describe-app", map[string]interface{}{
+		"app-id":   "12345",
+		"provider": "describe-provider",
+	})
+
+	settings, err := describeApp("describe-app")
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+
+	got := make(map[string]string)
+	for _, s := range settings {
+		got[s.Name] = s.Value
+	}
+
+	for name, want := range map[string]string{
+		"App":                "describe-app",
+		"Provider":           "describe-provider",
+		"Provider type":      "onelogin",
+		"App ID":             "12345",
+		"Region":             "US",
+		"Subdomain":          "mycompany",
+		"Client ID":          "my-client-id",
+		"Client secret":      redacted,
+		"Username":           "<prompt>",
+		"IP version":         "dual-stack",
+		"Extra HTTP headers": "X-Api-Key",
+		"MFA push mode":      "push-first",
+		"MFA device":         "<prompt>",
+		"Preferred role ARN": "<prompt>",
+		"Session duration":   "7200",
+		"Credentials file":   "/tmp/credentials",
+		"Profile":            "describe-app",
+	} {
+		if got[name] != want {
+			t.Errorf("%s: expected %q, received %q", name, want, got[name])
+		}
+	}
+
+	for _, s := range settings {
+		if strings.Contains(s.Value, "my-client-secret") || strings.Contains(s.Value, "my-api-key") {
+			t.Errorf("secret not redacted in %q", s.Name)
+		}
+	}
+}
+
+func TestDescribeAppMissingProvider(t *testing.T) {
+	viper.Set("apps.no-provider-app", map[string]interface{}{"app-id": "12345"})
+
+	if _, err := describeApp("no-provider-app"); err == nil {
+		t.Errorf("expected error")
+	}
+}
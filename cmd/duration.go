@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/viper"
+
+	"github.com/allcloud-io/clisso/prompt"
+)
+
+// The session durations allowed by STS for AssumeRoleWithSAML, in seconds.
+const (
+	minDuration = 900
+	maxDuration = 43200
+)
+
+// durationConfigured reports whether a session duration is configured for app or provider.
+func durationConfigured(app, provider string) bool {
+	return viper.GetInt64(fmt.Sprintf("apps.%s.duration", app)) != 0 ||
+		viper.GetInt64(fmt.Sprintf("providers.%s.duration", provider)) != 0
+}
+
+// askDuration asks the user for a session duration, returning def on empty input.
+func askDuration(def int64) (int64, error) {
+	for {
+		input, err := prompt.Line("session duration", fmt.Sprintf("Session duration? [%s]: ", formatDuration(def)))
+		if err != nil {
+			return 0, err
+		}
+
+		d, err := parseDuration(input, def)
+		if err != nil {
+			log.Printf(color.YellowString("%v"), err)
+			continue
+		}
+
+		return d, nil
+	}
+}
+
+// parseDuration parses a session duration given either as a number of seconds ("3600") or in the
+// format accepted by time.ParseDuration ("1h30m"). An empty string yields def. The duration is
+// returned in seconds and must be within the limits allowed by STS.
+func parseDuration(s string, def int64) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return def, nil
+	}
+
+	var seconds int64
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		seconds = n
+	} else {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid duration '%s'. Use e.g. 3600, 90m or 2h", s)
+		}
+		if d%time.Second != 0 {
+			return 0, fmt.Errorf("Invalid duration '%s'. Use whole seconds", s)
+		}
+		seconds = int64(d / time.Second)
+	}
+
+	if seconds < minDuration || seconds > maxDuration {
+		return 0, fmt.Errorf("Invalid duration %s. Valid values: %s - %s",
+			formatDuration(seconds), formatDuration(minDuration), formatDuration(maxDuration))
+	}
+
+	return seconds, nil
+}
+
+// formatDuration formats a number of seconds in a compact, human-friendly way, e.g. "1h30m".
+func formatDuration(seconds int64) string {
+	s := (time.Duration(seconds) * time.Second).String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+
+	return s
+}
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"testing"
+)
+
+func TestParseDuration(t *testing.T) {
+	for _, test := range []struct {
+		input       string
+		expect      int64
+		expectError bool
+	}{
+		{"", 3600, false},
+		{"  ", 3600, false},
+		{"7200", 7200, false},
+		{"1h", 3600, false},
+		{"90m", 5400, false},
+		{"2h30m", 9000, false},
+		{"15m", 900, false},
+		{"12h", 43200, false},
+		{"14m", 0, true},
+		{"13h", 0, true},
+		{"100", 0, true},
+		{"1.5s", 0, true},
+		{"-1h", 0, true},
+		{"one hour", 0, true},
+	} {
+		t.Run(test.input, func(t *testing.T) {
+			res, err := parseDuration(test.input, 3600)
+			if test.expectError && err == nil {
+				t.Errorf("expected error")
+			}
+			if !test.expectError && err != nil {
+				t.Errorf("unexpected error %+v", err)
+			}
+			if res != test.expect {
+				t.Errorf("expected %d, received %d", test.expect, res)
+			}
+		})
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	for _, test := range []struct {
+		seconds int64
+		expect  string
+	}{
+		{3600, "1h"},
+		{5400, "1h30m"},
+		{900, "15m"},
+		{3661, "1h1m1s"},
+		{45, "45s"},
+	} {
+		if res := formatDuration(test.seconds); res != test.expect {
+			t.Errorf("%d: expected %q, received %q", test.seconds, test.expect, res)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// expiry returns the expiration time t as a time in loc and as a duration relative to now, e.g.
+// "2021-03-04 13:30:00 CET" and "in 3h59m".
+func expiry(t, now time.Time, loc *time.Location) (absolute, relative string) {
+	absolute = t.In(loc).Format("2006-01-02 15:04:05 MST")
+
+	if d := t.Sub(now); d > 0 {
+		relative = "in " + formatRemaining(d)
+	} else {
+		relative = "expired"
+	}
+
+	return
+}
+
+// formatRemaining formats a positive remaining time compactly, e.g. "3h59m" or "42s".
+func formatRemaining(d time.Duration) string {
+	if d < time.Minute {
+		return fmt.Sprintf("%ds", int64(d/time.Second))
+	}
+
+	// Truncate rather than round so that the remaining time is never overstated.
+	return formatDuration(int64(d.Truncate(time.Minute) / time.Second))
+}
+
+// formatExpiry formats the expiration time t in the system's timezone including the remaining
+// time, e.g. "2021-03-04 13:30:00 CET (in 3h59m)".
+func formatExpiry(t time.Time) string {
+	absolute, relative := expiry(t, time.Now(), time.Local)
+
+	return fmt.Sprintf("%s (%s)", absolute, relative)
+}
+
+// writeExpiry writes only the expiration time t in RFC 3339 format in UTC to w, e.g.
+// "2021-03-04T12:30:00Z", for consumption by monitoring scripts.
+func writeExpiry(w io.Writer, t time.Time) error {
+	_, err := fmt.Fprintln(w, t.UTC().Format(time.RFC3339))
+
+	return err
+}
+
+// parseValidity parses a minimum remaining validity given either as a number of seconds ("1800")
+// or in the format accepted by time.ParseDuration ("30m").
+func parseValidity(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if n < 0 {
+			return 0, fmt.Errorf("invalid min-validity '%s': must not be negative", s)
+		}
+		return time.Duration(n) * time.Second, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid min-validity '%s'. Use e.g. 1800 or 30m", s)
+	}
+
+	return d, nil
+}
+
+// minValidity returns how long cached credentials of app have to stay valid to be reused, using
+// the following order of preference: --min-validity -> app.min-validity -> global.min-validity ->
+// zero, i.e. any credentials which haven't expired.
+func minValidity(app string) (time.Duration, error) {
+	if minValidityFlag != "" {
+		return parseValidity(minValidityFlag)
+	}
+	if key := fmt.Sprintf("apps.%s.min-validity", app); viper.IsSet(key) {
+		return parseValidity(viper.GetString(key))
+	}
+	if viper.IsSet("global.min-validity") {
+		return parseValidity(viper.GetString("global.min-validity"))
+	}
+
+	return 0, nil
+}
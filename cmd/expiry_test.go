@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestExpiry(t *testing.T) {
+	cet := time.FixedZone("CET", 3600)
+	pst := time.FixedZone("PST", -8*3600)
+	now := time.Date(2021, 3, 4, 8, 30, 0, 0, time.UTC)
+
+	for _, test := range []struct {
+		name           string
+		expiration     time.Time
+		loc            *time.Location
+		expectAbsolute string
+		expectRelative string
+	}{
+		{"UTC", now.Add(time.Hour), time.UTC, "2021-03-04 09:30:00 UTC", "in 1h"},
+		{"Positive offset", now.Add(4 * time.Hour), cet, "2021-03-04 13:30:00 CET", "in 4h"},
+		{"Negative offset", now.Add(3 * time.Hour), pst, "2021-03-04 03:30:00 PST", "in 3h"},
+		{"Truncated to minutes", now.Add(3*time.Hour + 59*time.Minute + 59*time.Second), time.UTC, "2021-03-04 12:29:59 UTC", "in 3h59m"},
+		{"Minutes only", now.Add(5*time.Minute + 30*time.Second), time.UTC, "2021-03-04 08:35:30 UTC", "in 5m"},
+		{"Under a minute", now.Add(42 * time.Second), time.UTC, "2021-03-04 08:30:42 UTC", "in 42s"},
+		{"Exactly now", now, time.UTC, "2021-03-04 08:30:00 UTC", "expired"},
+		{"Expired", now.Add(-time.Minute), time.UTC, "2021-03-04 08:29:00 UTC", "expired"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			absolute, relative := expiry(test.expiration, now, test.loc)
+			if absolute != test.expectAbsolute {
+				t.Errorf("expected %q, received %q", test.expectAbsolute, absolute)
+			}
+			if relative != test.expectRelative {
+				t.Errorf("expected %q, received %q", test.expectRelative, relative)
+			}
+		})
+	}
+}
+
+func TestWriteExpiry(t *testing.T) {
+	var buf bytes.Buffer
+	cet := time.FixedZone("CET", 3600)
+
+	if err := writeExpiry(&buf, time.Date(2021, 3, 4, 13, 30, 0, 0, cet)); err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	if buf.String() != "2021-03-04T12:30:00Z\n" {
+		t.Errorf("expected %q, received %q", "2021-03-04T12:30:00Z\n", buf.String())
+	}
+}
+
+func TestMinValidity(t *testing.T) {
+	defer viper.Set("global.min-validity", nil)
+	defer viper.Set("apps.validity-app.min-validity", nil)
+	defer func(v string) { minValidityFlag = v }(minValidityFlag)
+
+	for _, test := range []struct {
+		name        string
+		flag        string
+		app         interface{}
+		global      interface{}
+		expect      time.Duration
+		expectError bool
+	}{
+		{name: "Default"},
+		{name: "Global", global: 1800, expect: 30 * time.Minute},
+		{name: "App overrides global", app: "1h", global: 1800, expect: time.Hour},
+		{name: "Flag overrides app", flag: "45m", app: "1h", expect: 45 * time.Minute},
+		{name: "Zero", app: 0},
+		{name: "Negative", flag: "-5m", expectError: true},
+		{name: "Negative seconds", global: -60, expectError: true},
+		{name: "Invalid", app: "half an hour", expectError: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			minValidityFlag = test.flag
+			viper.Set("apps.validity-app.min-validity", test.app)
+			viper.Set("global.min-validity", test.global)
+
+			d, err := minValidity("validity-app")
+			if test.expectError {
+				if err == nil {
+					t.Errorf("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+			if d != test.expect {
+				t.Errorf("expected %v, received %v", test.expect, d)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/allcloud-io/clisso/aws"
+	"github.com/allcloud-io/clisso/config"
+)
+
+var exportPrefix string
+var awsConfigFile string
+var exportDiff bool
+
+func init() {
+	RootCmd.AddCommand(cmdExportConfig)
+	cmdExportConfig.Flags().StringVar(
+		&exportPrefix, "prefix", "", "Prepend this prefix to the name of every exported profile",
+	)
+	cmdExportConfig.Flags().StringVar(
+		&awsConfigFile, "aws-config-file", "",
+		"Write profiles to this file instead of the default ($HOME/.aws/config)",
+	)
+	cmdExportConfig.Flags().BoolVar(
+		&exportDiff, "diff", false, "Print the changes to the AWS CLI config file instead of writing them",
+	)
+	err := viper.BindPFlag("global.aws-config-path", cmdExportConfig.Flags().Lookup("aws-config-file"))
+	if err != nil {
+		log.Fatalf(color.RedString("Error binding flag global.aws-config-path: %v"), err)
+	}
+}
+
+// exportProfiles returns a profile for every configured app, mapping the profile name to a
+// credential_process command which runs exe to get credentials for the app.
+func exportProfiles(exe, prefix string) map[string]string {
+	profiles := make(map[string]string)
+	for _, app := range config.Apps() {
+		args := []string{exe, "get", app, "--credential-process"}
+		if cfgFile != "" {
+			args = append(args, "-c", cfgFile)
+		}
+		profiles[prefix+app] = commandLine(args)
+	}
+
+	return profiles
+}
+
+// commandLine joins args into a command line, quoting arguments which contain whitespace.
+func commandLine(args []string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		if strings.ContainsAny(a, " \t") {
+			a = fmt.Sprintf("%q", a)
+		}
+		quoted[i] = a
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+var cmdExportConfig = &cobra.Command{
+	Use:   "export-config",
+	Short: "Export all apps as profiles to an AWS CLI config file",
+	Long: `Write a profile for every configured app to the AWS CLI config file. Each
+profile gets its credentials by running 'clisso get --credential-process', so
+tools which use the AWS CLI config file obtain credentials from clisso on
+demand.
+
+Profiles are named after their apps. Other profiles and settings in the file
+are preserved.
+
+With --diff, the profiles which would change are printed as a diff and the file
+isn't written.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(config.Apps()) == 0 {
+			log.Fatal(color.RedString("No apps configured"))
+		}
+
+		exe, err := os.Executable()
+		if err != nil {
+			log.Fatalf(color.RedString("Error getting path of the clisso executable: %v"), err)
+		}
+
+		path, err := homedir.Expand(viper.GetString("global.aws-config-path"))
+		if err != nil {
+			log.Fatalf(color.RedString("Error expanding AWS config file path: %v"), err)
+		}
+
+		profiles := exportProfiles(exe, exportPrefix)
+		if exportDiff {
+			diff, err := aws.DiffCredentialProcessProfiles(path, profiles)
+			if err != nil {
+				log.Fatalf(color.RedString("Error comparing profiles: %v"), err)
+			}
+			if diff == "" {
+				log.Printf("No changes to '%s'", path)
+				return
+			}
+			fmt.Print(diff)
+			return
+		}
+
+		if err = ensureParentDir(path, "AWS config"); err != nil {
+			log.Fatal(color.RedString(err.Error()))
+		}
+
+		if err = aws.WriteCredentialProcessProfiles(path, profiles); err != nil {
+			log.Fatalf(color.RedString("Error writing profiles: %v"), err)
+		}
+		log.Printf(color.GreenString("Exported %d profiles to '%s'"), len(profiles), path)
+	},
+}
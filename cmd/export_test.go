@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestExportProfiles(t *testing.T) {
+	viper.Set("apps.export-app-1", map[string]interface{}{"provider": "export-provider"})
+	viper.Set("apps.export-app-2", map[string]interface{}{"provider": "export-provider"})
+	defer func(c string) { cfgFile = c }(cfgFile)
+	cfgFile = "/home/user/my config.yaml"
+
+	profiles := exportProfiles("/usr/local/bin/clisso", "sso-")
+
+	for _, app := range []string{"export-app-1", "export-app-2"} {
+		want := "/usr/local/bin/clisso get " + app + ` --credential-process -c "/home/user/my config.yaml"`
+		if got := profiles["sso-"+app]; got != want {
+			t.Errorf("Wrong command for app %s: got %q, want %q", app, got, want)
+		}
+	}
+
+	// Apps configured by other tests are exported too, but never without the prefix.
+	for name := range profiles {
+		if name[:4] != "sso-" {
+			t.Errorf("Profile %s has no prefix", name)
+		}
+	}
+}
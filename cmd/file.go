@@ -0,0 +1,191 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/fatih/color"
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/spf13/viper"
+
+	"github.com/allcloud-io/clisso/aws"
+)
+
+// Formats of credentials files.
+const (
+	formatCredentials = "credentials"
+	formatDotenv      = "dotenv"
+)
+
+// credentialsFile returns the file to write the credentials of app to and the file's format,
+// using the following order of preference: --write-to-file -> app.output-file ->
+// global.credentials-path. Environment variables and ~ in the path are expanded.
+func credentialsFile(app string) (path, format string, err error) {
+	path, format = viper.GetString("global.credentials-path"), formatCredentials
+
+	if f := viper.GetString(fmt.Sprintf("apps.%s.output-file", app)); f != "" && writeToFile == "" {
+		path = f
+		if format = viper.GetString(fmt.Sprintf("apps.%s.output-format", app)); format == "" {
+			format = formatCredentials
+		}
+		if format != formatCredentials && format != formatDotenv {
+			return "", "", fmt.Errorf("invalid output format '%s' for app %s. Valid values: %s, %s",
+				format, app, formatCredentials, formatDotenv)
+		}
+	}
+
+	path, err = homedir.Expand(os.ExpandEnv(path))
+	if err != nil {
+		return "", "", fmt.Errorf("expanding credentials file path: %v", err)
+	}
+
+	return path, format, nil
+}
+
+// expirationKey returns the additional key of the credentials file the expiration is written to, as
+// set in global.expiration-key, or an empty string if none is set.
+func expirationKey() (string, error) {
+	key := viper.GetString("global.expiration-key")
+	if key == "" {
+		return "", nil
+	}
+	if err := aws.ValidateExpirationKey(key); err != nil {
+		return "", fmt.Errorf("global.expiration-key: %v", err)
+	}
+
+	return key, nil
+}
+
+// writeCredentialsFile writes the credentials of app to the file returned by credentialsFile.
+func writeCredentialsFile(creds *aws.Credentials, app string) error {
+	path, format, err := credentialsFile(app)
+	if err != nil {
+		return err
+	}
+
+	// Create the parent directory of the file if it doesn't exist.
+	if err = ensureParentDir(path, "Credentials"); err != nil {
+		return err
+	}
+
+	if format == formatDotenv {
+		if err = aws.WriteToDotenv(creds, path, envPrefix, viper.GetBool("global.legacy-session-token")); err != nil {
+			return fmt.Errorf("writing credentials to file: %v", err)
+		}
+		log.Printf(color.GreenString("Credentials written successfully to '%s'"), path)
+
+		return nil
+	}
+
+	p, err := profileName(app, creds.RoleArn)
+	if err != nil {
+		return err
+	}
+	key, err := expirationKey()
+	if err != nil {
+		return err
+	}
+	if err = aws.WriteToFile(creds, path, p, key); err != nil {
+		return fmt.Errorf("writing credentials to file: %v", err)
+	}
+	log.Printf(color.GreenString("Credentials written successfully to profile '%s' in '%s'"), p, path)
+
+	if creds.Region != "" {
+		// The AWS CLI reads the region only from its config file.
+		cfgPath, err := awsConfigPath()
+		if err != nil {
+			return err
+		}
+		if err = ensureParentDir(cfgPath, "AWS config"); err != nil {
+			return err
+		}
+		if err = aws.WriteRegion(cfgPath, p, creds.Region); err != nil {
+			return fmt.Errorf("writing region to AWS config file: %v", err)
+		}
+		log.Printf(color.GreenString("Region of profile '%s' set to '%s' in '%s'"), p, creds.Region, cfgPath)
+	}
+
+	return nil
+}
+
+// diffCredentialsFile returns the changes writeCredentialsFile would make to the credentials file
+// and the AWS CLI config file, without writing them. Secrets are redacted.
+func diffCredentialsFile(creds *aws.Credentials, app string) (string, error) {
+	path, format, err := credentialsFile(app)
+	if err != nil {
+		return "", err
+	}
+	if format != formatCredentials {
+		return "", fmt.Errorf("the changes of credentials in %s format can't be shown", format)
+	}
+
+	p, err := profileName(app, creds.RoleArn)
+	if err != nil {
+		return "", err
+	}
+	key, err := expirationKey()
+	if err != nil {
+		return "", err
+	}
+	diff, err := aws.DiffToFile(creds, path, p, key)
+	if err != nil {
+		return "", fmt.Errorf("comparing credentials file: %v", err)
+	}
+
+	if creds.Region != "" {
+		cfgPath, err := awsConfigPath()
+		if err != nil {
+			return "", err
+		}
+		d, err := aws.DiffRegion(cfgPath, p, creds.Region)
+		if err != nil {
+			return "", fmt.Errorf("comparing AWS config file: %v", err)
+		}
+		diff += d
+	}
+
+	return diff, nil
+}
+
+// awsConfigPath returns the path of the AWS CLI config file.
+func awsConfigPath() (string, error) {
+	path, err := homedir.Expand(viper.GetString("global.aws-config-path"))
+	if err != nil {
+		return "", fmt.Errorf("expanding AWS config file path: %v", err)
+	}
+
+	return path, nil
+}
+
+// cachedCredentials returns the credentials of app previously written to the given profile of the
+// credentials file, if they are still valid for longer than the app's minimum validity (see
+// minValidity). An error is returned if there are no valid credentials, including when the app
+// writes its credentials in a format which can't be read back.
+func cachedCredentials(app, profile string) (*aws.Credentials, error) {
+	path, format, err := credentialsFile(app)
+	if err != nil {
+		return nil, err
+	}
+	if format != formatCredentials {
+		return nil, fmt.Errorf("credentials of app %s are written in %s format and can't be reused", app, format)
+	}
+
+	creds, err := aws.ReadFromFile(path, profile)
+	if err != nil {
+		return nil, err
+	}
+	if !creds.Expiration.After(time.Now()) {
+		return nil, fmt.Errorf("cached credentials of app %s have expired", app)
+	}
+	min, err := minValidity(app)
+	if err != nil {
+		return nil, err
+	}
+	if min > 0 && creds.Expiration.Sub(time.Now()) <= min {
+		return nil, fmt.Errorf("cached credentials of app %s expire within the minimum validity of %v", app, min)
+	}
+
+	return creds, nil
+}
@@ -0,0 +1,209 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-ini/ini"
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/spf13/viper"
+
+	"github.com/allcloud-io/clisso/aws"
+)
+
+func TestCredentialsFile(t *testing.T) {
+	home, err := homedir.Dir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("CLISSO_TEST_DIR", "/srv/my-service")
+	defer os.Unsetenv("CLISSO_TEST_DIR")
+	defer func() { writeToFile = "" }()
+	defer viper.Set("global.credentials-path", "")
+
+	for _, test := range []struct {
+		name         string
+		outputFile   string
+		outputFormat string
+		flag         string
+		expectPath   string
+		expectFormat string
+		expectError  bool
+	}{
+		{"Default", "", "", "", filepath.Join(home, ".aws", "credentials"), formatCredentials, false},
+		{"App file", "~/service/.env", "dotenv", "", filepath.Join(home, "service", ".env"), formatDotenv, false},
+		{"Environment variable", "$CLISSO_TEST_DIR/.env", "dotenv", "", "/srv/my-service/.env", formatDotenv, false},
+		{"Default format", "${CLISSO_TEST_DIR}/credentials", "", "", "/srv/my-service/credentials", formatCredentials, false},
+		{"Invalid format", "~/service/.env", "yaml", "", "", "", true},
+		// --write-to-file is bound to global.credentials-path.
+		{"Flag overrides app", "~/service/.env", "dotenv", "/tmp/credentials", "/tmp/credentials", formatCredentials, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			viper.Set("apps.file-app.output-file", test.outputFile)
+			viper.Set("apps.file-app.output-format", test.outputFormat)
+			writeToFile = test.flag
+			// The binding makes viper return the flag's value once it's set.
+			viper.Set("global.credentials-path", "~/.aws/credentials")
+			if test.flag != "" {
+				viper.Set("global.credentials-path", test.flag)
+			}
+
+			path, format, err := credentialsFile("file-app")
+			if test.expectError && err == nil {
+				t.Errorf("expected error")
+			}
+			if !test.expectError && err != nil {
+				t.Errorf("unexpected error %+v", err)
+			}
+			if path != test.expectPath || format != test.expectFormat {
+				t.Errorf("expected %q (%s), received %q (%s)", test.expectPath, test.expectFormat, path, format)
+			}
+		})
+	}
+}
+
+func TestWriteCredentialsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clisso")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("CLISSO_TEST_DIR", dir)
+	defer os.Unsetenv("CLISSO_TEST_DIR")
+	defer viper.Set("apps.file-app.output-file", "")
+
+	// Parent directories are created.
+	viper.Set("apps.file-app.output-file", "$CLISSO_TEST_DIR/service/.env")
+	viper.Set("apps.file-app.output-format", "dotenv")
+
+	creds := &aws.Credentials{
+		AccessKeyID:     "testkey",
+		SecretAccessKey: "testsecret",
+		SessionToken:    "testtoken",
+		Expiration:      time.Now().Add(time.Hour),
+	}
+	if err := writeCredentialsFile(creds, "file-app"); err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "service", ".env"))
+	if err != nil {
+		t.Fatal("Credentials weren't written: ", err)
+	}
+	if !strings.Contains(string(b), "AWS_ACCESS_KEY_ID=testkey\n") {
+		t.Errorf("Wrong dotenv file:\n%s", b)
+	}
+}
+
+func TestDiffCredentialsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clisso-diff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Unsetenv("AWS_PROFILE")
+	path := filepath.Join(dir, "credentials")
+	cfgPath := filepath.Join(dir, "config")
+	viper.Set("global.credentials-path", path)
+	defer viper.Set("global.credentials-path", "")
+	viper.Set("global.aws-config-path", cfgPath)
+	defer viper.Set("global.aws-config-path", nil)
+
+	creds := &aws.Credentials{
+		AccessKeyID:     "testkey",
+		SecretAccessKey: "testsecret",
+		SessionToken:    "testtoken",
+		Expiration:      time.Now().Add(time.Hour),
+		Region:          "eu-west-1",
+	}
+	diff, err := diffCredentialsFile(creds, "diff-app")
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	for _, expect := range []string{
+		"+++ " + path + "\n+[diff-app]\n+aws_access_key_id = testkey\n+aws_secret_access_key = REDACTED\n",
+		"+++ " + cfgPath + "\n+[profile diff-app]\n+region = eu-west-1\n",
+	} {
+		if !strings.Contains(diff, expect) {
+			t.Errorf("expected diff to contain %q, received %q", expect, diff)
+		}
+	}
+	if strings.Contains(diff, "testsecret") || strings.Contains(diff, "testtoken") {
+		t.Errorf("expected secrets to be redacted, received %q", diff)
+	}
+
+	// Neither file is written.
+	for _, p := range []string{path, cfgPath} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("expected %s not to be written", p)
+		}
+	}
+}
+
+func TestCachedCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clisso-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Unsetenv("AWS_PROFILE")
+	path := filepath.Join(dir, "credentials")
+	viper.Set("global.credentials-path", path)
+	defer viper.Set("global.credentials-path", "")
+
+	valid := aws.Credentials{
+		AccessKeyID:     "valid-key",
+		SecretAccessKey: "valid-secret",
+		SessionToken:    "valid-token",
+		Expiration:      time.Now().Add(time.Hour),
+	}
+	if err := aws.WriteToFile(&valid, path, "cached-app", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	creds, err := cachedCredentials("cached-app", "cached-app")
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	if creds.AccessKeyID != "valid-key" {
+		t.Errorf("expected access key %q, received %q", "valid-key", creds.AccessKeyID)
+	}
+
+	// Credentials which don't stay valid for the minimum validity are not reused.
+	viper.Set("apps.cached-app.min-validity", "90m")
+	_, err = cachedCredentials("cached-app", "cached-app")
+	viper.Set("apps.cached-app.min-validity", nil)
+	if err == nil {
+		t.Errorf("expected error for credentials expiring within the minimum validity")
+	}
+
+	if _, err := cachedCredentials("uncached-app", "uncached-app"); err == nil {
+		t.Errorf("expected error for app without cached credentials")
+	}
+
+	// Expired credentials are removed by WriteToFile, so they are written using the ini package.
+	cfg, err := ini.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := cfg.Section("expired-app")
+	for k, v := range map[string]string{
+		"aws_access_key_id":     "expired-key",
+		"aws_secret_access_key": "expired-secret",
+		"aws_session_token":     "expired-token",
+		"aws_expiration":        time.Now().Add(-time.Minute).UTC().Format(time.RFC3339),
+	} {
+		s.Key(k).SetValue(v)
+	}
+	if err := cfg.SaveTo(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := cachedCredentials("expired-app", "expired-app"); err == nil {
+		t.Errorf("expected error for expired credentials")
+	}
+}
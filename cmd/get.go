@@ -1,24 +1,63 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
-	"path/filepath"
 	"runtime"
+	"strings"
+	"time"
 
 	"github.com/fatih/color"
-	"github.com/mitchellh/go-homedir"
 
 	"github.com/allcloud-io/clisso/aws"
-	"github.com/allcloud-io/clisso/okta"
-	"github.com/allcloud-io/clisso/onelogin"
+	"github.com/allcloud-io/clisso/config"
+	"github.com/allcloud-io/clisso/prompt"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"golang.org/x/term"
 )
 
 var printToShell bool
 var writeToFile string
+var profile string
+var envPrefix string
+var credentialProcess bool
+var output string
+var awsRegion string
+var socketPath string
+var socketAttempts int
+var strictHooks bool
+var lockWait int
+var printExpiry bool
+var noKeyring bool
+var legacyToken bool
+var backupCode bool
+var showPolicies bool
+var hideOTP bool
+var hidePushMessage bool
+var recordFile string
+var replayFile string
+var auditLog string
+var profileTemplateText string
+var roleAlias string
+var getTag string
+var minValidityFlag string
+var alsoConsole bool
+var getDiff bool
+
+// Output modes for credentials.
+const (
+	outputFile              = "file"
+	outputShell             = "shell"
+	outputCredentialProcess = "credential-process"
+	outputSocket            = "socket"
+	outputBase64JSON        = "base64json"
+	outputPowerShell        = "pwsh-object"
+)
+
+var outputModes = []string{outputFile, outputShell, outputCredentialProcess, outputSocket, outputBase64JSON, outputPowerShell}
 
 func init() {
 	RootCmd.AddCommand(cmdGet)
@@ -29,43 +68,391 @@ func init() {
 		&writeToFile, "write-to-file", "w", "",
 		"Write credentials to this file instead of the default ($HOME/.aws/credentials)",
 	)
+	cmdGet.Flags().StringVarP(
+		&profile, "profile", "p", "",
+		"Write credentials to this profile instead of the default ($AWS_PROFILE or the app name)",
+	)
+	cmdGet.Flags().StringVar(
+		&envPrefix, "env-prefix", "",
+		"Prepend this prefix to the names of the environment variables printed by --shell or written to a dotenv file",
+	)
+	cmdGet.Flags().BoolVar(
+		&credentialProcess, "credential-process", false,
+		"Print credentials in the format expected by the AWS CLI's credential_process setting",
+	)
+	cmdGet.Flags().StringVar(
+		&awsRegion, "region", "",
+		"Use this AWS region instead of selecting one of the app's regions",
+	)
+	cmdGet.Flags().StringVarP(
+		&output, "output", "o", "",
+		fmt.Sprintf("Output mode for credentials: %s (default is the app's output setting or %s)",
+			strings.Join(outputModes, ", "), outputFile),
+	)
+	cmdGet.Flags().StringVar(
+		&socketPath, "socket", "",
+		"Send credentials as JSON to the local agent listening on this Unix domain socket",
+	)
+	cmdGet.Flags().IntVar(
+		&socketAttempts, "socket-attempts", 1,
+		"Number of times to try connecting to the socket given by --socket",
+	)
+	cmdGet.Flags().BoolVar(
+		&strictHooks, "strict-hooks", false,
+		"Fail if the post hook fails instead of printing a warning",
+	)
+	cmdGet.Flags().IntVar(
+		&lockWait, "lock-wait", defaultLockWait,
+		"Seconds to wait for another run for the same app to finish (0 to fail immediately, -1 to disable locking)",
+	)
+	cmdGet.Flags().BoolVar(
+		&printExpiry, "print-expiry", false,
+		"Print only the expiration time of the credentials (RFC 3339) to stdout, reusing valid credentials in the credentials file",
+	)
+	cmdGet.Flags().BoolVar(
+		&noKeyring, "no-keyring", false,
+		"Don't read passwords and TOTP keys from the keychain, prompting instead",
+	)
+	cmdGet.Flags().BoolVar(
+		&legacyToken, "legacy-session-token", false,
+		"Also print or write the session token as AWS_SECURITY_TOKEN for older tools",
+	)
+	cmdGet.Flags().BoolVar(
+		&backupCode, "backup-code", false,
+		"Verify MFA using a OneLogin backup code instead of the MFA device",
+	)
+	cmdGet.Flags().BoolVar(
+		&hideOTP, "hide-otp", false,
+		"Don't echo the OTP while typing it, like a password",
+	)
+	cmdGet.Flags().BoolVar(
+		&hidePushMessage, "hide-push-message", false,
+		"Don't print the status message of OneLogin while waiting for a push to be approved",
+	)
+	cmdGet.Flags().StringVar(
+		&recordFile, "record", "",
+		"Record the OneLogin requests and responses, with secrets redacted, to this file for debugging",
+	)
+	cmdGet.Flags().StringVar(
+		&replayFile, "replay", "",
+		"Answer OneLogin requests from a file written by --record instead of contacting OneLogin",
+	)
+	cmdGet.Flags().StringVar(
+		&auditLog, "audit-log", "",
+		"Append a line describing the obtained credentials, without secrets, to this file",
+	)
+	cmdGet.Flags().StringVar(
+		&roleAlias, "role", "",
+		"Assume the role with this alias, as defined in the roles setting of the app or of global",
+	)
+	cmdGet.Flags().StringVar(
+		&profileTemplateText, "profile-template", "",
+		"Go template for profile names, e.g. '{{.AccountID}}_{{.Role}}' (see the README for the available variables)",
+	)
+	cmdGet.Flags().StringVar(
+		&getTag, "tag", "",
+		"Use the app which has this tag, unless an app is specified (prompts for a choice if several apps have it)",
+	)
+	cmdGet.Flags().StringVar(
+		&minValidityFlag, "min-validity", "",
+		"Reuse cached credentials only if they stay valid for longer than this, e.g. 1800 or 30m",
+	)
+	cmdGet.Flags().BoolVar(
+		&getDiff, "diff", false,
+		"Print the changes to the credentials and AWS CLI config files instead of writing them",
+	)
+	cmdGet.Flags().BoolVar(
+		&alsoConsole, "also-console", false,
+		"Also print a URL signing in to the AWS console using the same credentials",
+	)
+	cmdGet.Flags().BoolVar(
+		&showPolicies, "show-policies", false,
+		"Print the names of the policies of the assumed role (requires iam:ListAttachedRolePolicies and iam:ListRolePolicies)",
+	)
 	err := viper.BindPFlag("global.credentials-path", cmdGet.Flags().Lookup("write-to-file"))
 	if err != nil {
 		log.Fatalf(color.RedString("Error binding flag global.credentials-path: %v"), err)
 	}
+	err = viper.BindPFlag("global.socket-path", cmdGet.Flags().Lookup("socket"))
+	if err != nil {
+		log.Fatalf(color.RedString("Error binding flag global.socket-path: %v"), err)
+	}
+	err = viper.BindPFlag("global.post-hook-strict", cmdGet.Flags().Lookup("strict-hooks"))
+	if err != nil {
+		log.Fatalf(color.RedString("Error binding flag global.post-hook-strict: %v"), err)
+	}
+	err = viper.BindPFlag("global.lock-wait", cmdGet.Flags().Lookup("lock-wait"))
+	if err != nil {
+		log.Fatalf(color.RedString("Error binding flag global.lock-wait: %v"), err)
+	}
+	err = viper.BindPFlag("global.no-keyring", cmdGet.Flags().Lookup("no-keyring"))
+	if err != nil {
+		log.Fatalf(color.RedString("Error binding flag global.no-keyring: %v"), err)
+	}
+	err = viper.BindPFlag("global.legacy-session-token", cmdGet.Flags().Lookup("legacy-session-token"))
+	if err != nil {
+		log.Fatalf(color.RedString("Error binding flag global.legacy-session-token: %v"), err)
+	}
+	err = viper.BindPFlag("global.backup-code", cmdGet.Flags().Lookup("backup-code"))
+	if err != nil {
+		log.Fatalf(color.RedString("Error binding flag global.backup-code: %v"), err)
+	}
+	err = viper.BindPFlag("global.hide-otp", cmdGet.Flags().Lookup("hide-otp"))
+	if err != nil {
+		log.Fatalf(color.RedString("Error binding flag global.hide-otp: %v"), err)
+	}
+	err = viper.BindPFlag("global.hide-push-message", cmdGet.Flags().Lookup("hide-push-message"))
+	if err != nil {
+		log.Fatalf(color.RedString("Error binding flag global.hide-push-message: %v"), err)
+	}
+	err = viper.BindPFlag("global.record", cmdGet.Flags().Lookup("record"))
+	if err != nil {
+		log.Fatalf(color.RedString("Error binding flag global.record: %v"), err)
+	}
+	err = viper.BindPFlag("global.replay", cmdGet.Flags().Lookup("replay"))
+	if err != nil {
+		log.Fatalf(color.RedString("Error binding flag global.replay: %v"), err)
+	}
+	err = viper.BindPFlag("global.audit-log", cmdGet.Flags().Lookup("audit-log"))
+	if err != nil {
+		log.Fatalf(color.RedString("Error binding flag global.audit-log: %v"), err)
+	}
+	err = viper.BindPFlag("global.show-policies", cmdGet.Flags().Lookup("show-policies"))
+	if err != nil {
+		log.Fatalf(color.RedString("Error binding flag global.show-policies: %v"), err)
+	}
+
+	cmdCredentialProcess.Flags().AddFlag(cmdGet.Flags().Lookup("region"))
+	cmdCredentialProcess.Flags().AddFlag(cmdGet.Flags().Lookup("role"))
+	cmdCredentialProcess.Flags().AddFlag(cmdGet.Flags().Lookup("lock-wait"))
+	cmdCredentialProcess.Flags().AddFlag(cmdGet.Flags().Lookup("audit-log"))
+	cmdCredentialProcess.Flags().AddFlag(cmdGet.Flags().Lookup("min-validity"))
 }
 
-// processCredentials prints the given Credentials to a file and/or to the shell.
-func processCredentials(creds *aws.Credentials, app string) error {
+// outputMode returns the output mode for the credentials of app using the following order of
+// preference: --output, --shell, --credential-process or --socket -> app.output -> file
+func outputMode(app string) (string, error) {
+	var flags []string
+	if output != "" {
+		flags = append(flags, output)
+	}
 	if printToShell {
-		// Print credentials to shell using the correct syntax for the OS.
-		aws.WriteToShell(creds, runtime.GOOS == "windows", os.Stdout)
-	} else {
-		path, err := homedir.Expand(viper.GetString("global.credentials-path"))
-		if err != nil {
-			return fmt.Errorf("expanding config file path: %v", err)
+		flags = append(flags, outputShell)
+	}
+	if credentialProcess {
+		flags = append(flags, outputCredentialProcess)
+	}
+	if socketPath != "" {
+		flags = append(flags, outputSocket)
+	}
+	if len(flags) > 1 {
+		return "", errors.New("--output, --shell, --credential-process and --socket are mutually exclusive")
+	}
+
+	if len(flags) == 1 {
+		if err := validateOutputMode(flags[0]); err != nil {
+			return "", err
 		}
+		return flags[0], nil
+	}
+
+	mode := viper.GetString(fmt.Sprintf("apps.%s.output", app))
+	if mode == "" {
+		return outputFile, nil
+	}
+	if err := validateOutputMode(mode); err != nil {
+		return "", fmt.Errorf("app %s: %v", app, err)
+	}
+
+	return mode, nil
+}
 
-		// Create the `global.credentials-path` directory if it doesn't exist.
-		credsFileParentDir := filepath.Dir(path)
-		if _, err := os.Stat(credsFileParentDir); os.IsNotExist(err) {
-			log.Printf(color.YellowString("Credentials directory '%s' does not exist - creating it"), credsFileParentDir)
+// machineOutput reports whether credentials are printed to stdout for another program in mode, in
+// which case all other output is written to stderr.
+func machineOutput(mode string) bool {
+	return mode == outputCredentialProcess || mode == outputBase64JSON || mode == outputPowerShell
+}
 
-			err = os.MkdirAll(credsFileParentDir, 0755)
+func validateOutputMode(mode string) error {
+	for _, m := range outputModes {
+		if mode == m {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("invalid output mode '%s'. Valid values: %s", mode, strings.Join(outputModes, ", "))
+}
+
+// processCredentials writes the given Credentials to a file, the shell or stdout depending on mode.
+func processCredentials(creds *aws.Credentials, app, mode string) error {
+	switch mode {
+	case outputCredentialProcess:
+		// stdout is redirected while obtaining credentials in credential_process mode, so the real
+		// stdout is used here.
+		if err := aws.WriteCredentialProcess(creds, stdout); err != nil {
+			return fmt.Errorf("writing credentials to stdout: %v", err)
+		}
+	case outputBase64JSON:
+		if err := aws.WriteBase64JSON(creds, stdout); err != nil {
+			return fmt.Errorf("writing credentials to stdout: %v", err)
+		}
+	case outputPowerShell:
+		if err := aws.WritePowerShellObject(creds, stdout); err != nil {
+			return fmt.Errorf("writing credentials to stdout: %v", err)
+		}
+	case outputSocket:
+		path := viper.GetString("global.socket-path")
+		if path == "" {
+			return errors.New("no socket specified. Use --socket or set global.socket-path")
+		}
+		if err := aws.WriteToSocket(creds, path, socketAttempts, time.Second); err != nil {
+			return err
+		}
+		log.Printf(color.GreenString("Credentials sent successfully to socket '%s'"), path)
+	case outputShell:
+		// Print credentials to shell using the correct syntax for the OS.
+		aws.WriteToShell(creds, runtime.GOOS == "windows", envPrefix, viper.GetBool("global.legacy-session-token"), os.Stdout)
+	default:
+		if getDiff {
+			diff, err := diffCredentialsFile(creds, app)
 			if err != nil {
-				return fmt.Errorf("creating credentials directory: %v", err)
+				return err
 			}
+			fmt.Fprint(stdout, diff)
+			return nil
 		}
+		return writeCredentialsFile(creds, app)
+	}
 
-		if err = aws.WriteToFile(creds, path, app); err != nil {
-			return fmt.Errorf("writing credentials to file: %v", err)
-		}
-		log.Printf(color.GreenString("Credentials written successfully to '%s'"), path)
+	return nil
+}
+
+// outputCredentials processes the given credentials of app according to mode and, with
+// --also-console, prints a console sign-in URL for them.
+func outputCredentials(creds *aws.Credentials, app, mode string) error {
+	if err := processCredentials(creds, app, mode); err != nil {
+		return err
+	}
+	if alsoConsole {
+		printConsoleURL(creds)
 	}
 
 	return nil
 }
 
+// consoleURL returns a URL signing in to the AWS console using the given credentials.
+var consoleURL = aws.ConsoleURL
+
+// printConsoleURL prints a URL signing in to the AWS console using the given credentials, which
+// have already been obtained for the CLI, so the role isn't assumed again. The URL is logged
+// rather than written to stdout, where it would mix with the credentials. Like the credentials,
+// the URL grants access to the role, but only until it's used or for 15 minutes at most. Since
+// the credentials have been processed already, a failure only results in a warning.
+func printConsoleURL(creds *aws.Credentials) {
+	u, err := consoleURL(creds)
+	if err != nil {
+		log.Printf(color.YellowString("Warning: could not get console sign-in URL: %v"), err)
+		return
+	}
+
+	log.Printf("Console sign-in URL (valid for 15 minutes):\n%s", u)
+}
+
+// printPolicies prints the names of the policies of the given role, which was assumed using creds.
+// Listing the policies is best-effort: failures are reported without failing the command.
+func printPolicies(creds *aws.Credentials, role string) {
+	p, err := aws.GetRolePolicies(creds, role)
+	if err == aws.ErrPoliciesDenied {
+		log.Printf("Not showing policies: role %s isn't allowed to list its policies", aws.RoleName(role))
+		return
+	}
+	if err != nil {
+		log.Printf(color.YellowString("Warning: could not list policies of role %s: %v"), aws.RoleName(role), err)
+		return
+	}
+
+	log.Printf("Policies of role %s:", aws.RoleName(role))
+	log.Printf("  Attached: %s", valueOrDefault(strings.Join(p.Attached, ", "), "<none>"))
+	log.Printf("  Inline: %s", valueOrDefault(strings.Join(p.Inline, ", "), "<none>"))
+}
+
+// profileName returns the name of the AWS profile to write the credentials of app to, using the
+// following order of preference: --profile -> $AWS_PROFILE -> profile template -> app name. role is
+// the ARN of the IAM role the credentials belong to, which is required for rendering the template.
+// Since the account alias isn't looked up, .Account is the account ID like .AccountID.
+func profileName(app, role string) (string, error) {
+	if profile != "" {
+		return profile, nil
+	}
+
+	if p := os.Getenv("AWS_PROFILE"); p != "" {
+		return p, nil
+	}
+
+	return appProfileName(app, role)
+}
+
+// appProfileName returns the name of the AWS profile app writes its credentials to unless another
+// profile is selected: the one rendered from the profile template, or the app name.
+func appProfileName(app, role string) (string, error) {
+	text := profileTemplate(app)
+	if text == "" {
+		return app, nil
+	}
+	if role == "" {
+		return "", errors.New("the profile template requires the IAM role, which isn't known yet")
+	}
+
+	t, err := parseProfileTemplate(text)
+	if err != nil {
+		return "", err
+	}
+
+	return renderProfileName(t, profileTemplateData{
+		App:       app,
+		Provider:  viper.GetString(fmt.Sprintf("apps.%s.provider", app)),
+		Account:   aws.AccountID(role),
+		AccountID: aws.AccountID(role),
+		Role:      aws.RoleName(role),
+	})
+}
+
+// selectRegion returns the AWS region to use for app. A region given using --region is used if it
+// is one of the app's regions (or the app has no regions configured). Otherwise, if the app has a
+// single region it is used and if it has multiple regions the user is asked to choose one. An
+// empty region is returned when no region is given or configured.
+func selectRegion(app string) (string, error) {
+	regions := viper.GetStringSlice(fmt.Sprintf("apps.%s.regions", app))
+
+	if awsRegion != "" {
+		if len(regions) == 0 {
+			return awsRegion, nil
+		}
+		for _, r := range regions {
+			if r == awsRegion {
+				return awsRegion, nil
+			}
+		}
+		return "", fmt.Errorf("region %s is not allowed for app %s. Allowed regions: %s",
+			awsRegion, app, strings.Join(regions, ", "))
+	}
+
+	switch len(regions) {
+	case 0:
+		return "", nil
+	case 1:
+		return regions[0], nil
+	}
+
+	i, err := prompt.Select("AWS region selection (use --region to avoid the prompt)",
+		"Please select an AWS region", regions)
+	if err != nil {
+		return "", err
+	}
+
+	return regions[i], nil
+}
+
 // sessionDuration returns a session duration using the following order of preference:
 // app.duration -> provider.duration -> hardcoded default of 3600
 func sessionDuration(app, provider string) int64 {
@@ -90,61 +477,183 @@ var cmdGet = &cobra.Command{
 assertion at the identity provider and using this assertion to retrieve
 temporary credentials from the cloud provider.
 
-If no app is specified, the selected app (if configured) will be assumed.`,
+If no app is specified, the selected app (if configured) will be assumed.
+Otherwise, when run interactively, a provider and one of its apps can be chosen
+from the configured apps.
+
+With --credential-process, the credentials are printed as JSON for use in the
+credential_process setting of an AWS CLI profile (see 'clisso export-config').
+With --output base64json, the same JSON is printed base64-encoded on a single
+line, and with --output pwsh-object as PowerShell code which assigns them to
+$ClissoCredentials. All other output, including prompts, is written to stderr
+in these modes.
+With --credential-process, the spinner and warnings are also disabled, and so is
+prompting if stderr isn't a terminal (see 'clisso credential-process').
+
+With --also-console, a URL which signs in to the AWS console as the assumed role
+is printed as well, in addition to the output of the chosen mode.
+
+With --diff, the changes to the credentials file and the AWS CLI config file are
+printed, with secrets redacted, instead of writing them.`,
 	Run: func(cmd *cobra.Command, args []string) {
+		if err := aws.ValidateEnvPrefix(envPrefix); err != nil {
+			log.Fatalf(color.RedString("Error validating flags: %v"), err)
+		}
+
 		var app string
-		if len(args) == 0 {
-			// No app specified.
-			selected := viper.GetString("global.selected-app")
-			if selected == "" {
-				// No default app configured.
-				log.Fatal(color.RedString("No app specified and no default app configured"))
-			}
-			app = selected
+		var err error
+		if len(args) == 0 && getTag != "" {
+			app, err = appWithTag(getTag)
 		} else {
-			// App specified - use it.
-			app = args[0]
+			app, err = appFromArgs(args)
+		}
+		if err == errNoApp && prompt.Optional() {
+			// Let occasional users pick one of the configured apps rather than remember its name.
+			app, err = pickApp(config.Apps())
+		}
+		if err != nil {
+			log.Fatal(color.RedString(err.Error()))
 		}
 
-		provider := viper.GetString(fmt.Sprintf("apps.%s.provider", app))
-		if provider == "" {
-			log.Fatalf(color.RedString("Could not get provider for app '%s'"), app)
+		mode, err := outputMode(app)
+		if err != nil {
+			log.Fatalf(color.RedString("Error validating output mode: %v"), err)
+		}
+		if alsoConsole && (mode == outputCredentialProcess || printExpiry) {
+			log.Fatal(color.RedString("Error validating flags: --also-console can't be used with --print-expiry or output mode credential-process"))
+		}
+		if _, err = minValidity(app); err != nil {
+			log.Fatalf(color.RedString("Error validating flags: %v"), err)
+		}
+		if getDiff && (mode != outputFile || printExpiry) {
+			log.Fatal(color.RedString("Error validating flags: --diff can only be used with output mode file and without --print-expiry"))
+		}
+		if printExpiry && (machineOutput(mode) || mode == outputShell) {
+			log.Fatalf(color.RedString("Error validating flags: --print-expiry can't be used with output mode %s"), mode)
+		}
+		if machineOutput(mode) || printExpiry {
+			redirectStdout()
 		}
+		credentialProcessContext(mode, term.IsTerminal(int(os.Stderr.Fd())))
 
-		pType := viper.GetString(fmt.Sprintf("providers.%s.type", provider))
-		if pType == "" {
-			log.Fatalf(color.RedString("Could not get provider type for provider '%s'"), provider)
+		if t := profileTemplate(app); t != "" {
+			if _, err = parseProfileTemplate(t); err != nil {
+				log.Fatalf(color.RedString("Error validating flags: %v"), err)
+			}
 		}
 
-		// allow preferred "arn" to be specified in the config file for each app
+		// allow preferred "arn" to be specified in the config file for each app, or using a role alias
 		// if this is not specified the value will be empty ("")
-		pArn := viper.GetString(fmt.Sprintf("apps.%s.arn", app))
+		pArn, err := preferredRole(app, roleAlias)
+		if err != nil {
+			log.Fatalf(color.RedString("Error validating flags: %v"), err)
+		}
+		if err = checkAllowedRole(app, pArn); err != nil {
+			log.Fatal(color.RedString(err.Error()))
+		}
 
-		duration := sessionDuration(app, provider)
+		if printExpiry && mode == outputFile {
+			// Valid credentials don't need to be obtained again just to report their expiration. A
+			// profile rendered from a template can only be found if the app's role is known.
+			if p, err := profileName(app, pArn); err == nil {
+				if creds, err := cachedCredentials(app, p); err == nil {
+					if err = writeExpiry(stdout, creds.Expiration); err != nil {
+						log.Fatalf(color.RedString("Error writing expiration: %v"), err)
+					}
+					return
+				}
+			}
+		}
+
+		provider, pType, err := appProvider(app)
+		if err != nil {
+			log.Fatal(color.RedString(err.Error()))
+		}
 
-		if pType == "onelogin" {
-			creds, err := onelogin.Get(app, provider, pArn, duration)
+		l, err := lockApp(app)
+		if err != nil {
+			log.Fatal(color.RedString(err.Error()))
+		}
+		if l != nil {
+			defer l.Release()
+		}
+
+		duration := sessionDuration(app, provider)
+		// Ask for a duration for ad-hoc use, unless the output is consumed by another program.
+		if !durationConfigured(app, provider) && !machineOutput(mode) && prompt.Optional() {
+			duration, err = askDuration(duration)
 			if err != nil {
-				log.Fatal(color.RedString("Could not get temporary credentials: "), err)
+				log.Fatal(color.RedString("Could not read session duration: "), err)
 			}
-			// Process credentials
-			err = processCredentials(creds, app)
-			if err != nil {
-				log.Fatalf(color.RedString("Error processing credentials: %v"), err)
+		}
+
+		region, err := selectRegion(app)
+		if err != nil {
+			log.Fatal(color.RedString("Could not select AWS region: "), err)
+		}
+
+		// The lock is held while looking for cached credentials, so that concurrent runs for the
+		// same app authenticate only once.
+		if mode == outputCredentialProcess {
+			if creds, err := cachedProcessCredentials(app, pArn, region, time.Now()); err == nil {
+				if err = processCredentials(creds, app, mode); err != nil {
+					log.Fatalf(color.RedString("Error processing credentials: %v"), err)
+				}
+				return
 			}
-		} else if pType == "okta" {
-			creds, err := okta.Get(app, provider, pArn, duration)
-			if err != nil {
+		}
+
+		assertion, err := samlAssertion(app, provider, pType)
+		if err != nil {
+			log.Fatal(color.RedString("Could not get temporary credentials: "), err)
+		}
+		if roleAlias != "" {
+			if err = checkRoleAlias(assertion, roleAlias, pArn); err != nil {
 				log.Fatal(color.RedString("Could not get temporary credentials: "), err)
 			}
-			// Process credentials
-			err = processCredentials(creds, app)
-			if err != nil {
-				log.Fatalf(color.RedString("Error processing credentials: %v"), err)
+		}
+
+		creds, role, err := assumeRole(app, assertion, pArn, duration, region)
+		if err != nil {
+			log.Fatal(color.RedString("Could not get temporary credentials: "), err)
+		}
+		if mode == outputCredentialProcess {
+			if err = cacheProcessCredentials(app, pArn, region, creds); err != nil {
+				log.Printf(color.YellowString("Warning: could not cache credentials: %v"), err)
 			}
-		} else {
-			log.Fatalf(color.RedString("Unsupported identity provider type '%s' for app '%s'"), pType, app)
 		}
-		printStatus()
+
+		// Process credentials
+		err = outputCredentials(creds, app, mode)
+		if err != nil {
+			log.Fatalf(color.RedString("Error processing credentials: %v"), err)
+		}
+
+		if !machineOutput(mode) {
+			log.Printf("Credentials expire at %s", formatExpiry(creds.Expiration))
+		}
+
+		if viper.GetBool("global.show-policies") {
+			printPolicies(creds, role)
+		}
+
+		// Credentials which haven't been written aren't audited and the hook isn't run for them.
+		if getDiff {
+			return
+		}
+		auditCredentials(app, provider, role, mode, creds)
+		if err = runPostHook(app, mode, creds); err != nil {
+			if viper.GetBool("global.post-hook-strict") {
+				log.Fatal(color.RedString(err.Error()))
+			}
+			log.Printf(color.YellowString("Warning: %v"), err)
+		}
+		if printExpiry {
+			if err = writeExpiry(stdout, creds.Expiration); err != nil {
+				log.Fatalf(color.RedString("Error writing expiration: %v"), err)
+			}
+		} else if !machineOutput(mode) {
+			printStatus()
+		}
 	},
 }
@@ -1,9 +1,17 @@
 package cmd
 
 import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/spf13/viper"
+
+	"github.com/allcloud-io/clisso/aws"
 )
 
 var testdata = []struct {
@@ -28,3 +36,229 @@ func TestSessionDuration(t *testing.T) {
 		}
 	}
 }
+
+func TestProfileName(t *testing.T) {
+	defer os.Unsetenv("AWS_PROFILE")
+	defer func() { profile = "" }()
+
+	for _, test := range []struct {
+		name       string
+		flag       string
+		awsProfile string
+		expect     string
+	}{
+		{"App name", "", "", "test"},
+		{"AWS_PROFILE", "", "env-profile", "env-profile"},
+		{"Flag", "flag-profile", "", "flag-profile"},
+		{"Flag and AWS_PROFILE", "flag-profile", "env-profile", "flag-profile"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			profile = test.flag
+			os.Setenv("AWS_PROFILE", test.awsProfile)
+
+			res, err := profileName("test", "")
+			if err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+			if res != test.expect {
+				t.Errorf("expected %q, received %q", test.expect, res)
+			}
+		})
+	}
+}
+
+func TestProfileNameTemplate(t *testing.T) {
+	os.Unsetenv("AWS_PROFILE")
+	viper.Set("apps.template-app.provider", "my-provider")
+	defer viper.Set("apps.template-app.profile-template", "")
+
+	for _, test := range []struct {
+		name        string
+		template    string
+		role        string
+		expect      string
+		expectError bool
+	}{
+		{"Account ID and role", "{{.AccountID}}_{{.Role}}", "arn:aws:iam::123456789012:role/AdminRole", "123456789012_AdminRole", false},
+		{"Role with path", "{{.AccountID}}_{{.Role}}", "arn:aws:iam::210987654321:role/team/ReadOnly", "210987654321_ReadOnly", false},
+		{"Account is the account ID", "{{.Account}}-{{.Role}}", "arn:aws:iam::123456789012:role/AdminRole", "123456789012-AdminRole", false},
+		{"App and provider", "{{.Provider}}/{{.App}}", "arn:aws:iam::123456789012:role/AdminRole", "my-provider-template-app", false},
+		{"Sanitized", "{{.Role}} (prod)", "arn:aws:iam::123456789012:role/AdminRole", "AdminRole-prod", false},
+		{"Unknown role", "{{.AccountID}}_{{.Role}}", "", "", true},
+		{"Empty name", "{{if false}}x{{end}}", "arn:aws:iam::123456789012:role/AdminRole", "", true},
+		{"Unknown variable", "{{.Region}}", "arn:aws:iam::123456789012:role/AdminRole", "", true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			viper.Set("apps.template-app.profile-template", test.template)
+
+			res, err := profileName("template-app", test.role)
+			if test.expectError && err == nil {
+				t.Errorf("expected error")
+			}
+			if !test.expectError && err != nil {
+				t.Errorf("unexpected error %+v", err)
+			}
+			if res != test.expect {
+				t.Errorf("expected %q, received %q", test.expect, res)
+			}
+		})
+	}
+
+	// An explicit profile takes precedence over the template.
+	viper.Set("apps.template-app.profile-template", "{{.AccountID}}_{{.Role}}")
+	profile = "flag-profile"
+	defer func() { profile = "" }()
+	if res, err := profileName("template-app", ""); err != nil || res != "flag-profile" {
+		t.Errorf("expected %q, received %q (error %v)", "flag-profile", res, err)
+	}
+}
+
+func TestOutputMode(t *testing.T) {
+	defer func() { output, printToShell, credentialProcess = "", false, false }()
+
+	for _, test := range []struct {
+		name              string
+		appOutput         string
+		output            string
+		printToShell      bool
+		credentialProcess bool
+		expect            string
+		expectError       bool
+	}{
+		{"Default", "", "", false, false, outputFile, false},
+		{"App setting", "shell", "", false, false, outputShell, false},
+		{"Invalid app setting", "console", "", false, false, "", true},
+		{"Output flag", "", "credential-process", false, false, outputCredentialProcess, false},
+		{"Base64 JSON output flag", "", "base64json", false, false, outputBase64JSON, false},
+		{"PowerShell output flag", "", "pwsh-object", false, false, outputPowerShell, false},
+		{"Output flag overrides app", "shell", "file", false, false, outputFile, false},
+		{"Shell flag overrides app", "credential-process", "", true, false, outputShell, false},
+		{"Credential process flag overrides app", "shell", "", false, true, outputCredentialProcess, false},
+		{"Invalid output flag", "", "console", false, false, "", true},
+		{"Conflicting flags", "", "file", true, false, "", true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			viper.Set("apps.output-app.output", test.appOutput)
+			output, printToShell, credentialProcess = test.output, test.printToShell, test.credentialProcess
+
+			res, err := outputMode("output-app")
+			if test.expectError && err == nil {
+				t.Errorf("expected error")
+			}
+			if !test.expectError && err != nil {
+				t.Errorf("unexpected error %+v", err)
+			}
+			if res != test.expect {
+				t.Errorf("expected %q, received %q", test.expect, res)
+			}
+		})
+	}
+}
+
+func TestOutputModeSocket(t *testing.T) {
+	defer func() { socketPath, printToShell = "", false }()
+	viper.Set("apps.output-app.output", "shell")
+
+	socketPath = "/tmp/agent.sock"
+	if res, err := outputMode("output-app"); err != nil || res != outputSocket {
+		t.Errorf("expected %q, received %q (error %v)", outputSocket, res, err)
+	}
+
+	printToShell = true
+	if _, err := outputMode("output-app"); err == nil {
+		t.Errorf("expected error when combining --socket and --shell")
+	}
+}
+
+func TestSelectRegion(t *testing.T) {
+	defer func() { awsRegion = "" }()
+	viper.Set("global.non-interactive", true)
+	defer viper.Set("global.non-interactive", false)
+
+	for _, test := range []struct {
+		name        string
+		regions     []string
+		flag        string
+		expect      string
+		expectError bool
+	}{
+		{"No regions", nil, "", "", false},
+		{"Flag without regions", nil, "eu-west-1", "eu-west-1", false},
+		{"Single region", []string{"eu-west-1"}, "", "eu-west-1", false},
+		{"Flag selects allowed region", []string{"eu-west-1", "us-east-1"}, "us-east-1", "us-east-1", false},
+		{"Flag selects disallowed region", []string{"eu-west-1", "us-east-1"}, "ap-south-1", "", true},
+		// The prompt fails in non-interactive mode.
+		{"Multiple regions", []string{"eu-west-1", "us-east-1"}, "", "", true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			viper.Set("apps.region-app.regions", test.regions)
+			awsRegion = test.flag
+
+			res, err := selectRegion("region-app")
+			if test.expectError && err == nil {
+				t.Errorf("expected error")
+			}
+			if !test.expectError && err != nil {
+				t.Errorf("unexpected error %+v", err)
+			}
+			if res != test.expect {
+				t.Errorf("expected %q, received %q", test.expect, res)
+			}
+		})
+	}
+}
+
+func TestOutputCredentialsAlsoConsole(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	var out bytes.Buffer
+	defer func(w io.Writer) { stdout = w }(stdout)
+	stdout = &out
+	defer func(f func(*aws.Credentials) (string, error)) { consoleURL = f }(consoleURL)
+	defer func() { alsoConsole = false }()
+
+	creds := &aws.Credentials{
+		AccessKeyID:     "expectedkey",
+		SecretAccessKey: "expectedsecret",
+		SessionToken:    "expectedtoken",
+		Expiration:      time.Date(2021, 2, 10, 10, 0, 0, 0, time.UTC),
+	}
+	var received *aws.Credentials
+	consoleURL = func(c *aws.Credentials) (string, error) {
+		received = c
+		return "https://signin.aws.amazon.com/federation?Action=login&SigninToken=token", nil
+	}
+
+	alsoConsole = true
+	if err := outputCredentials(creds, "console-app", outputBase64JSON); err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+
+	// The credentials are written as usual.
+	var expect bytes.Buffer
+	if err := aws.WriteBase64JSON(creds, &expect); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != expect.String() {
+		t.Errorf("expected output %q, received %q", expect.String(), out.String())
+	}
+	// The URL is obtained using the same credentials and kept out of the output.
+	if received != creds {
+		t.Errorf("expected the console URL to be obtained using the assumed credentials, received %+v", received)
+	}
+	if !strings.Contains(logged.String(), "https://signin.aws.amazon.com/federation?Action=login&SigninToken=token") {
+		t.Errorf("expected the console URL to be printed, received %q", logged.String())
+	}
+
+	// Without --also-console, no URL is obtained.
+	alsoConsole = false
+	received = nil
+	logged.Reset()
+	if err := outputCredentials(creds, "console-app", outputBase64JSON); err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	if received != nil || logged.Len() != 0 {
+		t.Errorf("expected no console URL, received %q", logged.String())
+	}
+}
@@ -0,0 +1,270 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/fatih/color"
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/allcloud-io/clisso/aws"
+	"github.com/allcloud-io/clisso/saml"
+	"github.com/allcloud-io/clisso/spinner"
+)
+
+var allPrefix string
+
+// invalidProfileChars matches characters which are replaced in the profile names of get-all, since
+// they aren't valid in (or would be ambiguous in) an ini section name.
+var invalidProfileChars = regexp.MustCompile(`[^A-Za-z0-9_.@+-]+`)
+
+func init() {
+	RootCmd.AddCommand(cmdGetAll)
+	cmdGetAll.Flags().StringVar(
+		&allPrefix, "prefix", "", "Prepend this prefix to the name of every profile",
+	)
+	// The flags are shared with get, which binds most of them to the global config.
+	cmdGetAll.Flags().AddFlag(cmdGet.Flags().Lookup("profile-template"))
+	cmdGetAll.Flags().AddFlag(cmdGet.Flags().Lookup("lock-wait"))
+	cmdGetAll.Flags().AddFlag(cmdGet.Flags().Lookup("no-keyring"))
+	cmdGetAll.Flags().AddFlag(cmdGet.Flags().Lookup("backup-code"))
+	cmdGetAll.Flags().AddFlag(cmdGet.Flags().Lookup("hide-otp"))
+	cmdGetAll.Flags().AddFlag(cmdGet.Flags().Lookup("hide-push-message"))
+	cmdGetAll.Flags().AddFlag(cmdGet.Flags().Lookup("record"))
+	cmdGetAll.Flags().AddFlag(cmdGet.Flags().Lookup("replay"))
+	cmdGetAll.Flags().AddFlag(cmdGet.Flags().Lookup("audit-log"))
+}
+
+// assumedRole is an IAM role assumed by get-all.
+type assumedRole struct {
+	arn saml.ARN
+	// account is the alias of the role's account or, if it can't be determined, the account ID.
+	account string
+	creds   *aws.Credentials
+}
+
+// profileTemplateData holds the variables available to profile name templates.
+type profileTemplateData struct {
+	App       string
+	Provider  string
+	Account   string
+	AccountID string
+	Role      string
+}
+
+// profileTemplate returns the profile name template of app from --profile-template,
+// apps.<app>.profile-template or global.profile-template, in this order of preference. An empty
+// string means the default naming is used.
+func profileTemplate(app string) string {
+	if profileTemplateText != "" {
+		return profileTemplateText
+	}
+	if t := viper.GetString(fmt.Sprintf("apps.%s.profile-template", app)); t != "" {
+		return t
+	}
+
+	return viper.GetString("global.profile-template")
+}
+
+// parseProfileTemplate parses a profile name template. The template is also rendered once with
+// empty values, so that references to unknown variables are detected before any credentials are
+// obtained.
+func parseProfileTemplate(text string) (*template.Template, error) {
+	t, err := template.New("profile").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parsing profile template: %v", err)
+	}
+	if err = t.Execute(&bytes.Buffer{}, profileTemplateData{}); err != nil {
+		return nil, fmt.Errorf("rendering profile template: %v", err)
+	}
+
+	return t, nil
+}
+
+// allProfileNames returns the names of the profiles to write the credentials of roles to. By
+// default a profile is named after its account, followed by the role name if there are several
+// roles in the account. If tmpl isn't nil, the names are rendered from it instead. The names are
+// sanitized, and an error is returned if they aren't unique.
+func allProfileNames(roles []assumedRole, prefix string, tmpl *template.Template, app, provider string) ([]string, error) {
+	count := make(map[string]int)
+	for _, r := range roles {
+		count[r.account]++
+	}
+
+	names := make([]string, len(roles))
+	seen := make(map[string]string)
+	for i, r := range roles {
+		var name string
+		if tmpl == nil {
+			name = r.account
+			if count[r.account] > 1 {
+				name += "-" + aws.RoleName(r.arn.Role)
+			}
+		} else {
+			var err error
+			name, err = renderProfileName(tmpl, profileTemplateData{
+				App:       app,
+				Provider:  provider,
+				Account:   r.account,
+				AccountID: aws.AccountID(r.arn.Role),
+				Role:      aws.RoleName(r.arn.Role),
+			})
+			if err != nil {
+				return nil, fmt.Errorf("role %s: %v", r.arn.Role, err)
+			}
+		}
+
+		name = sanitizeProfileName(prefix + name)
+		if other, ok := seen[name]; ok {
+			return nil, fmt.Errorf("roles %s and %s would both be written to profile '%s'", other, r.arn.Role, name)
+		}
+		seen[name] = r.arn.Role
+		names[i] = name
+	}
+
+	return names, nil
+}
+
+// renderProfileName renders a profile name from a template parsed by parseProfileTemplate. The
+// name is sanitized and an error is returned if it is empty.
+func renderProfileName(t *template.Template, data profileTemplateData) (string, error) {
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("rendering profile name: %v", err)
+	}
+
+	name := sanitizeProfileName(b.String())
+	if name == "" {
+		return "", errors.New("profile template renders an empty name")
+	}
+
+	return name, nil
+}
+
+// sanitizeProfileName replaces runs of characters which aren't valid in a profile name with a
+// dash, and trims leading and trailing dashes.
+func sanitizeProfileName(name string) string {
+	return strings.Trim(invalidProfileChars.ReplaceAllString(name, "-"), "-")
+}
+
+// accountName returns the alias of the account the credentials belong to, falling back to the ID
+// of the account if the alias can't be retrieved, e.g. because iam:ListAccountAliases is denied.
+func accountName(creds *aws.Credentials, roleArn string) string {
+	alias, err := aws.GetAccountAlias(creds)
+	if err != nil {
+		return aws.AccountID(roleArn)
+	}
+
+	return alias
+}
+
+var cmdGetAll = &cobra.Command{
+	Use:   "get-all",
+	Short: "Get temporary credentials for all roles of an app",
+	Long: `Obtain temporary credentials for every IAM role contained in the SAML
+assertion of the specified app and write them to the credentials file. Each
+profile is named after the alias of the role's AWS account (or the account ID
+if the alias can't be retrieved), followed by the role name if the account has
+several roles. Use --profile-template to name the profiles differently.
+
+If no app is specified, the selected app (if configured) will be assumed.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		app, err := appFromArgs(args)
+		if err != nil {
+			log.Fatal(color.RedString(err.Error()))
+		}
+
+		provider, pType, err := appProvider(app)
+		if err != nil {
+			log.Fatal(color.RedString(err.Error()))
+		}
+
+		var tmpl *template.Template
+		if text := profileTemplate(app); text != "" {
+			tmpl, err = parseProfileTemplate(text)
+			if err != nil {
+				log.Fatal(color.RedString(err.Error()))
+			}
+		}
+
+		l, err := lockApp(app)
+		if err != nil {
+			log.Fatal(color.RedString(err.Error()))
+		}
+		if l != nil {
+			defer l.Release()
+		}
+
+		duration := sessionDuration(app, provider)
+
+		assertion, err := samlAssertion(app, provider, pType)
+		if err != nil {
+			log.Fatal(color.RedString("Could not get temporary credentials: "), err)
+		}
+
+		if err = checkAssertion(app, assertion); err != nil {
+			log.Fatal(color.RedString("Could not get temporary credentials: "), err)
+		}
+
+		arns, err := appRoles(app, assertion)
+		if err != nil {
+			log.Fatal(color.RedString("Could not get temporary credentials: "), err)
+		}
+
+		var roles []assumedRole
+		s := spinner.New()
+		for _, arn := range arns {
+			creds, _, err := assumeWithFallback(app, arn, assertion, duration, "", aws.RoleName(arn.Role))
+			if err != nil {
+				log.Printf(color.YellowString("Could not assume role %s: %v"), arn.Role, err)
+				continue
+			}
+
+			s.SetMessage(aws.RoleName(arn.Role))
+			s.Start()
+			account := accountName(creds, arn.Role)
+			s.Stop()
+
+			roles = append(roles, assumedRole{arn: arn, account: account, creds: creds})
+		}
+		s.SetMessage("")
+
+		if len(roles) == 0 {
+			log.Fatal(color.RedString("Could not assume any role"))
+		}
+
+		names, err := allProfileNames(roles, allPrefix, tmpl, app, provider)
+		if err != nil {
+			log.Fatal(color.RedString(err.Error()))
+		}
+
+		path, err := homedir.Expand(viper.GetString("global.credentials-path"))
+		if err != nil {
+			log.Fatalf(color.RedString("Error expanding credentials file path: %v"), err)
+		}
+		if err = ensureParentDir(path, "Credentials"); err != nil {
+			log.Fatal(color.RedString(err.Error()))
+		}
+
+		key, err := expirationKey()
+		if err != nil {
+			log.Fatal(color.RedString(err.Error()))
+		}
+		for i, name := range names {
+			if err = aws.WriteToFile(roles[i].creds, path, name, key); err != nil {
+				log.Fatalf(color.RedString("Error writing credentials to file: %v"), err)
+			}
+			log.Printf(color.GreenString("Credentials for role %s written to profile '%s'"), roles[i].arn.Role, name)
+			auditCredentials(app, provider, roles[i].arn.Role, outputFile, roles[i].creds)
+		}
+		fmt.Println()
+		printStatus()
+	},
+}
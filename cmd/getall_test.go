@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/allcloud-io/clisso/saml"
+)
+
+func TestAllProfileNames(t *testing.T) {
+	roles := []assumedRole{
+		{arn: saml.ARN{Role: "arn:aws:iam::111111111111:role/Admin"}, account: "prod"},
+		{arn: saml.ARN{Role: "arn:aws:iam::111111111111:role/path/ReadOnly"}, account: "prod"},
+		{arn: saml.ARN{Role: "arn:aws:iam::222222222222:role/Admin"}, account: "staging"},
+		{arn: saml.ARN{Role: "arn:aws:iam::333333333333:role/Admin"}, account: "333333333333"},
+	}
+
+	for _, test := range []struct {
+		name   string
+		prefix string
+		expect []string
+	}{
+		{"No prefix", "", []string{"prod-Admin", "prod-ReadOnly", "staging", "333333333333"}},
+		{"Prefix", "sso-", []string{"sso-prod-Admin", "sso-prod-ReadOnly", "sso-staging", "sso-333333333333"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := allProfileNames(roles, test.prefix, nil, "test", "test")
+			if err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+			if !reflect.DeepEqual(res, test.expect) {
+				t.Errorf("expected %q, received %q", test.expect, res)
+			}
+		})
+	}
+}
+
+func TestAllProfileNamesInvalid(t *testing.T) {
+	// Default names are sanitized like rendered ones.
+	roles := []assumedRole{{arn: saml.ARN{Role: "arn:aws:iam::111111111111:role/Admin"}, account: "prod"}}
+	res, err := allProfileNames(roles, "my sso/", nil, "test", "test")
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	if expect := []string{"my-sso-prod"}; !reflect.DeepEqual(res, expect) {
+		t.Errorf("expected %q, received %q", expect, res)
+	}
+
+	// Roles with the same name in an account would be written to the same profile.
+	roles = []assumedRole{
+		{arn: saml.ARN{Role: "arn:aws:iam::111111111111:role/Admin"}, account: "prod"},
+		{arn: saml.ARN{Role: "arn:aws:iam::111111111111:role/path/Admin"}, account: "prod"},
+	}
+	if _, err := allProfileNames(roles, "", nil, "test", "test"); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestAllProfileNamesTemplate(t *testing.T) {
+	roles := []assumedRole{
+		{arn: saml.ARN{Role: "arn:aws:iam::111111111111:role/Admin"}, account: "prod"},
+		{arn: saml.ARN{Role: "arn:aws:iam::111111111111:role/path/ReadOnly"}, account: "prod"},
+		{arn: saml.ARN{Role: "arn:aws:iam::222222222222:role/Admin"}, account: "staging"},
+		{arn: saml.ARN{Role: "arn:aws:iam::333333333333:role/Power User"}, account: "333333333333"},
+	}
+	templates := []string{
+		"{{.Account}}-{{.Role}}",
+		"{{.App}}/{{.AccountID}}/{{.Role}}",
+		"{{.Provider}} {{.Account}} [{{.Role | printf \"%.5s\"}}]",
+	}
+
+	var got []string
+	for _, text := range templates {
+		tmpl, err := parseProfileTemplate(text)
+		if err != nil {
+			t.Fatalf("unexpected error %+v", err)
+		}
+		names, err := allProfileNames(roles, "sso-", tmpl, "my-app", "my-provider")
+		if err != nil {
+			t.Fatalf("unexpected error %+v", err)
+		}
+		got = append(got, text)
+		got = append(got, names...)
+	}
+
+	b, err := ioutil.ReadFile("testdata/profile-names")
+	if err != nil {
+		t.Fatalf("could not read golden file: %v", err)
+	}
+	if want := strings.Split(strings.TrimSpace(string(b)), "\n"); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %q, received %q", want, got)
+	}
+}
+
+func TestAllProfileNamesTemplateDuplicates(t *testing.T) {
+	roles := []assumedRole{
+		{arn: saml.ARN{Role: "arn:aws:iam::111111111111:role/Admin"}, account: "prod"},
+		{arn: saml.ARN{Role: "arn:aws:iam::111111111111:role/ReadOnly"}, account: "prod"},
+	}
+
+	tmpl, err := parseProfileTemplate("{{.Account}}")
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	if _, err := allProfileNames(roles, "", tmpl, "test", "test"); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestParseProfileTemplate(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		text        string
+		expectError bool
+	}{
+		{"Valid", "{{.Account}}-{{.Role}}", false},
+		{"Syntax error", "{{.Account", true},
+		{"Unknown variable", "{{.Region}}", true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseProfileTemplate(test.text)
+			if test.expectError && err == nil {
+				t.Errorf("expected error")
+			}
+			if !test.expectError && err != nil {
+				t.Errorf("unexpected error %+v", err)
+			}
+		})
+	}
+}
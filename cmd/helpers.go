@@ -1,10 +1,21 @@
 package cmd
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/allcloud-io/clisso/okta"
+	"github.com/allcloud-io/clisso/onelogin"
+	"github.com/allcloud-io/clisso/saml"
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 func mandatoryFlag(cmd *cobra.Command, name string)  {
@@ -12,4 +23,156 @@ func mandatoryFlag(cmd *cobra.Command, name string)  {
 	if err != nil {
 		log.Fatalf(color.RedString("Error marking flag %s as required: %v"), name, err)
 	}
-}
\ No newline at end of file
+}
+
+// errNoApp is returned by appFromArgs when no app is specified and no app is selected.
+var errNoApp = errors.New("No app specified and no default app configured")
+
+// appFromArgs returns the app specified in args or, if no app was specified, the selected app.
+func appFromArgs(args []string) (string, error) {
+	if len(args) > 0 {
+		// App specified - use it.
+		return args[0], nil
+	}
+
+	// No app specified.
+	selected := viper.GetString("global.selected-app")
+	if selected == "" {
+		// No default app configured.
+		return "", errNoApp
+	}
+
+	return selected, nil
+}
+
+// appProvider returns the name and type of the provider used by app.
+func appProvider(app string) (provider, pType string, err error) {
+	provider = viper.GetString(fmt.Sprintf("apps.%s.provider", app))
+	if provider == "" {
+		return "", "", fmt.Errorf("Could not get provider for app '%s'", app)
+	}
+
+	pType = viper.GetString(fmt.Sprintf("providers.%s.type", provider))
+	if pType == "" {
+		return "", "", fmt.Errorf("Could not get provider type for provider '%s'", provider)
+	}
+
+	return provider, pType, nil
+}
+
+// defaultAssertionRetries is the number of times an assertion without roles is fetched again,
+// unless configured otherwise using the provider's assertion-retries.
+const defaultAssertionRetries = 1
+
+// assertionRetryDelay is the time to wait before fetching an assertion without roles again.
+var assertionRetryDelay = time.Second
+
+// samlAssertion authenticates against the identity provider of app and returns a SAML assertion
+// for the app. An assertion without roles, which may be caused by a transient problem of the
+// identity provider, is fetched again as configured by the provider's assertion-retries.
+func samlAssertion(app, provider, pType string) (string, error) {
+	sess, err := newSession(provider, pType)
+	if err != nil {
+		return "", err
+	}
+	defer sess.Close()
+
+	return sessionAssertion(sess, app, provider)
+}
+
+// sessionAssertion returns a SAML assertion for app obtained using sess, fetching it again as
+// configured by the provider's assertion-retries if it contains no roles.
+func sessionAssertion(sess idpSession, app, provider string) (string, error) {
+	retries := defaultAssertionRetries
+	if key := fmt.Sprintf("providers.%s.assertion-retries", provider); viper.IsSet(key) {
+		retries = viper.GetInt(key)
+	}
+
+	return fetchAssertion(retries, func() (string, error) {
+		return sess.SAMLAssertion(app)
+	})
+}
+
+// fetchAssertion returns the SAML assertion returned by fetch, calling fetch up to retries more
+// times while the assertion contains no roles.
+func fetchAssertion(retries int, fetch func() (string, error)) (string, error) {
+	for attempt := 0; ; attempt++ {
+		assertion, err := fetch()
+		if err != nil {
+			return "", err
+		}
+
+		// Other problems of the assertion are reported when it is used.
+		if _, err = saml.Roles(assertion); err != saml.ErrNoRoles {
+			return assertion, nil
+		}
+		if attempt >= retries {
+			return "", errors.New("the SAML assertion contains no AWS roles. Make sure your user is " +
+				"assigned an AWS role in the app at the identity provider, or try again later")
+		}
+
+		log.Printf(color.YellowString("The SAML assertion contains no AWS roles, fetching it again (retry %d/%d)"),
+			attempt+1, retries)
+		time.Sleep(assertionRetryDelay)
+	}
+}
+
+// idpSession is a session at an identity provider, which returns SAML assertions for several apps
+// of the provider while authenticating the user once.
+type idpSession interface {
+	SAMLAssertion(app string) (string, error)
+	Close()
+}
+
+// newSession returns a session at provider, whose type is pType.
+var newSession = func(provider, pType string) (idpSession, error) {
+	switch pType {
+	case "onelogin":
+		return onelogin.NewSession(provider)
+	case "okta":
+		if viper.GetString("global.record") != "" || viper.GetString("global.replay") != "" {
+			return nil, errors.New("recording and replaying requests is only supported for OneLogin")
+		}
+		return okta.NewSession(provider)
+	default:
+		return nil, fmt.Errorf("Unsupported identity provider type '%s' for provider '%s'", pType, provider)
+	}
+}
+
+// ensureParentDir creates the parent directory of path if it doesn't exist. kind describes the
+// contents of the directory in log messages.
+func ensureParentDir(path, kind string) error {
+	dir := filepath.Dir(path)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		log.Printf(color.YellowString("%s directory '%s' does not exist - creating it"), kind, dir)
+
+		if err = os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("creating %s directory: %v", strings.ToLower(kind), err)
+		}
+	}
+
+	return nil
+}
+
+// stdout is the process's standard output, which remains available after redirectStdout.
+var stdout io.Writer = os.Stdout
+
+// redirectStdout sends everything which would otherwise be printed to stdout - prompts, the
+// spinner and log messages - to stderr, so that stdout carries only machine-readable output.
+func redirectStdout() {
+	os.Stdout = os.Stderr
+	color.Output = color.Error
+	log.SetOutput(color.Error)
+}
+
+// withStdoutRedirected calls f with stdout redirected as by redirectStdout, restoring it afterwards.
+func withStdoutRedirected(f func()) {
+	out, colorOut, logOut := os.Stdout, color.Output, log.Writer()
+	defer func() {
+		os.Stdout, color.Output = out, colorOut
+		log.SetOutput(logOut)
+	}()
+
+	redirectStdout()
+	f()
+}
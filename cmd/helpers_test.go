@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFetchAssertion(t *testing.T) {
+	defer func(d time.Duration) { assertionRetryDelay = d }(assertionRetryDelay)
+	assertionRetryDelay = 0
+
+	var data []string
+	for _, f := range []string{"no-arns-response", "valid-response"} {
+		b, err := ioutil.ReadFile("../saml/testdata/" + f)
+		if err != nil {
+			t.Fatalf("could not read test data: %v", err)
+		}
+		data = append(data, string(b))
+	}
+	empty, valid := data[0], data[1]
+
+	for _, test := range []struct {
+		name          string
+		retries       int
+		responses     []string
+		expect        string
+		expectFetches int
+		expectError   bool
+	}{
+		{"Roles", 1, []string{valid}, valid, 1, false},
+		{"Retry then success", 2, []string{empty, empty, valid}, valid, 3, false},
+		{"Persistently empty", 2, []string{empty, empty, empty, valid}, "", 3, true},
+		{"Retries disabled", 0, []string{empty, valid}, "", 1, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			fetches := 0
+			assertion, err := fetchAssertion(test.retries, func() (string, error) {
+				fetches++
+				return test.responses[fetches-1], nil
+			})
+			if test.expectError && (err == nil || !strings.Contains(err.Error(), "contains no AWS roles")) {
+				t.Errorf("expected error about missing roles, received %v", err)
+			}
+			if !test.expectError && err != nil {
+				t.Errorf("unexpected error %+v", err)
+			}
+			if assertion != test.expect {
+				t.Errorf("expected assertion %d bytes long, received %d bytes", len(test.expect), len(assertion))
+			}
+			if fetches != test.expectFetches {
+				t.Errorf("expected %d fetches, received %d", test.expectFetches, fetches)
+			}
+		})
+	}
+
+	// Errors of the identity provider aren't retried.
+	fetches := 0
+	_, err := fetchAssertion(2, func() (string, error) {
+		fetches++
+		return "", errors.New("invalid password")
+	})
+	if err == nil || fetches != 1 {
+		t.Errorf("expected a single failed fetch, received %d fetches and error %v", fetches, err)
+	}
+}
@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/viper"
+
+	"github.com/allcloud-io/clisso/aws"
+	"github.com/allcloud-io/clisso/onelogin"
+)
+
+// defaultHookTimeout is the time a post hook may run unless configured otherwise, in seconds.
+const defaultHookTimeout = 30
+
+// postHook returns the command to run after obtaining credentials for app using the following
+// order of preference: app.post-hook -> global.post-hook
+func postHook(app string) string {
+	if h := viper.GetString(fmt.Sprintf("apps.%s.post-hook", app)); h != "" {
+		return h
+	}
+
+	return viper.GetString("global.post-hook")
+}
+
+// hookEnv returns the environment for a post hook: the environment of clisso, the credentials in
+// the variables used by the AWS CLI and SDKs and information about the run. The OTP and any AWS
+// variables clisso was started with, e.g. the credentials of another role, are left out so that
+// the hook only receives the new credentials.
+func hookEnv(app, profile, mode string, creds *aws.Credentials) []string {
+	var env []string
+	for _, v := range os.Environ() {
+		if strings.HasPrefix(v, "AWS_") || strings.HasPrefix(v, onelogin.OTPEnvVar+"=") {
+			continue
+		}
+		env = append(env, v)
+	}
+
+	env = append(env,
+		"AWS_ACCESS_KEY_ID="+creds.AccessKeyID,
+		"AWS_SECRET_ACCESS_KEY="+creds.SecretAccessKey,
+		"AWS_SESSION_TOKEN="+creds.SessionToken,
+		"AWS_SESSION_EXPIRATION="+creds.Expiration.UTC().Format(time.RFC3339),
+		"CLISSO_APP="+app,
+		"CLISSO_PROFILE="+profile,
+		"CLISSO_OUTPUT="+mode,
+	)
+	if creds.Region != "" {
+		env = append(env, "AWS_REGION="+creds.Region, "AWS_DEFAULT_REGION="+creds.Region)
+	}
+
+	return env
+}
+
+// runPostHook runs the post hook configured for app, if any, using the system shell. The hook's
+// output is logged. An error is returned if the hook fails or doesn't finish within the
+// configured timeout.
+func runPostHook(app, mode string, creds *aws.Credentials) error {
+	hook := postHook(app)
+	if hook == "" {
+		return nil
+	}
+
+	timeout := viper.GetInt64("global.post-hook-timeout")
+	if timeout <= 0 {
+		timeout = defaultHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", hook)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", hook)
+	}
+	p, err := profileName(app, creds.RoleArn)
+	if err != nil {
+		return err
+	}
+	cmd.Env = hookEnv(app, p, mode, creds)
+
+	// Capture output in a file rather than a pipe: processes started by the hook may keep a pipe
+	// open after the hook is killed on timeout, which would block until they exit.
+	out, err := ioutil.TempFile("", "clisso-hook")
+	if err != nil {
+		return fmt.Errorf("creating file for post hook output: %v", err)
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+	cmd.Stdout = out
+	cmd.Stderr = out
+
+	err = cmd.Run()
+	if b, rerr := ioutil.ReadFile(out.Name()); rerr == nil {
+		if s := strings.TrimSpace(string(b)); s != "" {
+			log.Printf("Post hook output:\n%s", s)
+		}
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("post hook timed out after %d seconds", timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("running post hook: %v", err)
+	}
+	log.Println(color.GreenString("Post hook ran successfully"))
+
+	return nil
+}
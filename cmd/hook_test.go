@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/allcloud-io/clisso/aws"
+)
+
+func TestRunPostHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hook commands in this test require a POSIX shell")
+	}
+	os.Unsetenv("AWS_PROFILE")
+	defer viper.Set("apps.hook-app.post-hook", "")
+	defer viper.Set("global.post-hook-timeout", 0)
+
+	dir, err := ioutil.TempDir("", "clisso")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+
+	creds := &aws.Credentials{
+		AccessKeyID:     "testkey",
+		SecretAccessKey: "testsecret",
+		SessionToken:    "testtoken",
+		Expiration:      time.Date(2021, 3, 4, 12, 30, 0, 0, time.UTC),
+	}
+
+	viper.Set("apps.hook-app.post-hook",
+		`echo "$CLISSO_APP $CLISSO_PROFILE $CLISSO_OUTPUT $AWS_ACCESS_KEY_ID $AWS_SESSION_EXPIRATION" > `+out)
+	if err := runPostHook("hook-app", outputFile, creds); err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+
+	b, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal("Hook didn't run: ", err)
+	}
+	want := "hook-app hook-app file testkey 2021-03-04T12:30:00Z"
+	if got := strings.TrimSpace(string(b)); got != want {
+		t.Errorf("expected %q, received %q", want, got)
+	}
+
+	viper.Set("apps.hook-app.post-hook", "exit 3")
+	if err := runPostHook("hook-app", outputFile, creds); err == nil {
+		t.Errorf("expected error for failing hook")
+	}
+
+	viper.Set("apps.hook-app.post-hook", "sleep 5")
+	viper.Set("global.post-hook-timeout", 1)
+	start := time.Now()
+	if err := runPostHook("hook-app", outputFile, creds); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("expected timeout error, received %v", err)
+	}
+	if time.Since(start) > 4*time.Second {
+		t.Errorf("hook wasn't stopped on timeout")
+	}
+
+	viper.Set("apps.hook-app.post-hook", "")
+	if err := runPostHook("hook-app", outputFile, creds); err != nil {
+		t.Errorf("unexpected error without hook %+v", err)
+	}
+}
+
+func TestHookEnv(t *testing.T) {
+	for k, v := range map[string]string{
+		"CLISSO_OTP":            "123456",
+		"AWS_ACCESS_KEY_ID":     "oldkey",
+		"AWS_SECRET_ACCESS_KEY": "oldsecret",
+		"AWS_PROFILE":           "other",
+		"CLISSO_TEST_VAR":       "kept",
+	} {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+
+	env := hookEnv("hook-app", "hook-profile", outputFile, &aws.Credentials{AccessKeyID: "testkey"})
+
+	vars := make(map[string][]string)
+	for _, e := range env {
+		parts := strings.SplitN(e, "=", 2)
+		vars[parts[0]] = append(vars[parts[0]], parts[1])
+	}
+	for k, expect := range map[string]string{
+		"AWS_ACCESS_KEY_ID": "testkey",
+		"CLISSO_APP":        "hook-app",
+		"CLISSO_TEST_VAR":   "kept",
+	} {
+		if len(vars[k]) != 1 || vars[k][0] != expect {
+			t.Errorf("%s: expected %q, received %q", k, expect, vars[k])
+		}
+	}
+	for _, k := range []string{"CLISSO_OTP", "AWS_PROFILE"} {
+		if len(vars[k]) != 0 {
+			t.Errorf("expected no %s, received %q", k, vars[k])
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/viper"
+
+	"github.com/allcloud-io/clisso/lock"
+)
+
+// defaultLockWait is the time to wait for another run for the same app to finish, in seconds.
+const defaultLockWait = 60
+
+// lockPath returns the path of the lock file of app.
+func lockPath(app string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("getting cache directory: %v", err)
+	}
+
+	return filepath.Join(dir, "clisso", app+".lock"), nil
+}
+
+// lockApp prevents other clisso runs for app from prompting for input and writing credentials at
+// the same time as this one. It waits for up to global.lock-wait seconds for a concurrent run to
+// finish. A nil lock is returned if locking is disabled using a negative wait. The lock is
+// released by the OS when the process exits, including when it's interrupted.
+func lockApp(app string) (*lock.Lock, error) {
+	wait := viper.GetInt("global.lock-wait")
+	if wait < 0 {
+		return nil, nil
+	}
+
+	path, err := lockPath(app)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("creating lock directory: %v", err)
+	}
+
+	l, err := lock.Acquire(path, 0)
+	if err == lock.ErrLocked && wait > 0 {
+		log.Printf(color.YellowString("Waiting for another clisso run for app '%s' to finish"), app)
+		l, err = lock.Acquire(path, time.Duration(wait)*time.Second)
+	}
+	if err == lock.ErrLocked {
+		return nil, fmt.Errorf("another clisso run for app '%s' is in progress", app)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestLockApp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clisso-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// os.UserCacheDir is based on these variables, depending on the OS.
+	for _, v := range []string{"XDG_CACHE_HOME", "HOME", "LocalAppData"} {
+		defer os.Setenv(v, os.Getenv(v))
+		os.Setenv(v, dir)
+	}
+	defer viper.Set("global.lock-wait", nil)
+
+	viper.Set("global.lock-wait", 0)
+	l, err := lockApp("lock-app")
+	if err != nil || l == nil {
+		t.Fatalf("expected lock, received %v (error %v)", l, err)
+	}
+	defer l.Release()
+
+	if _, err := lockApp("lock-app"); err == nil {
+		t.Errorf("expected error while another run holds the lock")
+	}
+
+	// Other apps aren't affected.
+	l2, err := lockApp("other-app")
+	if err != nil {
+		t.Errorf("unexpected error %+v", err)
+	} else {
+		l2.Release()
+	}
+
+	viper.Set("global.lock-wait", -1)
+	if l, err := lockApp("lock-app"); l != nil || err != nil {
+		t.Errorf("expected locking to be disabled, received %v (error %v)", l, err)
+	}
+}
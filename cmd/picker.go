@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+
+	"github.com/allcloud-io/clisso/config"
+	"github.com/allcloud-io/clisso/prompt"
+)
+
+// selectOption is replaced in tests.
+var selectOption = prompt.Select
+
+// appsByProvider returns the names of the given apps by the name of their provider, in the order
+// in which they are given. Apps without a provider are omitted.
+func appsByProvider(names []string) map[string][]string {
+	apps := make(map[string][]string)
+	for _, app := range names {
+		if p := viper.GetString(fmt.Sprintf("apps.%s.provider", app)); p != "" {
+			apps[p] = append(apps[p], app)
+		}
+	}
+
+	return apps
+}
+
+// pickApp asks the user to choose a provider and then one of the provider's apps. The provider
+// isn't asked for if the apps of a single provider are configured, and the app isn't asked for if
+// the provider has a single app. Only the given apps, e.g. all configured apps, can be chosen.
+//
+// The menus are written to stderr, since whether stdout carries machine-readable output depends on
+// the output mode of the app which is picked.
+func pickApp(names []string) (app string, err error) {
+	withStdoutRedirected(func() {
+		app, err = chooseApp(names)
+	})
+
+	return app, err
+}
+
+func chooseApp(names []string) (string, error) {
+	apps := appsByProvider(names)
+	if len(apps) == 0 {
+		return "", errors.New("No app specified and no apps configured")
+	}
+
+	providers := make([]string, 0, len(apps))
+	for p := range apps {
+		providers = append(providers, p)
+	}
+	sort.Strings(providers)
+
+	provider := providers[0]
+	if len(providers) > 1 {
+		i, err := selectOption("provider selection (specify an app to avoid the prompt)", "Please select a provider", providers)
+		if err != nil {
+			return "", err
+		}
+		provider = providers[i]
+	}
+
+	app := apps[provider][0]
+	if len(apps[provider]) > 1 {
+		i, err := selectOption("app selection (specify an app to avoid the prompt)",
+			fmt.Sprintf("Please select an app of provider %s", provider), apps[provider])
+		if err != nil {
+			return "", err
+		}
+		app = apps[provider][i]
+	}
+
+	log.Printf("Using app %s. To skip the selection next time, run 'clisso get %s' or 'clisso apps select %s'", app, app, app)
+
+	return app, nil
+}
+
+// appWithTag returns the app which has tag. If several apps have the tag, the user is asked to
+// choose one of them if possible.
+func appWithTag(tag string) (string, error) {
+	apps := config.AppsWithTags(tag)
+	switch {
+	case len(apps) == 0:
+		return "", fmt.Errorf("No app has the tag '%s'", tag)
+	case len(apps) == 1:
+		return apps[0], nil
+	case prompt.Optional():
+		return pickApp(apps)
+	default:
+		return "", fmt.Errorf("Several apps have the tag '%s': %s. Please specify one of them", tag, strings.Join(apps, ", "))
+	}
+}
@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/allcloud-io/clisso/config"
+)
+
+func TestPickApp(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	defer func(f func(string, string, []string) (int, error)) { selectOption = f }(selectOption)
+
+	for _, test := range []struct {
+		name       string
+		apps       map[string]string
+		selections []int
+		expectApp  string
+		expectAsks [][]string
+	}{
+		{
+			name:       "Provider and app",
+			apps:       map[string]string{"dev": "okta-prov", "prod": "ol-prov", "staging": "ol-prov"},
+			selections: []int{1, 1},
+			expectApp:  "staging",
+			expectAsks: [][]string{{"okta-prov", "ol-prov"}, {"prod", "staging"}},
+		},
+		{
+			name:       "Single provider",
+			apps:       map[string]string{"prod": "ol-prov", "staging": "ol-prov"},
+			selections: []int{0},
+			expectApp:  "prod",
+			expectAsks: [][]string{{"prod", "staging"}},
+		},
+		{
+			name:       "Single app of the selected provider",
+			apps:       map[string]string{"dev": "okta-prov", "prod": "ol-prov", "staging": "ol-prov"},
+			selections: []int{0},
+			expectApp:  "dev",
+			expectAsks: [][]string{{"okta-prov", "ol-prov"}},
+		},
+		{
+			name:      "Single app",
+			apps:      map[string]string{"prod": "ol-prov"},
+			expectApp: "prod",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			viper.Set("apps", nil)
+			defer viper.Set("apps", nil)
+			for app, provider := range test.apps {
+				viper.Set("apps."+app+".provider", provider)
+			}
+
+			var asks [][]string
+			selectOption = func(input, message string, options []string) (int, error) {
+				// stdout may carry machine-readable output.
+				if os.Stdout != os.Stderr {
+					t.Errorf("expected the menu to be written to stderr")
+				}
+				asks = append(asks, options)
+				return test.selections[len(asks)-1], nil
+			}
+
+			stdout := os.Stdout
+			app, err := pickApp(config.Apps())
+			if err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+			if os.Stdout != stdout {
+				t.Errorf("expected stdout to be restored")
+			}
+			if app != test.expectApp {
+				t.Errorf("expected app %s, received %s", test.expectApp, app)
+			}
+			if !reflect.DeepEqual(asks, test.expectAsks) {
+				t.Errorf("expected to be asked %v, received %v", test.expectAsks, asks)
+			}
+		})
+	}
+}
+
+func TestPickAppNoApps(t *testing.T) {
+	viper.Set("apps", nil)
+
+	if _, err := pickApp(config.Apps()); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestAppWithTag(t *testing.T) {
+	viper.Set("apps", nil)
+	defer viper.Set("apps", nil)
+	viper.Set("apps.prod.provider", "ol-prov")
+	viper.Set("apps.prod.tags", []string{"prod"})
+	viper.Set("apps.staging.provider", "ol-prov")
+	viper.Set("apps.staging.tags", []string{"staging", "eu"})
+	viper.Set("apps.dev.provider", "ol-prov")
+	viper.Set("apps.dev.tags", []string{"eu"})
+
+	app, err := appWithTag("prod")
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	if app != "prod" {
+		t.Errorf("expected app prod, received %s", app)
+	}
+
+	// stdin isn't a terminal in tests, so the user can't choose between several apps.
+	if _, err = appWithTag("eu"); err == nil || !strings.Contains(err.Error(), "dev, staging") {
+		t.Errorf("expected error listing the apps, received %v", err)
+	}
+	if _, err = appWithTag("test"); err == nil {
+		t.Errorf("expected error")
+	}
+}
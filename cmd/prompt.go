@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/fatih/color"
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/allcloud-io/clisso/aws"
+)
+
+// Remaining times below which the output of the prompt command turns yellow and red.
+const (
+	promptWarnRemaining     = time.Hour
+	promptCriticalRemaining = 15 * time.Minute
+)
+
+var promptNoColor bool
+
+func init() {
+	RootCmd.AddCommand(cmdPrompt)
+	cmdPrompt.Flags().BoolVar(
+		&promptNoColor, "no-color", false,
+		"Don't color the output (also disabled by setting NO_COLOR)",
+	)
+}
+
+// activeProfile returns the profile used by the AWS CLI and SDKs: $AWS_PROFILE or the default
+// profile.
+func activeProfile() string {
+	if p := os.Getenv("AWS_PROFILE"); p != "" {
+		return p
+	}
+
+	return defaultProfile
+}
+
+// promptString returns a compact description of the credentials of profile for embedding in a
+// shell prompt, e.g. "prod-admin:3h59m". The text is colored by the remaining time if colored is
+// set. An empty string is returned if the credentials have expired.
+func promptString(profile string, expiration, now time.Time, colored bool) string {
+	d := expiration.Sub(now)
+	if d <= 0 {
+		return ""
+	}
+
+	s := fmt.Sprintf("%s:%s", profile, formatRemaining(d))
+	if !colored {
+		return s
+	}
+
+	c := color.New(color.FgGreen)
+	switch {
+	case d < promptCriticalRemaining:
+		c = color.New(color.FgRed)
+	case d < promptWarnRemaining:
+		c = color.New(color.FgYellow)
+	}
+	// The output is usually captured by the shell rather than written to a terminal, which would
+	// otherwise disable colors.
+	c.EnableColor()
+
+	return c.Sprint(s)
+}
+
+var cmdPrompt = &cobra.Command{
+	Use:   "prompt",
+	Short: "Print the active profile and its remaining time for a shell prompt",
+	Long: `Print the profile used by the AWS CLI ($AWS_PROFILE or the default profile) and
+the remaining time of its credentials in a compact form, e.g. "prod-admin:3h59m",
+for embedding in a shell prompt:
+
+    PS1='$(clisso prompt --no-color) '$PS1
+
+The output is green, yellow with less than an hour and red with less than 15
+minutes remaining, unless --no-color is given or NO_COLOR is set. Nothing is
+printed if the profile has no valid credentials.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		path, err := homedir.Expand(viper.GetString("global.credentials-path"))
+		if err != nil {
+			log.Fatalf(color.RedString("Failed to expand home: %s"), err)
+		}
+
+		profile := activeProfile()
+		creds, err := aws.ReadFromFile(path, profile)
+		if err != nil {
+			// No credentials is the normal state of a prompt outside of a session.
+			return
+		}
+
+		colored := !promptNoColor && os.Getenv("NO_COLOR") == ""
+		if s := promptString(profile, creds.Expiration, time.Now(), colored); s != "" {
+			fmt.Println(s)
+		}
+	},
+}
@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPromptString(t *testing.T) {
+	now := time.Date(2021, 3, 4, 8, 30, 0, 0, time.UTC)
+
+	for _, test := range []struct {
+		name       string
+		expiration time.Time
+		colored    bool
+		expect     string
+	}{
+		{"Hours", now.Add(3*time.Hour + 59*time.Minute + 59*time.Second), false, "prod-admin:3h59m"},
+		{"Minutes", now.Add(5*time.Minute + 30*time.Second), false, "prod-admin:5m"},
+		{"Seconds", now.Add(42 * time.Second), false, "prod-admin:42s"},
+		{"Expired", now, false, ""},
+		{"Green", now.Add(2 * time.Hour), true, "\x1b[32mprod-admin:2h\x1b[0m"},
+		{"Yellow", now.Add(59 * time.Minute), true, "\x1b[33mprod-admin:59m\x1b[0m"},
+		{"Red", now.Add(10 * time.Minute), true, "\x1b[31mprod-admin:10m\x1b[0m"},
+		{"Expired with color", now.Add(-time.Minute), true, ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if s := promptString("prod-admin", test.expiration, now, test.colored); s != test.expect {
+				t.Errorf("expected %q, received %q", test.expect, s)
+			}
+		})
+	}
+}
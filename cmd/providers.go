@@ -3,15 +3,16 @@ package cmd
 import (
 	"fmt"
 	"log"
-	"sort"
 	"strconv"
-	"syscall"
+	"strings"
 
+	"github.com/allcloud-io/clisso/config"
 	"github.com/allcloud-io/clisso/keychain"
+	"github.com/allcloud-io/clisso/prompt"
+	"github.com/allcloud-io/clisso/totp"
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-	"golang.org/x/term"
 )
 
 // OneLogin
@@ -20,11 +21,15 @@ var clientSecret string
 var subdomain string
 var username string
 var region string
+var ipVersion string
 var providerDuration int
 
 // Okta
 var baseURL string
 
+// passwd
+var passwordUsername string
+
 func init() {
 	// OneLogin
 	cmdProvidersCreateOneLogin.Flags().StringVar(&clientID, "client-id", "",
@@ -36,6 +41,8 @@ func init() {
 		"Don't ask for a username and use this instead")
 	cmdProvidersCreateOneLogin.Flags().StringVar(&region, "region", "US",
 		"Region in which the OneLogin API lives")
+	cmdProvidersCreateOneLogin.Flags().StringVar(&ipVersion, "ip-version", "",
+		"(Optional) Connect to the OneLogin API over IPv4 (4) or IPv6 (6) only")
 	cmdProvidersCreateOneLogin.Flags().IntVar(&providerDuration, "duration", 0, "(Optional) Default session duration in seconds")
 
 	mandatoryFlag(cmdProvidersCreateOneLogin, "client-id")
@@ -50,10 +57,14 @@ func init() {
 
 	mandatoryFlag(cmdProvidersCreateOkta, "base-url")
 
+	cmdProvidersPassword.Flags().StringVar(&passwordUsername, "username", "",
+		"Save the password of this user, for apps which override the provider's username")
+
 	// Build command tree
 	RootCmd.AddCommand(cmdProviders)
 	cmdProviders.AddCommand(cmdProvidersList)
 	cmdProviders.AddCommand(cmdProvidersPassword)
+	cmdProviders.AddCommand(cmdProvidersTOTP)
 	cmdProviders.AddCommand(cmdProvidersCreate)
 	cmdProvidersCreate.AddCommand(cmdProvidersCreateOneLogin)
 	cmdProvidersCreate.AddCommand(cmdProvidersCreateOkta)
@@ -70,20 +81,14 @@ var cmdProvidersList = &cobra.Command{
 	Short: "List providers",
 	Long:  "List all configured providers.",
 	Run: func(cmd *cobra.Command, args []string) {
-		providers := viper.GetStringMap("providers")
+		providers := config.Providers()
 
 		if len(providers) == 0 {
 			log.Println("No providers configured")
 			return
 		}
 
-		// Sort apps alphabetically
-		keys := make([]string, 0, len(providers))
-		for k := range providers {
-			keys = append(keys, k)
-		}
-		sort.Strings(keys)
-		for _, k := range keys {
+		for _, k := range providers {
 			log.Println(k)
 		}
 	},
@@ -92,24 +97,61 @@ var cmdProvidersList = &cobra.Command{
 var cmdProvidersPassword = &cobra.Command{
 	Use:   "passwd",
 	Short: "Save password in KeyChain for provider",
-	Long:  "Save password in KeyChain for provider, see github.com/tmc/keyring for supported stores.",
-	Args:  cobra.ExactArgs(1),
+	Long: `Save password in KeyChain for provider, see github.com/tmc/keyring for supported stores.
+Apps which set their own username use the password saved with --username.`,
+	Args: cobra.ExactArgs(1),
 
 	Run: func(cmd *cobra.Command, args []string) {
 		provider := args[0]
-		fmt.Printf("Please enter the password for the '%s' provider: ", provider)
-		pass, err := term.ReadPassword(int(syscall.Stdin))
+		key := provider
+		if passwordUsername != "" {
+			key = keychain.UserKey(provider, passwordUsername)
+		}
+		pass, err := prompt.Password(
+			fmt.Sprintf("password for provider '%s'", key),
+			fmt.Sprintf("Please enter the password for the '%s' provider: ", key),
+		)
 		if err != nil {
-			log.Fatalf(color.RedString("Could not read password"))
+			log.Fatalf(color.RedString("Could not read password: %v"), err)
 		}
 
 		keyChain := keychain.DefaultKeychain{}
 
-		err = keyChain.Set(provider, pass)
+		err = keyChain.Set(key, pass)
 		if err != nil {
 			log.Fatalf("Could not save to keychain: %+v", err)
 		}
-		log.Printf(color.GreenString("Saved password for Provider '%s'"), provider)
+		log.Printf(color.GreenString("Saved password for Provider '%s'"), key)
+	},
+}
+
+var cmdProvidersTOTP = &cobra.Command{
+	Use:   "totp",
+	Short: "Save TOTP key in KeyChain for provider",
+	Long: `Save a TOTP key in KeyChain for provider, so that one-time passwords are generated
+locally instead of being typed in. The key is either a provisioning URI
+(otpauth://totp/...) or a base32-encoded secret, as shown when enrolling an
+authenticator app.`,
+	Args: cobra.ExactArgs(1),
+
+	Run: func(cmd *cobra.Command, args []string) {
+		provider := args[0]
+		key, err := prompt.Password(
+			fmt.Sprintf("TOTP key for provider '%s'", provider),
+			fmt.Sprintf("Please enter the TOTP provisioning URI or secret for the '%s' provider: ", provider),
+		)
+		if err != nil {
+			log.Fatalf(color.RedString("Could not read TOTP key: %v"), err)
+		}
+
+		if _, err := totp.Parse(string(key)); err != nil {
+			log.Fatalf(color.RedString("Invalid TOTP key: %v"), err)
+		}
+
+		if err = keychain.SetTOTPKey(provider, string(key)); err != nil {
+			log.Fatalf("Could not save to keychain: %+v", err)
+		}
+		log.Printf(color.GreenString("Saved TOTP key for Provider '%s'"), provider)
 	},
 }
 
@@ -132,10 +174,14 @@ var cmdProvidersCreateOneLogin = &cobra.Command{
 			log.Fatalf(color.RedString("Provider '%s' already exists"), name)
 		}
 
-		switch region {
-		case "US", "EU":
+		if !config.IsOneLoginRegion(region) {
+			log.Fatalf(color.RedString("Region must be one of %s"), strings.Join(config.OneLoginRegions, ", "))
+		}
+
+		switch ipVersion {
+		case "", "4", "6":
 		default:
-			log.Fatal(color.RedString("Region must be either US or EU"))
+			log.Fatal(color.RedString("IP version must be either 4 or 6"))
 		}
 
 		conf := map[string]string{
@@ -146,6 +192,9 @@ var cmdProvidersCreateOneLogin = &cobra.Command{
 			"username":      username,
 			"region":        region,
 		}
+		if ipVersion != "" {
+			conf["ip-version"] = ipVersion
+		}
 		if providerDuration != 0 {
 			// Duration specified - validate value
 			if providerDuration < 3600 || providerDuration > 43200 {
@@ -153,10 +202,8 @@ var cmdProvidersCreateOneLogin = &cobra.Command{
 			}
 			conf["duration"] = strconv.Itoa(providerDuration)
 		}
-		viper.Set(fmt.Sprintf("providers.%s", name), conf)
-
 		// Write config to file
-		err := viper.WriteConfig()
+		err := config.Update(map[string]interface{}{fmt.Sprintf("providers.%s", name): conf})
 		if err != nil {
 			log.Fatalf(color.RedString("Error writing config: %v"), err)
 		}
@@ -189,10 +236,8 @@ var cmdProvidersCreateOkta = &cobra.Command{
 			}
 			conf["duration"] = strconv.Itoa(providerDuration)
 		}
-		viper.Set(fmt.Sprintf("providers.%s", name), conf)
-
 		// Write config to file
-		err := viper.WriteConfig()
+		err := config.Update(map[string]interface{}{fmt.Sprintf("providers.%s", name): conf})
 		if err != nil {
 			log.Fatalf(color.RedString("Error writing config: %v"), err)
 		}
@@ -0,0 +1,318 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/fatih/color"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/allcloud-io/clisso/config"
+	"github.com/allcloud-io/clisso/lock"
+)
+
+var refreshProviders []string
+var refreshTags []string
+
+func init() {
+	RootCmd.AddCommand(cmdRefresh)
+	cmdRefresh.Flags().StringSliceVar(
+		&refreshProviders, "providers", nil,
+		"Refresh only the apps of these providers, given by name or by one of their tags",
+	)
+	cmdRefresh.Flags().StringSliceVar(
+		&refreshTags, "tag", nil, "Refresh only the apps which have one of these tags",
+	)
+	err := viper.BindPFlag("global.refresh-providers", cmdRefresh.Flags().Lookup("providers"))
+	if err != nil {
+		log.Fatalf(color.RedString("Error binding flag global.refresh-providers: %v"), err)
+	}
+	// The flags are shared with get, which binds them to the global config.
+	cmdRefresh.Flags().AddFlag(cmdGet.Flags().Lookup("lock-wait"))
+	cmdRefresh.Flags().AddFlag(cmdGet.Flags().Lookup("no-keyring"))
+	cmdRefresh.Flags().AddFlag(cmdGet.Flags().Lookup("hide-otp"))
+	cmdRefresh.Flags().AddFlag(cmdGet.Flags().Lookup("hide-push-message"))
+	cmdRefresh.Flags().AddFlag(cmdGet.Flags().Lookup("audit-log"))
+}
+
+// providerGroup is a set of apps of a provider whose credentials are obtained after
+// authenticating at the provider once, using a single session.
+type providerGroup struct {
+	provider string
+	pType    string
+	// username is the username the apps override the provider's with, if any.
+	username string
+	// assertions are the apps grouped by the app at the identity provider they use. The apps of an
+	// assertion group differ only in the role to assume, so they share a single SAML assertion.
+	assertions [][]string
+}
+
+// size returns the number of apps of g.
+func (g *providerGroup) size() int {
+	n := 0
+	for _, apps := range g.assertions {
+		n += len(apps)
+	}
+
+	return n
+}
+
+// groupApps groups the given apps by provider and username and, within a provider group, by
+// identity provider app, preserving the order in which the apps are given.
+func groupApps(apps []string) ([]*providerGroup, error) {
+	var groups []*providerGroup
+	byProvider := make(map[string]*providerGroup)
+	byIdPApp := make(map[string]int)
+
+	for _, app := range apps {
+		provider, pType, err := appProvider(app)
+		if err != nil {
+			return nil, err
+		}
+
+		var idpApp string
+		switch pType {
+		case "onelogin":
+			a, err := config.GetOneLoginApp(app)
+			if err != nil {
+				return nil, fmt.Errorf("reading config for app %s: %v", app, err)
+			}
+			idpApp = a.ID
+		case "okta":
+			a, err := config.GetOktaApp(app)
+			if err != nil {
+				return nil, fmt.Errorf("reading config for app %s: %v", app, err)
+			}
+			idpApp = a.URL
+		default:
+			return nil, fmt.Errorf("Unsupported identity provider type '%s' for app '%s'", pType, app)
+		}
+
+		// Apps overriding the username authenticate as another user, so they need their own session.
+		user := viper.GetString(fmt.Sprintf("apps.%s.username", app))
+		key := provider + "\x00" + user
+		g, ok := byProvider[key]
+		if !ok {
+			g = &providerGroup{provider: provider, pType: pType, username: user}
+			byProvider[key] = g
+			groups = append(groups, g)
+		}
+
+		i, ok := byIdPApp[key+"\x00"+idpApp]
+		if !ok {
+			i = len(g.assertions)
+			byIdPApp[key+"\x00"+idpApp] = i
+			g.assertions = append(g.assertions, nil)
+		}
+		g.assertions[i] = append(g.assertions[i], app)
+	}
+
+	return groups, nil
+}
+
+// uniqueApps returns the given apps without duplicates, preserving their order.
+func uniqueApps(apps []string) []string {
+	seen := make(map[string]bool)
+	var unique []string
+	for _, app := range apps {
+		if !seen[app] {
+			seen[app] = true
+			unique = append(unique, app)
+		}
+	}
+
+	return unique
+}
+
+// filterProviders returns the given apps whose provider is selected by one of selectors, each of
+// which is either the name of a provider or one of the tags in the provider's tags setting. The
+// order of the apps is preserved, and all apps are returned if there are no selectors. An error is
+// returned for a selector which matches no provider, since it's likely a typo.
+func filterProviders(apps, selectors []string) ([]string, error) {
+	if len(selectors) == 0 {
+		return apps, nil
+	}
+
+	selected := make(map[string]bool)
+	for _, sel := range selectors {
+		matched := false
+		for _, p := range config.Providers() {
+			if p == sel || config.HasTag(config.ProviderTags(p), sel) {
+				selected[p] = true
+				matched = true
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("no provider is named or tagged '%s'", sel)
+		}
+	}
+
+	var filtered []string
+	for _, app := range apps {
+		if selected[viper.GetString(fmt.Sprintf("apps.%s.provider", app))] {
+			filtered = append(filtered, app)
+		}
+	}
+
+	return filtered, nil
+}
+
+// filterTags returns the given apps which have any of tags, preserving their order.
+func filterTags(apps, tags []string) []string {
+	var filtered []string
+	for _, app := range apps {
+		if config.HasTag(config.AppTags(app), tags...) {
+			filtered = append(filtered, app)
+		}
+	}
+
+	return filtered
+}
+
+// refreshGroup authenticates at the provider of g once and obtains a single SAML assertion for each
+// of its assertion groups, which refresh uses to get and write the credentials of each app. The
+// result of every app is returned, keyed by app name.
+func refreshGroup(g *providerGroup, refresh func(app, assertion string) error) map[string]error {
+	results := make(map[string]error)
+
+	sess, err := newSession(g.provider, g.pType)
+	if err != nil {
+		for _, apps := range g.assertions {
+			for _, app := range apps {
+				results[app] = fmt.Errorf("authenticating at provider %s: %v", g.provider, err)
+			}
+		}
+		return results
+	}
+	defer sess.Close()
+
+	for _, apps := range g.assertions {
+		assertion, err := sessionAssertion(sess, apps[0], g.provider)
+		for _, app := range apps {
+			if err != nil {
+				results[app] = fmt.Errorf("getting SAML assertion: %v", err)
+				continue
+			}
+			results[app] = refresh(app, assertion)
+		}
+	}
+
+	return results
+}
+
+// refreshApp assumes the role of app using the given assertion and writes the credentials to the
+// app's credentials file, recording them in the audit log.
+func refreshApp(app, provider, assertion string) error {
+	region, err := selectRegion(app)
+	if err != nil {
+		return fmt.Errorf("selecting AWS region: %v", err)
+	}
+
+	pArn := viper.GetString(fmt.Sprintf("apps.%s.arn", app))
+	creds, role, err := assumeRole(app, assertion, pArn, sessionDuration(app, provider), region)
+	if err != nil {
+		return err
+	}
+
+	if err = writeCredentialsFile(creds, app); err != nil {
+		return err
+	}
+	auditCredentials(app, provider, role, outputFile, creds)
+
+	return nil
+}
+
+var cmdRefresh = &cobra.Command{
+	Use:   "refresh [app names]",
+	Short: "Get temporary credentials for several apps at once",
+	Long: `Obtain temporary credentials for the specified apps, or all configured apps if
+none are specified, and write them to their credentials files.
+
+The apps of a provider share a single session, so the password is entered only
+once for them. Okta verifies MFA once per provider, OneLogin once per app at the
+identity provider: apps which use the same app there (e.g. apps which differ
+only in their preferred role ARN) share a single SAML assertion. The result of
+every app is reported at the end.
+
+With --providers (or global.refresh-providers), only the apps of the given
+providers are refreshed. Providers are given by name or by one of the tags
+listed in their tags setting, e.g. --providers work. With --tag, only the apps
+which have one of the given tags, in their own tags setting or their provider's,
+are refreshed.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		apps := uniqueApps(args)
+		if len(apps) == 0 {
+			apps = config.Apps()
+		}
+		if len(apps) == 0 {
+			log.Fatal(color.RedString("No apps configured"))
+		}
+
+		apps, err := filterProviders(apps, viper.GetStringSlice("global.refresh-providers"))
+		if err != nil {
+			log.Fatal(color.RedString(err.Error()))
+		}
+		if len(refreshTags) > 0 {
+			apps = filterTags(apps, refreshTags)
+		}
+		if len(apps) == 0 {
+			log.Fatal(color.RedString("None of the apps matches the given providers and tags"))
+		}
+
+		groups, err := groupApps(apps)
+		if err != nil {
+			log.Fatal(color.RedString(err.Error()))
+		}
+
+		// Lock the apps in a fixed order so that concurrent runs can't deadlock.
+		sorted := append([]string(nil), apps...)
+		sort.Strings(sorted)
+		var locks []*lock.Lock
+		for _, app := range sorted {
+			l, err := lockApp(app)
+			if err != nil {
+				log.Fatal(color.RedString(err.Error()))
+			}
+			if l != nil {
+				locks = append(locks, l)
+			}
+		}
+		defer func() {
+			for _, l := range locks {
+				l.Release()
+			}
+		}()
+
+		results := make(map[string]error)
+		for _, g := range groups {
+			log.Printf("Authenticating at provider '%s' for %d app(s)", g.provider, g.size())
+			refresh := func(app, assertion string) error {
+				return refreshApp(app, g.provider, assertion)
+			}
+			for app, err := range refreshGroup(g, refresh) {
+				results[app] = err
+			}
+		}
+
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"App", "Result"})
+		failed := 0
+		for _, app := range apps {
+			result := color.GreenString("ok")
+			if err := results[app]; err != nil {
+				result = color.RedString(err.Error())
+				failed++
+			}
+			table.Append([]string{app, result})
+		}
+		table.Render()
+
+		if failed > 0 {
+			log.Fatalf(color.RedString("Could not refresh %d of %d app(s)"), failed, len(apps))
+		}
+	},
+}
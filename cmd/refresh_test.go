@@ -0,0 +1,197 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestGroupApps(t *testing.T) {
+	viper.Set("providers.refresh-onelogin", map[string]interface{}{"type": "onelogin"})
+	viper.Set("providers.refresh-other-onelogin", map[string]interface{}{"type": "onelogin"})
+	viper.Set("providers.refresh-okta", map[string]interface{}{"type": "okta"})
+	for app, conf := range map[string]map[string]interface{}{
+		// Same OneLogin app, different roles: one assertion.
+		"refresh-admin":    {"provider": "refresh-onelogin", "app-id": "1", "arn": "arn:aws:iam::1:role/Admin"},
+		"refresh-readonly": {"provider": "refresh-onelogin", "app-id": "1", "arn": "arn:aws:iam::1:role/ReadOnly"},
+		// Another app of the same provider: same session, another assertion.
+		"refresh-staging": {"provider": "refresh-onelogin", "app-id": "2"},
+		// Another user of the same provider.
+		"refresh-ops": {"provider": "refresh-onelogin", "app-id": "1", "username": "ops"},
+		// Same app ID at another tenant.
+		"refresh-tenant": {"provider": "refresh-other-onelogin", "app-id": "1"},
+		"refresh-okta-a": {"provider": "refresh-okta", "url": "https://example.okta.com/home/amazon_aws/1"},
+		"refresh-okta-b": {"provider": "refresh-okta", "url": "https://example.okta.com/home/amazon_aws/1"},
+	} {
+		viper.Set("apps."+app, conf)
+	}
+
+	groups, err := groupApps([]string{
+		"refresh-admin", "refresh-okta-a", "refresh-staging", "refresh-ops", "refresh-readonly",
+		"refresh-tenant", "refresh-okta-b",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+
+	expect := []providerGroup{
+		{"refresh-onelogin", "onelogin", "", [][]string{{"refresh-admin", "refresh-readonly"}, {"refresh-staging"}}},
+		{"refresh-okta", "okta", "", [][]string{{"refresh-okta-a", "refresh-okta-b"}}},
+		{"refresh-onelogin", "onelogin", "ops", [][]string{{"refresh-ops"}}},
+		{"refresh-other-onelogin", "onelogin", "", [][]string{{"refresh-tenant"}}},
+	}
+	if len(groups) != len(expect) {
+		t.Fatalf("expected %d groups, received %d", len(expect), len(groups))
+	}
+	for i, g := range groups {
+		if !reflect.DeepEqual(*g, expect[i]) {
+			t.Errorf("group %d: expected %+v, received %+v", i, expect[i], *g)
+		}
+	}
+
+	if _, err := groupApps([]string{"refresh-missing"}); err == nil {
+		t.Errorf("expected error for app without provider")
+	}
+}
+
+type fakeSession struct {
+	assertions []string
+	closed     bool
+}
+
+func (s *fakeSession) SAMLAssertion(app string) (string, error) {
+	s.assertions = append(s.assertions, app)
+	return "assertion-" + app, nil
+}
+
+func (s *fakeSession) Close() { s.closed = true }
+
+func TestRefreshGroup(t *testing.T) {
+	var sessions []*fakeSession
+	defer func(f func(string, string) (idpSession, error)) { newSession = f }(newSession)
+	newSession = func(provider, pType string) (idpSession, error) {
+		s := &fakeSession{}
+		sessions = append(sessions, s)
+		return s, nil
+	}
+
+	g := &providerGroup{
+		provider:   "refresh-onelogin",
+		pType:      "onelogin",
+		assertions: [][]string{{"refresh-admin", "refresh-readonly"}, {"refresh-staging"}},
+	}
+	refreshed := make(map[string]string)
+	results := refreshGroup(g, func(app, assertion string) error {
+		refreshed[app] = assertion
+		return nil
+	})
+
+	// A single session gets an assertion for each app at the identity provider.
+	if len(sessions) != 1 {
+		t.Fatalf("expected 1 session, received %d", len(sessions))
+	}
+	if !reflect.DeepEqual(sessions[0].assertions, []string{"refresh-admin", "refresh-staging"}) {
+		t.Errorf("unexpected assertions %v", sessions[0].assertions)
+	}
+	if !sessions[0].closed {
+		t.Errorf("expected session to be closed")
+	}
+	expect := map[string]string{
+		"refresh-admin":    "assertion-refresh-admin",
+		"refresh-readonly": "assertion-refresh-admin",
+		"refresh-staging":  "assertion-refresh-staging",
+	}
+	if !reflect.DeepEqual(refreshed, expect) {
+		t.Errorf("expected %v, received %v", expect, refreshed)
+	}
+	for app, err := range results {
+		if err != nil {
+			t.Errorf("%s: unexpected error %+v", app, err)
+		}
+	}
+}
+
+func TestUniqueApps(t *testing.T) {
+	res := uniqueApps([]string{"b", "a", "b", "c", "a"})
+	if !reflect.DeepEqual(res, []string{"b", "a", "c"}) {
+		t.Errorf("expected %v, received %v", []string{"b", "a", "c"}, res)
+	}
+}
+
+func TestFilterProviders(t *testing.T) {
+	viper.Set("providers.filter-work", map[string]interface{}{"type": "onelogin", "tags": []string{"work", "eu"}})
+	viper.Set("providers.filter-work-us", map[string]interface{}{"type": "okta", "tags": []string{"work"}})
+	viper.Set("providers.filter-personal", map[string]interface{}{"type": "onelogin"})
+	defer viper.Set("providers.filter-work", nil)
+	defer viper.Set("providers.filter-work-us", nil)
+	defer viper.Set("providers.filter-personal", nil)
+	apps := []string{"filter-a", "filter-b", "filter-c", "filter-d"}
+	for app, provider := range map[string]string{
+		"filter-a": "filter-work", "filter-b": "filter-personal", "filter-c": "filter-work-us", "filter-d": "filter-work",
+	} {
+		viper.Set("apps."+app, map[string]interface{}{"provider": provider})
+		defer viper.Set("apps."+app, nil)
+	}
+
+	for _, test := range []struct {
+		name        string
+		selectors   []string
+		expect      []string
+		expectError bool
+	}{
+		{"No selectors", nil, apps, false},
+		{"Name", []string{"filter-personal"}, []string{"filter-b"}, false},
+		{"Tag", []string{"work"}, []string{"filter-a", "filter-c", "filter-d"}, false},
+		{"Tag of one provider", []string{"eu"}, []string{"filter-a", "filter-d"}, false},
+		{"Name and tag", []string{"eu", "filter-personal"}, []string{"filter-a", "filter-b", "filter-d"}, false},
+		{"Unknown", []string{"work", "hobby"}, nil, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := filterProviders(apps, test.selectors)
+			if test.expectError {
+				if err == nil {
+					t.Errorf("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+			if !reflect.DeepEqual(res, test.expect) {
+				t.Errorf("expected %v, received %v", test.expect, res)
+			}
+		})
+	}
+}
+
+func TestFilterTags(t *testing.T) {
+	viper.Set("providers.tags-work", map[string]interface{}{"type": "onelogin", "tags": []string{"work"}})
+	defer viper.Set("providers.tags-work", nil)
+	for app, conf := range map[string]map[string]interface{}{
+		"tags-prod":    {"provider": "tags-work", "tags": []string{"prod"}},
+		"tags-sandbox": {"provider": "tags-personal", "tags": []string{"prod", "sandbox"}},
+		"tags-blog":    {"provider": "tags-personal"},
+	} {
+		viper.Set("apps."+app, conf)
+		defer viper.Set("apps."+app, nil)
+	}
+	apps := []string{"tags-sandbox", "tags-blog", "tags-prod"}
+
+	for _, test := range []struct {
+		name   string
+		tags   []string
+		expect []string
+	}{
+		{"App tag", []string{"prod"}, []string{"tags-sandbox", "tags-prod"}},
+		{"Provider tag", []string{"work"}, []string{"tags-prod"}},
+		{"Any tag", []string{"sandbox", "work"}, []string{"tags-sandbox", "tags-prod"}},
+		{"No match", []string{"dev"}, nil},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if res := filterTags(apps, test.tags); !reflect.DeepEqual(res, test.expect) {
+				t.Errorf("expected %v, received %v", test.expect, res)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/fatih/color"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+
+	"github.com/allcloud-io/clisso/aws"
+	"github.com/allcloud-io/clisso/saml"
+)
+
+var rolesOutput string
+
+// Output formats of the roles command.
+const (
+	rolesTable = "table"
+	rolesJSON  = "json"
+)
+
+func init() {
+	RootCmd.AddCommand(cmdRoles)
+	cmdRoles.Flags().StringVar(
+		&rolesOutput, "output", rolesTable, "Output format: table or json",
+	)
+	cmdRoles.Flags().AddFlag(cmdGet.Flags().Lookup("no-keyring"))
+	cmdRoles.Flags().AddFlag(cmdGet.Flags().Lookup("backup-code"))
+	cmdRoles.Flags().AddFlag(cmdGet.Flags().Lookup("hide-otp"))
+	cmdRoles.Flags().AddFlag(cmdGet.Flags().Lookup("hide-push-message"))
+}
+
+// availableRole is a role of an app as printed by the roles command.
+type availableRole struct {
+	RoleARN     string `json:"role_arn"`
+	ProviderARN string `json:"provider_arn"`
+	AccountID   string `json:"account_id"`
+	RoleName    string `json:"role_name"`
+	// Name is the friendly name of the role if its account is listed in global.accounts.
+	Name string `json:"name,omitempty"`
+}
+
+func newAvailableRoles(arns []saml.ARN) []availableRole {
+	roles := make([]availableRole, 0, len(arns))
+	for _, a := range arns {
+		roles = append(roles, availableRole{
+			RoleARN:     a.Role,
+			ProviderARN: a.Provider,
+			AccountID:   aws.AccountID(a.Role),
+			RoleName:    aws.RoleName(a.Role),
+			Name:        a.Name,
+		})
+	}
+
+	return roles
+}
+
+// writeRoles writes roles to w as a JSON array if format is json, or as a table otherwise.
+func writeRoles(w io.Writer, roles []availableRole, format string) error {
+	if format == rolesJSON {
+		b, err := json.MarshalIndent(roles, "", "  ")
+		if err != nil {
+			return fmt.Errorf("encoding roles: %v", err)
+		}
+		_, err = fmt.Fprintf(w, "%s\n", b)
+		return err
+	}
+
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Account", "Role", "Name", "Role ARN", "Provider ARN"})
+	for _, r := range roles {
+		table.Append([]string{r.AccountID, r.RoleName, r.Name, r.RoleARN, r.ProviderARN})
+	}
+	table.Render()
+
+	return nil
+}
+
+var cmdRoles = &cobra.Command{
+	Use:   "roles [app name]",
+	Short: "List the roles available for an app",
+	Long: `Obtain the SAML assertion of the specified app and list the IAM roles it
+contains, with their SAML provider, account ID and friendly name (see
+global.accounts), without assuming any of them. Roles excluded by the app's
+saml-provider or allowed-roles aren't listed.
+
+With --output json, the roles are printed to stdout as a JSON array for use by
+other programs, and prompts and messages are written to stderr.
+
+If no app is specified, the selected app (if configured) will be used.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if rolesOutput != rolesTable && rolesOutput != rolesJSON {
+			log.Fatalf(color.RedString("Invalid output format '%s'. Valid values: %s, %s"), rolesOutput, rolesTable, rolesJSON)
+		}
+		if rolesOutput == rolesJSON {
+			redirectStdout()
+		}
+
+		app, err := appFromArgs(args)
+		if err != nil {
+			log.Fatal(color.RedString(err.Error()))
+		}
+
+		provider, pType, err := appProvider(app)
+		if err != nil {
+			log.Fatal(color.RedString(err.Error()))
+		}
+
+		assertion, err := samlAssertion(app, provider, pType)
+		if err != nil {
+			log.Fatal(color.RedString("Could not get SAML assertion: "), err)
+		}
+
+		if err = checkAssertion(app, assertion); err != nil {
+			log.Fatal(color.RedString("Could not list roles: "), err)
+		}
+
+		arns, err := appRoles(app, assertion)
+		if err != nil {
+			log.Fatal(color.RedString("Could not list roles: "), err)
+		}
+
+		w := io.Writer(os.Stdout)
+		if rolesOutput == rolesJSON {
+			w = stdout
+		}
+		if err = writeRoles(w, newAvailableRoles(arns), rolesOutput); err != nil {
+			log.Fatalf(color.RedString("Error writing roles: %v"), err)
+		}
+	},
+}
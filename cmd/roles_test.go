@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestWriteRolesJSON(t *testing.T) {
+	b, err := ioutil.ReadFile("../saml/testdata/valid-response")
+	if err != nil {
+		t.Fatalf("could not read test data: %v", err)
+	}
+
+	viper.Set("global.accounts", map[string]interface{}{"123456789012": "Production"})
+	defer viper.Set("global.accounts", nil)
+	viper.Set("apps.roles-app.allowed-roles", []string{
+		"arn:aws:iam::123456789012:role/OneLogin-MyRole0",
+		"arn:aws:iam::123456789012:role/OneLogin-MyRole2",
+	})
+	defer viper.Set("apps.roles-app.allowed-roles", nil)
+
+	arns, err := appRoles("roles-app", string(b))
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+
+	var out bytes.Buffer
+	if err = writeRoles(&out, newAvailableRoles(arns), rolesJSON); err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+
+	// Decoding into generic values checks the field names and types rather than the struct.
+	var roles []map[string]interface{}
+	if err = json.Unmarshal(out.Bytes(), &roles); err != nil {
+		t.Fatalf("output isn't a JSON array: %v\n%s", err, out.String())
+	}
+	expect := []map[string]interface{}{
+		{
+			"role_arn":     "arn:aws:iam::123456789012:role/OneLogin-MyRole0",
+			"provider_arn": "arn:aws:iam::123456789012:saml-provider/OneLogin-MyProvider0",
+			"account_id":   "123456789012",
+			"role_name":    "OneLogin-MyRole0",
+			"name":         "Production - role/OneLogin-MyRole0",
+		},
+		{
+			"role_arn":     "arn:aws:iam::123456789012:role/OneLogin-MyRole2",
+			"provider_arn": "arn:aws:iam::123456789012:saml-provider/OneLogin-MyProvider1",
+			"account_id":   "123456789012",
+			"role_name":    "OneLogin-MyRole2",
+			"name":         "Production - role/OneLogin-MyRole2",
+		},
+	}
+	if !reflect.DeepEqual(roles, expect) {
+		t.Errorf("expected %v, received %v", expect, roles)
+	}
+
+	// No roles are written as an empty array rather than null.
+	out.Reset()
+	if err = writeRoles(&out, newAvailableRoles(nil), rolesJSON); err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	if s := out.String(); s != "[]\n" {
+		t.Errorf("expected an empty array, received %q", s)
+	}
+}
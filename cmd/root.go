@@ -1,14 +1,20 @@
 package cmd
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/fatih/color"
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+
+	"github.com/allcloud-io/clisso/config"
+	"github.com/allcloud-io/clisso/onelogin"
+	"github.com/allcloud-io/clisso/spinner"
 )
 
 var VERSION string
@@ -22,10 +28,39 @@ func init() {
 	RootCmd.PersistentFlags().StringVarP(&cfgFile, "config", "c", "",
 		"config file (default is $HOME/.clisso.yaml)",
 	)
+	RootCmd.PersistentFlags().Bool("non-interactive", false,
+		"Fail instead of prompting for input (e.g. username, password, OTP or role selection)",
+	)
+	RootCmd.PersistentFlags().BoolP("quiet", "q", false,
+		"Don't print warnings and progress indicators or ask for optional input",
+	)
+	RootCmd.PersistentFlags().String("spinner-style", "",
+		fmt.Sprintf("Style of the progress indicator: %s", strings.Join(spinner.StyleNames(), ", ")),
+	)
+	RootCmd.PersistentFlags().Bool("strict-config", false,
+		"Fail instead of printing a warning if the config file contains unknown settings",
+	)
+	err := viper.BindPFlag("global.non-interactive", RootCmd.PersistentFlags().Lookup("non-interactive"))
+	if err != nil {
+		log.Fatalf(color.RedString("Error binding flag global.non-interactive: %v"), err)
+	}
+	err = viper.BindPFlag("global.quiet", RootCmd.PersistentFlags().Lookup("quiet"))
+	if err != nil {
+		log.Fatalf(color.RedString("Error binding flag global.quiet: %v"), err)
+	}
+	err = viper.BindPFlag("global.spinner-style", RootCmd.PersistentFlags().Lookup("spinner-style"))
+	if err != nil {
+		log.Fatalf(color.RedString("Error binding flag global.spinner-style: %v"), err)
+	}
+	err = viper.BindPFlag("global.strict-config", RootCmd.PersistentFlags().Lookup("strict-config"))
+	if err != nil {
+		log.Fatalf(color.RedString("Error binding flag global.strict-config: %v"), err)
+	}
 }
 
 func Execute(version string) {
 	VERSION = version
+	onelogin.UserAgent = "clisso/" + version
 	err := RootCmd.Execute()
 	if err != nil {
 		log.Fatalf("Failed to execute: %v", err)
@@ -58,7 +93,89 @@ func initConfig() {
 		viper.SetDefault("global.credentials-path", filepath.Join(home, ".aws", "credentials"))
 	}
 
+	// The AWS CLI config file is used only by export-config and has a default regardless of where
+	// the clisso config file lives.
+	viper.SetDefault("global.aws-config-path", filepath.Join("~", ".aws", "config"))
+
 	if err := viper.ReadInConfig(); err != nil {
 		log.Fatalf(color.RedString("Can't read config: %v"), err)
 	}
+
+	if err := checkConfig(viper.AllKeys()); err != nil {
+		log.Fatalf(color.RedString("Invalid config: %v"), err)
+	}
+	if err := config.CheckOneLoginRegions(); err != nil {
+		log.Fatalf(color.RedString("Invalid config: %v"), err)
+	}
+	if _, err := spinner.StyleNamed(viper.GetString("global.spinner-style")); err != nil {
+		log.Fatalf(color.RedString("Invalid config: %v"), err)
+	}
+
+	if err := applyProjectConfig(); err != nil {
+		log.Fatalf(color.RedString("Invalid project config: %v"), err)
+	}
+}
+
+// applyProjectConfig merges the project config of the current directory, if any, into the config.
+func applyProjectConfig() error {
+	dir, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("getting current directory: %v", err)
+	}
+
+	// The default global config file has the name of a project config, so it's excluded even if
+	// another config file is used, e.g. when running in the home directory.
+	exclude := []string{viper.ConfigFileUsed()}
+	if home, err := homedir.Dir(); err == nil {
+		exclude = append(exclude, filepath.Join(home, ".clisso.yaml"))
+	}
+	path, err := config.FindProject(dir, exclude...)
+	if err != nil || path == "" {
+		return err
+	}
+
+	p, err := config.LoadProject(path)
+	if err != nil {
+		return err
+	}
+
+	return config.ApplyProject(p)
+}
+
+// reloadConfig reads the config file and the project config again, e.g. for a long running command.
+// Flags keep taking precedence over the reloaded settings.
+func reloadConfig() error {
+	if err := viper.ReadInConfig(); err != nil {
+		return fmt.Errorf("reading config: %v", err)
+	}
+	if err := checkConfig(viper.AllKeys()); err != nil {
+		return fmt.Errorf("invalid config: %v", err)
+	}
+	if err := config.CheckOneLoginRegions(); err != nil {
+		return fmt.Errorf("invalid config: %v", err)
+	}
+	if err := applyProjectConfig(); err != nil {
+		return fmt.Errorf("invalid project config: %v", err)
+	}
+
+	return nil
+}
+
+// checkConfig reports config keys which don't refer to a known setting, e.g. because the config
+// file was written by another version of clisso. Unknown settings are ignored with a warning, or
+// cause an error if global.strict-config is set.
+func checkConfig(keys []string) error {
+	unknown := config.UnknownKeys(keys)
+	if len(unknown) == 0 {
+		return nil
+	}
+
+	if viper.GetBool("global.strict-config") {
+		return fmt.Errorf("unknown settings: %s", strings.Join(unknown, ", "))
+	}
+	if !viper.GetBool("global.quiet") {
+		log.Printf(color.YellowString("Ignoring unknown config settings: %s"), strings.Join(unknown, ", "))
+	}
+
+	return nil
 }
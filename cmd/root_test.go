@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestCheckConfig(t *testing.T) {
+	defer viper.Set("global.strict-config", false)
+
+	known := []string{
+		"global.credentials-path",
+		"global.accounts.123456789012",
+		"providers.my-provider.client-id",
+		"providers.my-provider.headers.x-api-key",
+		"apps.my-app.app-id",
+		"apps.my-app.roles.prod-admin",
+	}
+	unknown := append(known, "apps.my-app.unknown-field")
+
+	for _, test := range []struct {
+		name        string
+		keys        []string
+		strict      bool
+		expectError bool
+	}{
+		{"Known settings", known, false, false},
+		{"Known settings in strict mode", known, true, false},
+		{"Unknown setting", unknown, false, false},
+		{"Unknown setting in strict mode", unknown, true, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			viper.Set("global.strict-config", test.strict)
+
+			err := checkConfig(test.keys)
+			if test.expectError && err == nil {
+				t.Errorf("expected error")
+			}
+			if !test.expectError && err != nil {
+				t.Errorf("unexpected error %+v", err)
+			}
+		})
+	}
+}
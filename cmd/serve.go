@@ -0,0 +1,371 @@
+package cmd
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/allcloud-io/clisso/aws"
+)
+
+// serverRefreshWindow is how long before their expiration the credentials served by serve are
+// renewed. Served credentials thus stay valid for at least this long, during which the clients
+// are expected to ask for them again.
+const serverRefreshWindow = 5 * time.Minute
+
+// outputServer is the output recorded in the audit log for credentials served by serve.
+const outputServer = "server"
+
+// The actions serve can take on SIGHUP, configured by global.serve-sighup.
+const (
+	// hangupRefresh reloads the config and obtains new credentials.
+	hangupRefresh = "refresh"
+	// hangupReload reloads the config, which is used once the credentials are renewed.
+	hangupReload = "reload"
+	// hangupIgnore ignores the signal.
+	hangupIgnore = "ignore"
+	// hangupExit stops serving, as is the default for processes receiving SIGHUP.
+	hangupExit = "exit"
+)
+
+var hangupActions = []string{hangupRefresh, hangupReload, hangupIgnore, hangupExit}
+
+// defaultHangupAction is the action taken on SIGHUP unless global.serve-sighup is set.
+const defaultHangupAction = hangupRefresh
+
+var serveAddr string
+
+func init() {
+	RootCmd.AddCommand(cmdServe)
+	cmdServe.Flags().StringVar(
+		&serveAddr, "listen", "127.0.0.1:0",
+		"Serve credentials at this loopback address (a random port is used by default)",
+	)
+	// The flags are shared with get, which binds them to the global config.
+	cmdServe.Flags().AddFlag(cmdGet.Flags().Lookup("region"))
+	cmdServe.Flags().AddFlag(cmdGet.Flags().Lookup("role"))
+	cmdServe.Flags().AddFlag(cmdGet.Flags().Lookup("no-keyring"))
+	cmdServe.Flags().AddFlag(cmdGet.Flags().Lookup("hide-otp"))
+	cmdServe.Flags().AddFlag(cmdGet.Flags().Lookup("hide-push-message"))
+	cmdServe.Flags().AddFlag(cmdGet.Flags().Lookup("audit-log"))
+}
+
+// serverCredentials holds the credentials served by serve, obtaining them again using obtain
+// when they are about to expire.
+type serverCredentials struct {
+	// mu guards creds. It's never held while obtaining credentials, which may require input.
+	mu    sync.Mutex
+	creds *aws.Credentials
+	// obtaining serializes obtaining credentials and reloading the config.
+	obtaining sync.Mutex
+	obtain    func() (*aws.Credentials, error)
+	now       func() time.Time
+}
+
+// current returns the current credentials if they are valid for longer than serverRefreshWindow,
+// nil otherwise.
+func (s *serverCredentials) current() *aws.Credentials {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.creds == nil || s.creds.Expiration.Sub(s.now()) <= serverRefreshWindow {
+		return nil
+	}
+
+	return s.creds
+}
+
+// get returns the current credentials if they are valid for longer than serverRefreshWindow, or
+// obtains new ones. Concurrent requests wait for a single renewal.
+func (s *serverCredentials) get() (*aws.Credentials, error) {
+	if creds := s.current(); creds != nil {
+		return creds, nil
+	}
+
+	s.obtaining.Lock()
+	defer s.obtaining.Unlock()
+
+	// The credentials may have been renewed while waiting.
+	if creds := s.current(); creds != nil {
+		return creds, nil
+	}
+
+	return s.renew()
+}
+
+// refresh obtains new credentials regardless of the expiration of the current ones, calling
+// reload first if it's set. Requests keep getting the current credentials until the new ones are
+// obtained, and afterwards if obtaining them fails.
+func (s *serverCredentials) refresh(reload func() error) error {
+	s.obtaining.Lock()
+	defer s.obtaining.Unlock()
+
+	if reload != nil {
+		if err := reload(); err != nil {
+			return err
+		}
+	}
+
+	_, err := s.renew()
+	return err
+}
+
+// renew obtains new credentials and makes them the current ones. It must be called with
+// s.obtaining held.
+func (s *serverCredentials) renew() (*aws.Credentials, error) {
+	log.Println("Obtaining new credentials")
+	creds, err := s.obtain()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.creds = creds
+	s.mu.Unlock()
+	log.Printf("Credentials expire at %s", formatExpiry(creds.Expiration))
+
+	return creds, nil
+}
+
+// reload calls reload while no credentials are being obtained.
+func (s *serverCredentials) reload(reload func() error) error {
+	s.obtaining.Lock()
+	defer s.obtaining.Unlock()
+
+	return reload()
+}
+
+// hangupAction returns the action to take on SIGHUP, as configured by global.serve-sighup.
+func hangupAction() (string, error) {
+	action := viper.GetString("global.serve-sighup")
+	if action == "" {
+		return defaultHangupAction, nil
+	}
+	for _, a := range hangupActions {
+		if action == a {
+			return action, nil
+		}
+	}
+
+	return "", fmt.Errorf("invalid serve-sighup '%s': must be one of %s", action, strings.Join(hangupActions, ", "))
+}
+
+// handleHangup takes the action configured by global.serve-sighup for a SIGHUP received while
+// serving s, using reload to reload the config. It returns false if serving should stop.
+func handleHangup(s *serverCredentials, reload func() error) bool {
+	action, err := hangupAction()
+	if err != nil {
+		log.Printf(color.RedString("Error handling SIGHUP: %v"), err)
+		return true
+	}
+
+	switch action {
+	case hangupRefresh:
+		log.Println("Received SIGHUP, reloading config and refreshing credentials")
+		err = s.refresh(reload)
+	case hangupReload:
+		log.Println("Received SIGHUP, reloading config")
+		err = s.reload(reload)
+	case hangupExit:
+		log.Println("Received SIGHUP, exiting")
+		return false
+	default:
+		log.Println("Received SIGHUP, ignoring it")
+	}
+	if err != nil {
+		log.Printf(color.RedString("Error handling SIGHUP: %v"), err)
+	}
+
+	return true
+}
+
+// watchHangup handles every SIGHUP received while serving s using handleHangup, until stop is
+// called. done is called if serving should stop.
+func watchHangup(s *serverCredentials, reload func() error, done func()) (stop func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			if !handleHangup(s, reload) {
+				done()
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(signals)
+	}
+}
+
+// listenLoopback listens on addr, which has to be a loopback address since the credentials must
+// not be reachable from other hosts. The AWS SDKs also accept only loopback hosts for a container
+// credentials endpoint using plain HTTP.
+func listenLoopback(addr string) (net.Listener, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid listen address %s: %v", addr, err)
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return nil, fmt.Errorf("invalid listen address %s: only loopback IP addresses are allowed", addr)
+	}
+
+	return net.Listen("tcp", addr)
+}
+
+// newServerToken returns a random token which the clients of serve have to send.
+func newServerToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating token: %v", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// writeServerEnv writes the commands setting the environment variables which point the AWS CLI
+// and SDKs to the credentials served at url to w, using the syntax of the shell. The region is
+// only written if it's set.
+func writeServerEnv(w io.Writer, windows bool, url, token, region string) {
+	vars := [][2]string{
+		{aws.ContainerCredentialsURIVar, url},
+		{aws.ContainerAuthorizationTokenVar, token},
+	}
+	if region != "" {
+		vars = append(vars, [2]string{"AWS_REGION", region}, [2]string{"AWS_DEFAULT_REGION", region})
+	}
+
+	command := "export"
+	if windows {
+		command = "set"
+	}
+	for _, v := range vars {
+		fmt.Fprintf(w, "%s %s=%s\n", command, v[0], v[1])
+	}
+}
+
+var cmdServe = &cobra.Command{
+	Use:   "serve [app name]",
+	Short: "Serve temporary credentials for an app at a local endpoint",
+	Long: `Obtain temporary credentials for the specified app and serve them at a local
+HTTP endpoint in the format of a container credentials endpoint, as aws-vault's
+exec server does. Any tool using the AWS CLI or an AWS SDK can get credentials
+from the endpoint once the printed environment variables are set:
+
+    AWS_CONTAINER_CREDENTIALS_FULL_URI   the URL of the endpoint
+    AWS_CONTAINER_AUTHORIZATION_TOKEN    a random token generated at startup
+
+Requests without the token are rejected. The endpoint listens on a random port
+of 127.0.0.1 unless another loopback address is given using --listen.
+
+The credentials are obtained at startup and again whenever they are about to
+expire, so serve keeps running (until interrupted) through several sessions.
+Renewing may require an MFA, which is handled in the terminal serve runs in
+while the client waits, so a push notification or the password stored in the
+keychain help to avoid timeouts.
+
+On SIGHUP, the config is reloaded and new credentials are obtained right away,
+while requests keep being served the current credentials. global.serve-sighup changes this to one of:
+refresh (the default), reload (only reload the config, which is used once the
+credentials are renewed), ignore or exit.
+
+If no app is specified, the selected app (if configured) will be assumed.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		app, err := appFromArgs(args)
+		if err != nil {
+			log.Fatal(color.RedString(err.Error()))
+		}
+		if _, err = hangupAction(); err != nil {
+			log.Fatalf(color.RedString("Invalid config: %v"), err)
+		}
+		if _, err = preferredRole(app, roleAlias); err != nil {
+			log.Fatalf(color.RedString("Error validating flags: %v"), err)
+		}
+
+		listener, err := listenLoopback(serveAddr)
+		if err != nil {
+			log.Fatal(color.RedString(err.Error()))
+		}
+		token, err := newServerToken()
+		if err != nil {
+			log.Fatal(color.RedString(err.Error()))
+		}
+
+		region, err := selectRegion(app)
+		if err != nil {
+			log.Fatal(color.RedString("Could not select AWS region: "), err)
+		}
+
+		creds := &serverCredentials{
+			// The app's settings are read every time, since they may be reloaded on SIGHUP.
+			obtain: func() (*aws.Credentials, error) {
+				pArn, err := preferredRole(app, roleAlias)
+				if err != nil {
+					return nil, err
+				}
+				provider, pType, err := appProvider(app)
+				if err != nil {
+					return nil, err
+				}
+
+				assertion, err := samlAssertion(app, provider, pType)
+				if err != nil {
+					return nil, err
+				}
+				if roleAlias != "" {
+					if err = checkRoleAlias(assertion, roleAlias, pArn); err != nil {
+						return nil, err
+					}
+				}
+
+				creds, role, err := assumeRole(app, assertion, pArn, sessionDuration(app, provider), region)
+				if err != nil {
+					return nil, err
+				}
+				auditCredentials(app, provider, role, outputServer, creds)
+
+				return creds, nil
+			},
+			now: time.Now,
+		}
+		// Authenticate right away rather than when the first client is waiting.
+		if _, err = creds.get(); err != nil {
+			log.Fatal(color.RedString("Could not get temporary credentials: "), err)
+		}
+
+		url := fmt.Sprintf("http://%s/", listener.Addr())
+		log.Printf(color.GreenString("Serving credentials of app '%s' at %s. Set the following in the shell of the tools using them:"), app, url)
+		writeServerEnv(os.Stdout, runtime.GOOS == "windows", url, token, region)
+
+		server := &http.Server{Handler: aws.NewCredentialsHandler(token, creds.get)}
+		// Requests in progress are completed before exiting on SIGHUP.
+		shutdown := make(chan struct{})
+		stop := watchHangup(creds, reloadConfig, func() {
+			server.Shutdown(context.Background())
+			close(shutdown)
+		})
+		defer stop()
+		if err = server.Serve(listener); err != http.ErrServerClosed {
+			log.Fatalf(color.RedString("Error serving credentials: %v"), err)
+		}
+		<-shutdown
+	},
+}
@@ -0,0 +1,183 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/allcloud-io/clisso/aws"
+)
+
+func TestServerCredentials(t *testing.T) {
+	now := time.Date(2021, 2, 10, 10, 0, 0, 0, time.UTC)
+	calls := 0
+	s := &serverCredentials{
+		obtain: func() (*aws.Credentials, error) {
+			calls++
+			return &aws.Credentials{AccessKeyID: string(rune('a' + calls - 1)), Expiration: now.Add(time.Hour)}, nil
+		},
+		now: func() time.Time { return now },
+	}
+
+	for _, tc := range []struct {
+		name   string
+		after  time.Duration
+		expect string
+	}{
+		{name: "initial", expect: "a"},
+		{name: "valid", after: 30 * time.Minute, expect: "a"},
+		{name: "within refresh window", after: time.Hour - serverRefreshWindow, expect: "b"},
+		{name: "renewed", after: time.Hour + 30*time.Minute, expect: "b"},
+		{name: "expired", after: 2 * time.Hour, expect: "c"},
+	} {
+		now = time.Date(2021, 2, 10, 10, 0, 0, 0, time.UTC).Add(tc.after)
+		creds, err := s.get()
+		if err != nil {
+			t.Fatalf("%s: unexpected error %+v", tc.name, err)
+		}
+		if creds.AccessKeyID != tc.expect {
+			t.Errorf("%s: expected credentials %s, received %s", tc.name, tc.expect, creds.AccessKeyID)
+		}
+	}
+}
+
+func TestListenLoopback(t *testing.T) {
+	for _, addr := range []string{"0.0.0.0:0", ":0", "localhost:0", "192.0.2.1:0", "127.0.0.1"} {
+		if l, err := listenLoopback(addr); err == nil {
+			l.Close()
+			t.Errorf("%s: expected error", addr)
+		}
+	}
+
+	l, err := listenLoopback("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	l.Close()
+}
+
+func TestServeEndpoint(t *testing.T) {
+	l, err := listenLoopback("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	defer l.Close()
+
+	token, err := newServerToken()
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	s := &serverCredentials{
+		obtain: func() (*aws.Credentials, error) {
+			return &aws.Credentials{
+				AccessKeyID:     "expectedkey",
+				SecretAccessKey: "expectedsecret",
+				SessionToken:    "expectedtoken",
+				Expiration:      time.Now().Add(time.Hour),
+			}, nil
+		},
+		now: time.Now,
+	}
+	go http.Serve(l, aws.NewCredentialsHandler(token, s.get))
+
+	url := "http://" + l.Addr().String() + "/"
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected status %d without token, received %d", http.StatusForbidden, resp.StatusCode)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", token)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d, received %d", http.StatusOK, resp.StatusCode)
+	}
+	var out map[string]string
+	if err = json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	if out["AccessKeyId"] != "expectedkey" || out["Token"] != "expectedtoken" {
+		t.Errorf("unexpected credentials %v", out)
+	}
+}
+
+func TestWriteServerEnv(t *testing.T) {
+	for _, tc := range []struct {
+		windows bool
+		region  string
+		expect  string
+	}{
+		{
+			expect: "export AWS_CONTAINER_CREDENTIALS_FULL_URI=http://127.0.0.1:1234/\n" +
+				"export AWS_CONTAINER_AUTHORIZATION_TOKEN=token\n",
+		},
+		{
+			windows: true,
+			region:  "eu-west-1",
+			expect: "set AWS_CONTAINER_CREDENTIALS_FULL_URI=http://127.0.0.1:1234/\n" +
+				"set AWS_CONTAINER_AUTHORIZATION_TOKEN=token\n" +
+				"set AWS_REGION=eu-west-1\nset AWS_DEFAULT_REGION=eu-west-1\n",
+		},
+	} {
+		var b bytes.Buffer
+		writeServerEnv(&b, tc.windows, "http://127.0.0.1:1234/", "token", tc.region)
+		if b.String() != tc.expect {
+			t.Errorf("expected %q, received %q", tc.expect, b.String())
+		}
+	}
+}
+
+func TestHandleHangup(t *testing.T) {
+	defer viper.Set("global.serve-sighup", nil)
+
+	for _, tc := range []struct {
+		action       string
+		expectServe  bool
+		expectReload int
+		expectCalls  int
+	}{
+		{action: "", expectServe: true, expectReload: 1, expectCalls: 1},
+		{action: hangupRefresh, expectServe: true, expectReload: 1, expectCalls: 1},
+		{action: hangupReload, expectServe: true, expectReload: 1},
+		{action: hangupIgnore, expectServe: true},
+		{action: hangupExit},
+		{action: "restart", expectServe: true},
+	} {
+		viper.Set("global.serve-sighup", tc.action)
+		calls, reloads := 0, 0
+		s := &serverCredentials{
+			obtain: func() (*aws.Credentials, error) {
+				calls++
+				return &aws.Credentials{Expiration: time.Now().Add(time.Hour)}, nil
+			},
+			now: time.Now,
+		}
+
+		serve := handleHangup(s, func() error {
+			reloads++
+			return nil
+		})
+		if serve != tc.expectServe {
+			t.Errorf("%q: expected serving %v, received %v", tc.action, tc.expectServe, serve)
+		}
+		if reloads != tc.expectReload || calls != tc.expectCalls {
+			t.Errorf("%q: expected %d reloads and %d refreshes, received %d and %d",
+				tc.action, tc.expectReload, tc.expectCalls, reloads, calls)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// +build !windows
+
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/allcloud-io/clisso/aws"
+)
+
+func TestWatchHangup(t *testing.T) {
+	defer viper.Set("global.serve-sighup", nil)
+	viper.Set("global.serve-sighup", hangupRefresh)
+
+	obtained := make(chan struct{})
+	unblock := make(chan struct{})
+	calls := 0
+	s := &serverCredentials{
+		obtain: func() (*aws.Credentials, error) {
+			calls++
+			if calls > 1 {
+				obtained <- struct{}{}
+				<-unblock
+			}
+			return &aws.Credentials{AccessKeyID: string(rune('a' + calls - 1)), Expiration: time.Now().Add(time.Hour)}, nil
+		},
+		now: time.Now,
+	}
+	if _, err := s.get(); err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	srv := httptest.NewServer(aws.NewCredentialsHandler("token", s.get))
+	defer srv.Close()
+
+	reloads := 0
+	stop := watchHangup(s, func() error {
+		reloads++
+		return nil
+	}, func() { t.Error("expected serving not to stop") })
+	defer stop()
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-obtained:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected new credentials to be obtained on SIGHUP")
+	}
+
+	// Requests during the refresh keep being answered with the current credentials.
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "token")
+	client := http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	var out map[string]string
+	err = json.NewDecoder(resp.Body).Decode(&out)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	if out["AccessKeyId"] != "a" {
+		t.Errorf("expected the current credentials during the refresh, received %s", out["AccessKeyId"])
+	}
+
+	close(unblock)
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		creds, err := s.get()
+		if err != nil {
+			t.Fatalf("unexpected error %+v", err)
+		}
+		if creds.AccessKeyID == "b" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the refreshed credentials to be served")
+		}
+	}
+	if reloads != 1 {
+		t.Errorf("expected the config to be reloaded once, received %d reloads", reloads)
+	}
+}
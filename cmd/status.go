@@ -4,9 +4,11 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/allcloud-io/clisso/aws"
+	"github.com/allcloud-io/clisso/config"
 	"github.com/fatih/color"
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/olekukonko/tablewriter"
@@ -15,6 +17,11 @@ import (
 )
 
 var readFromFile string
+var statusVerify bool
+var statusTags []string
+
+// callerIdentity is replaced in tests.
+var callerIdentity = aws.GetCallerIdentity
 
 func init() {
 	RootCmd.AddCommand(cmdStatus)
@@ -22,6 +29,14 @@ func init() {
 		&readFromFile, "read-from-file", "r", "",
 		"Read credentials from this file instead of the default ($HOME/.aws/credentials)",
 	)
+	cmdStatus.Flags().BoolVar(
+		&statusVerify, "verify", false,
+		"Check that AWS accepts the credentials of each app and show the identity they belong to",
+	)
+	cmdStatus.Flags().StringSliceVar(
+		&statusTags, "tag", nil,
+		"Show only the credentials of apps which have one of these tags",
+	)
 	err := viper.BindPFlag("global.credentials-path", cmdStatus.Flags().Lookup("read-from-file"))
 	 if err != nil {
 		log.Fatalf(color.RedString("Error binding flag global.credentials-path: %v"), err)
@@ -31,7 +46,14 @@ func init() {
 var cmdStatus = &cobra.Command{
 	Use:   "status",
 	Short: "Show active (non-expired) credentials",
-	Long:  `Show active (non-expired) credentials`,
+	Long: `Show active (non-expired) credentials.
+
+With --verify, the credentials of all apps are checked concurrently using
+sts:GetCallerIdentity, which shows the role they belong to and catches
+credentials which were revoked before they expired.
+
+With --tag, only the profiles named after an app which has one of the given
+tags are shown.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		printStatus()
 	},
@@ -47,19 +69,112 @@ func printStatus() {
 	if err != nil {
 		log.Fatalf(color.RedString("Failed to retrieve non-expired credentials: %s"), err)
 	}
+	if len(statusTags) > 0 {
+		profiles = filterProfileTags(profiles, statusTags)
+	}
 
 	if len(profiles) == 0 {
 		fmt.Println("No apps with valid credentials")
 		return
 	}
 
+	header := []string{"App", "Expire At", "Remaining"}
+	var identities []string
+	if statusVerify {
+		header = append(header, "Identity")
+		identities = verifyProfiles(configfile, profiles)
+	}
+
 	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"App", "Expire At", "Remaining"})
+	table.SetHeader(header)
 
 	log.Print("The following apps currently have valid credentials:")
-	for _, p := range profiles {
-		table.Append([]string{p.Name, fmt.Sprintf("%d", p.ExpireAtUnix), p.LifetimeLeft.Round(time.Second).String()})
+	now := time.Now()
+	for i, p := range profiles {
+		absolute, relative := expiry(time.Unix(p.ExpireAtUnix, 0), now, time.Local)
+		row := []string{p.Name, absolute, relative}
+		if statusVerify {
+			row = append(row, identities[i])
+		}
+		table.Append(row)
 	}
 
 	table.Render()
 }
+
+// filterProfileTags returns the profiles which an app that has any of tags writes its credentials
+// to (see appProfileNames).
+func filterProfileTags(profiles []aws.Profile, tags []string) []aws.Profile {
+	names := make(map[string]bool)
+	for _, app := range config.AppsWithTags(tags...) {
+		for _, name := range appProfileNames(app) {
+			names[name] = true
+		}
+	}
+
+	var filtered []aws.Profile
+	for _, p := range profiles {
+		if names[p.Name] {
+			filtered = append(filtered, p)
+		}
+	}
+
+	return filtered
+}
+
+// appProfileNames returns the names of the profiles app writes its credentials to unless another
+// profile is selected. A profile rendered from the app's profile template is only known for the
+// roles the app is configured with: its arn and the roles of its and the global roles setting.
+func appProfileNames(app string) []string {
+	roles := []string{viper.GetString(fmt.Sprintf("apps.%s.arn", app))}
+	for _, section := range []string{fmt.Sprintf("apps.%s", app), "global"} {
+		for _, arn := range viper.GetStringMapString(section + ".roles") {
+			roles = append(roles, arn)
+		}
+	}
+
+	var names []string
+	for _, role := range roles {
+		if name, err := appProfileName(app, role); err == nil {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// verifyProfiles checks the credentials of profiles, which are read from the credentials file at
+// path, concurrently. For each profile, the ARN of the identity the credentials belong to is
+// returned, or a description of why it couldn't be verified.
+func verifyProfiles(path string, profiles []aws.Profile) []string {
+	identities := make([]string, len(profiles))
+
+	var wg sync.WaitGroup
+	for i, p := range profiles {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			identities[i] = verifyProfile(path, name)
+		}(i, p.Name)
+	}
+	wg.Wait()
+
+	return identities
+}
+
+func verifyProfile(path, name string) string {
+	creds, err := aws.ReadFromFile(path, name)
+	if err != nil {
+		return color.YellowString("not verified: %v", err)
+	}
+
+	arn, err := callerIdentity(creds)
+	if err == aws.ErrCredentialsRejected {
+		return color.RedString("REJECTED (revoked or invalid)")
+	}
+	if err != nil {
+		return color.YellowString("not verified: %v", err)
+	}
+
+	return arn
+}
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/allcloud-io/clisso/aws"
+)
+
+func TestVerifyProfiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clisso-status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "credentials")
+
+	for _, name := range []string{"valid", "revoked", "offline"} {
+		creds := aws.Credentials{
+			AccessKeyID:     name + "-key",
+			SecretAccessKey: name + "-secret",
+			SessionToken:    name + "-token",
+			Expiration:      time.Now().Add(time.Hour),
+		}
+		if err := aws.WriteToFile(&creds, path, name, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	arn := "arn:aws:sts::123456789012:assumed-role/Admin/user@example.com"
+	defer func(f func(*aws.Credentials) (string, error)) { callerIdentity = f }(callerIdentity)
+	callerIdentity = func(c *aws.Credentials) (string, error) {
+		switch c.AccessKeyID {
+		case "valid-key":
+			return arn, nil
+		case "revoked-key":
+			return "", aws.ErrCredentialsRejected
+		}
+		return "", errors.New("connection refused")
+	}
+
+	profiles := []aws.Profile{{Name: "valid"}, {Name: "revoked"}, {Name: "offline"}, {Name: "missing"}}
+	identities := verifyProfiles(path, profiles)
+	if len(identities) != len(profiles) {
+		t.Fatalf("expected %d identities, received %d", len(profiles), len(identities))
+	}
+
+	for i, expect := range []string{arn, "REJECTED", "not verified: connection refused", "not verified: no credentials"} {
+		if !strings.Contains(identities[i], expect) {
+			t.Errorf("%s: expected %q, received %q", profiles[i].Name, expect, identities[i])
+		}
+	}
+}
+
+func TestFilterProfileTags(t *testing.T) {
+	for app, conf := range map[string]map[string]interface{}{
+		"status-plain": {"tags": []string{"staging"}},
+		"status-template": {
+			"tags":             []string{"staging"},
+			"arn":              "arn:aws:iam::123456789012:role/Admin",
+			"roles":            map[string]interface{}{"ro": "arn:aws:iam::123456789012:role/ReadOnly"},
+			"profile-template": "{{.AccountID}}_{{.Role}}",
+		},
+		"status-other": {"tags": []string{"prod"}},
+	} {
+		viper.Set("apps."+app, conf)
+		defer viper.Set("apps."+app, nil)
+	}
+
+	profiles := []aws.Profile{
+		{Name: "status-plain"},
+		{Name: "123456789012_Admin"},
+		{Name: "123456789012_ReadOnly"},
+		{Name: "status-template"},
+		{Name: "status-other"},
+	}
+	var names []string
+	for _, p := range filterProfileTags(profiles, []string{"staging"}) {
+		names = append(names, p.Name)
+	}
+	// Profiles rendered from a template are matched, the app's name isn't its profile then.
+	expect := []string{"status-plain", "123456789012_Admin", "123456789012_ReadOnly"}
+	if strings.Join(names, ",") != strings.Join(expect, ",") {
+		t.Errorf("expected %q, received %q", expect, names)
+	}
+}
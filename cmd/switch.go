@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/allcloud-io/clisso/aws"
+)
+
+// defaultProfile is the profile used by the AWS CLI and SDKs when no profile is selected.
+const defaultProfile = "default"
+
+var switchToDefault bool
+
+func init() {
+	RootCmd.AddCommand(cmdSwitch)
+	cmdSwitch.Flags().BoolVar(
+		&switchToDefault, "default", false,
+		"Copy the cached credentials into the default profile instead of printing AWS_PROFILE",
+	)
+	// The flags are shared with get.
+	cmdSwitch.Flags().AddFlag(cmdGet.Flags().Lookup("min-validity"))
+	cmdSwitch.Flags().AddFlag(cmdGet.Flags().Lookup("profile"))
+	cmdSwitch.Flags().AddFlag(cmdGet.Flags().Lookup("role"))
+}
+
+// switchApp makes the valid credentials previously obtained for app active without
+// re-authenticating. By default, a command setting AWS_PROFILE to the app's profile is written to
+// w using the syntax of the shell. If toDefault is set, the credentials are copied into the
+// default profile of the credentials file instead.
+func switchApp(app string, toDefault, windows bool, w io.Writer) error {
+	// The profile is chosen as by get, except that AWS_PROFILE is ignored since it is set by this
+	// command.
+	p := profile
+	if p == "" {
+		pArn, err := preferredRole(app, roleAlias)
+		if err != nil {
+			return err
+		}
+		if p, err = appProfileName(app, pArn); err != nil {
+			return fmt.Errorf("getting profile of app %s: %v", app, err)
+		}
+	}
+	creds, err := cachedCredentials(app, p)
+	if err != nil {
+		return fmt.Errorf("no valid credentials for app %s, use 'clisso get %s' first: %v", app, app, err)
+	}
+
+	if toDefault {
+		path, _, err := credentialsFile(app)
+		if err != nil {
+			return err
+		}
+		key, err := expirationKey()
+		if err != nil {
+			return err
+		}
+		if err = aws.WriteToFile(creds, path, defaultProfile, key); err != nil {
+			return fmt.Errorf("writing credentials to file: %v", err)
+		}
+		log.Printf(color.GreenString("Credentials of app '%s' copied to profile '%s' in '%s'"), app, defaultProfile, path)
+
+		return nil
+	}
+
+	if windows {
+		fmt.Fprintf(w, "set AWS_PROFILE=%s\n", p)
+	} else {
+		fmt.Fprintf(w, "export AWS_PROFILE=%s\n", p)
+	}
+
+	return nil
+}
+
+var cmdSwitch = &cobra.Command{
+	Use:   "switch [app name]",
+	Short: "Switch to the cached credentials of an app",
+	Long: `Make the credentials previously obtained for the specified app active without
+authenticating again, as long as they are still valid.
+
+By default, a command which sets AWS_PROFILE to the app's profile is printed,
+e.g. for use with eval:
+
+    eval $(clisso switch my-app)
+
+With --default, the credentials are copied into the default profile instead.
+
+If no app is specified, the selected app (if configured) will be assumed.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		app, err := appFromArgs(args)
+		if err != nil {
+			log.Fatal(color.RedString(err.Error()))
+		}
+
+		if err = switchApp(app, switchToDefault, runtime.GOOS == "windows", os.Stdout); err != nil {
+			log.Fatal(color.RedString(err.Error()))
+		}
+	},
+}
@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/allcloud-io/clisso/aws"
+)
+
+func TestSwitchApp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clisso-switch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "credentials")
+	viper.Set("global.credentials-path", path)
+	defer viper.Set("global.credentials-path", "")
+
+	creds := aws.Credentials{
+		AccessKeyID:     "switch-key",
+		SecretAccessKey: "switch-secret",
+		SessionToken:    "switch-token",
+		Expiration:      time.Now().Add(time.Hour),
+	}
+	if err := aws.WriteToFile(&creds, path, "switch-app", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name    string
+		windows bool
+		expect  string
+	}{
+		{"Unix", false, "export AWS_PROFILE=switch-app\n"},
+		{"Windows", true, "set AWS_PROFILE=switch-app\n"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := switchApp("switch-app", false, test.windows, &buf); err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+			if buf.String() != test.expect {
+				t.Errorf("expected %q, received %q", test.expect, buf.String())
+			}
+		})
+	}
+
+	t.Run("Profile template", func(t *testing.T) {
+		viper.Set("apps.switch-template-app.arn", "arn:aws:iam::123456789012:role/Admin")
+		viper.Set("apps.switch-template-app.profile-template", "{{.AccountID}}_{{.Role}}")
+		defer viper.Set("apps.switch-template-app", nil)
+		if err := aws.WriteToFile(&creds, path, "123456789012_Admin", ""); err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		if err := switchApp("switch-template-app", false, false, &buf); err != nil {
+			t.Fatalf("unexpected error %+v", err)
+		}
+		if expect := "export AWS_PROFILE=123456789012_Admin\n"; buf.String() != expect {
+			t.Errorf("expected %q, received %q", expect, buf.String())
+		}
+	})
+
+	t.Run("Profile flag", func(t *testing.T) {
+		defer func(p string) { profile = p }(profile)
+		profile = "switch-profile"
+		if err := aws.WriteToFile(&creds, path, "switch-profile", ""); err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		if err := switchApp("switch-other-app", false, false, &buf); err != nil {
+			t.Fatalf("unexpected error %+v", err)
+		}
+		if expect := "export AWS_PROFILE=switch-profile\n"; buf.String() != expect {
+			t.Errorf("expected %q, received %q", expect, buf.String())
+		}
+	})
+
+	t.Run("Default profile", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := switchApp("switch-app", true, false, &buf); err != nil {
+			t.Fatalf("unexpected error %+v", err)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("expected no output, received %q", buf.String())
+		}
+
+		res, err := aws.ReadFromFile(path, defaultProfile)
+		if err != nil {
+			t.Fatalf("unexpected error %+v", err)
+		}
+		if res.AccessKeyID != "switch-key" {
+			t.Errorf("expected access key %q, received %q", "switch-key", res.AccessKeyID)
+		}
+	})
+
+	t.Run("Expired credentials", func(t *testing.T) {
+		expired := creds
+		expired.Expiration = time.Now().Add(-time.Minute)
+		// WriteToFile removes expired credentials after writing them.
+		if err := aws.WriteToFile(&expired, path, "expired-app", ""); err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		if err := switchApp("expired-app", false, false, &buf); err == nil {
+			t.Errorf("expected error")
+		}
+		if buf.Len() != 0 {
+			t.Errorf("expected no output, received %q", buf.String())
+		}
+	})
+}
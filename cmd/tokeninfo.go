@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/allcloud-io/clisso/onelogin"
+)
+
+func init() {
+	RootCmd.AddCommand(cmdTokenInfo)
+}
+
+// writeTokenInfo writes the type and expiry of an access token to w, followed by its decoded
+// header and claims if it is a JWT. The token itself is never written: the signature of a JWT is
+// redacted and of an opaque token only the length is shown.
+func writeTokenInfo(w io.Writer, resp *onelogin.GenerateTokensResponse) error {
+	fmt.Fprintf(w, "Token type: %s\n", resp.TokenType)
+	fmt.Fprintf(w, "Expires: %s\n", formatExpiry(resp.Expiry()))
+
+	jwt, err := onelogin.ParseJWT(resp.AccessToken)
+	if err != nil {
+		_, err = fmt.Fprintf(w, "Opaque token of %d characters\n", len(resp.AccessToken))
+		return err
+	}
+
+	if exp, ok := jwt.Expiry(); ok {
+		fmt.Fprintf(w, "Expires (exp claim): %s\n", formatExpiry(exp))
+	}
+	for _, part := range []struct {
+		name  string
+		value map[string]interface{}
+	}{
+		{"Header", jwt.Header},
+		{"Claims", jwt.Claims},
+	} {
+		b, err := json.MarshalIndent(part.value, "", "  ")
+		if err != nil {
+			return fmt.Errorf("encoding JWT %s: %v", part.name, err)
+		}
+		fmt.Fprintf(w, "%s:\n%s\n", part.name, b)
+	}
+	_, err = fmt.Fprintf(w, "Signature: %s\n", redacted)
+
+	return err
+}
+
+var cmdTokenInfo = &cobra.Command{
+	Use:   "token-info [provider name]",
+	Short: "Show the decoded OneLogin API access token of a provider",
+	Long: `Generate an access token for the OneLogin API using the API credentials of
+the specified provider and show its type and expiry. If the token is a JWT, its
+decoded header and claims are shown as well, which helps diagnosing scoping and
+expiry problems. The token itself is never printed.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		provider := args[0]
+
+		pType := viper.GetString(fmt.Sprintf("providers.%s.type", provider))
+		if pType == "" {
+			log.Fatalf(color.RedString("Could not get provider type for provider '%s'"), provider)
+		}
+		if pType != "onelogin" {
+			log.Fatalf(color.RedString("Provider '%s' is of type '%s', only OneLogin providers are supported"), provider, pType)
+		}
+
+		resp, err := onelogin.GetToken(provider)
+		if err != nil {
+			log.Fatal(color.RedString(err.Error()))
+		}
+
+		if err = writeTokenInfo(os.Stdout, resp); err != nil {
+			log.Fatalf(color.RedString("Error writing token info: %v"), err)
+		}
+	},
+}
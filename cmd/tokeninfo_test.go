@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/allcloud-io/clisso/onelogin"
+)
+
+func TestWriteTokenInfo(t *testing.T) {
+	encode := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	jwt := encode(`{"alg":"RS256","typ":"JWT"}`) + "." +
+		encode(`{"sub":"api-client","scope":"read write","exp":1614861000}`) + ".c2lnbmF0dXJl"
+
+	for _, test := range []struct {
+		name          string
+		token         string
+		expect        []string
+		expectMissing []string
+	}{
+		{
+			"JWT",
+			jwt,
+			[]string{`"alg": "RS256"`, `"scope": "read write"`, "Expires (exp claim): ", "Signature: " + redacted},
+			[]string{jwt, "c2lnbmF0dXJl", "Opaque"},
+		},
+		{
+			"Opaque token",
+			"0123456789abcdef",
+			[]string{"Opaque token of 16 characters"},
+			[]string{"0123456789abcdef", "Claims"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			resp := onelogin.GenerateTokensResponse{
+				AccessToken: test.token,
+				TokenType:   "bearer",
+				CreatedAt:   time.Now(),
+				ExpiresIn:   36000,
+			}
+			var b bytes.Buffer
+
+			if err := writeTokenInfo(&b, &resp); err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+
+			out := b.String()
+			for _, s := range append(test.expect, "Token type: bearer", "Expires: ") {
+				if !strings.Contains(out, s) {
+					t.Errorf("expected output to contain %q, received:\n%s", s, out)
+				}
+			}
+			for _, s := range test.expectMissing {
+				if strings.Contains(out, s) {
+					t.Errorf("expected output not to contain %q, received:\n%s", s, out)
+				}
+			}
+		})
+	}
+}
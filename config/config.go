@@ -3,10 +3,83 @@ package config
 import (
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/spf13/viper"
 )
 
+// Apps returns the names of all configured apps, sorted alphabetically.
+func Apps() []string {
+	return sortedKeys(viper.GetStringMap("apps"))
+}
+
+// Providers returns the names of all configured providers, sorted alphabetically.
+func Providers() []string {
+	return sortedKeys(viper.GetStringMap("providers"))
+}
+
+// ProviderTags returns the tags of provider, as listed in its tags setting.
+func ProviderTags(provider string) []string {
+	return viper.GetStringSlice(fmt.Sprintf("providers.%s.tags", provider))
+}
+
+// AppTags returns the tags of app: those listed in its tags setting followed by the tags of its
+// provider, which apply to all of the provider's apps.
+func AppTags(app string) []string {
+	tags := viper.GetStringSlice(fmt.Sprintf("apps.%s.tags", app))
+	if p := viper.GetString(fmt.Sprintf("apps.%s.provider", app)); p != "" {
+		tags = append(tags, ProviderTags(p)...)
+	}
+
+	return tags
+}
+
+// HasTag reports whether tags contains any of want.
+func HasTag(tags []string, want ...string) bool {
+	for _, t := range want {
+		if contains(tags, t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AppsWithTags returns the names of the apps which have any of the given tags, sorted
+// alphabetically.
+func AppsWithTags(tags ...string) []string {
+	var apps []string
+	for _, app := range Apps() {
+		if HasTag(AppTags(app), tags...) {
+			apps = append(apps, app)
+		}
+	}
+
+	return apps
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// contains reports whether values contains v.
+func contains(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+
+	return false
+}
+
 // OneLoginProviderConfig represents a OneLogin provider configuration.
 type OneLoginProviderConfig struct {
 	ClientID     string
@@ -15,6 +88,86 @@ type OneLoginProviderConfig struct {
 	Type         string
 	Username     string
 	Region       string
+	IPVersion    string
+	Headers      map[string]string
+	// RequestTimeout and Timeout are in seconds. Zero means the client's default.
+	RequestTimeout int64
+	Timeout        int64
+	// DialTimeout and TLSHandshakeTimeout are in seconds. Zero means the client's default.
+	DialTimeout         int64
+	TLSHandshakeTimeout int64
+	// RateLimit is the maximum number of requests per second. Zero means the client's default.
+	RateLimit float64
+	// MFAReselect lets the user select another MFA device if verification fails.
+	MFAReselect bool
+	// MFARetries is the number of times verification is retried using the same MFA device.
+	MFARetries int
+	// SAMLRequestRetries is the number of times the SAML assertion request is retried after a
+	// transient failure, reusing the password and access token.
+	SAMLRequestRetries int
+	// UserAgent overrides the default User-Agent header if set.
+	UserAgent string
+	// MultipleAssertions is the strategy for responses containing several SAML assertions: error,
+	// first, arn or prompt.
+	MultipleAssertions string
+	// MFAPush controls how OneLogin Protect devices are verified: push-first, otp-first or
+	// push-only.
+	MFAPush string
+	// MFADevice selects the MFA device by its type or ID instead of prompting, if set.
+	MFADevice string
+}
+
+// DefaultSAMLRequestRetries is the number of times the SAML assertion request of a OneLogin
+// provider is retried after a transient failure, unless configured otherwise.
+const DefaultSAMLRequestRetries = 1
+
+// MultipleAssertionsStrategies are the valid values of the multiple-assertions provider setting.
+var MultipleAssertionsStrategies = []string{"error", "first", "arn", "prompt"}
+
+// MFAPushModes are the valid values of the mfa-push provider and app setting.
+var MFAPushModes = []string{"push-first", "otp-first", "push-only"}
+
+// OneLoginRegions are the valid values of the region OneLogin provider setting.
+var OneLoginRegions = []string{"US", "EU"}
+
+// IsOneLoginRegion reports whether r is one of OneLoginRegions.
+func IsOneLoginRegion(r string) bool {
+	return contains(OneLoginRegions, r)
+}
+
+// checkOneLoginRegion returns an error if region isn't one of OneLoginRegions.
+func checkOneLoginRegion(region string) error {
+	if !IsOneLoginRegion(region) {
+		return fmt.Errorf("region config value %q is invalid, must be one of %s", region, strings.Join(OneLoginRegions, ", "))
+	}
+
+	return nil
+}
+
+// CheckOneLoginRegions returns an error if the region of any OneLogin provider isn't one of
+// OneLoginRegions, so that an invalid region is reported when the config is loaded rather than
+// when the provider is first used.
+func CheckOneLoginRegions() error {
+	var providers []string
+	for p := range viper.GetStringMap("providers") {
+		providers = append(providers, p)
+	}
+	sort.Strings(providers)
+
+	for _, p := range providers {
+		if viper.GetString(fmt.Sprintf("providers.%s.type", p)) != "onelogin" {
+			continue
+		}
+		region := viper.GetString(fmt.Sprintf("providers.%s.region", p))
+		if region == "" {
+			continue
+		}
+		if err := checkOneLoginRegion(region); err != nil {
+			return fmt.Errorf("provider %s: %v", p, err)
+		}
+	}
+
+	return nil
 }
 
 // GetOneLoginProvider returns a OneLoginProviderConfig struct containing the configuration for
@@ -25,6 +178,24 @@ func GetOneLoginProvider(p string) (*OneLoginProviderConfig, error) {
 	subdomain := viper.GetString(fmt.Sprintf("providers.%s.subdomain", p))
 	username := viper.GetString(fmt.Sprintf("providers.%s.username", p))
 	region := viper.GetString(fmt.Sprintf("providers.%s.region", p))
+	ipVersion := viper.GetString(fmt.Sprintf("providers.%s.ip-version", p))
+	headers := viper.GetStringMapString(fmt.Sprintf("providers.%s.headers", p))
+	requestTimeout := viper.GetInt64(fmt.Sprintf("providers.%s.request-timeout", p))
+	timeout := viper.GetInt64(fmt.Sprintf("providers.%s.timeout", p))
+	dialTimeout := viper.GetInt64(fmt.Sprintf("providers.%s.dial-timeout", p))
+	tlsHandshakeTimeout := viper.GetInt64(fmt.Sprintf("providers.%s.tls-handshake-timeout", p))
+	rateLimit := viper.GetFloat64(fmt.Sprintf("providers.%s.rate-limit", p))
+	mfaReselect := viper.GetBool(fmt.Sprintf("providers.%s.mfa-reselect", p))
+	mfaRetries := viper.GetInt(fmt.Sprintf("providers.%s.mfa-retries", p))
+	samlRequestRetries := DefaultSAMLRequestRetries
+	if key := fmt.Sprintf("providers.%s.saml-request-retries", p); viper.IsSet(key) {
+		samlRequestRetries = viper.GetInt(key)
+	}
+	userAgentKey := fmt.Sprintf("providers.%s.user-agent", p)
+	userAgent := viper.GetString(userAgentKey)
+	multipleAssertions := viper.GetString(fmt.Sprintf("providers.%s.multiple-assertions", p))
+	mfaPush := viper.GetString(fmt.Sprintf("providers.%s.mfa-push", p))
+	mfaDevice := viper.GetString(fmt.Sprintf("providers.%s.mfa-device", p))
 
 	if clientSecret == "" {
 		return nil, errors.New("client-secret config value must bet set")
@@ -35,10 +206,41 @@ func GetOneLoginProvider(p string) (*OneLoginProviderConfig, error) {
 	if subdomain == "" {
 		return nil, errors.New("subdomain config value must bet set")
 	}
+	if viper.IsSet(userAgentKey) && strings.TrimSpace(userAgent) == "" {
+		return nil, errors.New("user-agent config value must not be empty")
+	}
+
+	if rateLimit < 0 {
+		return nil, errors.New("rate-limit config value must not be negative")
+	}
+	if mfaRetries < 0 {
+		return nil, errors.New("mfa-retries config value must not be negative")
+	}
+	if samlRequestRetries < 0 {
+		return nil, errors.New("saml-request-retries config value must not be negative")
+	}
+	if multipleAssertions == "" {
+		multipleAssertions = "error"
+	}
+	if !contains(MultipleAssertionsStrategies, multipleAssertions) {
+		return nil, fmt.Errorf(
+			"multiple-assertions config value must be one of %s", strings.Join(MultipleAssertionsStrategies, ", "),
+		)
+	}
+
+	if mfaPush == "" {
+		mfaPush = "push-first"
+	}
+	if !contains(MFAPushModes, mfaPush) {
+		return nil, fmt.Errorf("mfa-push config value must be one of %s", strings.Join(MFAPushModes, ", "))
+	}
 
 	if region == "" {
 		region = "US"
 	}
+	if err := checkOneLoginRegion(region); err != nil {
+		return nil, err
+	}
 
 	c := OneLoginProviderConfig{
 		ClientID:     clientID,
@@ -46,6 +248,22 @@ func GetOneLoginProvider(p string) (*OneLoginProviderConfig, error) {
 		Subdomain:    subdomain,
 		Username:     username,
 		Region:       region,
+		IPVersion:    ipVersion,
+		Headers:      headers,
+
+		RequestTimeout:      requestTimeout,
+		Timeout:             timeout,
+		DialTimeout:         dialTimeout,
+		TLSHandshakeTimeout: tlsHandshakeTimeout,
+		RateLimit:           rateLimit,
+		MFAReselect:         mfaReselect,
+		MFARetries:          mfaRetries,
+		SAMLRequestRetries:  samlRequestRetries,
+		UserAgent:           userAgent,
+
+		MultipleAssertions: multipleAssertions,
+		MFAPush:            mfaPush,
+		MFADevice:          mfaDevice,
 	}
 
 	return &c, nil
@@ -55,6 +273,12 @@ func GetOneLoginProvider(p string) (*OneLoginProviderConfig, error) {
 type OneLoginAppConfig struct {
 	ID       string
 	Provider string
+	// MFAPush overrides the provider's mfa-push setting if set.
+	MFAPush string
+	// MFADevice overrides the provider's mfa-device setting if set.
+	MFADevice string
+	// Username overrides the provider's username setting if set.
+	Username string
 }
 
 // GetOneLoginApp returns a OneLoginAppConfig struct containing the configuration for app.
@@ -62,14 +286,22 @@ func GetOneLoginApp(app string) (*OneLoginAppConfig, error) {
 	config := viper.GetStringMapString("apps." + app)
 	appID := config["app-id"]
 	provider := config["provider"]
+	mfaPush := config["mfa-push"]
+	mfaDevice := config["mfa-device"]
 
 	if appID == "" {
 		return nil, errors.New("app-id config value must be set")
 	}
+	if mfaPush != "" && !contains(MFAPushModes, mfaPush) {
+		return nil, fmt.Errorf("mfa-push config value must be one of %s", strings.Join(MFAPushModes, ", "))
+	}
 
 	c := OneLoginAppConfig{
-		ID:       appID,
-		Provider: provider,
+		ID:        appID,
+		Provider:  provider,
+		MFAPush:   mfaPush,
+		MFADevice: mfaDevice,
+		Username:  config["username"],
 	}
 
 	return &c, nil
@@ -97,6 +329,8 @@ func GetOktaProvider(p string) (*OktaProviderConfig, error) {
 type OktaAppConfig struct {
 	Provider string
 	URL      string
+	// Username overrides the provider's username setting if set.
+	Username string
 }
 
 // GetOktaApp returns an OktaAppConfig struct containing the configuration for app.
@@ -117,5 +351,6 @@ func GetOktaApp(app string) (*OktaAppConfig, error) {
 	return &OktaAppConfig{
 		Provider: provider,
 		URL:      url,
+		Username: config["username"],
 	}, nil
 }
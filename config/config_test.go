@@ -0,0 +1,114 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestGetOneLoginProviderRegion(t *testing.T) {
+	defer viper.Reset()
+
+	for _, test := range []struct {
+		name        string
+		region      string
+		expect      string
+		expectError bool
+	}{
+		{name: "Default", expect: "US"},
+		{name: "US", region: "US", expect: "US"},
+		{name: "EU", region: "EU", expect: "EU"},
+		{name: "Typo", region: "UE", expectError: true},
+		{name: "Lowercase", region: "eu", expectError: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			viper.Reset()
+			viper.Set("providers.test.client-id", "id")
+			viper.Set("providers.test.client-secret", "secret")
+			viper.Set("providers.test.subdomain", "example")
+			if test.region != "" {
+				viper.Set("providers.test.region", test.region)
+			}
+
+			p, err := GetOneLoginProvider("test")
+			if test.expectError {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				if !strings.Contains(err.Error(), strings.Join(OneLoginRegions, ", ")) {
+					t.Fatalf("error %q doesn't list the valid regions", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+			if p.Region != test.expect {
+				t.Errorf("expected %q, received %q", test.expect, p.Region)
+			}
+		})
+	}
+}
+
+func TestCheckOneLoginRegions(t *testing.T) {
+	defer viper.Reset()
+
+	for _, test := range []struct {
+		name        string
+		providers   map[string]interface{}
+		expectError bool
+	}{
+		{"No region", map[string]interface{}{"work": map[string]interface{}{"type": "onelogin"}}, false},
+		{"Valid region", map[string]interface{}{"work": map[string]interface{}{"type": "onelogin", "region": "EU"}}, false},
+		{"Invalid region", map[string]interface{}{"work": map[string]interface{}{"type": "onelogin", "region": "UE"}}, true},
+		{"Okta", map[string]interface{}{"personal": map[string]interface{}{"type": "okta", "region": "UE"}}, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			viper.Reset()
+			viper.Set("providers", test.providers)
+
+			err := CheckOneLoginRegions()
+			if test.expectError && err == nil {
+				t.Errorf("expected error")
+			}
+			if !test.expectError && err != nil {
+				t.Errorf("unexpected error %+v", err)
+			}
+		})
+	}
+}
+
+func TestAppsWithTags(t *testing.T) {
+	defer viper.Reset()
+	viper.Reset()
+	viper.Set("providers.work", map[string]interface{}{"type": "onelogin", "tags": []string{"work"}})
+	viper.Set("providers.personal", map[string]interface{}{"type": "okta"})
+	viper.Set("apps.prod", map[string]interface{}{"provider": "work", "tags": []string{"prod", "eu"}})
+	viper.Set("apps.staging", map[string]interface{}{"provider": "work", "tags": []string{"staging"}})
+	viper.Set("apps.sandbox", map[string]interface{}{"provider": "personal", "tags": []string{"prod"}})
+	viper.Set("apps.blog", map[string]interface{}{"provider": "personal"})
+
+	if tags := AppTags("prod"); !reflect.DeepEqual(tags, []string{"prod", "eu", "work"}) {
+		t.Errorf("expected the tags of the app and its provider, received %v", tags)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		tags     []string
+		expected []string
+	}{
+		{name: "app tag", tags: []string{"prod"}, expected: []string{"prod", "sandbox"}},
+		{name: "provider tag", tags: []string{"work"}, expected: []string{"prod", "staging"}},
+		{name: "any of several", tags: []string{"eu", "staging"}, expected: []string{"prod", "staging"}},
+		{name: "unknown", tags: []string{"dev"}},
+		{name: "none", tags: nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if apps := AppsWithTags(tc.tags...); !reflect.DeepEqual(apps, tc.expected) {
+				t.Errorf("expected %v, received %v", tc.expected, apps)
+			}
+		})
+	}
+}
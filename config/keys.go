@@ -0,0 +1,150 @@
+package config
+
+import (
+	"sort"
+	"strings"
+)
+
+// The settings clisso reads from each section of the config file. Settings which hold a map with
+// arbitrary keys, e.g. HTTP headers, are listed in mapSettings.
+var (
+	globalSettings = []string{
+		"accounts",
+		"audit-log",
+		"aws-config-path",
+		"backup-code",
+		"check-audience",
+		"check-validity",
+		"clock-skew",
+		"credentials-path",
+		"expected-account-strict",
+		"expiration-key",
+		"fallback-duration",
+		"hide-otp",
+		"hide-push-message",
+		"legacy-session-token",
+		"lock-wait",
+		"min-validity",
+		"no-keyring",
+		"non-interactive",
+		"post-hook",
+		"post-hook-strict",
+		"post-hook-timeout",
+		"profile-template",
+		"quiet",
+		"record",
+		"refresh-providers",
+		"replay",
+		"roles",
+		"selected-app",
+		"serve-sighup",
+		"show-policies",
+		"socket-path",
+		"spinner-style",
+		"strict-config",
+		"sts-global-endpoint",
+	}
+	providerSettings = []string{
+		"assertion-retries",
+		"base-url",
+		"client-id",
+		"client-secret",
+		"dial-timeout",
+		"duration",
+		"headers",
+		"ip-version",
+		"mfa-device",
+		"mfa-push",
+		"mfa-reselect",
+		"mfa-retries",
+		"multiple-assertions",
+		"prompt-otp",
+		"rate-limit",
+		"region",
+		"request-timeout",
+		"saml-request-retries",
+		"subdomain",
+		"tags",
+		"timeout",
+		"tls-handshake-timeout",
+		"type",
+		"user-agent",
+		"username",
+	}
+	appSettings = []string{
+		"allowed-roles",
+		"app-id",
+		"arn",
+		"check-audience",
+		"check-validity",
+		"duration",
+		"expected-account",
+		"expected-account-strict",
+		"expected-issuer",
+		"fallback-duration",
+		"max-duration",
+		"max-duration-role",
+		"mfa-device",
+		"mfa-push",
+		"min-validity",
+		"output",
+		"output-file",
+		"output-format",
+		"post-hook",
+		"profile-template",
+		"provider",
+		"regions",
+		"roles",
+		"saml-provider",
+		"tags",
+		"url",
+		"username",
+	}
+	mapSettings = []string{"accounts", "headers", "roles"}
+)
+
+// UnknownKeys returns the sorted keys, e.g. as returned by viper.AllKeys, which don't refer to a
+// setting clisso knows. Keys in the global section are of the form global.<setting>, keys of a
+// provider or app of the form providers.<name>.<setting> and apps.<name>.<setting>.
+func UnknownKeys(keys []string) []string {
+	var unknown []string
+	for _, k := range keys {
+		if !knownKey(k) {
+			unknown = append(unknown, k)
+		}
+	}
+	sort.Strings(unknown)
+
+	return unknown
+}
+
+func knownKey(key string) bool {
+	parts := strings.Split(strings.ToLower(key), ".")
+
+	var settings []string
+	switch parts[0] {
+	case "global":
+		settings = globalSettings
+		parts = parts[1:]
+	case "providers":
+		settings = providerSettings
+		if len(parts) < 2 {
+			return false
+		}
+		parts = parts[2:]
+	case "apps":
+		settings = appSettings
+		if len(parts) < 2 {
+			return false
+		}
+		parts = parts[2:]
+	default:
+		return false
+	}
+
+	if len(parts) == 0 || !contains(settings, parts[0]) {
+		return false
+	}
+
+	return len(parts) == 1 || contains(mapSettings, parts[0])
+}
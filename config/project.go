@@ -0,0 +1,180 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// ProjectFile is the name of project config files, which select or define the app used in a
+// project's directory.
+const ProjectFile = ".clisso.yaml"
+
+// projectAppSettings are the settings a project config may define for an app. A project config is
+// usually part of a repository which may not be trusted, so settings which could expose secrets or
+// credentials (e.g. the url of an Okta app) or run commands (post-hook) aren't allowed. Providers
+// can't be defined either and are always taken from the global config.
+var projectAppSettings = []string{
+	"allowed-roles",
+	"app-id",
+	"arn",
+	"duration",
+	"provider",
+	"regions",
+	"roles",
+	"saml-provider",
+	"tags",
+}
+
+// protectedAppSettings are the settings a project config may define only for the apps it defines
+// itself. Overriding them for an app of the global config could make it use another identity
+// provider app or assume roles which allowed-roles doesn't allow.
+var protectedAppSettings = []string{
+	"allowed-roles",
+	"app-id",
+	"arn",
+	"provider",
+}
+
+// Project is a project config.
+type Project struct {
+	// Path is the path of the project config file.
+	Path string
+	// App is the app selected by the project, if any.
+	App string
+	// Apps holds the settings of the apps defined by the project, keyed by app name.
+	Apps map[string]map[string]interface{}
+}
+
+// FindProject returns the path of the project config file for dir, which is the ProjectFile in dir
+// or, if dir is inside a git repository, the first one found in dir's parents up to the root of
+// the repository. Outside of a git repository, only dir is searched. The files at exclude, i.e. the
+// global config files, are never returned. An empty path is returned if no project config is found.
+func FindProject(dir string, exclude ...string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	excluded := make(map[string]bool)
+	for _, e := range exclude {
+		if e == "" {
+			continue
+		}
+		if e, err = filepath.Abs(e); err != nil {
+			return "", err
+		}
+		excluded[e] = true
+	}
+
+	var found string
+	for d := dir; ; {
+		if p := filepath.Join(d, ProjectFile); found == "" && !excluded[p] && isFile(p) {
+			if d == dir {
+				return p, nil
+			}
+			found = p
+		}
+		if _, err := os.Stat(filepath.Join(d, ".git")); err == nil {
+			return found, nil
+		}
+
+		parent := filepath.Dir(d)
+		if parent == d {
+			// Not in a git repository.
+			return "", nil
+		}
+		d = parent
+	}
+}
+
+func isFile(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.Mode().IsRegular()
+}
+
+// LoadProject reads the project config file at path. It may contain the name of the selected app
+// in app and app definitions in apps. An error is returned for any other setting, e.g. secrets,
+// so that they are never read from a project config.
+func LoadProject(path string) (*Project, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	v.SetConfigType("yaml")
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("reading project config %s: %v", path, err)
+	}
+
+	p := Project{Path: path, App: v.GetString("app"), Apps: make(map[string]map[string]interface{})}
+	for _, k := range v.AllKeys() {
+		if k == "app" {
+			continue
+		}
+
+		parts := strings.SplitN(k, ".", 3)
+		// Map settings, e.g. roles, have keys below the setting.
+		if len(parts) < 3 || parts[0] != "apps" || !contains(projectAppSettings, strings.SplitN(parts[2], ".", 2)[0]) {
+			return nil, fmt.Errorf("project config %s: setting %s isn't allowed in a project config", path, k)
+		}
+
+		setting := strings.SplitN(parts[2], ".", 2)[0]
+		if p.Apps[parts[1]] == nil {
+			p.Apps[parts[1]] = make(map[string]interface{})
+		}
+		p.Apps[parts[1]][setting] = v.Get(fmt.Sprintf("apps.%s.%s", parts[1], setting))
+	}
+
+	return &p, nil
+}
+
+// ApplyProject merges p into the config, overriding the settings of the global config file. Flags
+// and environment variables still take precedence. An error is returned if p overrides any of the
+// protectedAppSettings of an app defined in the global config.
+func ApplyProject(p *Project) error {
+	for name, settings := range p.Apps {
+		if !viper.IsSet("apps." + name) {
+			continue
+		}
+		for setting := range settings {
+			if contains(protectedAppSettings, setting) {
+				return fmt.Errorf("project config %s: setting apps.%s.%s can't override the app of the global config", p.Path, name, setting)
+			}
+		}
+	}
+
+	cfg := make(map[string]interface{})
+	if p.App != "" {
+		cfg["global"] = map[string]interface{}{"selected-app": p.App}
+	}
+	if len(p.Apps) > 0 {
+		apps := make(map[string]interface{})
+		for name, settings := range p.Apps {
+			apps[name] = settings
+		}
+		cfg["apps"] = apps
+	}
+
+	return viper.MergeConfigMap(cfg)
+}
+
+// Update sets the given settings and writes them to the config file. Only the settings of the
+// config file itself are written, not those merged from a project config, flags or defaults.
+func Update(settings map[string]interface{}) error {
+	path := viper.ConfigFileUsed()
+	v := viper.New()
+	v.SetConfigFile(path)
+	if filepath.Ext(path) == "" {
+		v.SetConfigType("yaml")
+	}
+	if err := v.ReadInConfig(); err != nil {
+		return err
+	}
+
+	for k, value := range settings {
+		viper.Set(k, value)
+		v.Set(k, value)
+	}
+
+	return v.WriteConfig()
+}
@@ -0,0 +1,240 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFindProject(t *testing.T) {
+	root, err := ioutil.TempDir("", "clisso-project")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	// root/.clisso.yaml is outside of the repository at root/repo.
+	writeFile(t, filepath.Join(root, ProjectFile), "app: outside\n")
+	repo := filepath.Join(root, "repo")
+	if err = os.MkdirAll(filepath.Join(repo, ".git"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(repo, ProjectFile), "app: repo\n")
+	writeFile(t, filepath.Join(repo, "service", ProjectFile), "app: service\n")
+	if err = os.MkdirAll(filepath.Join(repo, "service", "src", "pkg"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.MkdirAll(filepath.Join(repo, "docs"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.MkdirAll(filepath.Join(root, "other", "sub"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		dir     string
+		exclude []string
+		expect  string
+	}{
+		{name: "repo root", dir: repo, expect: filepath.Join(repo, ProjectFile)},
+		{name: "closest parent", dir: filepath.Join(repo, "service", "src", "pkg"), expect: filepath.Join(repo, "service", ProjectFile)},
+		{name: "parent up to repo root", dir: filepath.Join(repo, "docs"), expect: filepath.Join(repo, ProjectFile)},
+		{name: "excluded", dir: filepath.Join(repo, "service"), exclude: []string{filepath.Join(repo, "service", ProjectFile)}, expect: filepath.Join(repo, ProjectFile)},
+		{name: "current dir outside repo", dir: root, expect: filepath.Join(root, ProjectFile)},
+		{name: "parent outside repo", dir: filepath.Join(root, "other", "sub")},
+		{name: "global config outside repo", dir: root, exclude: []string{filepath.Join(root, ProjectFile)}},
+		{name: "default global config", dir: root, exclude: []string{filepath.Join(root, "other.yaml"), filepath.Join(root, ProjectFile)}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path, err := FindProject(tc.dir, tc.exclude...)
+			if err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+			if path != tc.expect {
+				t.Errorf("expected %q, received %q", tc.expect, path)
+			}
+		})
+	}
+}
+
+func TestLoadProject(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clisso-project")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tc := range []struct {
+		name        string
+		content     string
+		expectError string
+	}{
+		{name: "app only", content: "app: my-app\n"},
+		{name: "app settings", content: "app: my-app\napps:\n  my-app:\n    provider: my-provider\n    app-id: \"12345\"\n    roles:\n      dev: arn:aws:iam::123456789012:role/Dev\n"},
+		{name: "secret", content: "providers:\n  my-provider:\n    client-secret: secret\n", expectError: "providers.my-provider.client-secret"},
+		{name: "global", content: "global:\n  credentials-path: /tmp/creds\n", expectError: "global.credentials-path"},
+		{name: "post hook", content: "apps:\n  my-app:\n    post-hook: rm -rf /\n", expectError: "apps.my-app.post-hook"},
+		{name: "url", content: "apps:\n  my-app:\n    url: https://example.com/app\n", expectError: "apps.my-app.url"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(dir, strings.Replace(tc.name, " ", "-", -1), ProjectFile)
+			writeFile(t, path, tc.content)
+
+			p, err := LoadProject(path)
+			if tc.expectError != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectError) {
+					t.Fatalf("expected error for %s, received %v", tc.expectError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+			if p.App != "my-app" {
+				t.Errorf("expected app my-app, received %q", p.App)
+			}
+		})
+	}
+}
+
+func TestApplyProject(t *testing.T) {
+	defer viper.Reset()
+	viper.Reset()
+
+	global := filepath.Join("testdata", "global.yaml")
+	viper.SetConfigFile(global)
+	if err := viper.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := LoadProject(filepath.Join("testdata", "project.yaml"))
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	if err = ApplyProject(p); err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+
+	for _, tc := range []struct {
+		key    string
+		expect string
+	}{
+		// The project selects the app and overrides its settings.
+		{key: "global.selected-app", expect: "project-app"},
+		{key: "apps.global-app.duration", expect: "7200"},
+		// Settings the project doesn't define are kept.
+		{key: "apps.global-app.provider", expect: "global-provider"},
+		{key: "apps.global-app.url", expect: "https://example.okta.com/app"},
+		{key: "apps.other-app.provider", expect: "global-provider"},
+		// Apps may be defined by the project.
+		{key: "apps.project-app.provider", expect: "global-provider"},
+		{key: "apps.project-app.app-id", expect: "12345"},
+		{key: "providers.global-provider.type", expect: "okta"},
+	} {
+		if v := viper.GetString(tc.key); v != tc.expect {
+			t.Errorf("%s: expected %q, received %q", tc.key, tc.expect, v)
+		}
+	}
+	if apps := viper.GetStringMap("apps"); len(apps) != 3 {
+		t.Errorf("expected 3 apps, received %v", apps)
+	}
+
+	// Flags and settings changed at runtime still take precedence.
+	viper.Set("global.selected-app", "other-app")
+	if app := viper.GetString("global.selected-app"); app != "other-app" {
+		t.Errorf("expected other-app, received %q", app)
+	}
+}
+
+func TestApplyProjectProtectedSettings(t *testing.T) {
+	defer viper.Reset()
+
+	for _, setting := range protectedAppSettings {
+		t.Run(setting, func(t *testing.T) {
+			viper.Reset()
+			viper.SetConfigFile(filepath.Join("testdata", "global.yaml"))
+			if err := viper.ReadInConfig(); err != nil {
+				t.Fatal(err)
+			}
+
+			// The setting may be defined for an app of the project...
+			p := &Project{Apps: map[string]map[string]interface{}{"project-app": {setting: "value"}}}
+			if err := ApplyProject(p); err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+
+			// ...but not override an app of the global config.
+			p = &Project{Apps: map[string]map[string]interface{}{"global-app": {setting: "value"}}}
+			if err := ApplyProject(p); err == nil || !strings.Contains(err.Error(), "apps.global-app."+setting) {
+				t.Errorf("expected error for apps.global-app.%s, received %v", setting, err)
+			}
+		})
+	}
+}
+
+func TestUpdateSkipsProject(t *testing.T) {
+	defer viper.Reset()
+	viper.Reset()
+
+	dir, err := ioutil.TempDir("", "clisso-project")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	b, err := ioutil.ReadFile(filepath.Join("testdata", "global.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	global := filepath.Join(dir, "global.yaml")
+	writeFile(t, global, string(b))
+	viper.SetConfigFile(global)
+	if err = viper.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := LoadProject(filepath.Join("testdata", "project.yaml"))
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	if err = ApplyProject(p); err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+
+	if err = Update(map[string]interface{}{"apps.other-app.duration": 7200}); err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	if d := viper.GetInt("apps.other-app.duration"); d != 7200 {
+		t.Errorf("expected the updated duration, received %d", d)
+	}
+
+	written := viper.New()
+	written.SetConfigFile(global)
+	if err = written.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if d := written.GetInt("apps.other-app.duration"); d != 7200 {
+		t.Errorf("expected the updated duration to be written, received %d", d)
+	}
+	if app := written.GetString("global.selected-app"); app != "global-app" {
+		t.Errorf("expected the global selected app to be kept, received %q", app)
+	}
+	if written.IsSet("apps.project-app") {
+		t.Error("expected the project app not to be written")
+	}
+}
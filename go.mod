@@ -19,6 +19,7 @@ require (
 	github.com/spf13/viper v1.7.1
 	github.com/zalando/go-keyring v0.1.1
 	golang.org/x/net v0.0.0-20201110031124-69a78807bb2b
+	golang.org/x/sys v0.0.0-20200930185726-fdedc70b468f
 	golang.org/x/term v0.0.0-20201117132131-f5c789dd3221
 	gopkg.in/ini.v1 v1.62.0 // indirect
 )
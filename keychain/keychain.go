@@ -1,17 +1,43 @@
 package keychain
 
 import (
+	"errors"
 	"fmt"
-	"syscall"
+	"log"
+	"time"
 
+	"github.com/fatih/color"
+	"github.com/spf13/viper"
 	keyring "github.com/zalando/go-keyring"
-	"golang.org/x/term"
+
+	"github.com/allcloud-io/clisso/prompt"
 )
 
 const (
 	// KeyChainName is the name of the keychain used to store
 	// passwords
 	KeyChainName = "clisso"
+
+	// TOTPKeyChainName is the name of the keychain used to store
+	// TOTP keys
+	TOTPKeyChainName = "clisso-totp"
+)
+
+// errDisabled is returned when reading from the keychain is disabled using global.no-keyring.
+var errDisabled = errors.New("keychain is disabled")
+
+// timeout is the time to wait for the keychain to respond. A locked keychain, e.g. the Secret
+// Service of a locked session on Linux, may otherwise block indefinitely.
+var timeout = 5 * time.Second
+
+// prompted holds the passwords entered by the user, keyed by provider, so that the user is asked
+// only once per provider when getting credentials for several apps.
+var prompted = make(map[string][]byte)
+
+// These are replaced in tests.
+var (
+	keyringGet     = keyring.Get
+	promptPassword = prompt.Password
 )
 
 // Keychain provides an interface to allow for the easy testing
@@ -33,28 +59,83 @@ func (DefaultKeychain) Set(provider string, password []byte) (err error) {
 
 // Get will, once given a valid provider, return the password associated
 // in order for logins to happen.
-// If any error occours while talking to the keychain provider, we silently swallow it
-// and just ask the user for the password instead. Error could be anything from access denied to
-// password not found.
+// If the password can't be read from the keychain we ask the user for the password instead. A
+// warning is printed unless the password isn't stored or the keychain is disabled, since other
+// errors (e.g. a locked or unavailable keychain) may surprise the user.
 func (DefaultKeychain) Get(provider string) (pw []byte, err error) {
+	if pass, ok := prompted[provider]; ok {
+		return pass, nil
+	}
+
 	pass, err := get(provider)
 	if err != nil {
-		// If we ever implement a logfile we might want to log what error occurred.
-		fmt.Printf("Please enter %s password: ", provider)
-		pass, err = term.ReadPassword(int(syscall.Stdin))
+		if err != keyring.ErrNotFound && err != errDisabled && !viper.GetBool("global.quiet") {
+			log.Printf(color.YellowString("Could not read password from keychain: %v"), err)
+		}
+		pass, err = promptPassword(
+			fmt.Sprintf("password for provider '%s'", provider),
+			fmt.Sprintf("Please enter %s password: ", provider),
+		)
 		if err != nil {
-			return nil, fmt.Errorf("couldn't read password from terminal: %w", err)
+			return nil, err
 		}
+		prompted[provider] = pass
 	}
 	return pass, nil
 }
 
+// UserKey returns the key of the password of user at provider, which is used instead of the
+// provider for apps which override the provider's username, so that each user has its own password.
+func UserKey(provider, user string) string {
+	return provider + ":" + user
+}
+
+// SetTOTPKey stores a TOTP key (a provisioning URI or a base32 secret)
+// for a provider in the keychain.
+func SetTOTPKey(provider, key string) error {
+	return keyring.Set(TOTPKeyChainName, provider, key)
+}
+
+// GetTOTPKey returns the TOTP key stored for a provider. Unlike Get, it
+// never prompts: an error is returned if no key is stored.
+func GetTOTPKey(provider string) (string, error) {
+	return lookup(TOTPKeyChainName, provider)
+}
+
+// lookup reads a secret from the keychain, giving up if the keychain doesn't respond within the
+// timeout.
+func lookup(service, provider string) (string, error) {
+	if viper.GetBool("global.no-keyring") {
+		return "", errDisabled
+	}
+
+	type result struct {
+		secret string
+		err    error
+	}
+	c := make(chan result, 1)
+	// The goroutine may outlive the call if the keychain doesn't respond, so it must not read
+	// keyringGet once it runs.
+	get := keyringGet
+	go func() {
+		secret, err := get(service, provider)
+		c <- result{secret, err}
+	}()
+
+	select {
+	case r := <-c:
+		return r.secret, r.err
+	case <-time.After(timeout):
+		return "", fmt.Errorf("keychain didn't respond within %v, it may be locked", timeout)
+	}
+}
+
 func set(provider string, password []byte) (err error) {
 	return keyring.Set(KeyChainName, provider, string(password))
 }
 
 func get(provider string) (pw []byte, err error) {
-	pwString, err := keyring.Get(KeyChainName, provider)
+	pwString, err := lookup(KeyChainName, provider)
 	pw = []byte(pwString)
 	return
 }
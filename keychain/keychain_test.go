@@ -0,0 +1,89 @@
+package keychain
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	keyring "github.com/zalando/go-keyring"
+
+	"github.com/allcloud-io/clisso/prompt"
+)
+
+func TestGetFallback(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	defer func() { keyringGet, promptPassword, timeout = keyring.Get, prompt.Password, 5*time.Second }()
+	defer viper.Set("global.no-keyring", false)
+
+	timeout = 50 * time.Millisecond
+	block := make(chan struct{})
+	defer close(block)
+
+	for _, test := range []struct {
+		name         string
+		noKeyring    bool
+		get          func(string, string) (string, error)
+		expect       string
+		expectPrompt bool
+		expectWarn   bool
+	}{
+		{"Stored password", false, func(string, string) (string, error) { return "stored", nil }, "stored", false, false},
+		{"Not stored", false, func(string, string) (string, error) { return "", keyring.ErrNotFound }, "prompted", true, false},
+		{"Keyring error", false, func(string, string) (string, error) { return "", errors.New("secret service is locked") }, "prompted", true, true},
+		{"Keyring hangs", false, func(string, string) (string, error) { <-block; return "stored", nil }, "prompted", true, true},
+		{"Keyring disabled", true, func(string, string) (string, error) { return "stored", nil }, "prompted", true, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			buf.Reset()
+			prompted = make(map[string][]byte)
+			viper.Set("global.no-keyring", test.noKeyring)
+			keyringGet = test.get
+			promptCalled := false
+			promptPassword = func(input, message string) ([]byte, error) {
+				promptCalled = true
+				return []byte("prompted"), nil
+			}
+
+			pw, err := DefaultKeychain{}.Get("test")
+			if err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+			if string(pw) != test.expect {
+				t.Errorf("expected %q, received %q", test.expect, pw)
+			}
+			if promptCalled != test.expectPrompt {
+				t.Errorf("expected prompt: %v, received: %v", test.expectPrompt, promptCalled)
+			}
+			if warned := buf.Len() > 0; warned != test.expectWarn {
+				t.Errorf("expected warning: %v, received: %q", test.expectWarn, buf.String())
+			}
+		})
+	}
+}
+
+func TestGetPromptsOnce(t *testing.T) {
+	defer func() { keyringGet, promptPassword = keyring.Get, prompt.Password }()
+	prompted = make(map[string][]byte)
+
+	keyringGet = func(string, string) (string, error) { return "", keyring.ErrNotFound }
+	prompts := 0
+	promptPassword = func(input, message string) ([]byte, error) {
+		prompts++
+		return []byte("prompted"), nil
+	}
+
+	for _, provider := range []string{"first", "first", "second"} {
+		if _, err := (DefaultKeychain{}).Get(provider); err != nil {
+			t.Fatalf("unexpected error %+v", err)
+		}
+	}
+	if prompts != 2 {
+		t.Errorf("expected a single prompt per provider, received %d prompts", prompts)
+	}
+}
@@ -0,0 +1,55 @@
+// Package lock provides advisory file locks which prevent concurrent clisso runs from interfering
+// with each other.
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ErrLocked is returned by Acquire when the lock is held by another process.
+var ErrLocked = errors.New("lock is held by another process")
+
+// pollInterval is the time between attempts to acquire a lock which is held by another process.
+const pollInterval = 100 * time.Millisecond
+
+// Lock is an advisory lock on a file. The lock is released when the process exits, even if
+// Release isn't called.
+type Lock struct {
+	f *os.File
+}
+
+// Acquire locks the file at path, creating it if needed. If the lock is held by another process,
+// Acquire retries for up to wait before returning ErrLocked. A zero wait fails immediately.
+func Acquire(path string, wait time.Duration) (*Lock, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("opening lock file: %v", err)
+	}
+
+	deadline := time.Now().Add(wait)
+	for {
+		err = tryLock(f)
+		if err == nil {
+			return &Lock{f: f}, nil
+		}
+		if err != ErrLocked || !time.Now().Before(deadline) {
+			f.Close()
+			return nil, err
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// Release releases the lock. The lock file is left in place since removing it would allow another
+// process to lock a new file while a third one still waits on the old one.
+func (l *Lock) Release() error {
+	if err := unlock(l.f); err != nil {
+		l.f.Close()
+		return fmt.Errorf("releasing lock: %v", err)
+	}
+
+	return l.f.Close()
+}
@@ -0,0 +1,79 @@
+package lock
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAcquire(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clisso-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.lock")
+
+	l, err := Acquire(path, 0)
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+
+	// A second run fails fast while the lock is held.
+	if _, err := Acquire(path, 0); err != ErrLocked {
+		t.Fatalf("expected %v, received %v", ErrLocked, err)
+	}
+
+	// A second run which waits gets the lock once the first one releases it.
+	acquired := make(chan error)
+	go func() {
+		l2, err := Acquire(path, 5*time.Second)
+		if err == nil {
+			err = l2.Release()
+		}
+		acquired <- err
+	}()
+
+	select {
+	case err := <-acquired:
+		t.Fatalf("lock acquired while held (error %v)", err)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	if err := l.Release(); err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Errorf("unexpected error %+v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("lock not acquired after release")
+	}
+}
+
+func TestAcquireTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clisso-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.lock")
+
+	l, err := Acquire(path, 0)
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	defer l.Release()
+
+	start := time.Now()
+	if _, err := Acquire(path, 200*time.Millisecond); err != ErrLocked {
+		t.Errorf("expected %v, received %v", ErrLocked, err)
+	}
+	if time.Since(start) < 200*time.Millisecond {
+		t.Errorf("gave up before the wait time elapsed")
+	}
+}
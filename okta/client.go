@@ -133,10 +133,14 @@ type LaunchAppParams struct {
 	URL          string
 }
 
-// LaunchApp launches an Okta app and returns a SAML assertion.
+// LaunchApp launches an Okta app and returns a SAML assertion. Without a session token, the
+// session established by a previous launch (kept in the client's cookie jar) is used.
 // TODO Error handling
 func (c *Client) LaunchApp(p *LaunchAppParams) (*string, error) {
-	url := fmt.Sprintf("%s?sessionToken=%s", p.URL, p.SessionToken)
+	url := p.URL
+	if p.SessionToken != "" {
+		url = fmt.Sprintf("%s?sessionToken=%s", p.URL, p.SessionToken)
+	}
 	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("constructing HTTP request: %v", err)
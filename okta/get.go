@@ -2,15 +2,13 @@ package okta
 
 import (
 	"fmt"
-	"log"
 	"time"
 
-	"github.com/allcloud-io/clisso/aws"
 	"github.com/allcloud-io/clisso/config"
 	"github.com/allcloud-io/clisso/keychain"
-	"github.com/allcloud-io/clisso/saml"
+	"github.com/allcloud-io/clisso/prompt"
 	"github.com/allcloud-io/clisso/spinner"
-	"github.com/fatih/color"
+	"github.com/allcloud-io/clisso/totp"
 )
 
 const (
@@ -25,37 +23,93 @@ var (
 	keyChain = keychain.DefaultKeychain{}
 )
 
-// Get gets temporary credentials for the given app.
-func Get(app, provider, pArn string, duration int64) (*aws.Credentials, error) {
+// GetSAMLAssertion authenticates against Okta and returns a SAML assertion for the given app.
+func GetSAMLAssertion(app, provider string) (string, error) {
+	s, err := NewSession(provider)
+	if err != nil {
+		return "", err
+	}
+
+	return s.SAMLAssertion(app)
+}
+
+// Session is a session at an Okta provider, which launches several of the provider's apps after
+// authenticating, including MFA, once.
+type Session struct {
+	provider string
+	p        *config.OktaProviderConfig
+	c        *Client
+	// user is the user the session is authenticated as, empty before authenticating.
+	user string
+	// sessionToken is the one-time token used to launch the first app, which establishes the
+	// session in the client's cookie jar.
+	sessionToken string
+}
+
+// NewSession returns a session at the given Okta provider. The user is authenticated when the first
+// app is launched.
+func NewSession(provider string) (*Session, error) {
 	// Get provider config
 	p, err := config.GetOktaProvider(provider)
 	if err != nil {
 		return nil, fmt.Errorf("reading provider config: %v", err)
 	}
 
+	// Initialize Okta client
+	c, err := NewClient(p.BaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("initializing Okta client: %v", err)
+	}
+
+	return &Session{provider: provider, p: p, c: c}, nil
+}
+
+// Close ends the use of the session.
+func (sess *Session) Close() {}
+
+// SAMLAssertion returns a SAML assertion for the given app, authenticating first unless the
+// session already is. All apps launched using a session have to use the same username.
+func (sess *Session) SAMLAssertion(app string) (string, error) {
 	// Get app config
 	a, err := config.GetOktaApp(app)
 	if err != nil {
-		return nil, fmt.Errorf("reading config for app %s: %v", app, err)
+		return "", fmt.Errorf("reading config for app %s: %v", app, err)
 	}
 
-	// Initialize Okta client
-	c, err := NewClient(p.BaseURL)
+	if sess.user != "" {
+		if a.Username != "" && a.Username != sess.user {
+			return "", fmt.Errorf("app %s uses username %s, but the session is authenticated as %s", app, a.Username, sess.user)
+		}
+	} else if err = sess.authenticate(a); err != nil {
+		return "", err
+	}
+
+	// Launch Okta app with session token, which can only be used once.
+	var s = spinner.New()
+	s.Start()
+	samlAssertion, err := sess.c.LaunchApp(&LaunchAppParams{SessionToken: sess.sessionToken, URL: a.URL})
+	s.Stop()
+	sess.sessionToken = ""
 	if err != nil {
-		return nil, fmt.Errorf("initializing Okta client: %v", err)
+		return "", fmt.Errorf("Error launching app: %v", err)
 	}
 
+	return *samlAssertion, nil
+}
+
+// authenticate authenticates the user of app, verifying MFA if required.
+func (sess *Session) authenticate(a *config.OktaAppConfig) error {
+	c, p, provider := sess.c, sess.p, sess.provider
+
 	// Get user credentials
-	user := p.Username
-	if user == "" {
-		// Get credentials from the user
-		fmt.Print("Okta username: ")
-		fmt.Scanln(&user)
+	user, err := username(a, p)
+	if err != nil {
+		return err
 	}
 
-	pass, err := keyChain.Get(provider)
+	pass, err := keyChain.Get(passwordKey(a, provider))
 	if err != nil {
-		return nil, fmt.Errorf("getting key chain: %v", err)
+		return fmt.Errorf("getting key chain: %v", err)
 	}
 
 	// Initialize spinner
@@ -69,7 +123,7 @@ func Get(app, provider, pArn string, duration int64) (*aws.Credentials, error) {
 	})
 	s.Stop()
 	if err != nil {
-		return nil, fmt.Errorf("getting session token: %v", err)
+		return fmt.Errorf("getting session token: %v", err)
 	}
 
 	var st string
@@ -97,7 +151,7 @@ func Get(app, provider, pArn string, duration int64) (*aws.Credentials, error) {
 				StateToken: stateToken,
 			})
 			if err != nil {
-				return nil, fmt.Errorf("verifying MFA: %v", err)
+				return fmt.Errorf("verifying MFA: %v", err)
 			}
 
 			for vfResp.FactorResult == VerifyFactorStatusWaiting {
@@ -109,9 +163,11 @@ func Get(app, provider, pArn string, duration int64) (*aws.Credentials, error) {
 			}
 			s.Stop()
 		case MFATypeTOTP:
-			fmt.Print("Please enter the OTP from your MFA device: ")
 			var otp string
-			fmt.Scanln(&otp)
+			otp, err = totp.OTP(provider)
+			if err != nil {
+				return err
+			}
 
 			s.Start()
 			vfResp, err = c.VerifyFactor(&VerifyFactorParams{
@@ -121,48 +177,48 @@ func Get(app, provider, pArn string, duration int64) (*aws.Credentials, error) {
 			})
 			s.Stop()
 		default:
-			return nil, fmt.Errorf("unsupported MFA type '%s'", factor.FactorType)
+			return fmt.Errorf("unsupported MFA type '%s'", factor.FactorType)
 		}
 
 		if err != nil {
-			return nil, fmt.Errorf("verifying MFA: %v", err)
+			return fmt.Errorf("verifying MFA: %v", err)
 		}
 
 		// Handle failed MFA verification (verification rejected or timed out)
 		if vfResp.Status != VerifyFactorStatusSuccess {
-			return nil, fmt.Errorf("MFA verification failed")
+			return fmt.Errorf("MFA verification failed")
 		}
 
 		st = vfResp.SessionToken
 	default:
-		return nil, fmt.Errorf("Invalid status %s", resp.Status)
+		return fmt.Errorf("Invalid status %s", resp.Status)
 	}
 
-	// Launch Okta app with session token
-	s.Start()
-	samlAssertion, err := c.LaunchApp(&LaunchAppParams{SessionToken: st, URL: a.URL})
-	s.Stop()
-	if err != nil {
-		return nil, fmt.Errorf("Error launching app: %v", err)
-	}
+	sess.user = user
+	sess.sessionToken = st
 
-	arn, err := saml.Get(*samlAssertion, pArn)
-	if err != nil {
-		return nil, err
+	return nil
+}
+
+// passwordKey returns the keychain key of the password for app a of provider: the provider, unless
+// the app overrides the provider's username.
+func passwordKey(a *config.OktaAppConfig, provider string) string {
+	if a.Username != "" {
+		return keychain.UserKey(provider, a.Username)
 	}
 
-	s.Start()
-	creds, err := aws.AssumeSAMLRole(arn.Provider, arn.Role, *samlAssertion, duration)
-	s.Stop()
+	return provider
+}
 
-	if err != nil {
-		if err.Error() == aws.ErrDurationExceeded {
-			log.Println(color.YellowString(aws.DurationExceededMessage))
-			s.Start()
-			creds, err = aws.AssumeSAMLRole(arn.Provider, arn.Role, *samlAssertion, 3600)
-			s.Stop()
-		}
+// username returns the Okta username to authenticate with: the app's username if set, otherwise the
+// provider's. If neither is configured, the user is prompted for it.
+func username(a *config.OktaAppConfig, p *config.OktaProviderConfig) (string, error) {
+	if a.Username != "" {
+		return a.Username, nil
+	}
+	if p.Username != "" {
+		return p.Username, nil
 	}
 
-	return creds, err
+	return prompt.Line("Okta username", "Okta username: ")
 }
@@ -0,0 +1,56 @@
+package okta
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/allcloud-io/clisso/config"
+	"github.com/allcloud-io/clisso/keychain"
+)
+
+func TestUsername(t *testing.T) {
+	viper.Set("global.non-interactive", true)
+	defer viper.Set("global.non-interactive", false)
+
+	for _, test := range []struct {
+		name        string
+		app         string
+		provider    string
+		expect      string
+		expectError bool
+	}{
+		{"App overrides provider", "app-user@mycompany.com", "user@mycompany.com", "app-user@mycompany.com", false},
+		{"Provider", "", "user@mycompany.com", "user@mycompany.com", false},
+		{"App only", "app-user@mycompany.com", "", "app-user@mycompany.com", false},
+		// The user would be prompted, which is disabled.
+		{"Neither", "", "", "", true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			user, err := username(&config.OktaAppConfig{Username: test.app}, &config.OktaProviderConfig{Username: test.provider})
+			if test.expectError {
+				if err == nil || !strings.Contains(err.Error(), "Okta username") {
+					t.Errorf("expected prompt error, received %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+			if user != test.expect {
+				t.Errorf("expected %q, received %q", test.expect, user)
+			}
+		})
+	}
+}
+
+func TestPasswordKey(t *testing.T) {
+	if key := passwordKey(&config.OktaAppConfig{}, "my-provider"); key != "my-provider" {
+		t.Errorf("expected %q, received %q", "my-provider", key)
+	}
+	expect := keychain.UserKey("my-provider", "app-user@mycompany.com")
+	if key := passwordKey(&config.OktaAppConfig{Username: "app-user@mycompany.com"}, "my-provider"); key != expect {
+		t.Errorf("expected %q, received %q", expect, key)
+	}
+}
@@ -2,20 +2,77 @@ package onelogin
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math"
+	"net"
 	"net/http"
+	"strings"
 	"time"
+
+	"golang.org/x/net/http/httpguts"
 )
 
+// networks maps the supported IP version preferences to the network used when dialing OneLogin.
+var networks = map[string]string{
+	"":  "tcp",
+	"4": "tcp4",
+	"6": "tcp6",
+}
+
+// UserAgent is the default User-Agent header of OneLogin requests. It is set to include the version
+// of clisso on startup.
+var UserAgent = "clisso"
+
 // Client represents a OneLogin API client.
 type Client struct {
 	http.Client
 	Endpoints Endpoints
+
+	// network is the network passed to dial when connecting to OneLogin.
+	network string
+	dial    func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// headers are sent with every request in addition to the headers required by the API.
+	headers   http.Header
+	userAgent string
+
+	// attempts is the number of times a request is tried before giving up. Requests are retried
+	// after network errors and server-side (5xx) or rate limiting (429) responses.
+	attempts int
+	backoff  time.Duration
+	// maintenanceBackoff replaces backoff while OneLogin reports maintenance, which usually lasts
+	// longer than transient failures.
+	maintenanceBackoff time.Duration
+	// onAttempt is called before every attempt of a request.
+	onAttempt func(attempt, attempts int)
+
+	// requestTimeout limits a single attempt of a request. An attempt which times out is retried.
+	requestTimeout time.Duration
+	// timeout limits a request including all of its attempts and the backoff between them.
+	timeout time.Duration
+	// dialTimeout limits establishing a connection to OneLogin.
+	dialTimeout time.Duration
+	// limiter limits the rate of requests, including retries. Requests aren't limited if nil.
+	limiter *rateLimiter
 }
 
+const (
+	// defaultDialTimeout and defaultTLSHandshakeTimeout are short enough for hung connections to
+	// fail fast and be retried.
+	defaultDialTimeout         = 10 * time.Second
+	defaultTLSHandshakeTimeout = 10 * time.Second
+
+	// DefaultRateLimit is the default maximum number of requests per second sent to OneLogin. It
+	// is generous enough not to slow down a single run, while keeping batch runs from hammering
+	// the API.
+	DefaultRateLimit = 10
+)
+
 type GenerateTokensParams struct {
 	GrantType string `json:"grant_type"`
 }
@@ -49,7 +106,31 @@ type GenerateSamlAssertionResponse struct {
 		Firstname string `json:"firstname"`
 		ID        int    `json:"id"`
 	}
-	Data string `json:"data"`
+	Data SAMLData `json:"data"`
+}
+
+// SAMLData holds the SAML assertions returned by OneLogin. The API usually returns a single
+// assertion as a string, however some responses contain an array of assertions.
+type SAMLData []string
+
+// UnmarshalJSON accepts a string or an array of strings. An empty string results in no assertions.
+func (d *SAMLData) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*d = nil
+		if s != "" {
+			*d = SAMLData{s}
+		}
+		return nil
+	}
+
+	var a []string
+	if err := json.Unmarshal(b, &a); err != nil {
+		return fmt.Errorf("SAML data must be a string or an array of strings: %v", err)
+	}
+	*d = a
+
+	return nil
 }
 
 type VerifyFactorParams struct {
@@ -61,8 +142,11 @@ type VerifyFactorParams struct {
 }
 
 type VerifyFactorResponse struct {
-	Message string `json:"message"`
-	Data    string `json:"data"`
+	Message string   `json:"message"`
+	Data    SAMLData `json:"data"`
+	// MatchNumber is the number the user has to select on the device when approving a push on
+	// tenants which use number matching. It is zero otherwise.
+	MatchNumber int `json:"match_number"`
 }
 
 type GetUserByEmailResponse struct {
@@ -80,6 +164,84 @@ type GetUserByEmailResponse struct {
 type Device struct {
 	DeviceID   int    `json:"device_id"`
 	DeviceType string `json:"device_type"`
+	// Factor is the factor the user chose to verify a device supporting several factors with, if
+	// any. It isn't part of the OneLogin API.
+	Factor string `json:"-"`
+}
+
+// ErrInvalidClient is returned by GenerateTokens when OneLogin rejects the API credentials.
+var ErrInvalidClient = errors.New(
+	"OneLogin API credentials (ClientID/ClientSecret) are invalid or expired - rotate them in the OneLogin admin console",
+)
+
+// ErrProviderUnavailable is returned when OneLogin is down for maintenance.
+var ErrProviderUnavailable = errors.New("OneLogin is temporarily unavailable (maintenance); try again shortly")
+
+// HTTPError is returned when OneLogin responds with a status other than 200 OK.
+type HTTPError struct {
+	StatusCode int
+	Status     string
+	// Body is the body of the response, which may describe the error.
+	Body string
+}
+
+func (e *HTTPError) Error() string {
+	return e.Status
+}
+
+// isInvalidClient reports whether the body of a 401 response to a token request says that the
+// client credentials were rejected. OAuth 2.0 endpoints report this as an invalid_client error,
+// the OneLogin v1 API with a 401 status code.
+func isInvalidClient(body string) bool {
+	var resp struct {
+		Error  string `json:"error"`
+		Status struct {
+			Code int `json:"code"`
+		} `json:"status"`
+	}
+	if err := json.Unmarshal([]byte(body), &resp); err != nil {
+		return false
+	}
+
+	return resp.Error == "invalid_client" || resp.Status.Code == http.StatusUnauthorized
+}
+
+// isMaintenance reports whether a response says that OneLogin is down for maintenance, i.e. it is a
+// 503 response which asks to retry later or mentions maintenance.
+func isMaintenance(resp *http.Response, body []byte) bool {
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		return false
+	}
+
+	return resp.Header.Get("Retry-After") != "" || bytes.Contains(bytes.ToLower(body), []byte("maintenance"))
+}
+
+// requestError describes an error returned by doRequest. ErrProviderUnavailable is returned as is,
+// so that callers can tell it apart from other failures.
+func requestError(err error) error {
+	if err == ErrProviderUnavailable {
+		return err
+	}
+
+	return fmt.Errorf("doing HTTP request: %w", err)
+}
+
+// IsTransient reports whether err, returned by a request to OneLogin, is likely caused by a
+// temporary problem of OneLogin or the network: maintenance, a server-side (5xx) or rate limiting
+// (429) response or a network error. Responses which reject the request, e.g. because of invalid
+// credentials, aren't transient.
+func IsTransient(err error) bool {
+	if errors.Is(err, ErrProviderUnavailable) {
+		return true
+	}
+
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode == http.StatusTooManyRequests || httpErr.StatusCode >= 500
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
 }
 
 // makeRequest constructs an HTTP request and returns a pointer to it.
@@ -102,31 +264,188 @@ func makeRequest(method string, url string, headers map[string]string, body inte
 	return req, nil
 }
 
+// notProcessed reports whether a failed attempt of a request provably wasn't processed by
+// OneLogin: connecting failed before the request was sent, or OneLogin rejected it because of rate
+// limiting or maintenance.
+func notProcessed(resp *http.Response, err error, maintenance bool) bool {
+	if err != nil {
+		return isDialError(err)
+	}
+
+	return resp.StatusCode == http.StatusTooManyRequests || maintenance
+}
+
+// retriedByClient reports whether err is a failure which doRequest retries even for requests which
+// aren't idempotent (see notProcessed), so that callers don't need to retry it again.
+func retriedByClient(err error) bool {
+	if errors.Is(err, ErrProviderUnavailable) {
+		return true
+	}
+
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode == http.StatusTooManyRequests
+	}
+
+	return isDialError(err)
+}
+
+// isDialError reports whether err is a failure to connect, before anything was sent.
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
 // doRequest gets a pointer to an HTTP request and an HTTP client, executes the request
 // using the client, handles any HTTP-related errors and returns any data as a string.
-func (c *Client) doRequest(r *http.Request) (string, error) {
-	resp, err := c.Do(r)
+// Transient failures are retried as configured on the client. Unless the request is idempotent,
+// it's only retried if OneLogin provably didn't process it, since e.g. verifying a factor again
+// would send another push notification.
+func (c *Client) doRequest(r *http.Request, idempotent bool) (string, error) {
+	if c.userAgent != "" {
+		r.Header.Set("User-Agent", c.userAgent)
+	}
+	for k, v := range c.headers {
+		r.Header[k] = v
+	}
+
+	attempts := c.attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	ctx := r.Context()
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	var resp *http.Response
+	// body is the body of the last failed response which was retried.
+	var body []byte
+	var err error
+	maintenance := false
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			backoff := c.backoff
+			if maintenance {
+				backoff = c.maintenanceBackoff
+			}
+			select {
+			case <-time.After(backoff * time.Duration(attempt-1)):
+			case <-ctx.Done():
+				if maintenance {
+					return "", ErrProviderUnavailable
+				}
+				return "", fmt.Errorf("sending HTTP request: timed out after %v", c.timeout)
+			}
+
+			// The body of the previous attempt has been consumed.
+			if r.GetBody != nil {
+				if r.Body, err = r.GetBody(); err != nil {
+					return "", fmt.Errorf("rewinding request body: %v", err)
+				}
+			}
+		}
+		if c.onAttempt != nil {
+			c.onAttempt(attempt, attempts)
+		}
+
+		if c.limiter != nil {
+			if err := c.limiter.wait(ctx); err != nil {
+				return "", fmt.Errorf("sending HTTP request: timed out after %v", c.timeout)
+			}
+		}
+		resp, err = c.attempt(ctx, r)
+		maintenance = false
+		if err == nil && resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+			break
+		}
+		if err != nil && ctx.Err() != nil {
+			// The overall timeout aborts the request, unlike the timeout of a single attempt.
+			return "", fmt.Errorf("sending HTTP request: timed out after %v", c.timeout)
+		}
+		if err == nil {
+			// The body is only used for describing the error, so failing to read it isn't fatal.
+			body, _ = ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			maintenance = isMaintenance(resp, body)
+		}
+		if !idempotent && !notProcessed(resp, err, maintenance) {
+			break
+		}
+	}
 	if err != nil {
-		return "", fmt.Errorf("sending HTTP request: %v", err)
+		return "", fmt.Errorf("sending HTTP request: %w", err)
+	}
+	if maintenance {
+		return "", ErrProviderUnavailable
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		// The body has been read already.
+		return "", &HTTPError{StatusCode: resp.StatusCode, Status: resp.Status, Body: string(body)}
 	}
 
+	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		return "", errors.New(resp.Status)
+		body, _ := ioutil.ReadAll(resp.Body)
+		return "", &HTTPError{StatusCode: resp.StatusCode, Status: resp.Status, Body: string(body)}
 	}
 
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+	body, err = ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return "", fmt.Errorf("error reading request body: %v", err)
 	}
-	b := []byte(body)
 
-	return string(b), nil
+	return string(body), nil
+}
+
+// attempt sends r once, limited by the client's request timeout. The timeout covers reading the
+// response body, which releases it when closed.
+func (c *Client) attempt(ctx context.Context, r *http.Request) (*http.Response, error) {
+	if c.requestTimeout <= 0 {
+		return c.Do(r.WithContext(ctx))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.requestTimeout)
+	resp, err := c.Do(r.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = cancelOnClose{resp.Body, cancel}
+
+	return resp, nil
+}
+
+// cancelOnClose cancels the context of a request when its response body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+
+	return err
 }
 
 // GenerateTokens generates the tokens required for interacting with the OneLogin
 // API.
 func (c *Client) GenerateTokens(clientID, clientSecret string) (string, error) {
+	resp, err := c.RequestToken(clientID, clientSecret)
+	if err != nil {
+		return "", err
+	}
+
+	return resp.AccessToken, nil
+}
+
+// RequestToken generates the tokens required for interacting with the OneLogin API like
+// GenerateTokens, but returns the complete response including the expiry of the access token.
+func (c *Client) RequestToken(clientID, clientSecret string) (*GenerateTokensResponse, error) {
 	headers := map[string]string{
 		"Authorization": fmt.Sprintf("client_id:%v, client_secret:%v", clientID, clientSecret),
 		"Content-Type":  "application/json",
@@ -135,22 +454,26 @@ func (c *Client) GenerateTokens(clientID, clientSecret string) (string, error) {
 
 	req, err := makeRequest(http.MethodPost, c.Endpoints.GenerateTokens(), headers, &body)
 	if err != nil {
-		return "", fmt.Errorf("creating request: %v", err)
+		return nil, fmt.Errorf("creating request: %v", err)
 	}
 
-	data, err := c.doRequest(req)
+	// Generating another access token is harmless.
+	data, err := c.doRequest(req, true)
+	if e, ok := err.(*HTTPError); ok && e.StatusCode == http.StatusUnauthorized && isInvalidClient(e.Body) {
+		return nil, ErrInvalidClient
+	}
 	if err != nil {
-		return "", fmt.Errorf("doing HTTP request: %v", err)
+		return nil, requestError(err)
 	}
 
 	var resp GenerateTokensResponse
 	if err := json.Unmarshal([]byte(data), &resp); err != nil {
-		return "", fmt.Errorf("parsing HTTP response: %v", err)
+		return nil, fmt.Errorf("parsing HTTP response: %v", err)
 	}
 
 	// TODO add handling for valid JSON with wrong response
 
-	return resp.AccessToken, nil
+	return &resp, nil
 }
 
 // GenerateSamlAssertion gets a OneLogin access token and a GenerateSamlAssertionParams struct
@@ -168,14 +491,14 @@ func (c *Client) GenerateSamlAssertion(token string, p *GenerateSamlAssertionPar
 		return nil, fmt.Errorf("creating request: %v", err)
 	}
 
-	data, err := c.doRequest(req)
+	data, err := c.doRequest(req, false)
 	// TODO An invalid Onelogin app ID gives HTTP 404 here. Need to show a nice
 	// error in this case.
 	if err != nil {
 		//if oneLoginError, ok := err.(*OneLoginError); ok {
 		//	fmt.Println(oneLoginError.StatusCode)
 		//}
-		return nil, fmt.Errorf("doing HTTP request: %v", err)
+		return nil, requestError(err)
 	}
 
 	var resp GenerateSamlAssertionResponse
@@ -201,9 +524,9 @@ func (c *Client) VerifyFactor(token string, p *VerifyFactorParams) (*VerifyFacto
 		return nil, fmt.Errorf("creating request: %v", err)
 	}
 
-	data, err := c.doRequest(req)
+	data, err := c.doRequest(req, false)
 	if err != nil {
-		return nil, fmt.Errorf("doing HTTP request: %v", err)
+		return nil, requestError(err)
 	}
 
 	var resp VerifyFactorResponse
@@ -214,6 +537,109 @@ func (c *Client) VerifyFactor(token string, p *VerifyFactorParams) (*VerifyFacto
 	return &resp, nil
 }
 
+// SetIPVersion forces the client to connect to OneLogin over IPv4 ("4") or IPv6 ("6"). An empty
+// value keeps the default dual-stack behavior.
+func (c *Client) SetIPVersion(v string) error {
+	n, ok := networks[v]
+	if !ok {
+		return fmt.Errorf("invalid IP version %q: valid values are 4, 6 or empty", v)
+	}
+	c.network = n
+
+	return nil
+}
+
+// SetHeaders sets extra HTTP headers to send with every request, e.g. for proxies which require
+// an API key. The Authorization header is used for authenticating against OneLogin and can't be
+// overridden.
+func (c *Client) SetHeaders(headers map[string]string) error {
+	h := make(http.Header)
+	for k, v := range headers {
+		if !httpguts.ValidHeaderFieldName(k) {
+			return fmt.Errorf("invalid HTTP header name %q", k)
+		}
+		if !httpguts.ValidHeaderFieldValue(v) {
+			return fmt.Errorf("invalid value for HTTP header %q", k)
+		}
+		if strings.EqualFold(k, "Authorization") {
+			return errors.New("the Authorization HTTP header can't be overridden")
+		}
+		h.Set(k, v)
+	}
+	c.headers = h
+
+	return nil
+}
+
+// SetUserAgent overrides the User-Agent header sent with every request, e.g. to let IdP admins
+// attribute traffic to a specific deployment of clisso.
+func (c *Client) SetUserAgent(ua string) error {
+	if strings.TrimSpace(ua) == "" {
+		return errors.New("the User-Agent HTTP header can't be empty")
+	}
+	if !httpguts.ValidHeaderFieldValue(ua) {
+		return errors.New("invalid value for the User-Agent HTTP header")
+	}
+	c.userAgent = ua
+
+	return nil
+}
+
+// OnAttempt registers f to be called before every attempt of a request, e.g. to tell the user
+// that a request is being retried.
+func (c *Client) OnAttempt(f func(attempt, attempts int)) {
+	c.onAttempt = f
+}
+
+// SetRequestTimeout limits the time a single attempt of a request may take. An attempt which
+// times out is retried like an attempt which fails with a network error. Zero disables the limit.
+func (c *Client) SetRequestTimeout(d time.Duration) {
+	c.requestTimeout = d
+}
+
+// SetTimeout limits the time a request may take in total, including retries. Unlike the timeout
+// set with SetRequestTimeout, reaching this timeout aborts the request. Zero disables the limit.
+func (c *Client) SetTimeout(d time.Duration) {
+	c.timeout = d
+}
+
+// SetDialTimeout limits the time establishing a connection to OneLogin may take. Zero disables
+// the limit.
+func (c *Client) SetDialTimeout(d time.Duration) {
+	c.dialTimeout = d
+}
+
+// SetTLSHandshakeTimeout limits the time the TLS handshake with OneLogin may take. Zero disables
+// the limit.
+func (c *Client) SetTLSHandshakeTimeout(d time.Duration) {
+	if t, ok := c.Transport.(*http.Transport); ok {
+		t.TLSHandshakeTimeout = d
+	}
+}
+
+// SetRateLimit limits the rate of requests to rate requests per second, allowing bursts of up to
+// rate requests (at least one). Zero disables the limit.
+func (c *Client) SetRateLimit(rate float64) {
+	if rate <= 0 {
+		c.limiter = nil
+		return
+	}
+
+	c.limiter = newRateLimiter(rate, int(math.Max(1, math.Ceil(rate))))
+}
+
+// dialContext connects to addr using the network selected by SetIPVersion, ignoring the network
+// requested by the transport.
+func (c *Client) dialContext(ctx context.Context, _, addr string) (net.Conn, error) {
+	if c.dialTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.dialTimeout)
+		defer cancel()
+	}
+
+	return c.dial(ctx, c.network, addr)
+}
+
 // NewClient creates a new Client and returns a pointer to it.
 func NewClient(region string) (c *Client, err error) {
 	c = new(Client)
@@ -221,5 +647,26 @@ func NewClient(region string) (c *Client, err error) {
 	c.Endpoints = Endpoints{Region: region}
 	err = c.Endpoints.setBase()
 
+	// Use the same settings as http.DefaultTransport while allowing control over the network. The
+	// dial timeout is applied by dialContext.
+	dialer := &net.Dialer{
+		KeepAlive: 30 * time.Second,
+	}
+	c.network = networks[""]
+	c.dial = dialer.DialContext
+	c.dialTimeout = defaultDialTimeout
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = c.dialContext
+	t.TLSHandshakeTimeout = defaultTLSHandshakeTimeout
+	c.Transport = t
+
+	c.attempts = 3
+	c.backoff = time.Second
+	c.maintenanceBackoff = 10 * time.Second
+	c.requestTimeout = 30 * time.Second
+	c.userAgent = UserAgent
+	c.SetRateLimit(DefaultRateLimit)
+
 	return
 }
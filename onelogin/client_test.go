@@ -1,10 +1,18 @@
 package onelogin
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func getTestServer(data string) *httptest.Server {
@@ -41,6 +49,450 @@ func TestNewClient(t *testing.T) {
 	}
 }
 
+func TestSetIPVersion(t *testing.T) {
+	for _, test := range []struct {
+		name          string
+		version       string
+		expectNetwork string
+		expectError   bool
+	}{
+		{"Dual-stack", "", "tcp", false},
+		{"IPv4", "4", "tcp4", false},
+		{"IPv6", "6", "tcp6", false},
+		{"Invalid version", "5", "", true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c, err := NewClient("US")
+			if err != nil {
+				t.Fatalf("creating client: %v", err)
+			}
+
+			err = c.SetIPVersion(test.version)
+			if test.expectError {
+				if err == nil {
+					t.Errorf("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+
+			// Record the network the transport dials with.
+			var network string
+			dial := c.dial
+			c.dial = func(ctx context.Context, n, addr string) (net.Conn, error) {
+				network = n
+				// The test server only listens on IPv4.
+				return dial(ctx, "tcp4", addr)
+			}
+
+			ts := getTestServer(`{"access_token": "fake_token"}`)
+			defer ts.Close()
+			c.Endpoints.base, _ = url.Parse(ts.URL)
+
+			if _, err := c.GenerateTokens("test", "test"); err != nil {
+				t.Fatalf("GenerateTokens failed: %s", err)
+			}
+			if network != test.expectNetwork {
+				t.Errorf("expected network %q, received %q", test.expectNetwork, network)
+			}
+		})
+	}
+}
+
+func TestGenerateTokensInvalidClient(t *testing.T) {
+	for _, test := range []struct {
+		name          string
+		status        int
+		body          string
+		expectInvalid bool
+	}{
+		{"OAuth error", http.StatusUnauthorized, `{"error": "invalid_client", "error_description": "client authentication failed"}`, true},
+		{"v1 error", http.StatusUnauthorized, `{"status": {"error": true, "code": 401, "type": "Unauthorized", "message": "Authentication Failure"}}`, true},
+		{"Unauthorized without body", http.StatusUnauthorized, ``, false},
+		{"Other error", http.StatusBadRequest, `{"error": "invalid_request"}`, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(test.status)
+				_, err := w.Write([]byte(test.body))
+				if err != nil {
+					panic(err)
+				}
+			}))
+			defer ts.Close()
+
+			c := Client{}
+			c.Endpoints.base, _ = url.Parse(ts.URL)
+
+			_, err := c.GenerateTokens("test", "test")
+			if err == nil {
+				t.Fatalf("expected error")
+			}
+			if (err == ErrInvalidClient) != test.expectInvalid {
+				t.Errorf("unexpected error %+v", err)
+			}
+		})
+	}
+}
+
+func TestSetHeaders(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		headers     map[string]string
+		expectError bool
+	}{
+		{"Valid headers", map[string]string{"X-Api-Key": "secret", "x-trace-id": "abc"}, false},
+		{"Invalid name", map[string]string{"X Api Key": "secret"}, true},
+		{"Invalid value", map[string]string{"X-Api-Key": "secret\n"}, true},
+		{"Authorization", map[string]string{"authorization": "bearer:fake"}, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c := Client{}
+
+			err := c.SetHeaders(test.headers)
+			if test.expectError && err == nil {
+				t.Errorf("expected error")
+			}
+			if !test.expectError && err != nil {
+				t.Errorf("unexpected error %+v", err)
+			}
+		})
+	}
+}
+
+func TestHeadersSent(t *testing.T) {
+	var received http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header
+		_, err := w.Write([]byte(`{"access_token": "fake_token"}`))
+		if err != nil {
+			panic(err)
+		}
+	}))
+	defer ts.Close()
+
+	c := Client{}
+	c.Endpoints.base, _ = url.Parse(ts.URL)
+	if err := c.SetHeaders(map[string]string{"x-api-key": "secret", "X-Trace-Id": "abc"}); err != nil {
+		t.Fatalf("setting headers: %v", err)
+	}
+
+	if _, err := c.GenerateTokens("test", "test"); err != nil {
+		t.Fatalf("GenerateTokens failed: %s", err)
+	}
+
+	for k, v := range map[string]string{"X-Api-Key": "secret", "X-Trace-Id": "abc"} {
+		if received.Get(k) != v {
+			t.Errorf("header %s: expected %q, received %q", k, v, received.Get(k))
+		}
+	}
+	if !strings.HasPrefix(received.Get("Authorization"), "client_id:test") {
+		t.Errorf("Authorization header was modified: %q", received.Get("Authorization"))
+	}
+}
+
+func TestRetries(t *testing.T) {
+	for _, test := range []struct {
+		name           string
+		failures       int
+		expectAttempts []int
+		expectError    bool
+	}{
+		{"No failures", 0, []int{1}, false},
+		{"Transient failures", 2, []int{1, 2, 3}, false},
+		{"Persistent failures", 3, []int{1, 2, 3}, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var requests int32
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if int(atomic.AddInt32(&requests, 1)) <= test.failures {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				_, err := w.Write([]byte(`{"access_token": "fake_token"}`))
+				if err != nil {
+					panic(err)
+				}
+			}))
+			defer ts.Close()
+
+			c := Client{attempts: 3}
+			c.Endpoints.base, _ = url.Parse(ts.URL)
+			var attempts []int
+			c.OnAttempt(func(attempt, max int) {
+				if max != 3 {
+					t.Errorf("expected 3 attempts in total, received %d", max)
+				}
+				attempts = append(attempts, attempt)
+			})
+
+			_, err := c.GenerateTokens("test", "test")
+			if test.expectError && err == nil {
+				t.Errorf("expected error")
+			}
+			if !test.expectError && err != nil {
+				t.Errorf("unexpected error %+v", err)
+			}
+			if !reflect.DeepEqual(attempts, test.expectAttempts) {
+				t.Errorf("expected attempts %v, received %v", test.expectAttempts, attempts)
+			}
+		})
+	}
+}
+
+func TestRetriesNotIdempotent(t *testing.T) {
+	for _, test := range []struct {
+		name           string
+		status         int
+		expectAttempts []int
+	}{
+		// The factor may have been verified, e.g. a push sent, so it's not verified again.
+		{"Server error", http.StatusInternalServerError, []int{1}},
+		{"Rate limited", http.StatusTooManyRequests, []int{1, 2}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var requests int32
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt32(&requests, 1) == 1 {
+					w.WriteHeader(test.status)
+					return
+				}
+				_, err := w.Write([]byte(`{"status": {"type": "success"}, "data": []}`))
+				if err != nil {
+					panic(err)
+				}
+			}))
+			defer ts.Close()
+
+			c := Client{attempts: 3}
+			c.Endpoints.base, _ = url.Parse(ts.URL)
+			var attempts []int
+			c.OnAttempt(func(attempt, max int) {
+				attempts = append(attempts, attempt)
+			})
+
+			c.VerifyFactor("token", &VerifyFactorParams{})
+			if !reflect.DeepEqual(attempts, test.expectAttempts) {
+				t.Errorf("expected attempts %v, received %v", test.expectAttempts, attempts)
+			}
+		})
+	}
+
+	t.Run("Connection refused", func(t *testing.T) {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		ts.Close()
+
+		c := Client{attempts: 3}
+		c.Endpoints.base, _ = url.Parse(ts.URL)
+		var attempts []int
+		c.OnAttempt(func(attempt, max int) {
+			attempts = append(attempts, attempt)
+		})
+
+		if _, err := c.VerifyFactor("token", &VerifyFactorParams{}); err == nil {
+			t.Errorf("expected error")
+		}
+		if expect := []int{1, 2, 3}; !reflect.DeepEqual(attempts, expect) {
+			t.Errorf("expected attempts %v, received %v", expect, attempts)
+		}
+	})
+}
+
+func TestProviderUnavailable(t *testing.T) {
+	for _, test := range []struct {
+		name              string
+		failures          int
+		header            string
+		body              string
+		expectError       bool
+		expectUnavailable bool
+		minDuration       time.Duration
+	}{
+		{"Maintenance", 3, "", `{"status": {"message": "Scheduled Maintenance"}}`, true, true, 60 * time.Millisecond},
+		{"Retry-After", 3, "120", "", true, true, 60 * time.Millisecond},
+		{"Maintenance over", 1, "", "Down for maintenance", false, false, 20 * time.Millisecond},
+		{"Generic failure", 3, "", "Service Unavailable", true, false, 0},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var requests int32
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if int(atomic.AddInt32(&requests, 1)) <= test.failures {
+					if test.header != "" {
+						w.Header().Set("Retry-After", test.header)
+					}
+					w.WriteHeader(http.StatusServiceUnavailable)
+					if _, err := w.Write([]byte(test.body)); err != nil {
+						panic(err)
+					}
+					return
+				}
+				if _, err := w.Write([]byte(`{"access_token": "fake_token"}`)); err != nil {
+					panic(err)
+				}
+			}))
+			defer ts.Close()
+
+			c := Client{attempts: 3, maintenanceBackoff: 20 * time.Millisecond}
+			c.Endpoints.base, _ = url.Parse(ts.URL)
+
+			start := time.Now()
+			_, err := c.GenerateTokens("test", "test")
+			if test.expectError && err == nil {
+				t.Errorf("expected error")
+			}
+			if !test.expectError && err != nil {
+				t.Errorf("unexpected error %+v", err)
+			}
+			if (err == ErrProviderUnavailable) != test.expectUnavailable {
+				t.Errorf("expected ErrProviderUnavailable: %v, received %v", test.expectUnavailable, err)
+			}
+			// Retries back off using maintenanceBackoff.
+			if d := time.Since(start); d < test.minDuration {
+				t.Errorf("expected retries to take at least %v, took %v", test.minDuration, d)
+			}
+		})
+	}
+}
+
+// getSlowTestServer returns a test server which delays the first slow requests by delay.
+func getSlowTestServer(slow int, delay time.Duration) *httptest.Server {
+	// The handler runs concurrently for the requests of a client that timed out.
+	var requests int32
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if int(atomic.AddInt32(&requests, 1)) <= slow {
+			select {
+			case <-time.After(delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		_, err := w.Write([]byte(`{"access_token": "fake_token"}`))
+		if err != nil {
+			panic(err)
+		}
+	}))
+}
+
+func TestTransportTimeouts(t *testing.T) {
+	c, err := NewClient("US")
+	if err != nil {
+		t.Fatalf("creating client: %v", err)
+	}
+
+	transport := c.Transport.(*http.Transport)
+	if transport.TLSHandshakeTimeout != defaultTLSHandshakeTimeout {
+		t.Errorf("expected TLS handshake timeout %v, received %v", defaultTLSHandshakeTimeout, transport.TLSHandshakeTimeout)
+	}
+	if c.dialTimeout != defaultDialTimeout {
+		t.Errorf("expected dial timeout %v, received %v", defaultDialTimeout, c.dialTimeout)
+	}
+
+	c.SetDialTimeout(3 * time.Second)
+	c.SetTLSHandshakeTimeout(5 * time.Second)
+	if transport.TLSHandshakeTimeout != 5*time.Second {
+		t.Errorf("expected TLS handshake timeout %v, received %v", 5*time.Second, transport.TLSHandshakeTimeout)
+	}
+
+	// The dial timeout is applied to the context passed to the dialer.
+	var remaining time.Duration
+	c.dial = func(ctx context.Context, n, addr string) (net.Conn, error) {
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			return nil, errors.New("no deadline")
+		}
+		remaining = time.Until(deadline)
+		return nil, errors.New("not dialing")
+	}
+	if _, err := c.dialContext(context.Background(), "tcp", "example.com:443"); err == nil || err.Error() != "not dialing" {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	if remaining <= 0 || remaining > 3*time.Second {
+		t.Errorf("expected a dial deadline within %v, received %v", 3*time.Second, remaining)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	ts := getTestServer(`{"access_token": "fake_token"}`)
+	defer ts.Close()
+
+	c := Client{}
+	c.Endpoints.base, _ = url.Parse(ts.URL)
+	// Allows bursts of 50 requests.
+	c.SetRateLimit(50)
+
+	// The 10 requests beyond the burst are paced at 50 requests per second, taking 200ms. Allow
+	// for some imprecision of the timers.
+	start := time.Now()
+	for i := 0; i < 60; i++ {
+		if _, err := c.GenerateTokens("test", "test"); err != nil {
+			t.Fatalf("GenerateTokens failed: %s", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 180*time.Millisecond {
+		t.Errorf("expected 60 requests to take at least %v, took %v", 180*time.Millisecond, elapsed)
+	}
+}
+
+func TestRateLimiterCancel(t *testing.T) {
+	l := newRateLimiter(1, 1)
+	if err := l.wait(context.Background()); err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+
+	// The bucket is empty, so the next request waits for a second unless it is canceled.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.wait(ctx); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	ts := getSlowTestServer(1, time.Second)
+	defer ts.Close()
+
+	c := Client{attempts: 3}
+	c.Endpoints.base, _ = url.Parse(ts.URL)
+	c.SetRequestTimeout(50 * time.Millisecond)
+	var attempts []int
+	c.OnAttempt(func(attempt, max int) { attempts = append(attempts, attempt) })
+
+	// The slow first attempt times out and is retried.
+	if _, err := c.GenerateTokens("test", "test"); err != nil {
+		t.Errorf("unexpected error %+v", err)
+	}
+	if !reflect.DeepEqual(attempts, []int{1, 2}) {
+		t.Errorf("expected attempts %v, received %v", []int{1, 2}, attempts)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	ts := getSlowTestServer(3, time.Second)
+	defer ts.Close()
+
+	c := Client{attempts: 3}
+	c.Endpoints.base, _ = url.Parse(ts.URL)
+	c.SetRequestTimeout(0)
+	c.SetTimeout(50 * time.Millisecond)
+	var attempts []int
+	c.OnAttempt(func(attempt, max int) { attempts = append(attempts, attempt) })
+
+	// The overall timeout aborts the request instead of retrying it.
+	start := time.Now()
+	_, err := c.GenerateTokens("test", "test")
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("expected timeout error, received %v", err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("request wasn't aborted on timeout")
+	}
+	if !reflect.DeepEqual(attempts, []int{1}) {
+		t.Errorf("expected attempts %v, received %v", []int{1}, attempts)
+	}
+}
+
 func TestGenerateTokens(t *testing.T) {
 	data := `{
 	"access_token": "fake_token",
@@ -136,10 +588,113 @@ func TestVerifyFactor(t *testing.T) {
 		t.Errorf("VerifyFactor: %s", err)
 	}
 
-	if resp.Data != "abcd" {
+	if !reflect.DeepEqual(resp.Data, SAMLData{"abcd"}) {
 		t.Errorf(
 			"Wrong response, got: %v, want: %v",
 			resp.Data, "abcd",
 		)
 	}
 }
+
+func TestSAMLDataUnmarshal(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		json        string
+		expect      SAMLData
+		expectError bool
+	}{
+		{"String", `{"data": "abcd"}`, SAMLData{"abcd"}, false},
+		{"Empty string", `{"data": ""}`, nil, false},
+		{"Missing", `{}`, nil, false},
+		{"Array", `{"data": ["abcd", "efgh"]}`, SAMLData{"abcd", "efgh"}, false},
+		{"Invalid", `{"data": 42}`, nil, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var resp VerifyFactorResponse
+			err := json.Unmarshal([]byte(test.json), &resp)
+			if test.expectError && err == nil {
+				t.Errorf("expected error")
+			}
+			if !test.expectError && err != nil {
+				t.Errorf("unexpected error %+v", err)
+			}
+			if !reflect.DeepEqual(resp.Data, test.expect) {
+				t.Errorf("expected %q, received %q", test.expect, resp.Data)
+			}
+		})
+	}
+}
+
+func TestVerifyFactorMatchNumber(t *testing.T) {
+	data := `{
+    "status": {
+        "type": "pending",
+        "message": "Authentication pending on OL Protect",
+        "code": 200,
+        "error": false
+    },
+    "message": "Authentication pending on OL Protect",
+    "match_number": 42
+}`
+
+	ts := getTestServer(data)
+	defer ts.Close()
+
+	c.Endpoints.base, _ = url.Parse(ts.URL)
+
+	resp, err := c.VerifyFactor("test", &VerifyFactorParams{AppId: "test", DeviceId: "test"})
+	if err != nil {
+		t.Fatalf("VerifyFactor: %s", err)
+	}
+
+	if resp.MatchNumber != 42 {
+		t.Errorf("Wrong match number, got: %v, want: %v", resp.MatchNumber, 42)
+	}
+	if m := pushMessage(resp); m != "Approve the push and select number 42 on your device" {
+		t.Errorf("Wrong push message: %q", m)
+	}
+}
+
+func TestUserAgent(t *testing.T) {
+	var received string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get("User-Agent")
+		_, err := w.Write([]byte(`{"access_token": "fake_token"}`))
+		if err != nil {
+			panic(err)
+		}
+	}))
+	defer ts.Close()
+
+	defer func(ua string) { UserAgent = ua }(UserAgent)
+	UserAgent = "clisso/1.2.3"
+
+	c, err := NewClient("US")
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	c.Endpoints.base, _ = url.Parse(ts.URL)
+
+	if _, err := c.GenerateTokens("test", "test"); err != nil {
+		t.Fatalf("GenerateTokens failed: %s", err)
+	}
+	if received != "clisso/1.2.3" {
+		t.Errorf("expected default User-Agent %q, received %q", "clisso/1.2.3", received)
+	}
+
+	if err := c.SetUserAgent("my-org-clisso/1.0"); err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	if _, err := c.GenerateTokens("test", "test"); err != nil {
+		t.Fatalf("GenerateTokens failed: %s", err)
+	}
+	if received != "my-org-clisso/1.0" {
+		t.Errorf("expected User-Agent %q, received %q", "my-org-clisso/1.0", received)
+	}
+
+	for _, ua := range []string{"", "  ", "clisso\n"} {
+		if err := c.SetUserAgent(ua); err == nil {
+			t.Errorf("expected error for User-Agent %q", ua)
+		}
+	}
+}
@@ -3,11 +3,14 @@ package onelogin
 import (
 	"fmt"
 	"net/url"
+	"strings"
+
+	"github.com/allcloud-io/clisso/config"
 )
 
 const (
-	usBase = "https://api.us.onelogin.com"
-	euBase = "https://api.eu.onelogin.com"
+	// baseURL is the base URL of the OneLogin API in a region, in lowercase.
+	baseURL = "https://api.%s.onelogin.com"
 
 	// GenerateSamlAssertionPath - OneLogin API endpoint to generate a SAML assertions
 	GenerateSamlAssertionPath string = "/api/2/saml_assertion"
@@ -30,19 +33,11 @@ type Endpoints struct {
 }
 
 func (e *Endpoints) setBase() (err error) {
-	var base string
-	switch e.Region {
-	case "US":
-		base = usBase
-
-	case "EU":
-		base = euBase
-
-	default:
-		return fmt.Errorf("Region %q is an invalid OneLogin region. Valid values are EU or US.", e.Region)
+	if !config.IsOneLoginRegion(e.Region) {
+		return fmt.Errorf("invalid OneLogin region %q: valid values are %s", e.Region, strings.Join(config.OneLoginRegions, ", "))
 	}
 
-	e.base, err = url.Parse(base)
+	e.base, err = url.Parse(fmt.Sprintf(baseURL, strings.ToLower(e.Region)))
 
 	return
 }
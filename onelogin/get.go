@@ -4,16 +4,20 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"os"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/allcloud-io/clisso/aws"
 	"github.com/allcloud-io/clisso/config"
 	"github.com/allcloud-io/clisso/keychain"
+	"github.com/allcloud-io/clisso/prompt"
 	"github.com/allcloud-io/clisso/saml"
 	"github.com/allcloud-io/clisso/spinner"
+	"github.com/allcloud-io/clisso/totp"
 	"github.com/fatih/color"
+	"github.com/spf13/viper"
 )
 
 const (
@@ -27,53 +31,181 @@ const (
 
 	// MFAInterval represents the interval at which we check for an accepted push message.
 	MFAInterval = 1
+
+	// deviceFilterThreshold is the number of MFA devices above which the user may filter the
+	// devices before choosing one.
+	deviceFilterThreshold = 5
+
+	// OTPEnvVar is the environment variable an OTP is read from instead of prompting for it.
+	OTPEnvVar = "CLISSO_OTP"
+)
+
+// Factors of MFA devices which support several of them.
+const (
+	factorPush = "push"
+	factorOTP  = "OTP"
 )
 
+// ErrNoMFADevice is returned when OneLogin requires MFA but returns no MFA device.
+var ErrNoMFADevice = errors.New("No MFA device returned by Onelogin")
+
+// ErrEmptyOTP is returned when an empty OTP is entered and the user can't be prompted again.
+var ErrEmptyOTP = errors.New("no OTP entered")
+
+// MFADeviceNotFoundError is returned when none of the MFA devices returned by OneLogin matches the
+// configured mfa-device.
+type MFADeviceNotFoundError struct {
+	// Device is the configured device type or ID.
+	Device string
+	// Devices are the devices returned by OneLogin.
+	Devices []Device
+}
+
+func (e *MFADeviceNotFoundError) Error() string {
+	available := make([]string, len(e.Devices))
+	for i, d := range e.Devices {
+		available[i] = fmt.Sprintf("%d - %s", d.DeviceID, d.DeviceType)
+	}
+
+	return fmt.Sprintf("configured MFA device '%s' not found. Available devices: %s",
+		e.Device, strings.Join(available, ", "))
+}
+
 var (
-	keyChain = keychain.DefaultKeychain{}
+	keyChain keychain.Keychain = keychain.DefaultKeychain{}
+
+	// backupCodePattern loosely matches OneLogin backup codes once spaces and dashes are removed.
+	backupCodePattern = regexp.MustCompile(`^[A-Za-z0-9]{6,16}$`)
+
+	// pushTimeout and pushInterval are the time to wait for a push to be approved and the interval
+	// at which the push is checked.
+	pushTimeout  = time.Duration(MFAPushTimeout) * time.Second
+	pushInterval = time.Duration(MFAInterval) * time.Second
+
+	// These are replaced in tests.
+	promptBackupCode = prompt.Password
+	promptOTP        = totp.OTPPrompt
+	promptTypedOTP   = prompt.OTP
+	interactive      = prompt.Interactive
+	newClient        = newProviderClient
 )
 
-// Get gets temporary credentials for the given app.
-// TODO Move AWS logic outside this function.
-func Get(app, provider, pArn string, duration int64) (*aws.Credentials, error) {
-	// Read config
-	p, err := config.GetOneLoginProvider(provider)
+// GetSAMLAssertion authenticates against OneLogin and returns a SAML assertion for the given app.
+func GetSAMLAssertion(app, provider string) (string, error) {
+	sess, err := NewSession(provider)
 	if err != nil {
-		return nil, fmt.Errorf("reading provider config: %v", err)
+		return "", err
 	}
+	defer sess.Close()
 
-	a, err := config.GetOneLoginApp(app)
+	return sess.SAMLAssertion(app)
+}
+
+// Session is a session at a OneLogin provider, which obtains SAML assertions for several of the
+// provider's apps using a single access token, username and password. OneLogin verifies MFA for
+// every assertion request, so MFA is verified for each app.
+type Session struct {
+	provider string
+	p        *config.OneLoginProviderConfig
+	c        *Client
+	s        spinner.SpinnerWrapper
+	// token is the OneLogin access token, empty until the first assertion is requested.
+	token string
+	// user and pass are used for all apps, once the first app has been requested.
+	user string
+	pass []byte
+	// closeRecording writes the recording of the session's requests, if any.
+	closeRecording func()
+}
+
+// NewSession returns a session at the given OneLogin provider. Requests are recorded or replayed
+// as configured by global.record and global.replay; a recording is written by Close.
+func NewSession(provider string) (*Session, error) {
+	// Read config
+	p, err := config.GetOneLoginProvider(provider)
 	if err != nil {
-		return nil, fmt.Errorf("reading config for app %s: %v", app, err)
+		return nil, fmt.Errorf("reading provider config: %v", err)
 	}
 
-	c, err := NewClient(p.Region)
+	c, err := newClient(p)
 	if err != nil {
 		return nil, err
 	}
+	if viper.GetString("global.record") != "" && viper.GetString("global.replay") != "" {
+		return nil, errors.New("recording and replaying requests are mutually exclusive")
+	}
+	if path := viper.GetString("global.replay"); path != "" {
+		r, err := ReadRecording(path)
+		if err != nil {
+			return nil, err
+		}
+		c.Replay(r)
+	}
+	sess := &Session{provider: provider, p: p, c: c, closeRecording: func() {}}
+	if path := viper.GetString("global.record"); path != "" {
+		r := c.Record()
+		// The recording is most useful when authentication fails, so it's written in any case.
+		sess.closeRecording = func() {
+			if err := r.WriteFile(path); err != nil {
+				log.Printf(color.YellowString("Could not save the recording of the OneLogin requests: %v"), err)
+				return
+			}
+			log.Printf("Recorded %d OneLogin requests, with secrets redacted, to %s", len(r.Interactions), path)
+		}
+	}
 
 	// Initialize spinner
-	var s = spinner.New()
+	sess.s = spinner.New()
+	c.OnAttempt(func(attempt, attempts int) {
+		sess.s.SetMessage(spinner.AttemptMessage(attempt, attempts))
+	})
 
-	// Get OneLogin access token
-	s.Start()
-	token, err := c.GenerateTokens(p.ClientID, p.ClientSecret)
-	s.Stop()
+	return sess, nil
+}
+
+// Close ends the use of the session and writes the recording of its requests, if any.
+func (sess *Session) Close() {
+	sess.closeRecording()
+}
+
+// SAMLAssertion authenticates against OneLogin and returns a SAML assertion for the given app. The
+// access token and the user's credentials are obtained for the first app only. All apps requested
+// using a session have to use the same username.
+func (sess *Session) SAMLAssertion(app string) (string, error) {
+	p, c, s, provider := sess.p, sess.c, sess.s, sess.provider
+
+	a, err := config.GetOneLoginApp(app)
 	if err != nil {
-		return nil, fmt.Errorf("generating access token: %s", err)
+		return "", fmt.Errorf("reading config for app %s: %v", app, err)
 	}
 
-	user := p.Username
-	if user == "" {
-		// Get credentials from the user
-		fmt.Print("OneLogin username: ")
-		fmt.Scanln(&user)
+	// Get OneLogin access token
+	if sess.token == "" {
+		s.Start()
+		token, err := c.GenerateTokens(p.ClientID, p.ClientSecret)
+		s.Stop()
+		if err != nil {
+			return "", fmt.Errorf("generating access token: %s", err)
+		}
+		sess.token = token
 	}
+	token := sess.token
 
-	pass, err := keyChain.Get(provider)
-	if err != nil {
-		return nil, fmt.Errorf("error getting keychain: %s", err)
+	if sess.user == "" {
+		user, err := username(a, p)
+		if err != nil {
+			return "", err
+		}
+
+		pass, err := keyChain.Get(passwordKey(a, provider))
+		if err != nil {
+			return "", fmt.Errorf("error getting keychain: %s", err)
+		}
+		sess.user, sess.pass = user, pass
+	} else if a.Username != "" && a.Username != sess.user {
+		return "", fmt.Errorf("app %s uses username %s, but the session is authenticated as %s", app, a.Username, sess.user)
 	}
+	user, pass := sess.user, sess.pass
 
 	// Generate SAML assertion
 	pSAML := GenerateSamlAssertionParams{
@@ -85,162 +217,626 @@ func Get(app, provider, pArn string, duration int64) (*aws.Credentials, error) {
 		Subdomain: p.Subdomain,
 	}
 
-	s.Start()
-	rSaml, err := c.GenerateSamlAssertion(token, &pSAML)
-	s.Stop()
+	// The password and access token are reused when retrying, so the user isn't asked again.
+	rSaml, err := generateWithRetries(p.SAMLRequestRetries, func() (*GenerateSamlAssertionResponse, error) {
+		s.Start()
+		defer s.Stop()
+		return c.GenerateSamlAssertion(token, &pSAML)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("generating SAML assertion: %v", err)
+		return "", fmt.Errorf("generating SAML assertion: %v", err)
 	}
 
-	var rData string
-	if rSaml.Message != "Success" {
-		st := rSaml.StateToken
+	arn := viper.GetString(fmt.Sprintf("apps.%s.arn", app))
+	if rSaml.Message == "Success" {
+		return selectAssertion(rSaml.Data, p.MultipleAssertions, arn)
+	}
 
-		devices := rSaml.Devices
-		device, err := getDevice(devices)
-		if err != nil {
-			return nil, fmt.Errorf("error getting devices: %s", err)
-		}
+	pushMode := p.MFAPush
+	if a.MFAPush != "" {
+		pushMode = a.MFAPush
+	}
+
+	pinned := p.MFADevice
+	if a.MFADevice != "" {
+		pinned = a.MFADevice
+	}
+	selectDevice := func(devices []Device) (*Device, error) {
+		d, err := getDevice(devices, pinned)
+		// If verification using the configured device fails, the user may select another device.
+		pinned = ""
+		return d, err
+	}
 
-		var rMfa *VerifyFactorResponse
+	verify := func(device *Device) (SAMLData, error) {
+		return verifyDevice(c, s, token, a.ID, rSaml.StateToken, device, provider, pushMode)
+	}
 
-		var pushOK = false
+	data, err := verifyWithReselect(rSaml.Devices, p.MFAReselect, p.MFARetries, selectDevice, verify)
+	if err != nil {
+		return "", err
+	}
 
-		if device.DeviceType == MFADeviceOneLoginProtect {
-			// Push is supported by the selected MFA device - try pushing and fall back to manual input
-			pushOK = true
-			pMfa := VerifyFactorParams{
-				AppId:       a.ID,
-				DeviceId:    fmt.Sprintf("%v", device.DeviceID),
-				StateToken:  st,
-				OtpToken:    "",
-				DoNotNotify: false,
-			}
+	// Selecting the assertion isn't part of the verification, so failing to select one doesn't
+	// cause MFA to be verified again.
+	return selectAssertion(data, p.MultipleAssertions, arn)
+}
 
-			s.Start()
-			rMfa, err = c.VerifyFactor(token, &pMfa)
-			s.Stop()
+// samlRequestRetryDelay is the time to wait before retrying a SAML assertion request which failed
+// transiently.
+var samlRequestRetryDelay = 2 * time.Second
+
+// generateWithRetries returns the response of generate, calling it up to retries more times while it
+// fails with a transient error (see IsTransient) which the client doesn't retry itself, i.e. a server
+// error or a network error after the request was sent. Other errors, e.g. rejected credentials or
+// rate limiting which the client has retried already, are returned right away.
+func generateWithRetries(retries int, generate func() (*GenerateSamlAssertionResponse, error)) (*GenerateSamlAssertionResponse, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := generate()
+		if err == nil || !IsTransient(err) || retriedByClient(err) || attempt >= retries {
+			return resp, err
+		}
+
+		log.Printf(color.YellowString("Generating the SAML assertion failed: %v. Retrying (%d/%d)"), err, attempt+1, retries)
+		time.Sleep(samlRequestRetryDelay)
+	}
+}
+
+// selectAssertion returns one of the SAML assertions of a response. If there are several, they are
+// handled according to strategy:
+//   - error: return an error
+//   - first: use the first assertion
+//   - arn: use the only assertion containing the role arn
+//   - prompt: let the user choose an assertion
+func selectAssertion(data SAMLData, strategy, arn string) (string, error) {
+	switch len(data) {
+	case 0:
+		return "", errors.New("no SAML assertion returned by OneLogin")
+	case 1:
+		return data[0], nil
+	}
+
+	switch strategy {
+	case "first":
+		return data[0], nil
+	case "arn":
+		if arn == "" {
+			return "", fmt.Errorf("%d SAML assertions returned, but the app has no arn to select one by", len(data))
+		}
+		var matches []string
+		for _, d := range data {
+			roles, err := saml.Roles(d)
 			if err != nil {
-				return nil, err
+				return "", fmt.Errorf("parsing SAML assertion: %v", err)
+			}
+			for _, r := range roles {
+				if r.Role == arn {
+					matches = append(matches, d)
+					break
+				}
 			}
+		}
+		if len(matches) != 1 {
+			return "", fmt.Errorf("%d of %d SAML assertions contain role %s, expected exactly one", len(matches), len(data), arn)
+		}
+		return matches[0], nil
+	case "prompt":
+		options := make([]string, len(data))
+		for i, d := range data {
+			options[i] = assertionLabel(i, d)
+		}
+		i, err := prompt.Select("SAML assertion selection", "Please choose a SAML assertion", options)
+		if err != nil {
+			return "", err
+		}
+		return data[i], nil
+	default:
+		return "", fmt.Errorf(
+			"%d SAML assertions returned, set multiple-assertions in the provider's config to choose one", len(data),
+		)
+	}
+}
 
-			pMfa.DoNotNotify = true
+// assertionLabel describes the SAML assertion with the given index by the roles it contains.
+func assertionLabel(i int, data string) string {
+	roles, err := saml.Roles(data)
+	if err != nil || len(roles) == 0 {
+		return fmt.Sprintf("Assertion %d", i+1)
+	}
 
-			fmt.Println(rMfa.Message)
+	arns := make([]string, len(roles))
+	for j, r := range roles {
+		arns[j] = r.Role
+	}
 
-			timeout := MFAPushTimeout
-			s.Start()
-			for strings.Contains(rMfa.Message, "pending") && timeout > 0 {
-				time.Sleep(time.Duration(MFAInterval) * time.Second)
-				rMfa, err = c.VerifyFactor(token, &pMfa)
-				if err != nil {
-					s.Stop()
-					return nil, err
-				}
+	return fmt.Sprintf("Assertion %d (%s)", i+1, strings.Join(arns, ", "))
+}
 
-				timeout -= MFAInterval
-			}
-			s.Stop()
+// newProviderClient returns a client configured using the given provider config.
+func newProviderClient(p *config.OneLoginProviderConfig) (*Client, error) {
+	c, err := NewClient(p.Region)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.SetIPVersion(p.IPVersion); err != nil {
+		return nil, err
+	}
+	if err := c.SetHeaders(p.Headers); err != nil {
+		return nil, fmt.Errorf("reading provider config: %v", err)
+	}
+	if p.UserAgent != "" {
+		if err := c.SetUserAgent(p.UserAgent); err != nil {
+			return nil, fmt.Errorf("reading provider config: %v", err)
+		}
+	}
+	if p.RequestTimeout > 0 {
+		c.SetRequestTimeout(time.Duration(p.RequestTimeout) * time.Second)
+	}
+	if p.Timeout > 0 {
+		c.SetTimeout(time.Duration(p.Timeout) * time.Second)
+	}
+	if p.DialTimeout > 0 {
+		c.SetDialTimeout(time.Duration(p.DialTimeout) * time.Second)
+	}
+	if p.TLSHandshakeTimeout > 0 {
+		c.SetTLSHandshakeTimeout(time.Duration(p.TLSHandshakeTimeout) * time.Second)
+	}
+	if p.RateLimit > 0 {
+		c.SetRateLimit(p.RateLimit)
+	}
 
-			if strings.Contains(rMfa.Message, "pending") {
-				fmt.Println("MFA verification timed out - falling back to manual OTP input")
-				pushOK = false
-			}
+	return c, nil
+}
+
+// verifyWithReselect lets the user select one of the given MFA devices using selectDevice and
+// verifies it using verify, returning the SAML assertions. If verification fails, it is retried up
+// to retries times using the same device, so that only the OTP is asked for (or the push is sent)
+// again. If reselect is set and verification still fails while other devices are available, the
+// user may select another device.
+func verifyWithReselect(
+	devices []Device,
+	reselect bool,
+	retries int,
+	selectDevice func([]Device) (*Device, error),
+	verify func(*Device) (SAMLData, error),
+) (SAMLData, error) {
+	for {
+		device, err := selectDevice(devices)
+		if err != nil {
+			return nil, fmt.Errorf("error getting devices: %w", err)
 		}
 
-		if !pushOK {
-			// Push failed or not supported by the selected MFA device
-			fmt.Print("Please enter the OTP from your MFA device: ")
-			var otp string
-			fmt.Scanln(&otp)
+		data, err := verify(device)
+		for attempt := 1; err != nil && attempt <= retries; attempt++ {
+			fmt.Printf("MFA verification failed: %v\n", err)
+			fmt.Printf("Retrying with device %d - %s (attempt %d/%d)\n", device.DeviceID, device.DeviceType, attempt+1, retries+1)
+			data, err = verify(device)
+		}
+		if err == nil {
+			return data, nil
+		}
 
-			// Verify MFA
-			pMfa := VerifyFactorParams{
-				AppId:       a.ID,
-				DeviceId:    fmt.Sprintf("%v", device.DeviceID),
-				StateToken:  st,
-				OtpToken:    otp,
-				DoNotNotify: false,
-			}
+		devices = excludeDevice(uniqueDevices(devices), device.DeviceID)
+		if !reselect || len(devices) == 0 {
+			return nil, err
+		}
+		fmt.Printf("MFA verification using device %d - %s failed: %v\n", device.DeviceID, device.DeviceType, err)
+		fmt.Println("Please select another MFA device")
+	}
+}
 
-			s.Start()
-			rMfa, err = c.VerifyFactor(token, &pMfa)
-			s.Stop()
-			if err != nil {
-				return nil, fmt.Errorf("verifying factor: %v", err)
-			}
+// excludeDevice returns the given devices without the device with the given ID.
+func excludeDevice(devices []Device, id int) []Device {
+	remaining := make([]Device, 0, len(devices))
+	for _, d := range devices {
+		if d.DeviceID != id {
+			remaining = append(remaining, d)
+		}
+	}
+
+	return remaining
+}
+
+// Modes of verifying OneLogin Protect devices, which support both push and OTP.
+const (
+	// pushFirst sends a push and falls back to OTP input if the push isn't approved in time.
+	pushFirst = "push-first"
+	// otpFirst asks for an OTP and sends a push if no OTP is entered.
+	otpFirst = "otp-first"
+	// pushOnly sends a push and fails if it isn't approved in time.
+	pushOnly = "push-only"
+)
+
+// passwordKey returns the keychain key of the password for app a of provider: the provider, unless
+// the app overrides the provider's username.
+func passwordKey(a *config.OneLoginAppConfig, provider string) string {
+	if a.Username != "" {
+		return keychain.UserKey(provider, a.Username)
+	}
+
+	return provider
+}
+
+// username returns the OneLogin username to authenticate with: the app's username if set, otherwise
+// the provider's. If neither is configured, the user is prompted for it.
+func username(a *config.OneLoginAppConfig, p *config.OneLoginProviderConfig) (string, error) {
+	if a.Username != "" {
+		return a.Username, nil
+	}
+	if p.Username != "" {
+		return p.Username, nil
+	}
+
+	return prompt.Line("OneLogin username", "OneLogin username: ")
+}
+
+// verifyDevice verifies the given MFA device using the state token of a SAML assertion request
+// and returns the SAML assertions. Devices which support push are verified according to pushMode,
+// the others using OTP input. If the user chose a factor for the device, it is used instead: OTP
+// input, or a push which is sent right away even in otp-first mode. If global.backup-code is set,
+// push is skipped and a backup code is used as the OTP.
+func verifyDevice(c *Client, s spinner.SpinnerWrapper, token, appID, st string, device *Device, provider, pushMode string) (SAMLData, error) {
+	useBackupCode := viper.GetBool("global.backup-code")
+	if device.DeviceType != MFADeviceOneLoginProtect || useBackupCode || device.Factor == factorOTP {
+		otp, err := otpToken(provider, useBackupCode)
+		if err != nil {
+			return nil, err
+		}
+		return verifyOTP(c, s, token, appID, st, device, otp)
+	}
+
+	if pushMode == otpFirst && device.Factor != factorPush {
+		otp, err := readOTP(provider, "Please enter the OTP from your MFA device (leave empty to send a push): ")
+		if err != nil {
+			return nil, err
+		}
+		if otp != "" {
+			return verifyOTP(c, s, token, appID, st, device, otp)
 		}
-		rData = rMfa.Data
-	} else {
-		rData = rSaml.Data
 	}
 
-	arn, err := saml.Get(rData, pArn)
+	rMfa, err := verifyPush(c, s, token, appID, st, device)
 	if err != nil {
 		return nil, err
 	}
 
+	if strings.Contains(rMfa.Message, "pending") {
+		if pushMode == pushOnly {
+			return nil, errors.New("MFA verification timed out")
+		}
+		fmt.Println("MFA verification timed out - falling back to manual OTP input")
+
+		otp, err := otpToken(provider, false)
+		if err != nil {
+			return nil, err
+		}
+		return verifyOTP(c, s, token, appID, st, device, otp)
+	}
+
+	// A denied push returns no assertion.
+	if len(rMfa.Data) == 0 {
+		return nil, fmt.Errorf("verifying factor: %s", rMfa.Message)
+	}
+
+	return rMfa.Data, nil
+}
+
+// verifyPush sends a push to the given device and waits for it to be approved for up to
+// pushTimeout. The last response is returned, which is still pending if the push timed out.
+func verifyPush(c *Client, s spinner.SpinnerWrapper, token, appID, st string, device *Device) (*VerifyFactorResponse, error) {
+	pMfa := VerifyFactorParams{
+		AppId:       appID,
+		DeviceId:    fmt.Sprintf("%v", device.DeviceID),
+		StateToken:  st,
+		OtpToken:    "",
+		DoNotNotify: false,
+	}
+
 	s.Start()
-	creds, err := aws.AssumeSAMLRole(arn.Provider, arn.Role, rData, duration)
+	rMfa, err := c.VerifyFactor(token, &pMfa)
 	s.Stop()
-
 	if err != nil {
-		if err.Error() == aws.ErrDurationExceeded {
-			log.Println(color.YellowString(aws.DurationExceededMessage))
-			s.Start()
-			creds, err = aws.AssumeSAMLRole(arn.Provider, arn.Role, rData, 3600)
+		return nil, err
+	}
+
+	pMfa.DoNotNotify = true
+
+	if showPushMessage(rMfa) {
+		fmt.Println(pushMessage(rMfa))
+	}
+	number := rMfa.MatchNumber
+
+	s.Start()
+	defer s.Stop()
+	for waited := time.Duration(0); strings.Contains(rMfa.Message, "pending") && waited < pushTimeout; waited += pushInterval {
+		time.Sleep(pushInterval)
+		rMfa, err = c.VerifyFactor(token, &pMfa)
+		if err != nil {
+			return nil, err
+		}
+		// Some tenants only return the number while polling.
+		if rMfa.MatchNumber != 0 && rMfa.MatchNumber != number {
+			number = rMfa.MatchNumber
 			s.Stop()
-			if err != nil {
-				return nil, err
+			fmt.Println(pushMessage(rMfa))
+			s.Start()
+		}
+	}
+
+	return rMfa, nil
+}
+
+// verifyOTP verifies the given device using otp and returns the SAML assertions.
+func verifyOTP(c *Client, s spinner.SpinnerWrapper, token, appID, st string, device *Device, otp string) (SAMLData, error) {
+	pMfa := VerifyFactorParams{
+		AppId:       appID,
+		DeviceId:    fmt.Sprintf("%v", device.DeviceID),
+		StateToken:  st,
+		OtpToken:    otp,
+		DoNotNotify: false,
+	}
+
+	s.Start()
+	rMfa, err := c.VerifyFactor(token, &pMfa)
+	s.Stop()
+	if err != nil {
+		return nil, fmt.Errorf("verifying factor: %v", err)
+	}
+
+	if len(rMfa.Data) == 0 {
+		return nil, fmt.Errorf("verifying factor: %s", rMfa.Message)
+	}
+
+	return rMfa.Data, nil
+}
+
+// otpToken returns the OTP to verify an MFA device with: a backup code entered by the user if backup
+// is set, otherwise a TOTP. An empty OTP is never returned: the user is prompted again if possible,
+// otherwise ErrEmptyOTP is returned.
+func otpToken(provider string, backup bool) (string, error) {
+	if !backup {
+		for {
+			otp, err := readOTP(provider, "Please enter the OTP from your MFA device: ")
+			if err != nil || otp != "" {
+				return otp, err
+			}
+			if !interactive() {
+				return "", ErrEmptyOTP
 			}
+			fmt.Println("The OTP can't be empty")
 		}
 	}
 
-	return creds, err
+	code, err := promptBackupCode("MFA backup code", "Please enter a backup code: ")
+	if err != nil {
+		return "", err
+	}
+
+	otp, err := normalizeBackupCode(string(code))
+	if err != nil {
+		return "", err
+	}
+
+	if !viper.GetBool("global.quiet") {
+		log.Println(color.YellowString("Backup codes can be used only once, please make sure you have enough left"))
+	}
+
+	return otp, nil
+}
+
+// readOTP returns the OTP given in $CLISSO_OTP, if set, so that scripts can verify MFA without a
+// terminal. Otherwise the OTP is generated from a stored TOTP key or the user is prompted for it
+// using message. If the provider's prompt-otp is set, the user is always prompted, regardless of
+// whether the password is read from the keychain.
+func readOTP(provider, message string) (string, error) {
+	if otp := strings.TrimSpace(os.Getenv(OTPEnvVar)); otp != "" {
+		return otp, nil
+	}
+
+	if viper.GetBool(fmt.Sprintf("providers.%s.prompt-otp", provider)) {
+		return promptTypedOTP("OTP", message)
+	}
+
+	return promptOTP(provider, message)
+}
+
+// normalizeBackupCode removes spaces and dashes, which are commonly used for grouping, from a backup
+// code and checks that the remainder looks like a backup code.
+func normalizeBackupCode(code string) (string, error) {
+	otp := strings.NewReplacer(" ", "", "-", "").Replace(strings.TrimSpace(code))
+	if !backupCodePattern.MatchString(otp) {
+		return "", errors.New("invalid backup code: expected 6 to 16 letters or digits")
+	}
+
+	return otp, nil
+}
+
+// pushMessage returns the message to show the user while waiting for a push to be approved. On
+// tenants which use number matching, it includes the number to select on the device.
+func pushMessage(r *VerifyFactorResponse) string {
+	if r.MatchNumber == 0 {
+		return r.Message
+	}
+
+	return fmt.Sprintf("Approve the push and select number %d on your device", r.MatchNumber)
+}
+
+// showPushMessage reports whether the message of a push response is printed. The number to select
+// on the device is always printed, while the status message OneLogin returns otherwise is omitted in
+// quiet mode or if global.hide-push-message is set.
+func showPushMessage(r *VerifyFactorResponse) bool {
+	if r.MatchNumber != 0 {
+		return true
+	}
+
+	return !viper.GetBool("global.quiet") && !viper.GetBool("global.hide-push-message")
 }
 
 // getDevice gets a slice of MFA devices, prompts the user to select one and returns the selected device.
-// If the slice contains only a single device, that device is returned. If the slice is empty, an error is returned.
-func getDevice(devices []Device) (device *Device, err error) {
+// Duplicate devices are ignored. If pinned is set, the device it refers to is returned without
+// prompting. If the slice contains only a single device, that device is returned. Otherwise a
+// device supporting several factors is offered once for each of them, and the factor the user
+// chose is set in the returned device. If the slice is empty, ErrNoMFADevice is returned.
+func getDevice(devices []Device, pinned string) (device *Device, err error) {
 	if len(devices) == 0 {
 		// This should never happen
-		err = errors.New("No MFA device returned by Onelogin")
+		err = ErrNoMFADevice
 		return
 	}
 
+	devices = uniqueDevices(devices)
+
+	if pinned != "" {
+		return pinnedDevice(devices, pinned)
+	}
+
 	if len(devices) == 1 {
 		device = &Device{DeviceID: devices[0].DeviceID, DeviceType: devices[0].DeviceType}
 		return
 	}
 
-	var selection int
-	for {
-		for i, d := range devices {
-			fmt.Printf("%d. %d - %s\n", i+1, d.DeviceID, d.DeviceType)
-		}
+	if err = prompt.Check("MFA device selection"); err != nil {
+		return
+	}
 
-		fmt.Printf("Please choose an MFA device to authenticate with (1-%d): ", len(devices))
-		var input string
-		_, err := fmt.Scanln(&input)
+	// Let the user narrow down long lists before choosing from them.
+	for len(devices) > deviceFilterThreshold {
+		var filter string
+		filter, err = prompt.Line("MFA device filter", "Type to filter MFA devices (leave empty to list all): ")
 		if err != nil {
-			fmt.Printf("Error reading input: %v\n", err)
+			return
+		}
+		if filter == "" {
+			break
+		}
+
+		matches := filterDevices(devices, filter)
+		if len(matches) == 0 {
+			fmt.Printf("No MFA device matches '%s'\n", filter)
 			continue
 		}
+		devices = matches
+	}
 
-		// Verify we got an integer.
-		selection, err = strconv.Atoi(input)
-		if err != nil {
-			fmt.Printf("Invalid input '%s'\n", input)
+	if len(devices) == 1 {
+		device = &Device{DeviceID: devices[0].DeviceID, DeviceType: devices[0].DeviceType}
+		return
+	}
+
+	// Devices supporting several factors are offered once for each of them.
+	devices = deviceOptions(devices)
+
+	labels := make([]string, len(devices))
+	for i, d := range devices {
+		labels[i] = deviceLabel(d)
+	}
+	selection, err := prompt.Select("MFA device selection", "Please choose an MFA device to authenticate with", labels)
+	if err != nil {
+		return
+	}
+	d := devices[selection]
+	device = &d
+	return
+}
+
+// deviceFactors returns the factors a device of the given type can be verified with, if it supports
+// several of them. OneLogin Protect supports both push and OTP.
+func deviceFactors(deviceType string) []string {
+	if deviceType == MFADeviceOneLoginProtect {
+		return []string{factorPush, factorOTP}
+	}
+
+	return nil
+}
+
+// deviceOptions returns the options offered when selecting one of the given MFA devices: a device
+// supporting several factors is listed once for each factor, other devices once without a factor.
+func deviceOptions(devices []Device) []Device {
+	var options []Device
+	for _, d := range devices {
+		factors := deviceFactors(d.DeviceType)
+		if len(factors) == 0 {
+			options = append(options, Device{DeviceID: d.DeviceID, DeviceType: d.DeviceType})
 			continue
 		}
+		for _, f := range factors {
+			options = append(options, Device{DeviceID: d.DeviceID, DeviceType: d.DeviceType, Factor: f})
+		}
+	}
+
+	return options
+}
+
+// deviceLabel describes an MFA device in the device selection.
+func deviceLabel(d Device) string {
+	if d.Factor == "" {
+		return fmt.Sprintf("%d - %s", d.DeviceID, d.DeviceType)
+	}
+
+	return fmt.Sprintf("%d - %s (%s)", d.DeviceID, d.DeviceType, d.Factor)
+}
+
+// pinnedDevice returns the device whose ID or type matches pinned. Types are compared
+// case-insensitively. An error is returned if pinned matches none or several of the devices.
+func pinnedDevice(devices []Device, pinned string) (*Device, error) {
+	id, idErr := strconv.Atoi(pinned)
+
+	var matches []Device
+	for _, d := range devices {
+		if (idErr == nil && d.DeviceID == id) || strings.EqualFold(d.DeviceType, pinned) {
+			matches = append(matches, d)
+		}
+	}
 
-		// Verify selection is within range.
-		if selection < 1 || selection > len(devices) {
-			fmt.Printf("Invalid value %d. Valid values: 1-%d\n", selection, len(devices))
+	switch len(matches) {
+	case 0:
+		return nil, &MFADeviceNotFoundError{Device: pinned, Devices: devices}
+	case 1:
+		return &Device{DeviceID: matches[0].DeviceID, DeviceType: matches[0].DeviceType}, nil
+	}
+
+	return nil, fmt.Errorf("configured MFA device '%s' matches %d devices, use the device ID instead",
+		pinned, len(matches))
+}
+
+// filterDevices returns the devices whose type or ID fuzzily matches filter.
+func filterDevices(devices []Device, filter string) []Device {
+	var matches []Device
+	for _, d := range devices {
+		if fuzzyMatch(filter, d.DeviceType) || fuzzyMatch(filter, strconv.Itoa(d.DeviceID)) {
+			matches = append(matches, d)
+		}
+	}
+
+	return matches
+}
+
+// fuzzyMatch reports whether the characters of pattern appear in s in the same order, ignoring
+// case and whitespace, e.g. "gauth" matches "Google Authenticator".
+func fuzzyMatch(pattern, s string) bool {
+	p := []rune(strings.ToLower(strings.Join(strings.Fields(pattern), "")))
+	i := 0
+	for _, r := range strings.ToLower(s) {
+		if i < len(p) && r == p[i] {
+			i++
+		}
+	}
+
+	return i == len(p)
+}
+
+// uniqueDevices returns the given devices without duplicates, preserving their order. OneLogin may
+// return the same device more than once.
+func uniqueDevices(devices []Device) []Device {
+	seen := make(map[int]bool)
+	unique := make([]Device, 0, len(devices))
+	for _, d := range devices {
+		if seen[d.DeviceID] {
 			continue
 		}
-		break
+		seen[d.DeviceID] = true
+		unique = append(unique, d)
 	}
-	device = &Device{DeviceID: devices[selection-1].DeviceID, DeviceType: devices[selection-1].DeviceType}
-	return
+
+	return unique
 }
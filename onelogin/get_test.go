@@ -0,0 +1,932 @@
+package onelogin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/allcloud-io/clisso/config"
+	"github.com/allcloud-io/clisso/keychain"
+	"github.com/allcloud-io/clisso/spinner"
+	"github.com/spf13/viper"
+)
+
+func TestUniqueDevices(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		devices []Device
+		expect  []Device
+	}{
+		{
+			"No duplicates",
+			[]Device{{DeviceID: 1, DeviceType: "Google Authenticator"}, {DeviceID: 2, DeviceType: MFADeviceOneLoginProtect}},
+			[]Device{{DeviceID: 1, DeviceType: "Google Authenticator"}, {DeviceID: 2, DeviceType: MFADeviceOneLoginProtect}},
+		},
+		{
+			"Duplicates",
+			[]Device{
+				{DeviceID: 2, DeviceType: MFADeviceOneLoginProtect},
+				{DeviceID: 1, DeviceType: "Google Authenticator"},
+				{DeviceID: 2, DeviceType: MFADeviceOneLoginProtect},
+				{DeviceID: 3, DeviceType: "Yubikey"},
+				{DeviceID: 1, DeviceType: "Google Authenticator"},
+			},
+			[]Device{{DeviceID: 2, DeviceType: MFADeviceOneLoginProtect}, {DeviceID: 1, DeviceType: "Google Authenticator"}, {DeviceID: 3, DeviceType: "Yubikey"}},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			res := uniqueDevices(test.devices)
+			if !reflect.DeepEqual(res, test.expect) {
+				t.Errorf("expected %v, received %v", test.expect, res)
+			}
+		})
+	}
+}
+
+func TestGetDeviceDuplicates(t *testing.T) {
+	// A single device returned several times must be selected without prompting.
+	devices := []Device{{DeviceID: 1, DeviceType: "Google Authenticator"}, {DeviceID: 1, DeviceType: "Google Authenticator"}}
+
+	d, err := getDevice(devices, "")
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	if d.DeviceID != 1 {
+		t.Errorf("expected device 1, received %d", d.DeviceID)
+	}
+}
+
+func TestGetDeviceNonInteractive(t *testing.T) {
+	viper.Set("global.non-interactive", true)
+	defer viper.Set("global.non-interactive", false)
+
+	devices := []Device{
+		{DeviceID: 1, DeviceType: "Google Authenticator"},
+		{DeviceID: 2, DeviceType: MFADeviceOneLoginProtect},
+	}
+
+	if _, err := getDevice(devices, ""); err == nil {
+		t.Fatal("expected error when selecting a device in non-interactive mode")
+	}
+
+	// A single device requires no selection.
+	if _, err := getDevice(devices[:1], ""); err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+}
+
+func TestGetDeviceClosedStdin(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	defer func(stdin *os.File) { os.Stdin = stdin }(os.Stdin)
+	os.Stdin = r
+
+	devices := []Device{
+		{DeviceID: 1, DeviceType: "Google Authenticator"},
+		{DeviceID: 2, DeviceType: "Yubico YubiKey"},
+	}
+
+	// Reaching EOF must fail rather than prompting again.
+	if _, err := getDevice(devices, ""); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestGetDevicePinned(t *testing.T) {
+	// Pinning a device must not prompt.
+	viper.Set("global.non-interactive", true)
+	defer viper.Set("global.non-interactive", false)
+
+	devices := []Device{
+		{DeviceID: 1, DeviceType: "Google Authenticator"},
+		{DeviceID: 2, DeviceType: MFADeviceOneLoginProtect},
+		{DeviceID: 3, DeviceType: "Yubico YubiKey"},
+		{DeviceID: 4, DeviceType: "Yubico YubiKey"},
+	}
+
+	for _, test := range []struct {
+		name   string
+		pinned string
+		expect int
+	}{
+		{"Type", MFADeviceOneLoginProtect, 2},
+		{"Type is case-insensitive", "google authenticator", 1},
+		{"ID", "4", 4},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			d, err := getDevice(devices, test.pinned)
+			if err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+			if d.DeviceID != test.expect {
+				t.Errorf("expected device %d, received %d", test.expect, d.DeviceID)
+			}
+		})
+	}
+
+	_, err := getDevice(devices, "Duo")
+	if e, ok := err.(*MFADeviceNotFoundError); !ok || e.Device != "Duo" || len(e.Devices) != len(devices) {
+		t.Errorf("expected MFADeviceNotFoundError for Duo, received %v", err)
+	}
+
+	_, err = getDevice(devices, "Yubico YubiKey")
+	if _, ok := err.(*MFADeviceNotFoundError); err == nil || ok {
+		t.Errorf("expected error for ambiguous device, received %v", err)
+	}
+
+	if _, err = getDevice(nil, "2"); err != ErrNoMFADevice {
+		t.Errorf("expected %v, received %v", ErrNoMFADevice, err)
+	}
+}
+
+func TestReadOTP(t *testing.T) {
+	defer func(f func(string, string) (string, error)) { promptOTP = f }(promptOTP)
+	promptOTP = func(string, string) (string, error) { return "prompted", nil }
+	defer os.Unsetenv(OTPEnvVar)
+
+	for env, expect := range map[string]string{"": "prompted", " 123456 ": "123456"} {
+		os.Setenv(OTPEnvVar, env)
+		otp, err := readOTP("test", "OTP: ")
+		if err != nil {
+			t.Fatalf("unexpected error %+v", err)
+		}
+		if otp != expect {
+			t.Errorf("%s=%q: expected %q, received %q", OTPEnvVar, env, expect, otp)
+		}
+	}
+}
+
+func TestUsername(t *testing.T) {
+	viper.Set("global.non-interactive", true)
+	defer viper.Set("global.non-interactive", false)
+
+	for _, test := range []struct {
+		name        string
+		app         string
+		provider    string
+		expect      string
+		expectError bool
+	}{
+		{"App overrides provider", "app-user@mycompany.com", "user@mycompany.com", "app-user@mycompany.com", false},
+		{"Provider", "", "user@mycompany.com", "user@mycompany.com", false},
+		{"App only", "app-user@mycompany.com", "", "app-user@mycompany.com", false},
+		// The user would be prompted, which is disabled.
+		{"Neither", "", "", "", true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			user, err := username(&config.OneLoginAppConfig{Username: test.app}, &config.OneLoginProviderConfig{Username: test.provider})
+			if test.expectError {
+				if err == nil || !strings.Contains(err.Error(), "OneLogin username") {
+					t.Errorf("expected prompt error, received %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+			if user != test.expect {
+				t.Errorf("expected %q, received %q", test.expect, user)
+			}
+		})
+	}
+}
+
+func TestPasswordKey(t *testing.T) {
+	if key := passwordKey(&config.OneLoginAppConfig{}, "my-provider"); key != "my-provider" {
+		t.Errorf("expected %q, received %q", "my-provider", key)
+	}
+	expect := keychain.UserKey("my-provider", "app-user@mycompany.com")
+	if key := passwordKey(&config.OneLoginAppConfig{Username: "app-user@mycompany.com"}, "my-provider"); key != expect {
+		t.Errorf("expected %q, received %q", expect, key)
+	}
+}
+
+// mockKeychain stores passwords in memory.
+type mockKeychain map[string][]byte
+
+func (k mockKeychain) Get(provider string) ([]byte, error) {
+	pass, ok := k[provider]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return pass, nil
+}
+
+func (k mockKeychain) Set(provider string, password []byte) error {
+	k[provider] = password
+	return nil
+}
+
+func TestKeychainPasswordInteractiveOTP(t *testing.T) {
+	viper.Set("providers.kc-provider", map[string]interface{}{
+		"type":          "onelogin",
+		"client-id":     "id",
+		"client-secret": "secret",
+		"subdomain":     "mycompany",
+		"username":      "user@mycompany.com",
+		"prompt-otp":    true,
+	})
+	viper.Set("apps.kc-app", map[string]interface{}{"app-id": "12345", "provider": "kc-provider"})
+	defer viper.Set("providers.kc-provider", nil)
+	defer viper.Set("apps.kc-app", nil)
+
+	defer func(k keychain.Keychain) { keyChain = k }(keyChain)
+	keyChain = mockKeychain{"kc-provider": []byte("keychain-password")}
+
+	// A stored TOTP key must not be used, the OTP is typed by the user.
+	defer func(f func(string, string) (string, error)) { promptOTP = f }(promptOTP)
+	promptOTP = func(string, string) (string, error) { return "", errors.New("TOTP key used") }
+	defer func(f func(string, string) (string, error)) { promptTypedOTP = f }(promptTypedOTP)
+	prompted := false
+	promptTypedOTP = func(input, message string) (string, error) {
+		prompted = true
+		return "654321", nil
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var resp string
+		switch r.URL.Path {
+		case GenerateTokensPath:
+			resp = `{"access_token": "token"}`
+		case GenerateSamlAssertionPath:
+			var p GenerateSamlAssertionParams
+			if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+				panic(err)
+			}
+			if p.Password != "keychain-password" {
+				t.Errorf("expected the password from the keychain, received %q", p.Password)
+			}
+			resp = `{"message": "MFA is required for this user", "state_token": "state",
+				"devices": [{"device_id": 1, "device_type": "Google Authenticator"}]}`
+		case VerifyFactorPath:
+			var p VerifyFactorParams
+			if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+				panic(err)
+			}
+			if p.OtpToken != "654321" {
+				t.Errorf("expected the OTP entered by the user, received %q", p.OtpToken)
+			}
+			resp = `{"message": "Success", "data": "assertion"}`
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+		}
+		if _, err := w.Write([]byte(resp)); err != nil {
+			panic(err)
+		}
+	}))
+	defer ts.Close()
+
+	defer func(f func(*config.OneLoginProviderConfig) (*Client, error)) { newClient = f }(newClient)
+	newClient = func(p *config.OneLoginProviderConfig) (*Client, error) {
+		c, err := newProviderClient(p)
+		if err != nil {
+			return nil, err
+		}
+		c.Endpoints.base, _ = url.Parse(ts.URL)
+		return c, nil
+	}
+
+	assertion, err := GetSAMLAssertion("kc-app", "kc-provider")
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	if assertion != "assertion" {
+		t.Errorf("expected %q, received %q", "assertion", assertion)
+	}
+	if !prompted {
+		t.Errorf("expected the user to be prompted for the OTP")
+	}
+}
+
+func TestSelectionErrorNotRetried(t *testing.T) {
+	viper.Set("providers.sel-provider", map[string]interface{}{
+		"type":                "onelogin",
+		"client-id":           "id",
+		"client-secret":       "secret",
+		"subdomain":           "mycompany",
+		"username":            "user@mycompany.com",
+		"mfa-retries":         2,
+		"multiple-assertions": "error",
+	})
+	viper.Set("apps.sel-app", map[string]interface{}{"app-id": "12345", "provider": "sel-provider"})
+	defer viper.Set("providers.sel-provider", nil)
+	defer viper.Set("apps.sel-app", nil)
+
+	defer func(k keychain.Keychain) { keyChain = k }(keyChain)
+	keyChain = mockKeychain{"sel-provider": []byte("password")}
+	os.Setenv(OTPEnvVar, "654321")
+	defer os.Unsetenv(OTPEnvVar)
+
+	verifications := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var resp string
+		switch r.URL.Path {
+		case GenerateTokensPath:
+			resp = `{"access_token": "token"}`
+		case GenerateSamlAssertionPath:
+			resp = `{"message": "MFA is required for this user", "state_token": "state",
+				"devices": [{"device_id": 1, "device_type": "Google Authenticator"}]}`
+		case VerifyFactorPath:
+			verifications++
+			resp = `{"message": "Success", "data": ["assertion-1", "assertion-2"]}`
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+		}
+		if _, err := w.Write([]byte(resp)); err != nil {
+			panic(err)
+		}
+	}))
+	defer ts.Close()
+
+	defer func(f func(*config.OneLoginProviderConfig) (*Client, error)) { newClient = f }(newClient)
+	newClient = func(p *config.OneLoginProviderConfig) (*Client, error) {
+		c, err := newProviderClient(p)
+		if err != nil {
+			return nil, err
+		}
+		c.Endpoints.base, _ = url.Parse(ts.URL)
+		return c, nil
+	}
+
+	// Several assertions are an error with this strategy, which verifying MFA again can't fix.
+	if _, err := GetSAMLAssertion("sel-app", "sel-provider"); err == nil {
+		t.Errorf("expected error")
+	}
+	if verifications != 1 {
+		t.Errorf("expected MFA to be verified once, received %d verifications", verifications)
+	}
+}
+
+func TestFilterDevices(t *testing.T) {
+	devices := []Device{
+		{DeviceID: 1001, DeviceType: "Google Authenticator"},
+		{DeviceID: 1002, DeviceType: MFADeviceOneLoginProtect},
+		{DeviceID: 2001, DeviceType: "Yubico YubiKey"},
+		{DeviceID: 2002, DeviceType: "OneLogin SMS"},
+	}
+
+	for _, test := range []struct {
+		filter string
+		expect []int
+	}{
+		{"gauth", []int{1001}},
+		{"GOOGLE", []int{1001}},
+		{"onelogin", []int{1002, 2002}},
+		{"ol protect", []int{1002}},
+		{"yk", []int{2001}},
+		{"100", []int{1001, 1002}},
+		{"2002", []int{2002}},
+		{"xyz", nil},
+	} {
+		t.Run(test.filter, func(t *testing.T) {
+			var ids []int
+			for _, d := range filterDevices(devices, test.filter) {
+				ids = append(ids, d.DeviceID)
+			}
+			if !reflect.DeepEqual(ids, test.expect) {
+				t.Errorf("expected %v, received %v", test.expect, ids)
+			}
+		})
+	}
+}
+
+func TestPushMessageWithoutNumberMatching(t *testing.T) {
+	r := VerifyFactorResponse{Message: "Authentication pending on OL Protect"}
+
+	if m := pushMessage(&r); m != r.Message {
+		t.Errorf("expected %q, received %q", r.Message, m)
+	}
+}
+
+func TestShowPushMessage(t *testing.T) {
+	defer viper.Set("global.quiet", false)
+	defer viper.Set("global.hide-push-message", false)
+
+	for _, test := range []struct {
+		name        string
+		quiet       bool
+		hide        bool
+		matchNumber int
+		expect      bool
+	}{
+		{"Default", false, false, 0, true},
+		{"Quiet", true, false, 0, false},
+		{"Hidden", false, true, 0, false},
+		{"Number matching", false, false, 42, true},
+		{"Number matching, quiet", true, true, 42, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			viper.Set("global.quiet", test.quiet)
+			viper.Set("global.hide-push-message", test.hide)
+
+			r := VerifyFactorResponse{Message: "Authentication pending on OL Protect", MatchNumber: test.matchNumber}
+			if s := showPushMessage(&r); s != test.expect {
+				t.Errorf("expected %t, received %t", test.expect, s)
+			}
+		})
+	}
+}
+
+func TestVerifyWithReselect(t *testing.T) {
+	devices := []Device{
+		{DeviceID: 1, DeviceType: MFADeviceOneLoginProtect},
+		{DeviceID: 2, DeviceType: "Google Authenticator"},
+	}
+	// Select the first of the offered devices.
+	selectFirst := func(devices []Device) (*Device, error) { return &devices[0], nil }
+
+	for _, test := range []struct {
+		name         string
+		reselect     bool
+		failing      map[int]bool
+		expectData   SAMLData
+		expectError  bool
+		expectVerify []int
+	}{
+		{"Success", true, nil, SAMLData{"assertion-1"}, false, []int{1}},
+		{"Reselect after failure", true, map[int]bool{1: true}, SAMLData{"assertion-2"}, false, []int{1, 2}},
+		{"All devices fail", true, map[int]bool{1: true, 2: true}, nil, true, []int{1, 2}},
+		{"Reselect disabled", false, map[int]bool{1: true}, nil, true, []int{1}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var verified []int
+			verify := func(d *Device) (SAMLData, error) {
+				verified = append(verified, d.DeviceID)
+				if test.failing[d.DeviceID] {
+					return nil, errors.New("push denied")
+				}
+				return SAMLData{fmt.Sprintf("assertion-%d", d.DeviceID)}, nil
+			}
+
+			data, err := verifyWithReselect(devices, test.reselect, 0, selectFirst, verify)
+			if test.expectError && err == nil {
+				t.Errorf("expected error")
+			}
+			if !test.expectError && err != nil {
+				t.Errorf("unexpected error %+v", err)
+			}
+			if !reflect.DeepEqual(data, test.expectData) {
+				t.Errorf("expected %q, received %q", test.expectData, data)
+			}
+			if !reflect.DeepEqual(verified, test.expectVerify) {
+				t.Errorf("expected devices %v to be verified, received %v", test.expectVerify, verified)
+			}
+		})
+	}
+}
+
+func TestVerifyWithReselectDeviceErrors(t *testing.T) {
+	verify := func(d *Device) (SAMLData, error) { return SAMLData{"assertion"}, nil }
+
+	_, err := verifyWithReselect(nil, false, 0, func([]Device) (*Device, error) { return getDevice(nil, "") }, verify)
+	if !errors.Is(err, ErrNoMFADevice) {
+		t.Errorf("expected %v, received %v", ErrNoMFADevice, err)
+	}
+
+	devices := []Device{{DeviceID: 1, DeviceType: "Google Authenticator"}}
+	_, err = verifyWithReselect(devices, false, 0, func(d []Device) (*Device, error) { return getDevice(d, "Duo") }, verify)
+	var notFound *MFADeviceNotFoundError
+	if !errors.As(err, &notFound) || notFound.Device != "Duo" {
+		t.Errorf("expected MFADeviceNotFoundError for Duo, received %v", err)
+	}
+}
+
+func TestVerifyWithRetries(t *testing.T) {
+	devices := []Device{
+		{DeviceID: 1, DeviceType: "Google Authenticator"},
+		{DeviceID: 2, DeviceType: "Yubikey"},
+	}
+
+	for _, test := range []struct {
+		name         string
+		retries      int
+		failures     int
+		expectError  bool
+		expectSelect int
+		expectVerify []int
+	}{
+		{"Retry succeeds", 2, 2, false, 1, []int{1, 1, 1}},
+		{"Retries exhausted", 1, 2, true, 1, []int{1, 1}},
+		{"No retries", 0, 1, true, 1, []int{1}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			selections := 0
+			selectFirst := func(devices []Device) (*Device, error) {
+				selections++
+				return &devices[0], nil
+			}
+
+			var verified []int
+			verify := func(d *Device) (SAMLData, error) {
+				verified = append(verified, d.DeviceID)
+				if len(verified) <= test.failures {
+					return nil, errors.New("invalid OTP")
+				}
+				return SAMLData{"assertion"}, nil
+			}
+
+			_, err := verifyWithReselect(devices, false, test.retries, selectFirst, verify)
+			if test.expectError && err == nil {
+				t.Errorf("expected error")
+			}
+			if !test.expectError && err != nil {
+				t.Errorf("unexpected error %+v", err)
+			}
+			// The device is selected once, no matter how often verification is retried.
+			if selections != test.expectSelect {
+				t.Errorf("expected %d device selections, received %d", test.expectSelect, selections)
+			}
+			if !reflect.DeepEqual(verified, test.expectVerify) {
+				t.Errorf("expected devices %v to be verified, received %v", test.expectVerify, verified)
+			}
+		})
+	}
+}
+
+func TestNormalizeBackupCode(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		code        string
+		expect      string
+		expectError bool
+	}{
+		{"Plain", "a1b2c3d4", "a1b2c3d4", false},
+		{"Grouped", " a1b2-c3d4 ", "a1b2c3d4", false},
+		{"Too short", "12345", "", true},
+		{"Invalid characters", "a1b2c3d4!", "", true},
+		{"Empty", "", "", true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := normalizeBackupCode(test.code)
+			if test.expectError && err == nil {
+				t.Errorf("expected error")
+			}
+			if !test.expectError && err != nil {
+				t.Errorf("unexpected error %+v", err)
+			}
+			if res != test.expect {
+				t.Errorf("expected %q, received %q", test.expect, res)
+			}
+		})
+	}
+}
+
+func TestVerifyDeviceBackupCode(t *testing.T) {
+	viper.Set("global.backup-code", true)
+	defer viper.Set("global.backup-code", false)
+	defer func(f func(string, string) ([]byte, error)) { promptBackupCode = f }(promptBackupCode)
+	promptBackupCode = func(string, string) ([]byte, error) { return []byte("a1b2-c3d4"), nil }
+
+	var received []VerifyFactorParams
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p VerifyFactorParams
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			panic(err)
+		}
+		received = append(received, p)
+		_, err := w.Write([]byte(`{"status": {"type": "success", "message": "Success"}, "data": "assertion"}`))
+		if err != nil {
+			panic(err)
+		}
+	}))
+	defer ts.Close()
+
+	c := Client{}
+	c.Endpoints.base, _ = url.Parse(ts.URL)
+
+	// Push is skipped for devices which support it.
+	device := Device{DeviceID: 1, DeviceType: MFADeviceOneLoginProtect}
+	data, err := verifyDevice(&c, spinner.New(), "token", "app", "state", &device, "test", pushFirst)
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	if !reflect.DeepEqual(data, SAMLData{"assertion"}) {
+		t.Errorf("expected %q, received %q", "assertion", data)
+	}
+	if len(received) != 1 || received[0].OtpToken != "a1b2c3d4" || received[0].DoNotNotify {
+		t.Errorf("expected a single verification with backup code a1b2c3d4, received %+v", received)
+	}
+}
+
+// newVerifyServer returns a server responding to MFA verification requests. OTPs are always
+// accepted and pushes are approved when polled if approve is set. The kind of each request (push,
+// poll or otp) is appended to received.
+func newVerifyServer(approve bool, received *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p VerifyFactorParams
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			panic(err)
+		}
+
+		resp := `{"message": "Authentication pending on OL Protect"}`
+		switch {
+		case p.OtpToken != "":
+			*received = append(*received, "otp")
+			resp = `{"message": "Success", "data": "assertion"}`
+		case !p.DoNotNotify:
+			*received = append(*received, "push")
+		default:
+			*received = append(*received, "poll")
+			if approve {
+				resp = `{"message": "Success", "data": "assertion"}`
+			}
+		}
+		if _, err := w.Write([]byte(resp)); err != nil {
+			panic(err)
+		}
+	}))
+}
+
+func TestVerifyDevicePushModes(t *testing.T) {
+	defer func(timeout, interval time.Duration) { pushTimeout, pushInterval = timeout, interval }(pushTimeout, pushInterval)
+	pushTimeout, pushInterval = 3*time.Millisecond, time.Millisecond
+	defer func(f func(string, string) (string, error)) { promptOTP = f }(promptOTP)
+
+	for _, test := range []struct {
+		name        string
+		mode        string
+		approve     bool
+		otp         string
+		expect      []string
+		expectError bool
+	}{
+		{"Push first, approved", pushFirst, true, "123456", []string{"push", "poll"}, false},
+		{"Push first, timed out", pushFirst, false, "123456", []string{"push", "poll", "poll", "poll", "otp"}, false},
+		{"OTP first", otpFirst, false, "123456", []string{"otp"}, false},
+		{"OTP first, no OTP entered", otpFirst, true, "", []string{"push", "poll"}, false},
+		{"Push only, approved", pushOnly, true, "123456", []string{"push", "poll"}, false},
+		{"Push only, timed out", pushOnly, false, "123456", []string{"push", "poll", "poll", "poll"}, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			promptOTP = func(string, string) (string, error) { return test.otp, nil }
+
+			var received []string
+			ts := newVerifyServer(test.approve, &received)
+			defer ts.Close()
+
+			c := Client{}
+			c.Endpoints.base, _ = url.Parse(ts.URL)
+
+			device := Device{DeviceID: 1, DeviceType: MFADeviceOneLoginProtect}
+			data, err := verifyDevice(&c, spinner.New(), "token", "app", "state", &device, "test", test.mode)
+			if test.expectError && err == nil {
+				t.Errorf("expected error")
+			}
+			if !test.expectError && err != nil {
+				t.Errorf("unexpected error %+v", err)
+			}
+			if !test.expectError && !reflect.DeepEqual(data, SAMLData{"assertion"}) {
+				t.Errorf("expected %q, received %q", "assertion", data)
+			}
+			if !reflect.DeepEqual(received, test.expect) {
+				t.Errorf("expected requests %v, received %v", test.expect, received)
+			}
+		})
+	}
+}
+
+func TestVerifyDeviceEmptyOTP(t *testing.T) {
+	defer func(f func(string, string) (string, error)) { promptOTP = f }(promptOTP)
+	defer func(f func() bool) { interactive = f }(interactive)
+
+	for _, test := range []struct {
+		name        string
+		interactive bool
+		otps        []string
+		expect      []string
+		expectError error
+	}{
+		{"Interactive", true, []string{"", "", "123456"}, []string{"otp"}, nil},
+		{"Non-interactive", false, []string{"", "123456"}, nil, ErrEmptyOTP},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			interactive = func() bool { return test.interactive }
+			prompts := 0
+			promptOTP = func(string, string) (string, error) {
+				otp := test.otps[prompts]
+				prompts++
+				return otp, nil
+			}
+
+			var received []string
+			ts := newVerifyServer(false, &received)
+			defer ts.Close()
+
+			c := Client{}
+			c.Endpoints.base, _ = url.Parse(ts.URL)
+
+			device := Device{DeviceID: 1, DeviceType: "Google Authenticator"}
+			_, err := verifyDevice(&c, spinner.New(), "token", "app", "state", &device, "test", pushFirst)
+			if err != test.expectError {
+				t.Errorf("expected error %v, received %v", test.expectError, err)
+			}
+			// An empty OTP is never sent to OneLogin.
+			if !reflect.DeepEqual(received, test.expect) {
+				t.Errorf("expected requests %v, received %v", test.expect, received)
+			}
+			if test.interactive && prompts != len(test.otps) {
+				t.Errorf("expected %d prompts, received %d", len(test.otps), prompts)
+			}
+		})
+	}
+}
+
+func TestDeviceFactors(t *testing.T) {
+	devices := []Device{
+		{DeviceID: 1, DeviceType: "Google Authenticator"},
+		{DeviceID: 2, DeviceType: MFADeviceOneLoginProtect},
+	}
+
+	options := deviceOptions(devices)
+	expect := []Device{
+		{DeviceID: 1, DeviceType: "Google Authenticator"},
+		{DeviceID: 2, DeviceType: MFADeviceOneLoginProtect, Factor: factorPush},
+		{DeviceID: 2, DeviceType: MFADeviceOneLoginProtect, Factor: factorOTP},
+	}
+	if !reflect.DeepEqual(options, expect) {
+		t.Fatalf("expected %v, received %v", expect, options)
+	}
+	if l := deviceLabel(options[2]); l != "2 - OneLogin Protect (OTP)" {
+		t.Errorf("expected label %q, received %q", "2 - OneLogin Protect (OTP)", l)
+	}
+
+	defer func(timeout, interval time.Duration) { pushTimeout, pushInterval = timeout, interval }(pushTimeout, pushInterval)
+	pushTimeout, pushInterval = 3*time.Millisecond, time.Millisecond
+	defer func(f func(string, string) (string, error)) { promptOTP = f }(promptOTP)
+	promptOTP = func(string, string) (string, error) { return "123456", nil }
+
+	// The chosen factor takes precedence over the push mode.
+	for _, test := range []struct {
+		name   string
+		device Device
+		mode   string
+		expect []string
+	}{
+		{"Push", options[1], otpFirst, []string{"push", "poll"}},
+		{"OTP", options[2], pushFirst, []string{"otp"}},
+		{"No factor", devices[1], pushFirst, []string{"push", "poll"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var received []string
+			ts := newVerifyServer(true, &received)
+			defer ts.Close()
+
+			c := Client{}
+			c.Endpoints.base, _ = url.Parse(ts.URL)
+
+			if _, err := verifyDevice(&c, spinner.New(), "token", "app", "state", &test.device, "test", test.mode); err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+			if !reflect.DeepEqual(received, test.expect) {
+				t.Errorf("expected requests %v, received %v", test.expect, received)
+			}
+		})
+	}
+}
+
+func TestPinnedDevicePushFallback(t *testing.T) {
+	defer func(timeout, interval time.Duration) { pushTimeout, pushInterval = timeout, interval }(pushTimeout, pushInterval)
+	pushTimeout, pushInterval = 2*time.Millisecond, time.Millisecond
+	viper.Set("global.non-interactive", true)
+	defer viper.Set("global.non-interactive", false)
+	os.Setenv(OTPEnvVar, "123456")
+	defer os.Unsetenv(OTPEnvVar)
+
+	var received []string
+	ts := newVerifyServer(false, &received)
+	defer ts.Close()
+
+	c := Client{}
+	c.Endpoints.base, _ = url.Parse(ts.URL)
+
+	devices := []Device{
+		{DeviceID: 1, DeviceType: "Google Authenticator"},
+		{DeviceID: 2, DeviceType: MFADeviceOneLoginProtect},
+	}
+	var verified *Device
+	data, err := verifyWithReselect(devices, false, 0,
+		func(d []Device) (*Device, error) { return getDevice(d, MFADeviceOneLoginProtect) },
+		func(d *Device) (SAMLData, error) {
+			verified = d
+			return verifyDevice(&c, spinner.New(), "token", "app", "state", d, "test", pushFirst)
+		},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	if len(data) != 1 || data[0] != "assertion" || verified.DeviceID != 2 {
+		t.Errorf("expected assertion from device 2, received %q from %+v", data, verified)
+	}
+	// The push times out and the OTP is read from the environment instead of prompting.
+	if expect := []string{"push", "poll", "poll", "otp"}; !reflect.DeepEqual(received, expect) {
+		t.Errorf("expected requests %v, received %v", expect, received)
+	}
+}
+
+func TestGenerateWithRetries(t *testing.T) {
+	defer func(d time.Duration) { samlRequestRetryDelay = d }(samlRequestRetryDelay)
+	samlRequestRetryDelay = 0
+
+	for _, test := range []struct {
+		name        string
+		statuses    []int
+		retries     int
+		expectError bool
+		expectCalls int
+	}{
+		{"Transient, then success", []int{http.StatusBadGateway, http.StatusOK}, 1, false, 2},
+		// Rate limiting is retried by the client only.
+		{"Rate limited, then success", []int{http.StatusTooManyRequests, http.StatusOK}, 2, false, 2},
+		{"Rate limited persistently", []int{http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusOK}, 2, true, 3},
+		{"Transient failures exceed retries", []int{http.StatusBadGateway, http.StatusServiceUnavailable}, 1, true, 2},
+		{"Invalid credentials", []int{http.StatusUnauthorized, http.StatusOK}, 1, true, 1},
+		{"Retries disabled", []int{http.StatusBadGateway, http.StatusOK}, 0, true, 1},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			calls := 0
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(test.statuses[calls])
+				calls++
+				if _, err := w.Write([]byte(`{"message": "Success", "data": "assertion"}`)); err != nil {
+					panic(err)
+				}
+			}))
+			defer ts.Close()
+
+			// The client retries only the failures of the assertion request which it provably
+			// didn't process.
+			c := Client{attempts: 3}
+			c.Endpoints.base, _ = url.Parse(ts.URL)
+
+			resp, err := generateWithRetries(test.retries, func() (*GenerateSamlAssertionResponse, error) {
+				return c.GenerateSamlAssertion("token", &GenerateSamlAssertionParams{})
+			})
+			if test.expectError && err == nil {
+				t.Errorf("expected error")
+			}
+			if !test.expectError && (err != nil || resp.Message != "Success") {
+				t.Errorf("unexpected error %+v", err)
+			}
+			if calls != test.expectCalls {
+				t.Errorf("expected %d requests, received %d", test.expectCalls, calls)
+			}
+		})
+	}
+}
+
+func TestSelectAssertion(t *testing.T) {
+	var data SAMLData
+	for _, f := range []string{"valid-response", "single-arn-response"} {
+		b, err := ioutil.ReadFile("../saml/testdata/" + f)
+		if err != nil {
+			t.Fatalf("could not read test data: %v", err)
+		}
+		data = append(data, string(b))
+	}
+	viper.Set("global.non-interactive", true)
+	defer viper.Set("global.non-interactive", false)
+
+	for _, test := range []struct {
+		name        string
+		data        SAMLData
+		strategy    string
+		arn         string
+		expect      string
+		expectError bool
+	}{
+		{"Single assertion", data[1:], "error", "", data[1], false},
+		{"No assertion", nil, "first", "", "", true},
+		{"Error", data, "error", "", "", true},
+		{"First", data, "first", "", data[0], false},
+		{"ARN", data, "arn", "arn:aws:iam::123456789012:role/OneLogin-MyRole", data[1], false},
+		{"ARN without match", data, "arn", "arn:aws:iam::123456789012:role/Other", "", true},
+		{"ARN not configured", data, "arn", "", "", true},
+		// The prompt fails in non-interactive mode.
+		{"Prompt", data, "prompt", "", "", true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := selectAssertion(test.data, test.strategy, test.arn)
+			if test.expectError && err == nil {
+				t.Errorf("expected error")
+			}
+			if !test.expectError && err != nil {
+				t.Errorf("unexpected error %+v", err)
+			}
+			if res != test.expect {
+				t.Errorf("expected assertion %d bytes long, received %d bytes", len(test.expect), len(res))
+			}
+		})
+	}
+}
@@ -0,0 +1,309 @@
+package onelogin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// redacted replaces secrets in recordings.
+const redacted = "REDACTED"
+
+// secretFields are the fields of request and response bodies whose values are replaced by
+// redacted in recordings. The data field is only redacted if it holds a string, i.e. the SAML
+// assertion rather than a list of MFA devices.
+var secretFields = map[string]bool{
+	"access_token":      true,
+	"client_secret":     true,
+	"otp_token":         true,
+	"password":          true,
+	"refresh_token":     true,
+	"state_token":       true,
+	"username_or_email": true,
+}
+
+// secretHeaders are the request headers whose values are replaced by redacted in recordings.
+var secretHeaders = []string{"Authorization", "Cookie"}
+
+// secretResponseHeaders are the response headers whose values are replaced by redacted in
+// recordings.
+var secretResponseHeaders = []string{"Set-Cookie"}
+
+// secretQueryParams are the query parameters of request URLs whose values are replaced by redacted
+// in recordings, e.g. the user's email address when looking up the user.
+var secretQueryParams = []string{"email"}
+
+// Interaction is an HTTP request to the OneLogin API and the response to it, with secrets redacted.
+type Interaction struct {
+	Method         string      `json:"method"`
+	URL            string      `json:"url"`
+	RequestHeader  http.Header `json:"request_header,omitempty"`
+	RequestBody    string      `json:"request_body,omitempty"`
+	StatusCode     int         `json:"status_code"`
+	ResponseHeader http.Header `json:"response_header,omitempty"`
+	ResponseBody   string      `json:"response_body,omitempty"`
+}
+
+// Recording holds the interactions of a Client with the OneLogin API in the order they happened.
+type Recording struct {
+	Interactions []Interaction `json:"interactions"`
+
+	mu sync.Mutex
+}
+
+// WriteFile writes the recording as JSON to the file at path, which is only readable by the user.
+func (r *Recording) WriteFile(path string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding recording: %v", err)
+	}
+
+	if err = ioutil.WriteFile(path, append(b, '\n'), 0600); err != nil {
+		return fmt.Errorf("writing recording: %v", err)
+	}
+
+	return nil
+}
+
+// ReadRecording reads a recording written by Recording.WriteFile.
+func ReadRecording(path string) (*Recording, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading recording: %v", err)
+	}
+
+	var r Recording
+	if err = json.Unmarshal(b, &r); err != nil {
+		return nil, fmt.Errorf("decoding recording %s: %v", path, err)
+	}
+
+	return &r, nil
+}
+
+// Record makes c record its requests and their responses, with secrets redacted, to the returned
+// Recording.
+func (c *Client) Record() *Recording {
+	r := &Recording{}
+	next := c.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	c.Transport = &recordingTransport{next: next, rec: r}
+
+	return r
+}
+
+// Replay makes c answer its requests with the responses of r, in order, instead of contacting
+// OneLogin. A request which doesn't match the method and path of the next recorded request fails.
+// Retries aren't delayed, so that a recorded session replays quickly.
+func (c *Client) Replay(r *Recording) {
+	c.Transport = &replayTransport{interactions: r.Interactions}
+	c.backoff = 0
+	c.maintenanceBackoff = 0
+	c.limiter = nil
+}
+
+type recordingTransport struct {
+	next http.RoundTripper
+	rec  *Recording
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		var err error
+		if reqBody, err = ioutil.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = ioutil.NopCloser(bytes.NewReader(reqBody))
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		// Network errors can't be replayed and aren't recorded.
+		return nil, err
+	}
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+
+	i := Interaction{
+		Method:         req.Method,
+		URL:            redactURI(req.URL),
+		RequestHeader:  redactHeader(req.Header, secretHeaders),
+		RequestBody:    redactBody(reqBody),
+		StatusCode:     resp.StatusCode,
+		ResponseHeader: redactHeader(resp.Header, secretResponseHeaders),
+		ResponseBody:   redactBody(respBody),
+	}
+	t.rec.mu.Lock()
+	t.rec.Interactions = append(t.rec.Interactions, i)
+	t.rec.mu.Unlock()
+
+	return resp, nil
+}
+
+type replayTransport struct {
+	mu           sync.Mutex
+	interactions []Interaction
+	next         int
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.next >= len(t.interactions) {
+		return nil, fmt.Errorf("replay: unexpected request %s %s after the end of the recording", req.Method, req.URL.Path)
+	}
+	i := t.interactions[t.next]
+	if i.Method != req.Method || pathOf(i.URL) != req.URL.Path {
+		return nil, fmt.Errorf("replay: unexpected request %s %s, recorded request %d is %s %s",
+			req.Method, req.URL.Path, t.next+1, i.Method, pathOf(i.URL))
+	}
+	t.next++
+
+	header := i.ResponseHeader.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", i.StatusCode, http.StatusText(i.StatusCode)),
+		StatusCode:    i.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader([]byte(i.ResponseBody))),
+		ContentLength: int64(len(i.ResponseBody)),
+		Request:       req,
+	}, nil
+}
+
+// pathOf returns the path of a recorded request URI, without the query.
+func pathOf(uri string) string {
+	if i := strings.IndexByte(uri, '?'); i >= 0 {
+		return uri[:i]
+	}
+
+	return uri
+}
+
+// redactHeader returns a copy of h with the values of the given headers redacted.
+func redactHeader(h http.Header, secrets []string) http.Header {
+	h = h.Clone()
+	for _, k := range secrets {
+		if h.Get(k) != "" {
+			h.Set(k, redacted)
+		}
+	}
+
+	return h
+}
+
+// redactURI returns the request URI of u with the values of secretQueryParams redacted.
+func redactURI(u *url.URL) string {
+	q := u.Query()
+	found := false
+	for _, k := range secretQueryParams {
+		if q.Get(k) != "" {
+			q.Set(k, redacted)
+			found = true
+		}
+	}
+	if !found {
+		return u.RequestURI()
+	}
+
+	r := *u
+	r.RawQuery = q.Encode()
+
+	return r.RequestURI()
+}
+
+// redactBody returns body with the values of secretFields, and a data field holding a SAML
+// assertion or a list of them, redacted at any depth if body is JSON. Other bodies are returned as
+// is.
+func redactBody(body []byte) string {
+	// Numbers are kept as is rather than converted to floats, which could change large IDs.
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.UseNumber()
+	var v interface{}
+	if len(body) == 0 || d.Decode(&v) != nil {
+		return string(body)
+	}
+
+	b, err := json.Marshal(redactValue(v))
+	if err != nil {
+		return string(body)
+	}
+
+	return string(b)
+}
+
+func redactValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			if secretFields[k] {
+				v[k] = redacted
+				continue
+			}
+			if k == "data" {
+				if r, ok := redactAssertions(e); ok {
+					v[k] = r
+					continue
+				}
+			}
+			v[k] = redactValue(e)
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = redactValue(e)
+		}
+	}
+
+	return v
+}
+
+// redactAssertions redacts the value of a data field if it holds assertions, i.e. a string or a
+// list of strings, keeping the number of assertions. Other values aren't changed and false is
+// returned.
+func redactAssertions(v interface{}) (interface{}, bool) {
+	switch v := v.(type) {
+	case string:
+		return redacted, true
+	case []interface{}:
+		if len(v) == 0 {
+			return v, false
+		}
+		r := make([]interface{}, len(v))
+		for i, e := range v {
+			if _, ok := e.(string); !ok {
+				return v, false
+			}
+			r[i] = redacted
+		}
+		return r, true
+	}
+
+	return v, false
+}
@@ -0,0 +1,179 @@
+package onelogin
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/allcloud-io/clisso/config"
+	"github.com/allcloud-io/clisso/keychain"
+)
+
+func TestRedactBody(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		body   string
+		expect string
+	}{
+		{"Token", `{"access_token":"secret","expires_in":36000}`, `{"access_token":"REDACTED","expires_in":36000}`},
+		{"Nested", `{"data":[{"state_token":"secret","devices":[{"device_id":123456789012}]}]}`,
+			`{"data":[{"devices":[{"device_id":123456789012}],"state_token":"REDACTED"}]}`},
+		{"Assertion", `{"message":"Success","data":"PHNhbWw+"}`, `{"data":"REDACTED","message":"Success"}`},
+		{"Assertions", `{"data":["a","b"]}`, `{"data":["REDACTED","REDACTED"]}`},
+		{"Not JSON", "Service Unavailable", "Service Unavailable"},
+		{"Empty", "", ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if s := redactBody([]byte(test.body)); s != test.expect {
+				t.Errorf("expected %s, received %s", test.expect, s)
+			}
+		})
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	viper.Set("providers.rec-provider", map[string]interface{}{
+		"type":          "onelogin",
+		"client-id":     "client-id-value",
+		"client-secret": "client-secret-value",
+		"subdomain":     "mycompany",
+		"username":      "user@mycompany.com",
+	})
+	viper.Set("apps.rec-app", map[string]interface{}{"app-id": "12345", "provider": "rec-provider"})
+	defer viper.Set("providers.rec-provider", nil)
+	defer viper.Set("apps.rec-app", nil)
+
+	defer func(k keychain.Keychain) { keyChain = k }(keyChain)
+	keyChain = mockKeychain{"rec-provider": []byte("password-value")}
+	defer func(f func(string, string) (string, error)) { promptOTP = f }(promptOTP)
+	promptOTP = func(string, string) (string, error) { return "otp-value", nil }
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var resp string
+		switch r.URL.Path {
+		case GenerateTokensPath:
+			resp = `{"access_token": "access-token-value", "refresh_token": "refresh-token-value"}`
+		case GenerateSamlAssertionPath:
+			resp = `{"message": "MFA is required for this user", "state_token": "state-token-value",
+				"devices": [{"device_id": 1, "device_type": "Google Authenticator"}]}`
+		case VerifyFactorPath:
+			resp = `{"message": "Success", "data": "assertion-value"}`
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+		}
+		if _, err := w.Write([]byte(resp)); err != nil {
+			panic(err)
+		}
+	}))
+	defer ts.Close()
+
+	defer func(f func(*config.OneLoginProviderConfig) (*Client, error)) { newClient = f }(newClient)
+	newClient = func(p *config.OneLoginProviderConfig) (*Client, error) {
+		c, err := newProviderClient(p)
+		if err != nil {
+			return nil, err
+		}
+		c.Endpoints.base, _ = url.Parse(ts.URL)
+		return c, nil
+	}
+
+	dir, err := ioutil.TempDir("", "clisso-recording")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "recording.json")
+
+	viper.Set("global.record", path)
+	assertion, err := GetSAMLAssertion("rec-app", "rec-provider")
+	viper.Set("global.record", "")
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	if assertion != "assertion-value" {
+		t.Errorf("expected %q, received %q", "assertion-value", assertion)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{
+		"client-secret-value", "password-value", "otp-value", "access-token-value",
+		"refresh-token-value", "state-token-value", "assertion-value",
+	} {
+		if strings.Contains(string(b), secret) {
+			t.Errorf("recording contains secret %q", secret)
+		}
+	}
+
+	// The recording replays the same flow without contacting OneLogin, every time.
+	ts.Close()
+	viper.Set("global.replay", path)
+	defer viper.Set("global.replay", "")
+	for i := 0; i < 2; i++ {
+		assertion, err = GetSAMLAssertion("rec-app", "rec-provider")
+		if err != nil {
+			t.Fatalf("replay %d: unexpected error %+v", i+1, err)
+		}
+		if assertion != redacted {
+			t.Errorf("replay %d: expected the redacted assertion, received %q", i+1, assertion)
+		}
+	}
+}
+
+func TestRecordRedactsHeadersAndQuery(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "cookie-value"})
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write([]byte(`{"data": []}`)); err != nil {
+			panic(err)
+		}
+	}))
+	defer ts.Close()
+
+	rec := &Recording{}
+	c := http.Client{Transport: &recordingTransport{next: http.DefaultTransport, rec: rec}}
+	resp, err := c.Get(ts.URL + "/api/2/users?email=" + url.QueryEscape("user@mycompany.com"))
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	resp.Body.Close()
+
+	if len(rec.Interactions) != 1 {
+		t.Fatalf("expected 1 interaction, received %d", len(rec.Interactions))
+	}
+	i := rec.Interactions[0]
+	if expect := "/api/2/users?email=" + redacted; i.URL != expect {
+		t.Errorf("expected %q, received %q", expect, i.URL)
+	}
+	if c := i.ResponseHeader.Get("Set-Cookie"); c != redacted {
+		t.Errorf("expected %q, received %q", redacted, c)
+	}
+	// Other headers are kept.
+	if ct := i.ResponseHeader.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected %q, received %q", "application/json", ct)
+	}
+}
+
+func TestReplayUnexpectedRequest(t *testing.T) {
+	c, err := NewClient("US")
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	c.Replay(&Recording{Interactions: []Interaction{
+		{Method: http.MethodPost, URL: GenerateTokensPath, StatusCode: 200, ResponseBody: `{"access_token": "REDACTED"}`},
+	}})
+
+	if _, err = c.GenerateSamlAssertion("token", &GenerateSamlAssertionParams{}); err == nil ||
+		!strings.Contains(err.Error(), "unexpected request") {
+		t.Errorf("expected error for request not matching the recording, received %v", err)
+	}
+}
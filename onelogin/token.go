@@ -0,0 +1,92 @@
+package onelogin
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/allcloud-io/clisso/config"
+	"github.com/allcloud-io/clisso/spinner"
+)
+
+// JWT holds the decoded header and claims of a JSON Web Token. The signature isn't verified.
+type JWT struct {
+	Header map[string]interface{}
+	Claims map[string]interface{}
+}
+
+// ParseJWT decodes the header and claims of a JWT without verifying its signature. An error is
+// returned if token isn't a JWT, e.g. because it is an opaque token.
+func ParseJWT(token string) (*JWT, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("token isn't a JWT")
+	}
+
+	var j JWT
+	if err := decodeJWTPart(parts[0], &j.Header); err != nil {
+		return nil, fmt.Errorf("decoding JWT header: %v", err)
+	}
+	if err := decodeJWTPart(parts[1], &j.Claims); err != nil {
+		return nil, fmt.Errorf("decoding JWT claims: %v", err)
+	}
+
+	return &j, nil
+}
+
+// decodeJWTPart decodes a base64url-encoded JSON part of a JWT into v.
+func decodeJWTPart(part string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(part, "="))
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
+
+// Expiry returns the time of the exp claim of the token, if it has one.
+func (j *JWT) Expiry() (time.Time, bool) {
+	exp, ok := j.Claims["exp"].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	return time.Unix(int64(exp), 0), true
+}
+
+// Expiry returns the time at which the access token of the response expires.
+func (r *GenerateTokensResponse) Expiry() time.Time {
+	created := r.CreatedAt
+	if created.IsZero() {
+		created = time.Now()
+	}
+
+	return created.Add(time.Duration(r.ExpiresIn) * time.Second)
+}
+
+// GetToken generates an access token for the OneLogin API using the API credentials of provider,
+// e.g. for debugging scoping and expiry problems.
+func GetToken(provider string) (*GenerateTokensResponse, error) {
+	p, err := config.GetOneLoginProvider(provider)
+	if err != nil {
+		return nil, fmt.Errorf("reading provider config: %v", err)
+	}
+
+	c, err := newProviderClient(p)
+	if err != nil {
+		return nil, err
+	}
+
+	s := spinner.New()
+	s.Start()
+	resp, err := c.RequestToken(p.ClientID, p.ClientSecret)
+	s.Stop()
+	if err != nil {
+		return nil, fmt.Errorf("generating access token: %s", err)
+	}
+
+	return resp, nil
+}
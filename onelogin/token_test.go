@@ -0,0 +1,44 @@
+package onelogin
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func TestParseJWT(t *testing.T) {
+	encode := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+
+	for _, test := range []struct {
+		name        string
+		token       string
+		expectError bool
+		expectExp   bool
+	}{
+		{"JWT", encode(`{"alg":"RS256"}`) + "." + encode(`{"sub":"client","exp":1614861000}`) + ".sig", false, true},
+		{"JWT without exp", encode(`{"alg":"RS256"}`) + "." + encode(`{"sub":"client"}`) + ".sig", false, false},
+		{"Opaque token", "0123456789abcdef", true, false},
+		{"Invalid claims", encode(`{"alg":"RS256"}`) + ".not-json.sig", true, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			jwt, err := ParseJWT(test.token)
+			if test.expectError && err == nil {
+				t.Errorf("expected error")
+			}
+			if !test.expectError && err != nil {
+				t.Errorf("unexpected error %+v", err)
+			}
+			if err != nil {
+				return
+			}
+
+			exp, ok := jwt.Expiry()
+			if ok != test.expectExp {
+				t.Fatalf("expected exp claim: %v, received: %v", test.expectExp, ok)
+			}
+			if ok && !exp.Equal(time.Unix(1614861000, 0)) {
+				t.Errorf("expected expiry %v, received %v", time.Unix(1614861000, 0), exp)
+			}
+		})
+	}
+}
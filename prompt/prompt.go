@@ -0,0 +1,158 @@
+// Package prompt reads input from the user. When prompting is disabled using the
+// global.non-interactive setting, every prompt fails immediately instead of blocking on stdin.
+package prompt
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/viper"
+	"golang.org/x/term"
+)
+
+// readPassword reads input from the terminal without echoing it. It's replaced in tests.
+var readPassword = term.ReadPassword
+
+// Check returns an error if prompting is disabled. input describes the input which would have been
+// requested from the user.
+func Check(input string) error {
+	if viper.GetBool("global.non-interactive") {
+		return fmt.Errorf("%s is required but prompting is disabled (non-interactive mode)", input)
+	}
+
+	return nil
+}
+
+// Interactive reports whether the user can be asked for input again, e.g. after entering an
+// invalid value. This is the case only if prompting is enabled and stdin is a terminal.
+func Interactive() bool {
+	return !viper.GetBool("global.non-interactive") && term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// Optional reports whether optional input, which has a sensible default, should be asked for. This
+// is the case only if prompting is enabled, quiet mode is off and stdin is a terminal, so that
+// unattended runs never block on such prompts.
+func Optional() bool {
+	return !viper.GetBool("global.non-interactive") && !viper.GetBool("global.quiet") &&
+		term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// Line prints message and reads a line of input from the user.
+func Line(input, message string) (string, error) {
+	if err := Check(input); err != nil {
+		return "", err
+	}
+
+	fmt.Print(message)
+
+	return readLine(os.Stdin)
+}
+
+// readLine reads a line from r, without the line terminator and surrounding whitespace. Input is
+// read one byte at a time so that nothing beyond the line is consumed, leaving further input to
+// subsequent prompts.
+func readLine(r io.Reader) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := r.Read(b)
+		if n == 1 {
+			if b[0] == '\n' {
+				break
+			}
+			line = append(line, b[0])
+			continue
+		}
+		if err == io.EOF {
+			if len(line) == 0 {
+				return "", fmt.Errorf("reading input: %v", err)
+			}
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("reading input: %v", err)
+		}
+	}
+
+	return strings.TrimSpace(string(line)), nil
+}
+
+// Password prints message and reads a password from the terminal without echoing it.
+func Password(input, message string) ([]byte, error) {
+	if err := Check(input); err != nil {
+		return nil, err
+	}
+
+	fmt.Print(message)
+	pass, err := readPassword(int(syscall.Stdin))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read password from terminal: %w", err)
+	}
+
+	return pass, nil
+}
+
+// OTP prints message and reads a one-time password. The OTP is visible while typing unless
+// global.hide-otp is set, in which case it's read like a password. If the OTP can't be read without
+// echoing it, e.g. because stdin isn't a terminal, it's read as a visible line instead.
+func OTP(input, message string) (string, error) {
+	if err := Check(input); err != nil {
+		return "", err
+	}
+
+	fmt.Print(message)
+	if !viper.GetBool("global.hide-otp") {
+		return readLine(os.Stdin)
+	}
+
+	otp, err := readPassword(int(syscall.Stdin))
+	if err != nil {
+		return readLine(os.Stdin)
+	}
+	// The newline typed by the user isn't echoed either.
+	fmt.Println()
+
+	return strings.TrimSpace(string(otp)), nil
+}
+
+// Select prints options and asks the user to choose one of them until a valid choice is made. The
+// index of the selected option is returned.
+func Select(input, message string, options []string) (int, error) {
+	if err := Check(input); err != nil {
+		return 0, err
+	}
+
+	for {
+		for i, o := range options {
+			// Use one-based indexing for human-friendliness.
+			fmt.Printf("%d. %s\n", i+1, o)
+		}
+
+		fmt.Printf("%s (1-%d): ", message, len(options))
+		var s string
+		if _, err := fmt.Scanln(&s); err != nil {
+			if err == io.EOF {
+				return 0, fmt.Errorf("reading %s: %v", input, err)
+			}
+			fmt.Printf("Error reading input: %v\n", err)
+			continue
+		}
+
+		selected, err := strconv.Atoi(s)
+		if err != nil {
+			fmt.Printf("Invalid input '%s'\n", s)
+			continue
+		}
+
+		if selected < 1 || selected > len(options) {
+			fmt.Printf("Invalid value %d. Valid values: 1-%d\n", selected, len(options))
+			continue
+		}
+
+		return selected - 1, nil
+	}
+}
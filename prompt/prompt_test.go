@@ -0,0 +1,152 @@
+package prompt
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestNonInteractive(t *testing.T) {
+	viper.Set("global.non-interactive", true)
+	defer viper.Set("global.non-interactive", false)
+
+	if _, err := Line("OneLogin username", "OneLogin username: "); err == nil ||
+		!strings.Contains(err.Error(), "OneLogin username") {
+		t.Errorf("Line: expected error naming the input, got %v", err)
+	}
+
+	if _, err := Password("password for provider 'p'", "Please enter p password: "); err == nil ||
+		!strings.Contains(err.Error(), "password for provider 'p'") {
+		t.Errorf("Password: expected error naming the input, got %v", err)
+	}
+}
+
+func TestCheckInteractive(t *testing.T) {
+	viper.Set("global.non-interactive", false)
+
+	if err := Check("OTP"); err != nil {
+		t.Errorf("unexpected error %+v", err)
+	}
+}
+
+func TestSelect(t *testing.T) {
+	viper.Set("global.non-interactive", false)
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(stdin, stdout *os.File) { os.Stdin, os.Stdout = stdin, stdout }(os.Stdin, os.Stdout)
+	os.Stdin = r
+	os.Stdout, _ = os.Open(os.DevNull)
+
+	// Invalid choices are asked again.
+	if _, err := w.WriteString("x\n0\n2\n"); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	i, err := Select("region", "Please select a region", []string{"us-east-1", "eu-west-1"})
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	if i != 1 {
+		t.Errorf("expected 1, received %d", i)
+	}
+
+	// Running out of input doesn't loop forever.
+	if _, err := Select("region", "Please select a region", []string{"us-east-1", "eu-west-1"}); err == nil {
+		t.Errorf("expected error at end of input")
+	}
+}
+
+func TestReadLine(t *testing.T) {
+	r := strings.NewReader("ol protect\r\nsecond\nlast")
+
+	for _, expect := range []string{"ol protect", "second", "last"} {
+		s, err := readLine(r)
+		if err != nil {
+			t.Fatalf("unexpected error %+v", err)
+		}
+		if s != expect {
+			t.Errorf("expected %q, received %q", expect, s)
+		}
+	}
+
+	if _, err := readLine(r); err == nil {
+		t.Errorf("expected error at end of input")
+	}
+}
+
+func TestOTPHidden(t *testing.T) {
+	viper.Set("global.non-interactive", false)
+	viper.Set("global.hide-otp", true)
+	defer viper.Set("global.hide-otp", false)
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(stdin, stdout *os.File) { os.Stdin, os.Stdout = stdin, stdout }(os.Stdin, os.Stdout)
+	os.Stdin = r
+	os.Stdout, _ = os.Open(os.DevNull)
+	if _, err := w.WriteString("654321\n"); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	defer func(f func(int) ([]byte, error)) { readPassword = f }(readPassword)
+	readPassword = func(int) ([]byte, error) { return []byte(" 123456 "), nil }
+
+	otp, err := OTP("OTP", "Please enter the OTP: ")
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	if otp != "123456" {
+		t.Errorf("expected the OTP read without echo, received %q", otp)
+	}
+
+	// The OTP is read as a line if it can't be read without echo.
+	readPassword = func(int) ([]byte, error) { return nil, errors.New("not a terminal") }
+
+	otp, err = OTP("OTP", "Please enter the OTP: ")
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	if otp != "654321" {
+		t.Errorf("expected the OTP read as a line, received %q", otp)
+	}
+}
+
+func TestOTPVisible(t *testing.T) {
+	viper.Set("global.non-interactive", false)
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(stdin, stdout *os.File) { os.Stdin, os.Stdout = stdin, stdout }(os.Stdin, os.Stdout)
+	os.Stdin = r
+	os.Stdout, _ = os.Open(os.DevNull)
+	if _, err := w.WriteString("123456\n"); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	defer func(f func(int) ([]byte, error)) { readPassword = f }(readPassword)
+	readPassword = func(int) ([]byte, error) {
+		t.Fatal("OTP read without echo although hide-otp isn't set")
+		return nil, nil
+	}
+
+	otp, err := OTP("OTP", "Please enter the OTP: ")
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	if otp != "123456" {
+		t.Errorf("expected 123456, received %q", otp)
+	}
+}
@@ -8,9 +8,12 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/edaniels/go-saml"
 	"github.com/spf13/viper"
+
+	"github.com/allcloud-io/clisso/prompt"
 )
 
 type ARN struct {
@@ -19,7 +22,32 @@ type ARN struct {
 	Name     string
 }
 
+// ErrNoRoles is returned when the SAML response data contains no valid AWS roles.
+var ErrNoRoles = errors.New("no valid AWS roles were returned")
+
+// ErrNoAllowedRoles is returned by Select when none of the roles in the SAML response data is
+// allowed.
+var ErrNoAllowedRoles = errors.New("none of the AWS roles returned is allowed")
+
+// Preferences narrow down the roles of a SAML assertion Select chooses from.
+type Preferences struct {
+	// Role is the ARN of the role to select, if any.
+	Role string
+	// Provider is the ARN of the IAM SAML provider to assume the role with, if any. This matters
+	// if the assertion contains the same role for several providers, whose trust policies may
+	// differ.
+	Provider string
+	// Allowed are the ARNs of the roles which may be selected. Any role may be selected if empty.
+	Allowed []string
+}
+
 func Get(data, pArn string) (a ARN, err error) {
+	return Select(data, Preferences{Role: pArn})
+}
+
+// Select returns the role of the SAML response data matching p. If several roles match, the user
+// is asked to choose one of them.
+func Select(data string, p Preferences) (a ARN, err error) {
 	samlBody, err := decode(data)
 	if err != nil {
 		return
@@ -31,11 +59,25 @@ func Get(data, pArn string) (a ARN, err error) {
 		return
 	}
 
-	arns := extractArns(x.Assertion.AttributeStatement.Attributes, pArn)
+	arns := extractArns(x.Assertion.AttributeStatement.Attributes, p.Role)
+	if len(arns) > 0 && len(p.Allowed) > 0 {
+		if arns = FilterRoles(arns, p.Allowed); len(arns) == 0 {
+			err = ErrNoAllowedRoles
+
+			return
+		}
+	}
+	if len(arns) > 0 && p.Provider != "" {
+		if arns = FilterProvider(arns, p.Provider); len(arns) == 0 {
+			err = fmt.Errorf("none of the AWS roles returned can be assumed using the SAML provider %s", p.Provider)
+
+			return
+		}
+	}
 
 	switch len(arns) {
 	case 0:
-		err = errors.New("no valid AWS roles were returned")
+		err = ErrNoRoles
 
 		return
 
@@ -46,11 +88,245 @@ func Get(data, pArn string) (a ARN, err error) {
 	}
 
 	// Multiple ARNs returned - ask user which one to use.
-	a = arns[ask(arns)]
+	idx, err := ask(arns)
+	if err != nil {
+		return
+	}
+	a = arns[idx]
 
 	return
 }
 
+// CheckIssuer returns an error if the issuer of the assertion contained in the SAML response data
+// differs from expected.
+func CheckIssuer(data, expected string) error {
+	samlBody, err := decode(data)
+	if err != nil {
+		return err
+	}
+
+	x := new(saml.Response)
+	if err = xml.Unmarshal(samlBody, x); err != nil {
+		return err
+	}
+
+	if x.Assertion.Issuer == nil {
+		return fmt.Errorf("SAML assertion has no issuer, expected '%s'", expected)
+	}
+
+	if issuer := strings.TrimSpace(x.Assertion.Issuer.Value); issuer != expected {
+		return fmt.Errorf("SAML assertion issued by '%s', expected '%s'", issuer, expected)
+	}
+
+	return nil
+}
+
+// AWSAudience is the audience of SAML assertions issued for AWS.
+const AWSAudience = "urn:amazon:webservices"
+
+// audienceResponse contains the audience restrictions of a SAML response. The upstream schema
+// supports a single audience per restriction only.
+type audienceResponse struct {
+	Assertion struct {
+		Conditions struct {
+			AudienceRestrictions []struct {
+				Audiences []string `xml:"Audience"`
+			} `xml:"AudienceRestriction"`
+		}
+	}
+}
+
+// CheckAudience returns an error if the assertion contained in the SAML response data isn't
+// restricted to the expected audience. Each audience restriction of the assertion has to include
+// the expected audience.
+func CheckAudience(data, expected string) error {
+	samlBody, err := decode(data)
+	if err != nil {
+		return err
+	}
+
+	x := new(audienceResponse)
+	if err = xml.Unmarshal(samlBody, x); err != nil {
+		return err
+	}
+
+	restrictions := x.Assertion.Conditions.AudienceRestrictions
+	if len(restrictions) == 0 {
+		return fmt.Errorf("SAML assertion has no audience restriction, expected '%s'", expected)
+	}
+
+	for _, r := range restrictions {
+		found := false
+		for _, a := range r.Audiences {
+			if strings.TrimSpace(a) == expected {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("SAML assertion issued for audience '%s', expected '%s'",
+				strings.Join(r.Audiences, "', '"), expected)
+		}
+	}
+
+	return nil
+}
+
+// validityResponse contains the times at which a SAML assertion becomes valid and expires.
+type validityResponse struct {
+	Assertion struct {
+		Subject struct {
+			SubjectConfirmations []struct {
+				Data struct {
+					NotBefore    string `xml:",attr"`
+					NotOnOrAfter string `xml:",attr"`
+				} `xml:"SubjectConfirmationData"`
+			} `xml:"SubjectConfirmation"`
+		}
+		Conditions struct {
+			NotBefore    string `xml:",attr"`
+			NotOnOrAfter string `xml:",attr"`
+		}
+	}
+}
+
+// CheckValidity returns an error if the assertion contained in the SAML response data isn't valid
+// at now according to the NotBefore and NotOnOrAfter times of its conditions and subject
+// confirmations. skew is the difference between the local clock and that of the identity provider
+// which is tolerated. Since an assertion which is checked right after it was issued can only be
+// invalid if a clock is wrong, the error suggests checking the clock.
+func CheckValidity(data string, now time.Time, skew time.Duration) error {
+	samlBody, err := decode(data)
+	if err != nil {
+		return err
+	}
+
+	x := new(validityResponse)
+	if err = xml.Unmarshal(samlBody, x); err != nil {
+		return err
+	}
+
+	notBefore := []string{x.Assertion.Conditions.NotBefore}
+	notOnOrAfter := []string{x.Assertion.Conditions.NotOnOrAfter}
+	for _, c := range x.Assertion.Subject.SubjectConfirmations {
+		notBefore = append(notBefore, c.Data.NotBefore)
+		notOnOrAfter = append(notOnOrAfter, c.Data.NotOnOrAfter)
+	}
+
+	for _, s := range notBefore {
+		t, err := parseTime(s)
+		if err != nil {
+			return err
+		}
+		if !t.IsZero() && now.Add(skew).Before(t) {
+			return fmt.Errorf("SAML assertion not yet valid: valid from %s, local time is %s - check your clock",
+				t.UTC().Format(time.RFC3339), now.UTC().Format(time.RFC3339))
+		}
+	}
+	for _, s := range notOnOrAfter {
+		t, err := parseTime(s)
+		if err != nil {
+			return err
+		}
+		if !t.IsZero() && !now.Add(-skew).Before(t) {
+			return fmt.Errorf("SAML assertion already expired: valid until %s, local time is %s - check your clock",
+				t.UTC().Format(time.RFC3339), now.UTC().Format(time.RFC3339))
+		}
+	}
+
+	return nil
+}
+
+// parseTime parses a time of a SAML assertion. The zero time is returned for an empty string.
+func parseTime(s string) (time.Time, error) {
+	if s = strings.TrimSpace(s); s == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parsing time of SAML assertion: %v", err)
+	}
+
+	return t, nil
+}
+
+// Roles returns all roles contained in the SAML response data.
+func Roles(data string) ([]ARN, error) {
+	samlBody, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	x := new(saml.Response)
+	if err = xml.Unmarshal(samlBody, x); err != nil {
+		return nil, err
+	}
+
+	arns := extractArns(x.Assertion.AttributeStatement.Attributes, "")
+	if len(arns) == 0 {
+		return nil, ErrNoRoles
+	}
+
+	return arns, nil
+}
+
+// FilterRoles returns the roles of arns whose ARN is contained in allowed, keeping their order.
+func FilterRoles(arns []ARN, allowed []string) []ARN {
+	var filtered []ARN
+	for _, a := range arns {
+		for _, r := range allowed {
+			if a.Role == r {
+				filtered = append(filtered, a)
+				break
+			}
+		}
+	}
+
+	return filtered
+}
+
+// FilterProvider returns the roles of arns which are assumed using the IAM SAML provider with the
+// given ARN, keeping their order.
+func FilterProvider(arns []ARN, provider string) []ARN {
+	var filtered []ARN
+	for _, a := range arns {
+		if a.Provider == provider {
+			filtered = append(filtered, a)
+		}
+	}
+
+	return filtered
+}
+
+// SessionDuration returns the value of the SessionDuration attribute of the SAML response data in
+// seconds, which limits the duration of console sessions started using the assertion. Zero is
+// returned if the attribute isn't present.
+func SessionDuration(data string) (int64, error) {
+	samlBody, err := decode(data)
+	if err != nil {
+		return 0, err
+	}
+
+	x := new(saml.Response)
+	if err = xml.Unmarshal(samlBody, x); err != nil {
+		return 0, err
+	}
+
+	for _, attr := range x.Assertion.AttributeStatement.Attributes {
+		if attr.Name != "https://aws.amazon.com/SAML/Attributes/SessionDuration" || len(attr.Values) == 0 {
+			continue
+		}
+		d, err := strconv.ParseInt(strings.TrimSpace(attr.Values[0].Value), 10, 64)
+		if err != nil || d <= 0 {
+			return 0, fmt.Errorf("invalid SessionDuration attribute '%s'", attr.Values[0].Value)
+		}
+		return d, nil
+	}
+
+	return 0, nil
+}
+
 func decode(in string) (b []byte, err error) {
 	return base64.StdEncoding.DecodeString(in)
 }
@@ -132,44 +408,25 @@ func extractArns(attrs []saml.Attribute, pArn string) (arns []ARN) {
 	return
 }
 
-func ask(arns []ARN) (idx int) {
-	for {
-		for i, a := range arns {
-			name := a.Role
-			// Add the human friendly name if available
-			if a.Name != "" {
-				name = a.Name
-			}
-
-			// Use one-based indexing for human-friendliness.
-			fmt.Printf("%d. %s\n", i+1, name)
-		}
-
-		var input string
-		fmt.Print("Please select an IAM role to assume: ")
-		_, err := fmt.Scanln(&input)
-		if err != nil {
-			fmt.Printf("Error reading input: %v\n", err)
-			continue
-		}
+func ask(arns []ARN) (int, error) {
+	providers := make(map[string]int)
+	for _, a := range arns {
+		providers[a.Role]++
+	}
 
-		// Verify we got an integer.
-		selected, err := strconv.Atoi(input)
-		if err != nil {
-			fmt.Printf("Invalid input '%s'\n", input)
-			continue
+	names := make([]string, len(arns))
+	for i, a := range arns {
+		name := a.Role
+		// Add the human friendly name if available
+		if a.Name != "" {
+			name = a.Name
 		}
-
-		// Verify selection is within range.
-		if selected < 1 || selected > len(arns) {
-			fmt.Printf("Invalid value %d. Valid values: 1-%d\n", selected, len(arns))
-			continue
+		// Tell apart a role which may be assumed using several SAML providers.
+		if providers[a.Role] > 1 {
+			name = fmt.Sprintf("%s (via %s)", name, a.Provider)
 		}
-
-		// Translate user-selected index back to zero-based index.
-		idx = selected - 1
-		break
+		names[i] = name
 	}
 
-	return
+	return prompt.Select("IAM role selection (set the app's arn to avoid the prompt)", "Please select an IAM role to assume", names)
 }
@@ -2,7 +2,12 @@ package saml
 
 import (
 	"io/ioutil"
+	"os"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/spf13/viper"
 )
 
 func TestDecode(t *testing.T) {
@@ -44,7 +49,7 @@ func TestGet(t *testing.T) {
 			false,
 		},
 		//{"Many ARNs", "testdata/valid-response", "", "", false},         // will ask questions
-		{"No ARNs", "testdata/no-arns-resonse", "", "", true},
+		{"No ARNs", "testdata/no-arns-response", "", "", true},
 		{"No ARN value", "testdata/no-arn-value-response", "", "", true},
 		{
 			"IdP ARN before role ARN",
@@ -57,7 +62,10 @@ func TestGet(t *testing.T) {
 		{"Malformed ARN components", "testdata/malformed-components", "", "", true},
 	} {
 		t.Run(test.name, func(t *testing.T) {
-			b, _ := ioutil.ReadFile(test.path)
+			b, err := ioutil.ReadFile(test.path)
+			if err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
 
 			arn, err := Get(string(b), "")
 			if test.expectError && err == nil {
@@ -76,3 +84,251 @@ func TestGet(t *testing.T) {
 		})
 	}
 }
+
+func TestGetNonInteractive(t *testing.T) {
+	viper.Set("global.non-interactive", true)
+	defer viper.Set("global.non-interactive", false)
+
+	b, _ := ioutil.ReadFile("testdata/valid-response")
+
+	if _, err := Get(string(b), ""); err == nil || !strings.Contains(err.Error(), "non-interactive") {
+		t.Errorf("expected non-interactive error when selecting a role, got %v", err)
+	}
+}
+
+func TestCheckIssuer(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		path        string
+		expected    string
+		expectError bool
+	}{
+		{"Matching issuer", "testdata/issuer-response", "https://app.onelogin.com/saml/metadata/123456", false},
+		{"Mismatching issuer", "testdata/issuer-response", "https://app.onelogin.com/saml/metadata/654321", true},
+		{"No issuer", "testdata/single-arn-response", "https://app.onelogin.com/saml/metadata/123456", true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			b, _ := ioutil.ReadFile(test.path)
+
+			err := CheckIssuer(string(b), test.expected)
+			if test.expectError && err == nil {
+				t.Errorf("expected error")
+			}
+			if !test.expectError && err != nil {
+				t.Errorf("unexpected error %+v", err)
+			}
+		})
+	}
+}
+
+func TestCheckAudience(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		path        string
+		expectError bool
+	}{
+		{"AWS audience", "testdata/audience-response", false},
+		{"Other audience", "testdata/wrong-audience-response", true},
+		{"No audience", "testdata/issuer-response", true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			b, _ := ioutil.ReadFile(test.path)
+
+			err := CheckAudience(string(b), AWSAudience)
+			if test.expectError && err == nil {
+				t.Errorf("expected error")
+			}
+			if !test.expectError && err != nil {
+				t.Errorf("unexpected error %+v", err)
+			}
+		})
+	}
+}
+
+func TestCheckValidity(t *testing.T) {
+	at := func(hour, min, sec int) time.Time { return time.Date(2021, 2, 10, hour, min, sec, 0, time.UTC) }
+
+	for _, test := range []struct {
+		name        string
+		path        string
+		now         time.Time
+		skew        time.Duration
+		expectError string
+	}{
+		{"Valid", "testdata/validity-response", at(10, 1, 0), 0, ""},
+		{"Not yet valid", "testdata/validity-response", at(9, 58, 0), time.Minute, "not yet valid"},
+		{"Not yet valid within skew", "testdata/validity-response", at(9, 59, 30), time.Minute, ""},
+		{"Expired", "testdata/validity-response", at(10, 10, 0), time.Minute, "already expired"},
+		// The subject confirmation expires before the conditions.
+		{"Subject confirmation expired", "testdata/validity-response", at(10, 4, 0), 0, "already expired"},
+		{"Expired within skew", "testdata/validity-response", at(10, 4, 0), 2 * time.Minute, ""},
+		{"Expires now", "testdata/validity-response", at(10, 3, 0), 0, "already expired"},
+		{"No conditions", "testdata/issuer-response", at(23, 0, 0), 0, ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			b, _ := ioutil.ReadFile(test.path)
+
+			err := CheckValidity(string(b), test.now, test.skew)
+			if test.expectError == "" && err != nil {
+				t.Errorf("unexpected error %+v", err)
+			}
+			if test.expectError != "" && (err == nil || !strings.Contains(err.Error(), test.expectError) ||
+				!strings.Contains(err.Error(), "check your clock")) {
+				t.Errorf("expected %s error, received %v", test.expectError, err)
+			}
+		})
+	}
+}
+
+func TestRoles(t *testing.T) {
+	b, _ := ioutil.ReadFile("testdata/valid-response")
+	arns, err := Roles(string(b))
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	if len(arns) < 2 {
+		t.Errorf("expected multiple roles, received %d", len(arns))
+	}
+
+	b, err = ioutil.ReadFile("testdata/no-arns-response")
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	if _, err := Roles(string(b)); err == nil {
+		t.Errorf("expected error for response without roles")
+	}
+}
+
+func TestSelectAllowed(t *testing.T) {
+	b, _ := ioutil.ReadFile("testdata/valid-response")
+	role1 := "arn:aws:iam::123456789012:role/OneLogin-MyRole1"
+
+	// Only one of the roles of the assertion is allowed, so there is nothing to ask about.
+	arn, err := Select(string(b), Preferences{Allowed: []string{role1, "arn:aws:iam::123456789012:role/Other"}})
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	if arn.Role != role1 {
+		t.Errorf("expected %q, received %q", role1, arn.Role)
+	}
+
+	if _, err = Select(string(b), Preferences{Allowed: []string{"arn:aws:iam::123456789012:role/Other"}}); err != ErrNoAllowedRoles {
+		t.Errorf("expected ErrNoAllowedRoles, received %v", err)
+	}
+
+	b, err = ioutil.ReadFile("testdata/no-arns-response")
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	if _, err = Select(string(b), Preferences{Allowed: []string{role1}}); err != ErrNoRoles {
+		t.Errorf("expected ErrNoRoles, received %v", err)
+	}
+}
+
+func TestSelectProvider(t *testing.T) {
+	b, _ := ioutil.ReadFile("testdata/duplicate-role-response")
+	role := "arn:aws:iam::123456789012:role/OneLogin-MyRole0"
+	provider0 := "arn:aws:iam::123456789012:saml-provider/OneLogin-MyProvider0"
+	provider1 := "arn:aws:iam::123456789012:saml-provider/OneLogin-MyProvider1"
+
+	arn, err := Select(string(b), Preferences{Role: role, Provider: provider1})
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	if arn.Role != role || arn.Provider != provider1 {
+		t.Errorf("expected %s via %s, received %s via %s", role, provider1, arn.Role, arn.Provider)
+	}
+
+	if _, err = Select(string(b), Preferences{Provider: "arn:aws:iam::123456789012:saml-provider/Other"}); err == nil {
+		t.Errorf("expected error for provider without roles")
+	}
+
+	// Without a preferred provider the user chooses, telling the providers apart.
+	viper.Set("global.non-interactive", false)
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.TempFile("", "clisso-select")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(out.Name())
+	defer func(stdin, stdout *os.File) { os.Stdin, os.Stdout = stdin, stdout }(os.Stdin, os.Stdout)
+	os.Stdin, os.Stdout = r, out
+	if _, err := w.WriteString("1\n"); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	arn, err = Select(string(b), Preferences{Role: role})
+	if err != nil {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	if arn.Provider != provider0 {
+		t.Errorf("expected %s, received %s", provider0, arn.Provider)
+	}
+
+	printed, _ := ioutil.ReadFile(out.Name())
+	for _, p := range []string{provider0, provider1} {
+		if !strings.Contains(string(printed), "(via "+p+")") {
+			t.Errorf("expected the choices to name provider %s, received %q", p, printed)
+		}
+	}
+}
+
+func TestAskClosedStdin(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	defer func(stdin *os.File) { os.Stdin = stdin }(os.Stdin)
+	os.Stdin = r
+
+	// Reaching EOF must fail rather than prompting again.
+	if _, err := ask([]ARN{{Role: "role0"}, {Role: "role1"}}); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestFilterRoles(t *testing.T) {
+	arns := []ARN{{Role: "role0"}, {Role: "role1"}, {Role: "role2"}}
+
+	filtered := FilterRoles(arns, []string{"role2", "role0", "other"})
+	if len(filtered) != 2 || filtered[0].Role != "role0" || filtered[1].Role != "role2" {
+		t.Errorf("expected role0 and role2, received %v", filtered)
+	}
+
+	if filtered := FilterRoles(arns, nil); len(filtered) != 0 {
+		t.Errorf("expected no roles, received %v", filtered)
+	}
+}
+
+func TestSessionDuration(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		path        string
+		expect      int64
+		expectError bool
+	}{
+		{"SessionDuration", "testdata/session-duration-response", 7200, false},
+		{"No SessionDuration", "testdata/single-arn-response", 0, false},
+		{"Invalid SessionDuration", "testdata/invalid-session-duration-response", 0, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			b, _ := ioutil.ReadFile(test.path)
+
+			res, err := SessionDuration(string(b))
+			if test.expectError && err == nil {
+				t.Errorf("expected error")
+			}
+			if !test.expectError && err != nil {
+				t.Errorf("unexpected error %+v", err)
+			}
+			if res != test.expect {
+				t.Errorf("expected %d, received %d", test.expect, res)
+			}
+		})
+	}
+}
@@ -1,14 +1,161 @@
 package spinner
 
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"golang.org/x/term"
+)
+
 // This is a wrapper around spinner to disable unsupported operation systems transparently until upstream is fixed.
 // See https://github.com/briandowns/spinner/issues/52
 
-func New() SpinnerWrapper {
-	return new()
+// Style is the appearance of a spinner: the frames it shows in turn and the interval between them.
+type Style struct {
+	Frames   []string
+	Interval time.Duration
+}
+
+// Names of the built-in spinner styles.
+const (
+	StyleDefault = "default"
+	StyleASCII   = "ascii"
+	StyleText    = "text"
+)
+
+// Styles are the built-in spinner styles, which can be selected using global.spinner-style. The
+// text style shows a static text instead of an animation, for terminals which can't render the
+// others.
+var Styles = map[string]Style{
+	StyleDefault: {Frames: []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}, Interval: 50 * time.Millisecond},
+	StyleASCII:   {Frames: []string{"|", "/", "-", "\\"}, Interval: 100 * time.Millisecond},
+	StyleText:    {Frames: []string{"working..."}, Interval: time.Second},
+}
+
+// StyleNames returns the sorted names of the built-in spinner styles.
+func StyleNames() []string {
+	names := make([]string, 0, len(Styles))
+	for name := range Styles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// StyleNamed returns the built-in style with the given name. An empty name refers to the default
+// style.
+func StyleNamed(name string) (Style, error) {
+	if name == "" {
+		name = StyleDefault
+	}
+
+	style, ok := Styles[name]
+	if !ok {
+		return Style{}, fmt.Errorf("unknown spinner style '%s'. Valid values: %s", name, strings.Join(StyleNames(), ", "))
+	}
+
+	return style, nil
+}
+
+// Option customizes a spinner returned by New.
+type Option func(*Style)
+
+// WithStyle makes a spinner use style instead of the style configured in global.spinner-style.
+func WithStyle(style Style) Option {
+	return func(s *Style) {
+		*s = style
+	}
+}
+
+// New returns a spinner using the style configured in global.spinner-style, unless overridden by
+// opts. An unknown style is replaced by the default style. When stdout isn't a terminal, e.g. in
+// CI or when output is piped, or in quiet mode, the returned spinner doesn't display anything.
+func New(opts ...Option) SpinnerWrapper {
+	if viper.GetBool("global.quiet") || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return &syncSpinner{s: &noopSpinner{}}
+	}
+
+	style, err := StyleNamed(viper.GetString("global.spinner-style"))
+	if err != nil {
+		style = Styles[StyleDefault]
+	}
+	for _, opt := range opts {
+		opt(&style)
+	}
+
+	return &syncSpinner{s: new(style)}
 }
 
 // SpinnerWrapper is used to abstract a spinner so that it can be conveniently disabled on terminals which don't support it.
 type SpinnerWrapper interface {
 	Start()
 	Stop()
+	SetMessage(string)
+}
+
+// AttemptMessage returns a spinner message for the given attempt of an operation which is tried up
+// to attempts times. The first attempt has no message, so that the spinner is only annotated while
+// retrying.
+func AttemptMessage(attempt, attempts int) string {
+	if attempt <= 1 {
+		return ""
+	}
+
+	return fmt.Sprintf("retrying... (attempt %d/%d)", attempt, attempts)
+}
+
+// noopSpinner is a mock spinner which doesn't do anything. It is used to centrally disable the
+// spinner on Windows (because it isn't supported by the Windows terminal) and when not writing to
+// a terminal.
+// See https://github.com/briandowns/spinner/issues/52
+type noopSpinner struct{}
+
+func (s *noopSpinner) Start()            {}
+func (s *noopSpinner) Stop()             {}
+func (s *noopSpinner) SetMessage(string) {}
+
+// syncSpinner serializes all calls to the underlying spinner so that a single spinner can be
+// shared between goroutines without racing or leaving the terminal in an inconsistent state.
+type syncSpinner struct {
+	mu     sync.Mutex
+	s      SpinnerWrapper
+	active bool
+}
+
+// Start starts the spinner. Calling Start on an active spinner does nothing.
+func (s *syncSpinner) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.active {
+		return
+	}
+	s.active = true
+	s.s.Start()
+}
+
+// Stop stops the spinner. Calling Stop on an inactive spinner does nothing.
+func (s *syncSpinner) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.active {
+		return
+	}
+	s.active = false
+	s.s.Stop()
+}
+
+// SetMessage sets a message to display next to the spinner.
+func (s *syncSpinner) SetMessage(m string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.s.SetMessage(m)
 }
@@ -0,0 +1,108 @@
+package spinner
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// countingSpinner is a non thread-safe spinner which records the calls made to it. Unsynchronized
+// access to it is reported by the race detector.
+type countingSpinner struct {
+	active  bool
+	starts  int
+	stops   int
+	message string
+}
+
+func (s *countingSpinner) Start() {
+	s.active = true
+	s.starts++
+}
+
+func (s *countingSpinner) Stop() {
+	s.active = false
+	s.stops++
+}
+
+func (s *countingSpinner) SetMessage(m string) {
+	s.message = m
+}
+
+func TestConcurrentUse(t *testing.T) {
+	c := &countingSpinner{}
+	s := &syncSpinner{s: c}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Start()
+			s.SetMessage("working")
+			s.Stop()
+		}()
+	}
+	wg.Wait()
+
+	if c.active {
+		t.Errorf("spinner still active after all goroutines stopped it")
+	}
+	if c.starts != c.stops {
+		t.Errorf("unbalanced calls to the underlying spinner: %d starts, %d stops", c.starts, c.stops)
+	}
+}
+
+func TestStartStopIdempotent(t *testing.T) {
+	c := &countingSpinner{}
+	s := &syncSpinner{s: c}
+
+	s.Start()
+	s.Start()
+	s.Stop()
+	s.Stop()
+
+	if c.starts != 1 || c.stops != 1 {
+		t.Errorf("expected 1 start and 1 stop, got %d starts and %d stops", c.starts, c.stops)
+	}
+}
+
+func TestAttemptMessage(t *testing.T) {
+	for _, test := range []struct {
+		attempt  int
+		attempts int
+		expect   string
+	}{
+		{1, 3, ""},
+		{2, 3, "retrying... (attempt 2/3)"},
+		{3, 3, "retrying... (attempt 3/3)"},
+	} {
+		if m := AttemptMessage(test.attempt, test.attempts); m != test.expect {
+			t.Errorf("attempt %d/%d: expected %q, received %q", test.attempt, test.attempts, test.expect, m)
+		}
+	}
+}
+
+func TestStyleNamed(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		expect      []string
+		expectError bool
+	}{
+		{"", Styles[StyleDefault].Frames, false},
+		{StyleASCII, []string{"|", "/", "-", "\\"}, false},
+		{StyleText, []string{"working..."}, false},
+		{"unicorn", nil, true},
+	} {
+		style, err := StyleNamed(test.name)
+		if test.expectError && err == nil {
+			t.Errorf("%q: expected error", test.name)
+		}
+		if !test.expectError && err != nil {
+			t.Errorf("%q: unexpected error %+v", test.name, err)
+		}
+		if !reflect.DeepEqual(style.Frames, test.expect) {
+			t.Errorf("%q: expected frames %q, received %q", test.name, test.expect, style.Frames)
+		}
+	}
+}
@@ -3,11 +3,27 @@
 package spinner
 
 import (
-	"time"
-
 	"github.com/briandowns/spinner"
 )
 
-func new() SpinnerWrapper {
-	return spinner.New(spinner.CharSets[14], 50*time.Millisecond)
+func new(style Style) SpinnerWrapper {
+	return &unixSpinner{spinner.New(style.Frames, style.Interval)}
+}
+
+// unixSpinner adds SetMessage to the upstream spinner.
+type unixSpinner struct {
+	*spinner.Spinner
+}
+
+// SetMessage displays m after the spinner. The upstream spinner's lock is held since the suffix is
+// read concurrently by the goroutine drawing the spinner.
+func (s *unixSpinner) SetMessage(m string) {
+	s.Lock()
+	defer s.Unlock()
+
+	if m == "" {
+		s.Suffix = ""
+		return
+	}
+	s.Suffix = " " + m
 }
@@ -0,0 +1,30 @@
+// +build !windows
+
+package spinner
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStyleFrames(t *testing.T) {
+	style := Style{Frames: []string{"<a>", "<b>"}, Interval: time.Millisecond}
+	s := new(style).(*unixSpinner)
+	var out bytes.Buffer
+	s.Writer = &out
+
+	s.Start()
+	time.Sleep(20 * time.Millisecond)
+	s.Stop()
+
+	for _, frame := range style.Frames {
+		if !strings.Contains(out.String(), frame) {
+			t.Errorf("expected frame %q to be drawn, received %q", frame, out.String())
+		}
+	}
+	if strings.Contains(out.String(), Styles[StyleDefault].Frames[0]) {
+		t.Errorf("expected only the frames of the style to be drawn, received %q", out.String())
+	}
+}
@@ -0,0 +1,30 @@
+package totp
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/allcloud-io/clisso/keychain"
+	"github.com/allcloud-io/clisso/prompt"
+)
+
+// OTP returns a one-time password for provider. If a TOTP key is stored in the keychain for the
+// provider the code is generated locally, otherwise the user is prompted for it.
+func OTP(provider string) (string, error) {
+	return OTPPrompt(provider, "Please enter the OTP from your MFA device: ")
+}
+
+// OTPPrompt is like OTP, but shows message when prompting the user.
+func OTPPrompt(provider, message string) (string, error) {
+	s, err := keychain.GetTOTPKey(provider)
+	if err != nil {
+		return prompt.OTP("OTP", message)
+	}
+
+	k, err := Parse(s)
+	if err != nil {
+		return "", fmt.Errorf("parsing TOTP key of provider %s: %v", provider, err)
+	}
+
+	return k.Code(time.Now()), nil
+}
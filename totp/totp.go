@@ -0,0 +1,121 @@
+// Package totp generates time-based one-time passwords (RFC 6238) from keys stored in the
+// keychain, so that users don't have to type OTPs from their MFA device.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var algorithms = map[string]func() hash.Hash{
+	"SHA1":   sha1.New,
+	"SHA256": sha256.New,
+	"SHA512": sha512.New,
+}
+
+// Key holds the parameters required for generating TOTP codes.
+type Key struct {
+	Secret    []byte
+	Algorithm string
+	Digits    int
+	Period    int
+}
+
+// Parse parses a provisioning URI (otpauth://totp/...) or a bare base32-encoded secret. Parameters
+// missing from the URI default to SHA1, 6 digits and a period of 30 seconds.
+func Parse(s string) (*Key, error) {
+	k := &Key{Algorithm: "SHA1", Digits: 6, Period: 30}
+
+	if !strings.HasPrefix(strings.ToLower(s), "otpauth://") {
+		secret, err := decodeSecret(s)
+		if err != nil {
+			return nil, err
+		}
+		k.Secret = secret
+
+		return k, nil
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("parsing provisioning URI: %v", err)
+	}
+	if !strings.EqualFold(u.Host, "totp") {
+		return nil, fmt.Errorf("unsupported OTP type '%s', only totp is supported", u.Host)
+	}
+
+	q := u.Query()
+	if k.Secret, err = decodeSecret(q.Get("secret")); err != nil {
+		return nil, err
+	}
+
+	if a := q.Get("algorithm"); a != "" {
+		k.Algorithm = strings.ToUpper(a)
+		if _, ok := algorithms[k.Algorithm]; !ok {
+			return nil, fmt.Errorf("unsupported algorithm '%s'", a)
+		}
+	}
+
+	if d := q.Get("digits"); d != "" {
+		if k.Digits, err = strconv.Atoi(d); err != nil || k.Digits < 6 || k.Digits > 8 {
+			return nil, fmt.Errorf("invalid number of digits '%s'. Valid values: 6-8", d)
+		}
+	}
+
+	if p := q.Get("period"); p != "" {
+		if k.Period, err = strconv.Atoi(p); err != nil || k.Period < 1 {
+			return nil, fmt.Errorf("invalid period '%s'", p)
+		}
+	}
+
+	return k, nil
+}
+
+// decodeSecret decodes a base32 secret. Padding is optional and whitespace and case are ignored
+// since secrets are often displayed in groups of lowercase letters.
+func decodeSecret(s string) ([]byte, error) {
+	s = strings.ToUpper(strings.Join(strings.Fields(s), ""))
+	s = strings.TrimRight(s, "=")
+	if s == "" {
+		return nil, errors.New("TOTP secret is empty")
+	}
+
+	b, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("decoding TOTP secret: %v", err)
+	}
+
+	return b, nil
+}
+
+// Code returns the code for the time t.
+func (k *Key) Code(t time.Time) string {
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(t.Unix()/int64(k.Period)))
+
+	mac := hmac.New(algorithms[k.Algorithm], k.Secret)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation as described in RFC 4226, section 5.3.
+	offset := sum[len(sum)-1] & 0xf
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < k.Digits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", k.Digits, code%mod)
+}
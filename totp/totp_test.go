@@ -0,0 +1,77 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// Test vectors from RFC 6238, appendix B.
+var (
+	sha1Secret   = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	sha256Secret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890123456789012"))
+)
+
+func TestParse(t *testing.T) {
+	for _, test := range []struct {
+		name            string
+		input           string
+		expectAlgorithm string
+		expectDigits    int
+		expectPeriod    int
+		expectError     bool
+	}{
+		{"Bare secret", sha1Secret, "SHA1", 6, 30, false},
+		{"Default URI", "otpauth://totp/OneLogin:user@example.com?secret=" + sha1Secret + "&issuer=OneLogin", "SHA1", 6, 30, false},
+		{"Non-default URI", "otpauth://totp/Okta:user?secret=" + sha256Secret + "&algorithm=SHA256&digits=8&period=60", "SHA256", 8, 60, false},
+		{"Lowercase secret without padding", "otpauth://totp/x?secret=gezdgnbvgy3tqojqgezdgnbvgy3tqojq", "SHA1", 6, 30, false},
+		{"HOTP URI", "otpauth://hotp/x?secret=" + sha1Secret + "&counter=0", "", 0, 0, true},
+		{"Missing secret", "otpauth://totp/x?digits=6", "", 0, 0, true},
+		{"Invalid secret", "not base32!", "", 0, 0, true},
+		{"Unsupported algorithm", "otpauth://totp/x?secret=" + sha1Secret + "&algorithm=MD5", "", 0, 0, true},
+		{"Invalid digits", "otpauth://totp/x?secret=" + sha1Secret + "&digits=12", "", 0, 0, true},
+		{"Invalid period", "otpauth://totp/x?secret=" + sha1Secret + "&period=0", "", 0, 0, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			k, err := Parse(test.input)
+			if test.expectError {
+				if err == nil {
+					t.Errorf("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+			if k.Algorithm != test.expectAlgorithm || k.Digits != test.expectDigits || k.Period != test.expectPeriod {
+				t.Errorf("expected %s/%d/%d, received %s/%d/%d", test.expectAlgorithm, test.expectDigits,
+					test.expectPeriod, k.Algorithm, k.Digits, k.Period)
+			}
+		})
+	}
+}
+
+func TestCode(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		uri    string
+		time   int64
+		expect string
+	}{
+		{"SHA1 6 digits", "otpauth://totp/x?secret=" + sha1Secret, 59, "287082"},
+		{"SHA1 8 digits", "otpauth://totp/x?secret=" + sha1Secret + "&digits=8", 1111111109, "07081804"},
+		{"SHA256 8 digits", "otpauth://totp/x?secret=" + sha256Secret + "&algorithm=SHA256&digits=8", 59, "46119246"},
+		{"SHA256 8 digits later", "otpauth://totp/x?secret=" + sha256Secret + "&algorithm=SHA256&digits=8", 20000000000, "77737706"},
+		{"SHA1 60 second period", "otpauth://totp/x?secret=" + sha1Secret + "&digits=8&period=60", 118, "94287082"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			k, err := Parse(test.uri)
+			if err != nil {
+				t.Fatalf("unexpected error %+v", err)
+			}
+			if c := k.Code(time.Unix(test.time, 0)); c != test.expect {
+				t.Errorf("expected %q, received %q", test.expect, c)
+			}
+		})
+	}
+}